@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,9 +17,19 @@ import (
 	"github.com/Upellift99/GateCHA/internal/auth"
 	"github.com/Upellift99/GateCHA/internal/config"
 	"github.com/Upellift99/GateCHA/internal/database"
+	"github.com/Upellift99/GateCHA/internal/difficulty"
+	"github.com/Upellift99/GateCHA/internal/metrics"
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/oidc"
+	"github.com/Upellift99/GateCHA/internal/replay"
+	"github.com/Upellift99/GateCHA/internal/token"
+	"github.com/Upellift99/GateCHA/internal/webhook"
 )
 
+// webhookDeliveryRetention is how long finished webhook deliveries stay in
+// the delivery log.
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -27,7 +39,7 @@ func main() {
 
 	setupLogger(cfg.LogLevel)
 
-	slog.Info("starting GateCHA", "listen", cfg.ListenAddr)
+	slog.Info("starting GateCHA", "listen", cfg.ListenAddr, "replay_store", cfg.ReplayStore)
 
 	db, err := database.Open(cfg.DBPath)
 	if err != nil {
@@ -41,12 +53,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Create the token signing key up front, so serving the JWKS never writes.
+	if _, err := models.EnsureSigningKey(db); err != nil {
+		slog.Error("failed to ensure signing key", "error", err)
+		os.Exit(1)
+	}
+
+	replayStore, err := replay.New(cfg.ReplayStore, db, replay.Options{
+		BloomCapacity: cfg.ReplayBloomCapacity,
+		BloomFPRate:   cfg.ReplayBloomFPRate,
+		BloomWindow:   cfg.ReplayBloomWindow,
+	})
+	if err != nil {
+		slog.Error("failed to create replay store", "error", err)
+		os.Exit(1)
+	}
+
+	tracker := difficulty.NewTracker()
+
+	metrics.RegisterDBStats(metrics.Default, db)
+	metrics.Default.GaugeFunc("gatecha_replay_store_entries", "Consumed challenges held by the replay store.", func() float64 {
+		n, err := replayStore.Len()
+		if err != nil {
+			slog.Error("failed to count replay store entries", "error", err)
+			return math.NaN()
+		}
+		return float64(n)
+	})
+	var metricsHandler http.Handler
+	if cfg.MetricsAddr == "" && cfg.MetricsToken != "" {
+		metricsHandler = metrics.Default.Handler(cfg.MetricsToken)
+	}
+
 	// Start cleanup worker
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go cleanupWorker(ctx, db, cfg.CleanupInterval)
+	go cleanupWorker(ctx, db, replayStore, tracker, cfg)
+	go webhookWorker(ctx, webhook.NewDispatcher(db, cfg.WebhookAllowedNetworks), cfg.WebhookInterval)
+
+	events, err := eventOptions(db, cfg)
+	if err != nil {
+		slog.Error("failed to load event IP hash key", "error", err)
+		os.Exit(1)
+	}
 
-	router := api.NewRouter(db, cfg.SecretKey, cfg.CORSAllowAll)
+	router := api.NewRouter(db, api.Options{
+		SecretKey:           cfg.SecretKey,
+		CORSAllowAll:        cfg.CORSAllowAll,
+		RequireVerifySecret: cfg.RequireVerifySecret,
+		TrustedProxies:      cfg.TrustedProxies,
+		ReplayStore:         replayStore,
+		Difficulty:          tracker,
+		SecretGracePeriod:   cfg.SecretGracePeriod,
+		OIDC:                oidcOptions(cfg.OIDC),
+		Metrics:             metricsHandler,
+		Events:              events,
+	})
 
 	srv := &http.Server{
 		Addr:         cfg.ListenAddr,
@@ -65,6 +127,25 @@ func main() {
 		}
 	}()
 
+	var metricsSrv *http.Server
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Default.Handler(cfg.MetricsToken))
+		metricsSrv = &http.Server{
+			Addr:         cfg.MetricsAddr,
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("serving metrics", "listen", cfg.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("metrics server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -76,9 +157,68 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("shutdown error", "error", err)
 	}
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(shutdownCtx)
+	}
+}
+
+// oidcOptions returns the single sign-on options, or nil when it is not
+// configured.
+func oidcOptions(c config.OIDCConfig) *api.OIDCOptions {
+	if !c.Enabled() {
+		return nil
+	}
+	slog.Info("admin single sign-on enabled", "issuer", c.Issuer, "password_login", !c.DisablePasswordLogin)
+	return &api.OIDCOptions{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  c.RedirectURL,
+			Scopes:       c.Scopes,
+		}, nil),
+		UsernameClaim:        c.UsernameClaim,
+		GroupsClaim:          c.GroupsClaim,
+		GroupRoles:           c.GroupRoles,
+		DisablePasswordLogin: c.DisablePasswordLogin,
+		SecureCookie:         strings.HasPrefix(c.RedirectURL, "https://"),
+	}
+}
+
+func eventOptions(db *sql.DB, cfg *config.Config) (*api.EventOptions, error) {
+	if cfg.EventSampleRate == 0 {
+		return nil, nil
+	}
+	opts := &api.EventOptions{
+		SampleRate: cfg.EventSampleRate,
+		IPPolicy:   cfg.EventIPPolicy,
+	}
+	if opts.IPPolicy == api.IPPolicyHash {
+		key, err := models.EnsureEventIPHashKey(db)
+		if err != nil {
+			return nil, err
+		}
+		opts.IPHashKey = key
+	}
+	slog.Info("verification event log enabled", "sample_rate", cfg.EventSampleRate, "retention", cfg.EventRetention, "ip_policy", cfg.EventIPPolicy)
+	return opts, nil
 }
 
-func cleanupWorker(ctx context.Context, db *sql.DB, interval time.Duration) {
+func cleanupWorker(ctx context.Context, db *sql.DB, store replay.Store, tracker *difficulty.Tracker, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCleanup(db, store, cfg)
+			tracker.Prune()
+		}
+	}
+}
+
+func webhookWorker(ctx context.Context, dispatcher *webhook.Dispatcher, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
@@ -86,16 +226,101 @@ func cleanupWorker(ctx context.Context, db *sql.DB, interval time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			deleted, err := models.CleanupExpired(db)
-			if err != nil {
-				slog.Error("cleanup error", "error", err)
-			} else if deleted > 0 {
-				slog.Info("cleaned up expired challenges", "count", deleted)
+			if _, err := dispatcher.Run(ctx); err != nil {
+				slog.Error("webhook delivery error", "error", err)
 			}
 		}
 	}
 }
 
+// cleanupTask is one job of the cleanup worker. run returns how many rows
+// or entries it removed.
+type cleanupTask struct {
+	name    string
+	removed string
+	run     func() (int64, error)
+}
+
+func runCleanup(db *sql.DB, store replay.Store, cfg *config.Config) {
+	metrics.CleanupRuns.Inc()
+	tasks := []cleanupTask{
+		{"replay_store", "cleaned up expired challenges", store.Cleanup},
+		{"api_key_secrets", "removed expired API key secrets", func() (int64, error) {
+			return models.DeleteExpiredAPIKeySecrets(db)
+		}},
+		{"webhook_deliveries", "removed old webhook deliveries", func() (int64, error) {
+			return models.DeleteOldWebhookDeliveries(db, webhookDeliveryRetention)
+		}},
+		{"admin_sessions", "removed ended admin sessions", func() (int64, error) {
+			return auth.DeleteEndedSessions(db)
+		}},
+		{"personal_tokens", "removed expired personal access tokens", func() (int64, error) {
+			return auth.DeleteExpiredPersonalTokens(db)
+		}},
+		{"login_lockouts", "removed stale login lockouts", func() (int64, error) {
+			return auth.DeleteStaleLockouts(db, time.Now())
+		}},
+		{"verification_events", "removed old verification events", func() (int64, error) {
+			return models.DeleteOldVerificationEvents(db, cfg.EventRetention)
+		}},
+	}
+	if cfg.StatsRetention > 0 {
+		tasks = append(tasks,
+			cleanupTask{"hourly_stats", "removed old hourly statistics", func() (int64, error) {
+				return models.DeleteOldHourlyStats(db, cfg.StatsRetention)
+			}},
+			cleanupTask{"daily_stats", "rolled up daily statistics into months", func() (int64, error) {
+				return models.RollUpDailyStats(db, cfg.StatsRetention)
+			}},
+			cleanupTask{"solve_times", "removed old solve times", func() (int64, error) {
+				return models.DeleteOldSolveTimes(db, cfg.StatsRetention)
+			}},
+		)
+	}
+	if cfg.StatsMonthlyRetention > 0 {
+		tasks = append(tasks, cleanupTask{"monthly_stats", "removed old monthly statistics", func() (int64, error) {
+			return models.DeleteOldMonthlyStats(db, cfg.StatsMonthlyRetention)
+		}})
+	}
+	for _, task := range tasks {
+		removed, err := task.run()
+		if err != nil {
+			metrics.CleanupErrors.Inc(task.name)
+			slog.Error("cleanup error", "task", task.name, "error", err)
+			continue
+		}
+		metrics.CleanupDeleted.Add(float64(removed), task.name)
+		if removed > 0 {
+			slog.Info(task.removed, "count", removed)
+		}
+	}
+
+	promoted, err := models.PromoteSigningKey(db, models.SigningKeyPublishDelay)
+	if err != nil {
+		slog.Error("signing key promotion error", "error", err)
+	} else if promoted {
+		slog.Info("rotated verification token signing key")
+	}
+	staged, err := models.RotateSigningKeyIfOlder(db, cfg.SigningKeyRotation)
+	if err != nil {
+		slog.Error("signing key rotation error", "error", err)
+	} else if staged {
+		slog.Info("published next verification token signing key")
+	}
+
+	// Retired keys stay in the JWKS until the last token they signed expires.
+	deleted, err := models.DeleteRetiredSigningKeys(db, token.TTL)
+	if err != nil {
+		metrics.CleanupErrors.Inc("signing_keys")
+		slog.Error("signing key cleanup error", "error", err)
+		return
+	}
+	metrics.CleanupDeleted.Add(float64(deleted), "signing_keys")
+	if deleted > 0 {
+		slog.Info("removed retired signing keys", "count", deleted)
+	}
+}
+
 func setupLogger(level string) {
 	var logLevel slog.Level
 	switch level {
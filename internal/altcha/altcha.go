@@ -1,18 +1,35 @@
 package altcha
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/url"
 	"time"
 
 	lib "github.com/altcha-org/altcha-lib-go"
 )
 
 func GenerateChallenge(hmacSecret string, maxNumber int64, algorithm string, expireSeconds int) (lib.Challenge, error) {
+	return GenerateChallengeWithParams(hmacSecret, maxNumber, algorithm, expireSeconds, nil)
+}
+
+// GenerateChallengeWithParams embeds params in the salt. They are covered by
+// the challenge signature, so they can be trusted when the solution verifies.
+func GenerateChallengeWithParams(hmacSecret string, maxNumber int64, algorithm string, expireSeconds int, params url.Values) (lib.Challenge, error) {
 	expires := time.Now().Add(time.Duration(expireSeconds) * time.Second)
 	opts := lib.ChallengeOptions{
 		HMACKey:   hmacSecret,
 		MaxNumber: maxNumber,
 		Algorithm: lib.Algorithm(algorithm),
 		Expires:   &expires,
+		Params:    params,
 	}
 	return lib.CreateChallenge(opts)
 }
@@ -20,3 +37,59 @@ func GenerateChallenge(hmacSecret string, maxNumber int64, algorithm string, exp
 func VerifyPayload(hmacSecret string, payload string) (bool, error) {
 	return lib.VerifySolution(payload, hmacSecret, true)
 }
+
+// VerifyPayloadWithSecrets accepts a solution signed with any of secrets,
+// so challenges issued before a secret rotation stay solvable. It returns
+// the secret that verified the solution.
+func VerifyPayloadWithSecrets(secrets []string, payload string) (string, bool, error) {
+	for _, secret := range secrets {
+		ok, err := VerifyPayload(secret, payload)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			return secret, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// CreateServerSignature builds a base64 server-signature payload over
+// verificationData, in the format checked by the ALTCHA libraries'
+// verifyServerSignature: HMAC(hmacSecret, H(verificationData)).
+func CreateServerSignature(hmacSecret string, algorithm string, verificationData url.Values) (string, error) {
+	newHash, err := hashFunc(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	data := verificationData.Encode()
+	digest := newHash()
+	digest.Write([]byte(data))
+	mac := hmac.New(newHash, []byte(hmacSecret))
+	mac.Write(digest.Sum(nil))
+
+	payload, err := json.Marshal(lib.ServerSignaturePayload{
+		Algorithm:        lib.Algorithm(algorithm),
+		VerificationData: data,
+		Signature:        hex.EncodeToString(mac.Sum(nil)),
+		Verified:         verificationData.Get("verified") == "true",
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+func hashFunc(algorithm string) (func() hash.Hash, error) {
+	switch lib.Algorithm(algorithm) {
+	case lib.SHA1:
+		return sha1.New, nil
+	case lib.SHA256:
+		return sha256.New, nil
+	case lib.SHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
@@ -1,7 +1,14 @@
 package altcha
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
 	"testing"
+	"time"
+
+	lib "github.com/altcha-org/altcha-lib-go"
 )
 
 func TestGenerateChallenge(t *testing.T) {
@@ -49,3 +56,75 @@ func TestVerifyPayload_EmptyPayload(t *testing.T) {
 		t.Error("expected failure for empty payload")
 	}
 }
+
+func TestVerifyPayloadWithSecrets(t *testing.T) {
+	challenge, err := GenerateChallenge("old-secret", 100, "SHA-256", 60)
+	if err != nil {
+		t.Fatalf("GenerateChallenge failed: %v", err)
+	}
+	solution, err := lib.SolveChallenge(challenge.Challenge, challenge.Salt, lib.SHA256, int(challenge.MaxNumber), 0, nil)
+	if err != nil || solution == nil {
+		t.Fatalf("SolveChallenge failed: %v", err)
+	}
+	payload, _ := json.Marshal(lib.Payload{
+		Algorithm: challenge.Algorithm,
+		Challenge: challenge.Challenge,
+		Number:    int64(solution.Number),
+		Salt:      challenge.Salt,
+		Signature: challenge.Signature,
+	})
+	encoded := base64.StdEncoding.EncodeToString(payload)
+
+	if secret, ok, err := VerifyPayloadWithSecrets([]string{"new-secret", "old-secret"}, encoded); err != nil || !ok || secret != "old-secret" {
+		t.Errorf("expected payload to verify against the previous secret, got %q, %v, %v", secret, ok, err)
+	}
+	if _, ok, _ := VerifyPayloadWithSecrets([]string{"new-secret"}, encoded); ok {
+		t.Error("expected payload to fail without its secret")
+	}
+}
+
+func TestGenerateChallengeWithParams(t *testing.T) {
+	challenge, err := GenerateChallengeWithParams("secret", 1000, "SHA-256", 60, url.Values{"origin": {"https://example.com"}})
+	if err != nil {
+		t.Fatalf("GenerateChallengeWithParams failed: %v", err)
+	}
+	params := lib.ExtractParams(lib.Payload{Salt: challenge.Salt})
+	if params.Get("origin") != "https://example.com" {
+		t.Errorf("expected origin param, got %q", params.Get("origin"))
+	}
+	if params.Get("expires") == "" {
+		t.Error("expected expires param to be kept")
+	}
+}
+
+func TestCreateServerSignature(t *testing.T) {
+	for _, alg := range []string{"SHA-1", "SHA-256", "SHA-512"} {
+		data := url.Values{}
+		data.Set("verified", "true")
+		data.Set("expire", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		payload, err := CreateServerSignature("secret", alg, data)
+		if err != nil {
+			t.Fatalf("%s: CreateServerSignature failed: %v", alg, err)
+		}
+
+		ok, verification, err := lib.VerifyServerSignature(payload, "secret")
+		if err != nil || !ok {
+			t.Errorf("%s: expected signature to verify, got ok=%v err=%v", alg, ok, err)
+		}
+		if !verification.Verified {
+			t.Errorf("%s: expected verified=true in verification data", alg)
+		}
+
+		ok, _, _ = lib.VerifyServerSignature(payload, "other-secret")
+		if ok {
+			t.Errorf("%s: expected failure with wrong secret", alg)
+		}
+	}
+}
+
+func TestCreateServerSignature_UnsupportedAlgorithm(t *testing.T) {
+	if _, err := CreateServerSignature("secret", "MD5", url.Values{}); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
@@ -3,14 +3,21 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/altcha"
 	"github.com/Upellift99/GateCHA/internal/auth"
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/origins"
 	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/http/httpguts"
 )
 
 const (
@@ -22,6 +29,14 @@ const (
 type AdminHandler struct {
 	DB        *sql.DB
 	SecretKey string
+	// SecretGracePeriod is how long a rotated-out HMAC secret keeps
+	// verifying solutions, unless the rotate request overrides it.
+	SecretGracePeriod time.Duration
+	// OIDC enables single sign-on. Nil disables it.
+	OIDC *OIDCOptions
+	// Events is the verification event log configuration, used to search
+	// by client IP. Nil when the log is disabled.
+	Events *EventOptions
 }
 
 // verifyLoginCaptcha validates the ALTCHA captcha payload during login.
@@ -38,7 +53,11 @@ func (h *AdminHandler) verifyLoginCaptcha(w http.ResponseWriter, payload string)
 	}
 	valid, err := altcha.VerifyPayload(key.HMACSecret, payload)
 	if err != nil || !valid {
-		if err := models.IncrementVerificationsFail(h.DB, key.ID); err != nil {
+		reason := models.FailInvalidSolution
+		if err != nil {
+			reason = models.FailVerificationError
+		}
+		if err := models.IncrementVerificationsFail(h.DB, key.ID, reason); err != nil {
 			slog.Error("failed to increment verifications_fail", "error", err, "api_key_id", key.ID)
 		}
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid captcha"})
@@ -62,41 +81,106 @@ func (h *AdminHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok, err := auth.ValidateCredentials(h.DB, req.Username, req.Password)
-	if err != nil || !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+	if h.OIDC != nil && h.OIDC.DisablePasswordLogin {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "password login is disabled, use single sign-on"})
+		return
+	}
+
+	loginAudit := models.AuditEntry{Actor: req.Username, Action: models.AuditLogin, TargetType: auditTargetUser, TargetID: req.Username}
+
+	attempt, ok := h.beginLogin(w, r, req.Username)
+	if !ok {
 		return
 	}
 
+	// The CAPTCHA comes first so its result says nothing about the password.
 	captchaEnabled, err := models.GetLoginCaptchaEnabled(h.DB)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
 		return
 	}
 	if captchaEnabled && !h.verifyLoginCaptcha(w, req.AltchaPayload) {
+		// Without a solved CAPTCHA the password went unchecked, so only
+		// the IP keeps the failure.
+		h.forgiveLogin(attempt, auth.LockoutScopeUser)
+		h.audit(r, loginAudit, nil, nil)
 		return
 	}
 
-	token, expiresAt, err := auth.GenerateJWT(req.Username, h.SecretKey)
+	ok, err = auth.ValidateCredentials(h.DB, req.Username, req.Password)
+	if err != nil || !ok {
+		h.audit(r, loginAudit, nil, nil)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+		return
+	}
+
+	user, err := auth.GetAdminUserByUsername(h.DB, req.Username)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"token":      token,
-		"expires_at": expiresAt,
-	})
+	// With TOTP enabled the password only earns a challenge for the second
+	// step; the login is audited once that completes.
+	if user.TOTPEnabled {
+		h.forgiveLogin(attempt, auth.LockoutScopeUser, auth.LockoutScopeIP)
+		challenge, expiresAt, err := auth.GenerateLoginChallenge(user.Username, h.SecretKey)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"totp_required": true,
+			"login_token":   challenge,
+			"expires_at":    expiresAt,
+		})
+		return
+	}
+
+	h.completeLogin(w, r, attempt, user, loginAudit)
+}
+
+// completeLogin starts a session once every login factor has passed.
+func (h *AdminHandler) completeLogin(w http.ResponseWriter, r *http.Request, attempt *auth.LoginAttempt, user *auth.AdminUser, loginAudit models.AuditEntry) {
+	session, refresh, err := auth.CreateSession(h.DB, user.ID, clientIP(r), r.UserAgent())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
+		return
+	}
+
+	if _, err := auth.ClearLoginFailures(h.DB, auth.LockoutScopeUser, user.Username); err != nil {
+		slog.Error("failed to clear login failures", "error", err, "user", user.Username)
+	}
+	h.forgiveLogin(attempt, auth.LockoutScopeIP)
+	loginAudit.Success = true
+	h.audit(r, loginAudit, nil, nil)
+	h.writeSessionTokens(w, user, session, refresh)
 }
 
 // GET /api/admin/me
 func (h *AdminHandler) Me(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"username": "admin"})
+	username := GetAdminUserFromContext(r)
+	resp := map[string]interface{}{
+		"username": username,
+		"role":     GetAdminRoleFromContext(r),
+	}
+	if user, err := auth.GetAdminUserByUsername(h.DB, username); err == nil {
+		resp["totp_enabled"] = user.TOTPEnabled
+		if user.TOTPEnabled {
+			resp["recovery_codes_remaining"], _ = auth.RemainingRecoveryCodes(h.DB, user.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // GET /api/admin/keys
 func (h *AdminHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
-	keys, err := models.ListAPIKeys(h.DB)
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	keys, err := models.ListAPIKeysInScope(h.DB, scope)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list keys"})
 		return
@@ -104,32 +188,94 @@ func (h *AdminHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
 	if keys == nil {
 		keys = []models.APIKey{}
 	}
+	if !auth.RoleAtLeast(GetAdminRoleFromContext(r), auth.RoleEditor) {
+		for i := range keys {
+			keys[i].HMACSecret = ""
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
 }
 
 // POST /api/admin/keys
 func (h *AdminHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name          string `json:"name"`
-		Domain        string `json:"domain"`
-		MaxNumber     int64  `json:"max_number"`
-		ExpireSeconds int    `json:"expire_seconds"`
-		Algorithm     string `json:"algorithm"`
+		Name           string   `json:"name"`
+		Domain         string   `json:"domain"`
+		AllowedOrigins []string `json:"allowed_origins"`
+		MaxNumber      int64    `json:"max_number"`
+		ExpireSeconds  int      `json:"expire_seconds"`
+		Algorithm      string   `json:"algorithm"`
+		ProjectID      int64    `json:"project_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
 		return
 	}
 
-	key, err := models.CreateAPIKey(h.DB, req.Name, req.Domain, req.MaxNumber, req.ExpireSeconds, req.Algorithm)
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	if msg := h.checkKeyProject(scope, req.ProjectID); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	allowed, err := allowedOriginsFromRequest(req.AllowedOrigins, req.Domain)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	key, err := models.CreateAPIKeyWithParams(h.DB, models.CreateAPIKeyParams{
+		ProjectID:      req.ProjectID,
+		Name:           req.Name,
+		AllowedOrigins: allowed,
+		MaxNumber:      req.MaxNumber,
+		ExpireSeconds:  req.ExpireSeconds,
+		Algorithm:      req.Algorithm,
+	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create key"})
 		return
 	}
 
+	h.audit(r, models.AuditEntry{Action: models.AuditKeyCreate, TargetType: auditTargetKey, TargetID: strconv.FormatInt(key.ID, 10), Success: true}, nil, key)
+	h.emit(models.EventKeyCreated, keyEventData(key), key.ProjectID)
 	writeJSON(w, http.StatusCreated, key)
 }
 
+// allowedOriginsFromRequest normalises the allowed_origins list of a key
+// request. Clients that only send the older single domain field get a
+// one-entry list.
+func allowedOriginsFromRequest(list []string, domain string) ([]string, error) {
+	if list == nil && domain != "" {
+		list = []string{domain}
+	}
+	normalized, err := origins.Normalize(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed origin: %w", err)
+	}
+	return normalized, nil
+}
+
+// maxCORSMaxAge caps cors_max_age at one day; browsers clamp longer values.
+const maxCORSMaxAge = 86400
+
+// exposedHeadersFromRequest validates and canonicalises the header names a
+// key exposes to browsers.
+func exposedHeadersFromRequest(list []string) ([]string, error) {
+	headers := make([]string, 0, len(list))
+	for _, h := range list {
+		h = strings.TrimSpace(h)
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("invalid exposed header %q", h)
+		}
+		headers = append(headers, http.CanonicalHeaderKey(h))
+	}
+	return headers, nil
+}
+
 // GET /api/admin/keys/{id}
 func (h *AdminHandler) GetKey(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
@@ -143,6 +289,9 @@ func (h *AdminHandler) GetKey(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": errKeyNotFound})
 		return
 	}
+	if !auth.RoleAtLeast(GetAdminRoleFromContext(r), auth.RoleEditor) {
+		key.HMACSecret = ""
+	}
 
 	writeJSON(w, http.StatusOK, key)
 }
@@ -156,12 +305,29 @@ func (h *AdminHandler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name          string `json:"name"`
-		Domain        string `json:"domain"`
-		MaxNumber     int64  `json:"max_number"`
-		ExpireSeconds int    `json:"expire_seconds"`
-		Algorithm     string `json:"algorithm"`
-		Enabled       *bool  `json:"enabled"`
+		Name               string   `json:"name"`
+		Domain             string   `json:"domain"`
+		AllowedOrigins     []string `json:"allowed_origins"`
+		MaxNumber          int64    `json:"max_number"`
+		ExpireSeconds      int      `json:"expire_seconds"`
+		Algorithm          string   `json:"algorithm"`
+		Enabled            *bool    `json:"enabled"`
+		AdaptiveDifficulty *bool    `json:"adaptive_difficulty"`
+		DifficultyMin      *int64   `json:"difficulty_min"`
+		DifficultyMax      *int64   `json:"difficulty_max"`
+		DifficultyCurve    string   `json:"difficulty_curve"`
+		OriginEnforcement  string   `json:"origin_enforcement"`
+
+		CORSExposedHeaders   []string `json:"cors_exposed_headers"`
+		CORSAllowCredentials *bool    `json:"cors_allow_credentials"`
+		CORSMaxAge           *int     `json:"cors_max_age"`
+
+		ProjectID *int64 `json:"project_id"`
+
+		MinSolveMS             *int64 `json:"min_solve_ms"`
+		MinChallengeAgeMS      *int64 `json:"min_challenge_age_ms"`
+		MaxChallengeAgeSeconds *int   `json:"max_challenge_age_seconds"`
+		TimingEnforcement      string `json:"timing_enforcement"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
@@ -174,44 +340,128 @@ func (h *AdminHandler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := existing.Name
+	params := existing.UpdateParams()
 	if req.Name != "" {
-		name = req.Name
+		params.Name = req.Name
 	}
-	domain := existing.Domain
-	if req.Domain != "" {
-		domain = req.Domain
+	if req.AllowedOrigins != nil || req.Domain != "" {
+		allowed, err := allowedOriginsFromRequest(req.AllowedOrigins, req.Domain)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		params.AllowedOrigins = allowed
+		params.Domain = ""
+		if len(allowed) > 0 {
+			params.Domain = allowed[0]
+		}
 	}
-	maxNumber := existing.MaxNumber
 	if req.MaxNumber > 0 {
-		maxNumber = req.MaxNumber
+		params.MaxNumber = req.MaxNumber
 	}
-	expireSeconds := existing.ExpireSeconds
 	if req.ExpireSeconds > 0 {
-		expireSeconds = req.ExpireSeconds
+		params.ExpireSeconds = req.ExpireSeconds
 	}
-	algorithm := existing.Algorithm
 	if req.Algorithm != "" {
-		algorithm = req.Algorithm
+		params.Algorithm = req.Algorithm
 	}
-	enabled := existing.Enabled
 	if req.Enabled != nil {
-		enabled = *req.Enabled
+		params.Enabled = *req.Enabled
+	}
+	if req.AdaptiveDifficulty != nil {
+		params.AdaptiveDifficulty = *req.AdaptiveDifficulty
+	}
+	if req.DifficultyMin != nil {
+		params.DifficultyMin = *req.DifficultyMin
+	}
+	if req.DifficultyMax != nil {
+		params.DifficultyMax = *req.DifficultyMax
+	}
+	if req.DifficultyCurve != "" {
+		params.DifficultyCurve = req.DifficultyCurve
+	}
+	if req.OriginEnforcement != "" {
+		params.OriginEnforcement = req.OriginEnforcement
+	}
+	if req.CORSExposedHeaders != nil {
+		headers, err := exposedHeadersFromRequest(req.CORSExposedHeaders)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		params.CORSExposedHeaders = headers
+	}
+	if req.CORSAllowCredentials != nil {
+		params.CORSAllowCredentials = *req.CORSAllowCredentials
+	}
+	if req.CORSMaxAge != nil {
+		params.CORSMaxAge = *req.CORSMaxAge
+	}
+	if req.MinSolveMS != nil {
+		params.MinSolveMS = *req.MinSolveMS
+	}
+	if req.MinChallengeAgeMS != nil {
+		params.MinChallengeAgeMS = *req.MinChallengeAgeMS
+	}
+	if req.MaxChallengeAgeSeconds != nil {
+		params.MaxChallengeAgeSeconds = *req.MaxChallengeAgeSeconds
+	}
+	if req.TimingEnforcement != "" {
+		params.TimingEnforcement = req.TimingEnforcement
+	}
+	if req.ProjectID != nil && *req.ProjectID != params.ProjectID {
+		scope, err := h.projectScope(r)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if msg := h.checkKeyProject(scope, *req.ProjectID); msg != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		params.ProjectID = *req.ProjectID
+	}
+
+	if params.DifficultyCurve != models.DifficultyCurveLinear && params.DifficultyCurve != models.DifficultyCurveExponential {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "difficulty_curve must be linear or exponential"})
+		return
+	}
+	switch params.OriginEnforcement {
+	case models.OriginEnforcementOff, models.OriginEnforcementLenient, models.OriginEnforcementStrict:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "origin_enforcement must be off, lenient or strict"})
+		return
+	}
+	if params.CORSMaxAge < 0 || params.CORSMaxAge > maxCORSMaxAge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("cors_max_age must be between 0 and %d", maxCORSMaxAge)})
+		return
+	}
+	if params.DifficultyMin < 0 || params.DifficultyMax < 0 ||
+		(params.DifficultyMax > 0 && params.DifficultyMin > params.DifficultyMax) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid difficulty bounds"})
+		return
+	}
+	if params.TimingEnforcement != models.TimingEnforcementFlag && params.TimingEnforcement != models.TimingEnforcementReject {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "timing_enforcement must be flag or reject"})
+		return
+	}
+	if params.MinSolveMS < 0 || params.MinChallengeAgeMS < 0 || params.MaxChallengeAgeSeconds < 0 ||
+		(params.MaxChallengeAgeSeconds > 0 && params.MinChallengeAgeMS >= int64(params.MaxChallengeAgeSeconds)*1000) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid timing thresholds"})
+		return
 	}
 
-	if err := models.UpdateAPIKey(h.DB, id, models.UpdateAPIKeyParams{
-		Name:          name,
-		Domain:        domain,
-		MaxNumber:     maxNumber,
-		ExpireSeconds: expireSeconds,
-		Algorithm:     algorithm,
-		Enabled:       enabled,
-	}); err != nil {
+	if err := models.UpdateAPIKey(h.DB, id, params); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update key"})
 		return
 	}
 
 	updated, _ := models.GetAPIKeyByID(h.DB, id)
+	if updated != nil {
+		h.audit(r, models.AuditEntry{Action: models.AuditKeyUpdate, TargetType: auditTargetKey, TargetID: strconv.FormatInt(id, 10), Success: true}, existing, updated)
+		// A key moved between projects is news to the webhooks of both.
+		h.emit(models.EventKeyUpdated, keyEventData(updated), existing.ProjectID, updated.ProjectID)
+	}
 	writeJSON(w, http.StatusOK, updated)
 }
 
@@ -223,10 +473,15 @@ func (h *AdminHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	existing, _ := models.GetAPIKeyByID(h.DB, id)
 	if err := models.DeleteAPIKey(h.DB, id); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete key"})
 		return
 	}
+	if existing != nil {
+		h.audit(r, models.AuditEntry{Action: models.AuditKeyDelete, TargetType: auditTargetKey, TargetID: strconv.FormatInt(id, 10), Success: true}, existing, nil)
+		h.emit(models.EventKeyDeleted, keyEventData(existing), existing.ProjectID)
+	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
@@ -239,18 +494,155 @@ func (h *AdminHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newSecret, err := models.RotateHMACSecret(h.DB, id)
+	// The body is optional; an empty one uses the configured grace period.
+	var req struct {
+		GracePeriodSeconds *int `json:"grace_period_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	grace := h.SecretGracePeriod
+	if req.GracePeriodSeconds != nil {
+		if *req.GracePeriodSeconds < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "grace_period_seconds must not be negative"})
+			return
+		}
+		grace = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	before, _ := models.GetAPIKeyByID(h.DB, id)
+	newSecret, err := models.RotateHMACSecret(h.DB, id, grace)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errKeyNotFound})
+		return
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to rotate secret"})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"hmac_secret": newSecret})
+	resp := map[string]string{"hmac_secret": newSecret}
+	if grace > 0 {
+		resp["previous_expires_at"] = time.Now().Add(grace).UTC().Format(time.RFC3339)
+	}
+	if key, err := models.GetAPIKeyByID(h.DB, id); err == nil {
+		h.audit(r, models.AuditEntry{Action: models.AuditKeyRotateSecret, TargetType: auditTargetKey, TargetID: strconv.FormatInt(id, 10), Success: true}, before, key)
+		data := keyEventData(key)
+		if expires, ok := resp["previous_expires_at"]; ok {
+			data["previous_expires_at"] = expires
+		}
+		h.emit(models.EventKeySecretRotated, data, key.ProjectID)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /api/admin/keys/{id}/verify-secret
+func (h *AdminHandler) RegenerateVerifySecret(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidKeyID})
+		return
+	}
+
+	secret, err := models.RegenerateVerifySecret(h.DB, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errKeyNotFound})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to regenerate verify secret"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditKeyVerifySecret, TargetType: auditTargetKey, TargetID: strconv.FormatInt(id, 10), Success: true},
+		nil, map[string]string{"verify_secret": secret})
+	writeJSON(w, http.StatusOK, map[string]string{"verify_secret": secret})
+}
+
+// GET /api/admin/keys/{id}/secrets
+func (h *AdminHandler) ListSecrets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidKeyID})
+		return
+	}
+	if _, err := models.GetAPIKeyByID(h.DB, id); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errKeyNotFound})
+		return
+	}
+
+	secrets, err := models.ListAPIKeySecrets(h.DB, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list secrets"})
+		return
+	}
+	if secrets == nil {
+		secrets = []models.APIKeySecret{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"secrets": secrets})
+}
+
+// POST /api/admin/keys/{id}/secrets/{secretID}/retire
+func (h *AdminHandler) RetireSecret(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidKeyID})
+		return
+	}
+	secretID, err := strconv.ParseInt(chi.URLParam(r, "secretID"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid secret ID"})
+		return
+	}
+
+	err = models.RetireAPIKeySecret(h.DB, id, secretID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "secret not found"})
+	case errors.Is(err, models.ErrPrimarySecret):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to retire secret"})
+	default:
+		h.audit(r, models.AuditEntry{Action: models.AuditKeyRetireSecret, TargetType: auditTargetKey, TargetID: strconv.FormatInt(id, 10), Success: true},
+			nil, map[string]interface{}{"secret_id": secretID, "status": models.SecretStatusRetired})
+		writeJSON(w, http.StatusOK, map[string]string{"status": models.SecretStatusRetired})
+	}
+}
+
+// GET /api/admin/signing-keys
+func (h *AdminHandler) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := models.ListSigningKeys(h.DB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list signing keys"})
+		return
+	}
+	if keys == nil {
+		keys = []models.SigningKey{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// POST /api/admin/signing-keys/rotate
+func (h *AdminHandler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := models.RotateSigningKey(h.DB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to rotate signing key"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditSigningKeyRotate, TargetType: auditTargetSigningKey, TargetID: key.KID, Success: true}, nil, key)
+	writeJSON(w, http.StatusOK, key)
 }
 
 // GET /api/admin/stats/keys-summary
 func (h *AdminHandler) KeysStatsSummary(w http.ResponseWriter, r *http.Request) {
-	summary, err := models.GetAllKeysStatsSummary(h.DB)
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	summary, err := models.GetAllKeysStatsSummary(h.DB, scope)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats summary"})
 		return
@@ -274,15 +666,88 @@ func (h *AdminHandler) StatsOverview(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	overview, err := models.GetStatsOverview(h.DB, days)
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	if p := r.URL.Query().Get("project_id"); p != "" {
+		projectID, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidProjectID})
+			return
+		}
+		if _, err := models.GetProject(h.DB, projectID); err != nil || !scope.Includes(projectID) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errProjectNotFound})
+			return
+		}
+		scope = models.ProjectScope{ProjectIDs: []int64{projectID}}
+	}
+
+	rng, ranged, err := statsRange(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	overview, err := models.GetStatsOverview(h.DB, days, scope)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
 		return
 	}
+	if ranged {
+		overview.Series, err = models.GetStatsSeries(h.DB, rng, scope, 0)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
+			return
+		}
+	}
 
 	writeJSON(w, http.StatusOK, overview)
 }
 
+// statsRange reads the from, to and bucket query parameters, and reports
+// whether any of them was set. Times are RFC 3339 or YYYY-MM-DD in UTC. to
+// defaults to now, from to 30 days before to (24 hours for hourly buckets),
+// and bucket to day.
+func statsRange(r *http.Request) (models.StatsRange, bool, error) {
+	q := r.URL.Query()
+	if q.Get("from") == "" && q.Get("to") == "" && q.Get("bucket") == "" {
+		return models.StatsRange{}, false, nil
+	}
+
+	rng := models.StatsRange{To: time.Now().UTC(), Bucket: q.Get("bucket")}
+	if rng.Bucket == "" {
+		rng.Bucket = models.BucketDay
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := parseStatsTime(v)
+		if err != nil {
+			return rng, true, fmt.Errorf("invalid to: %s", v)
+		}
+		rng.To = t
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := parseStatsTime(v)
+		if err != nil {
+			return rng, true, fmt.Errorf("invalid from: %s", v)
+		}
+		rng.From = t
+	} else if rng.Bucket == models.BucketHour {
+		rng.From = rng.To.Add(-24 * time.Hour)
+	} else {
+		rng.From = rng.To.AddDate(0, 0, -30)
+	}
+	return rng, true, rng.Validate()
+}
+
+func parseStatsTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
 // GET /api/admin/stats/keys/{id}
 func (h *AdminHandler) KeyStats(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
@@ -304,6 +769,12 @@ func (h *AdminHandler) KeyStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rng, ranged, err := statsRange(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
 	stats, err := models.GetKeyStats(h.DB, id, days)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
@@ -313,11 +784,44 @@ func (h *AdminHandler) KeyStats(w http.ResponseWriter, r *http.Request) {
 		stats = []models.DailyStat{}
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"key_id": key.KeyID,
-		"name":   key.Name,
-		"days":   stats,
-	})
+	// Solve times cover the same days, estimated at the key's difficulty
+	// unless max_number asks about another.
+	now := time.Now().UTC()
+	from, to := now.AddDate(0, 0, -days), now
+	if ranged {
+		from, to = rng.From.UTC(), rng.To.UTC()
+	}
+	maxNumber := key.MaxNumber
+	if m := r.URL.Query().Get("max_number"); m != "" {
+		parsed, err := strconv.ParseInt(m, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "max_number must be a positive integer"})
+			return
+		}
+		maxNumber = parsed
+	}
+	solveTimes, err := models.GetSolveTimeStats(h.DB, key.ID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
+		return
+	}
+	solveTimes.EstimateFor(maxNumber)
+
+	resp := map[string]interface{}{
+		"key_id":      key.KeyID,
+		"name":        key.Name,
+		"days":        stats,
+		"solve_times": solveTimes,
+	}
+	if ranged {
+		series, err := models.GetStatsSeries(h.DB, rng, models.AllProjects, key.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
+			return
+		}
+		resp["series"] = series
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // POST /api/admin/change-password
@@ -331,16 +835,30 @@ func (h *AdminHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok, err := auth.ValidateCredentials(h.DB, "admin", req.CurrentPassword)
+	username := GetAdminUserFromContext(r)
+	entry := models.AuditEntry{Action: models.AuditPasswordChange, TargetType: auditTargetUser, TargetID: username}
+
+	ok, err := auth.ValidateCredentials(h.DB, username, req.CurrentPassword)
 	if err != nil || !ok {
+		h.audit(r, entry, nil, nil)
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid current password"})
 		return
 	}
 
-	if err := auth.ChangePassword(h.DB, "admin", req.NewPassword); err != nil {
+	if err := auth.ChangePassword(h.DB, username, req.NewPassword); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to change password"})
 		return
 	}
+	entry.Success = true
+	h.audit(r, entry, map[string]string{"password": req.CurrentPassword}, map[string]string{"password": req.NewPassword})
+
+	// Sign out every other session; a stolen token must not outlive the
+	// password it was obtained with.
+	if user, err := auth.GetAdminUserByUsername(h.DB, username); err == nil {
+		if _, err := auth.RevokeUserSessions(h.DB, user.ID, GetAdminSessionFromContext(r)); err != nil {
+			slog.Error("failed to revoke sessions", "error", err, "user", username)
+		}
+	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "password changed"})
 }
@@ -367,6 +885,12 @@ func (h *AdminHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before, err := models.GetLoginCaptchaEnabled(h.DB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch settings"})
+		return
+	}
+
 	if req.LoginCaptchaEnabled != nil {
 		val := "false"
 		if *req.LoginCaptchaEnabled {
@@ -383,6 +907,8 @@ func (h *AdminHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	enabled, _ := models.GetLoginCaptchaEnabled(h.DB)
+	h.audit(r, models.AuditEntry{Action: models.AuditSettingsUpdate, TargetType: auditTargetSettings, Success: true},
+		map[string]bool{"login_captcha_enabled": before}, map[string]bool{"login_captcha_enabled": enabled})
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"login_captcha_enabled": enabled,
 	})
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+)
+
+const (
+	auditTargetKey        = "api_key"
+	auditTargetSettings   = "settings"
+	auditTargetUser       = "admin_user"
+	auditTargetSigningKey = "signing_key"
+	auditTargetProject    = "project"
+	auditTargetToken      = "admin_token"
+	auditTargetWebhook    = "webhook"
+)
+
+// audit records an admin action with the diff between before and after.
+// The actor defaults to the authenticated admin. Failures are logged; the
+// action itself has already happened.
+func (h *AdminHandler) audit(r *http.Request, e models.AuditEntry, before, after interface{}) {
+	if e.Actor == "" {
+		e.Actor = GetAdminUserFromContext(r)
+	}
+	e.IP = clientIP(r)
+	e.Changes = models.AuditDiff(before, after)
+	if err := models.InsertAuditEntry(h.DB, e); err != nil {
+		slog.Error("failed to write audit log", "error", err, "action", e.Action)
+	}
+}
+
+// GET /api/admin/audit
+func (h *AdminHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		From:       q.Get("from"),
+		To:         q.Get("to"),
+	}
+	for _, ts := range []string{filter.From, filter.To} {
+		if ts == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from and to must be RFC 3339 timestamps"})
+			return
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			filter.Limit = parsed
+		}
+	}
+	if o := q.Get("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			filter.Offset = parsed
+		}
+	}
+
+	entries, total, err := models.ListAuditEntries(h.DB, filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list audit log"})
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"offset":  filter.Offset,
+	})
+}
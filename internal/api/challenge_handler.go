@@ -4,13 +4,20 @@ import (
 	"database/sql"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/altcha"
+	"github.com/Upellift99/GateCHA/internal/difficulty"
+	"github.com/Upellift99/GateCHA/internal/metrics"
 	"github.com/Upellift99/GateCHA/internal/models"
 )
 
 type ChallengeHandler struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Difficulty *difficulty.Tracker
+	Events     *EventOptions
 }
 
 func (h *ChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -20,15 +27,40 @@ func (h *ChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	challenge, err := altcha.GenerateChallenge(key.HMACSecret, key.MaxNumber, key.Algorithm, key.ExpireSeconds)
+	// The issue time is signed with the salt, so timing checks do not
+	// depend on what the client reports.
+	params := url.Values{}
+	params.Set("issued", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if origin := requestOrigin(r); origin != "" {
+		params.Set("origin", origin)
+	}
+
+	maxNumber := key.MaxNumber
+	if key.AdaptiveDifficulty && h.Difficulty != nil {
+		tag := difficulty.ClientTag(key.HMACSecret, difficulty.ClientPrefix(clientIP(r)))
+		params.Set("client", tag)
+		pressure := h.Difficulty.RecordChallenge(trackerKey(key.ID, tag))
+		min, max := key.DifficultyBounds()
+		maxNumber = difficulty.Scale(pressure, min, max, key.DifficultyCurve)
+	}
+
+	challenge, err := altcha.GenerateChallengeWithParams(key.HMACSecret, maxNumber, key.Algorithm, key.ExpireSeconds, params)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate challenge"})
 		return
 	}
 
+	metrics.ChallengesIssued.Inc(key.KeyID)
 	if err := models.IncrementChallengesIssued(h.DB, key.ID); err != nil {
 		slog.Error("failed to increment challenges_issued", "error", err, "api_key_id", key.ID)
 	}
+	recordEvent(h.DB, h.Events, r, key, models.VerificationEvent{Kind: models.EventChallenge, Outcome: models.EventIssued})
 
 	writeJSON(w, http.StatusOK, challenge)
 }
+
+// trackerKey scopes a client tag to one API key, since difficulty bounds
+// and escalation are configured per key.
+func trackerKey(apiKeyID int64, tag string) string {
+	return strconv.FormatInt(apiKeyID, 10) + ":" + tag
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+)
+
+// Client IP policies of the verification event log.
+const (
+	IPPolicyRaw  = "raw"
+	IPPolicyHash = "hash"
+	IPPolicyNone = "none"
+)
+
+// EventOptions enables the verification event log.
+type EventOptions struct {
+	// SampleRate is the share of requests recorded, from 0 to 1.
+	SampleRate float64
+	// IPPolicy says how client IPs are stored: raw, hash or none.
+	IPPolicy string
+	// IPHashKey keys the HMAC that hashes client IPs.
+	IPHashKey string
+}
+
+// sampled reports whether to record the current request.
+func (o *EventOptions) sampled() bool {
+	return o != nil && o.SampleRate > 0 && rand.Float64() < o.SampleRate
+}
+
+// clientIP returns ip as stored under the IP policy.
+func (o *EventOptions) clientIP(ip string) string {
+	switch o.IPPolicy {
+	case IPPolicyRaw:
+		return ip
+	case IPPolicyHash:
+		mac := hmac.New(sha256.New, []byte(o.IPHashKey))
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil))[:32]
+	}
+	return ""
+}
+
+// recordEvent writes a sampled challenge or verification request to the
+// event log. Failures are logged; the request itself goes on.
+func recordEvent(db *sql.DB, opts *EventOptions, r *http.Request, key *models.APIKey, e models.VerificationEvent) {
+	if !opts.sampled() {
+		return
+	}
+	e.APIKeyID = key.ID
+	e.ClientIP = opts.clientIP(clientIP(r))
+	if e.Origin == "" {
+		e.Origin = requestOrigin(r)
+	}
+	e.UserAgent = r.UserAgent()
+	if err := models.InsertVerificationEvent(db, e); err != nil {
+		slog.Error("failed to record verification event", "error", err, "api_key_id", key.ID)
+	}
+}
+
+// GET /api/admin/events
+func (h *AdminHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.EventFilter{
+		Kind:    q.Get("kind"),
+		Outcome: q.Get("outcome"),
+		Origin:  q.Get("origin"),
+	}
+	if filter.Kind != "" && filter.Kind != models.EventChallenge && filter.Kind != models.EventVerify {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be challenge or verify"})
+		return
+	}
+	if id := q.Get("api_key_id"); id != "" {
+		parsed, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidKeyID})
+			return
+		}
+		filter.APIKeyID = parsed
+	}
+	// Searching by IP matches however the IPs were stored.
+	if ip := q.Get("ip"); ip != "" {
+		filter.ClientIP = ip
+		if h.Events != nil && h.Events.IPPolicy == IPPolicyHash {
+			filter.ClientIP = h.Events.clientIP(ip)
+		}
+	}
+	for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from and to must be RFC 3339 timestamps"})
+			return
+		}
+		*dest = t
+	}
+	if c := q.Get("cursor"); c != "" {
+		parsed, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		filter.Before = parsed
+	}
+	if l := q.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			filter.Limit = parsed
+		}
+	}
+
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	filter.Scope = scope
+
+	events, next, err := models.ListVerificationEvents(h.DB, filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list events"})
+		return
+	}
+	if events == nil {
+		events = []models.VerificationEvent{}
+	}
+	resp := map[string]interface{}{"events": events, "next_cursor": ""}
+	if next != 0 {
+		resp["next_cursor"] = strconv.FormatInt(next, 10)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
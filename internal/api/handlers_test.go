@@ -2,19 +2,31 @@ package api
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/metrics"
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/oidc"
+	"github.com/Upellift99/GateCHA/internal/oidc/oidctest"
+	"github.com/Upellift99/GateCHA/internal/replay"
 	"github.com/Upellift99/GateCHA/internal/testutil"
+	"github.com/Upellift99/GateCHA/internal/token"
+
+	lib "github.com/altcha-org/altcha-lib-go"
 )
 
 const testSecretKey = "test-secret-key-for-jwt"
@@ -23,13 +35,27 @@ func setupTestRouter(t *testing.T) (http.Handler, *sql.DB) {
 	t.Helper()
 	db := testutil.SetupTestDB(t)
 	auth.EnsureAdminUser(db, "admin", "password123")
-	router := NewRouter(db, testSecretKey, true)
+	router := NewRouter(db, Options{SecretKey: testSecretKey, CORSAllowAll: true})
 	return router, db
 }
 
-func getAdminToken(t *testing.T) string {
+func getAdminToken(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	return getUserToken(t, db, "admin")
+}
+
+// getUserToken starts a session for an existing admin user.
+func getUserToken(t *testing.T, db *sql.DB, username string) string {
 	t.Helper()
-	token, _, err := auth.GenerateJWT("admin", testSecretKey)
+	user, err := auth.GetAdminUserByUsername(db, username)
+	if err != nil {
+		t.Fatalf("failed to load user %s: %v", username, err)
+	}
+	session, _, err := auth.CreateSession(db, user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	token, _, err := auth.GenerateJWT(user.Username, user.Role, session.ID, testSecretKey)
 	if err != nil {
 		t.Fatalf("failed to generate test token: %v", err)
 	}
@@ -90,8 +116,8 @@ func TestLogin_InvalidBody(t *testing.T) {
 }
 
 func TestMe(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("GET", "/api/admin/me", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -122,8 +148,8 @@ func TestMe_Unauthorized(t *testing.T) {
 }
 
 func TestKeyCRUD(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	// Create
 	body, _ := json.Marshal(map[string]interface{}{
@@ -201,8 +227,8 @@ func TestKeyCRUD(t *testing.T) {
 }
 
 func TestCreateKey_InvalidBody(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("POST", "/api/admin/keys", bytes.NewReader([]byte("bad")))
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -216,8 +242,8 @@ func TestCreateKey_InvalidBody(t *testing.T) {
 }
 
 func TestGetKey_NotFound(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("GET", "/api/admin/keys/99999", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -230,8 +256,8 @@ func TestGetKey_NotFound(t *testing.T) {
 }
 
 func TestGetKey_InvalidID(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("GET", "/api/admin/keys/abc", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -244,8 +270,8 @@ func TestGetKey_InvalidID(t *testing.T) {
 }
 
 func TestUpdateKey_InvalidID(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	body, _ := json.Marshal(map[string]string{"name": "test"})
 	req := httptest.NewRequest("PUT", "/api/admin/keys/abc", bytes.NewReader(body))
@@ -260,8 +286,8 @@ func TestUpdateKey_InvalidID(t *testing.T) {
 }
 
 func TestUpdateKey_NotFound(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	body, _ := json.Marshal(map[string]string{"name": "test"})
 	req := httptest.NewRequest("PUT", "/api/admin/keys/99999", bytes.NewReader(body))
@@ -276,8 +302,8 @@ func TestUpdateKey_NotFound(t *testing.T) {
 }
 
 func TestDeleteKey_InvalidID(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("DELETE", "/api/admin/keys/abc", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -290,8 +316,8 @@ func TestDeleteKey_InvalidID(t *testing.T) {
 }
 
 func TestRotateSecret_InvalidID(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("POST", "/api/admin/keys/abc/rotate-secret", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -305,7 +331,7 @@ func TestRotateSecret_InvalidID(t *testing.T) {
 
 func TestStatsEndpoints(t *testing.T) {
 	router, db := setupTestRouter(t)
-	token := getAdminToken(t)
+	token := getAdminToken(t, db)
 
 	key, _ := models.CreateAPIKey(db, "Stats Key", "", 0, 0, "")
 	models.IncrementChallengesIssued(db, key.ID)
@@ -352,8 +378,8 @@ func TestStatsEndpoints(t *testing.T) {
 }
 
 func TestKeyStats_InvalidID(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("GET", "/api/admin/stats/keys/abc", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -366,8 +392,8 @@ func TestKeyStats_InvalidID(t *testing.T) {
 }
 
 func TestKeyStats_NotFound(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("GET", "/api/admin/stats/keys/99999", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -380,8 +406,8 @@ func TestKeyStats_NotFound(t *testing.T) {
 }
 
 func TestChangePassword_Success(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	body, _ := json.Marshal(map[string]string{
 		"current_password": "password123",
@@ -399,8 +425,8 @@ func TestChangePassword_Success(t *testing.T) {
 }
 
 func TestChangePassword_WrongCurrent(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	body, _ := json.Marshal(map[string]string{
 		"current_password": "wrong",
@@ -418,8 +444,8 @@ func TestChangePassword_WrongCurrent(t *testing.T) {
 }
 
 func TestChangePassword_InvalidBody(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("POST", "/api/admin/change-password", bytes.NewReader([]byte("bad")))
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -433,8 +459,8 @@ func TestChangePassword_InvalidBody(t *testing.T) {
 }
 
 func TestSettingsEndpoints(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	// Get settings
 	req := httptest.NewRequest("GET", "/api/admin/settings", nil)
@@ -476,8 +502,8 @@ func TestSettingsEndpoints(t *testing.T) {
 }
 
 func TestUpdateSettings_InvalidBody(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("PUT", "/api/admin/settings", bytes.NewReader([]byte("bad")))
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -515,6 +541,44 @@ func TestChallengeEndpoint(t *testing.T) {
 	}
 }
 
+func TestMetricsEndpoint(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey, Metrics: metrics.Default.Handler("scrape-token")})
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := metrics.ChallengesIssued.Value(key.KeyID); got != 1 {
+		t.Errorf("expected 1 challenge issued, got %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`gatecha_challenges_issued_total{key="` + key.KeyID + `"} 1`,
+		`gatecha_http_request_duration_seconds_count{method="GET",route="/api/v1/challenge",code="200"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
 func TestChallengeEndpoint_NoKey(t *testing.T) {
 	router, _ := setupTestRouter(t)
 
@@ -532,6 +596,7 @@ func TestVerifyEndpoint_InvalidBody(t *testing.T) {
 	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
 
 	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader([]byte("bad")))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -547,6 +612,7 @@ func TestVerifyEndpoint_EmptyPayload(t *testing.T) {
 
 	body, _ := json.Marshal(map[string]string{"payload": ""})
 	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -562,6 +628,7 @@ func TestVerifyEndpoint_InvalidPayload(t *testing.T) {
 
 	body, _ := json.Marshal(map[string]string{"payload": "not-base64!!!"})
 	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -584,6 +651,7 @@ func TestVerifyEndpoint_InvalidBase64Content(t *testing.T) {
 	// Valid base64 but not valid JSON inside
 	body, _ := json.Marshal(map[string]string{"payload": "bm90anNvbg=="}) // "notjson" in base64
 	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -612,6 +680,7 @@ func TestVerifyEndpoint_ValidBase64InvalidSolution(t *testing.T) {
 
 	body, _ := json.Marshal(map[string]string{"payload": payloadB64})
 	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -710,6 +779,49 @@ func solveChallenge(t *testing.T, challenge, salt string, maxNumber int64) int {
 	return -1
 }
 
+// solvedPayload fetches a challenge for keyID and returns a base64 payload solving it.
+func solvedPayload(t *testing.T, router http.Handler, keyID string) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+keyID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("challenge: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var challenge struct {
+		Algorithm string `json:"algorithm"`
+		Challenge string `json:"challenge"`
+		MaxNumber int64  `json:"maxnumber"`
+		Salt      string `json:"salt"`
+		Signature string `json:"signature"`
+	}
+	json.NewDecoder(w.Body).Decode(&challenge)
+
+	number := solveChallenge(t, challenge.Challenge, challenge.Salt, challenge.MaxNumber)
+	payloadJSON, _ := json.Marshal(map[string]interface{}{
+		"algorithm": challenge.Algorithm,
+		"challenge": challenge.Challenge,
+		"number":    number,
+		"salt":      challenge.Salt,
+		"signature": challenge.Signature,
+	})
+	return base64.StdEncoding.EncodeToString(payloadJSON)
+}
+
+// withTook adds the solving time the widget reports to an encoded payload.
+func withTook(t *testing.T, payload string, took interface{}) string {
+	t.Helper()
+	decoded, _ := base64.StdEncoding.DecodeString(payload)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(decoded, &fields); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	fields["took"] = took
+	encoded, _ := json.Marshal(fields)
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
 func TestVerifyEndpoint_FullFlow(t *testing.T) {
 	router, db := setupTestRouter(t)
 	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
@@ -749,6 +861,7 @@ func TestVerifyEndpoint_FullFlow(t *testing.T) {
 	// Verify - should succeed
 	body, _ := json.Marshal(map[string]string{"payload": payloadB64})
 	req = httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -766,6 +879,7 @@ func TestVerifyEndpoint_FullFlow(t *testing.T) {
 	// Replay should fail
 	body, _ = json.Marshal(map[string]string{"payload": payloadB64})
 	req = httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
 	req.Header.Set("Content-Type", "application/json")
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -778,6 +892,363 @@ func TestVerifyEndpoint_FullFlow(t *testing.T) {
 	if respReplay.Error != "already_used" {
 		t.Errorf("expected error 'already_used', got %q", respReplay.Error)
 	}
+
+	stats, _ := models.GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 || stats[0].FailureReasons.AlreadyUsed != 1 {
+		t.Errorf("expected the replay to be counted as already_used, got %+v", stats)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	solveTimes, _ := models.GetSolveTimeStats(db, key.ID, today, today)
+	if solveTimes.Count != 0 || solveTimes.FallbackCount != 1 {
+		t.Errorf("expected the solve time without took as a fallback, got %d reported and %d fallback", solveTimes.Count, solveTimes.FallbackCount)
+	}
+}
+
+func TestVerifyEndpoint_FailureReasonStats(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	invalidSolution, _ := json.Marshal(map[string]interface{}{
+		"algorithm": "SHA-256", "challenge": "abcdef", "number": 42, "salt": "salt", "signature": "invalid",
+	})
+	for _, payload := range []string{
+		"not-base64!!!",
+		base64.StdEncoding.EncodeToString([]byte("notjson")),
+		base64.StdEncoding.EncodeToString(invalidSolution),
+		base64.StdEncoding.EncodeToString(invalidSolution),
+	} {
+		body, _ := json.Marshal(map[string]string{"payload": payload})
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	stats, _ := models.GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 stat row, got %d", len(stats))
+	}
+	got := stats[0]
+	want := models.FailureReasons{InvalidEncoding: 1, InvalidFormat: 1, InvalidSolution: 2}
+	if got.VerificationsFail != 4 || got.FailureReasons != want {
+		t.Errorf("expected 4 failures with reasons %+v, got %d %+v", want, got.VerificationsFail, got.FailureReasons)
+	}
+}
+
+func TestVerificationEvents(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	auth.EnsureAdminUser(db, "admin", "password123")
+	router := NewRouter(db, Options{SecretKey: testSecretKey, Events: &EventOptions{SampleRate: 1, IPPolicy: IPPolicyHash, IPHashKey: "hash-key"}})
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+	req.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var challenge struct {
+		Algorithm string `json:"algorithm"`
+		Challenge string `json:"challenge"`
+		MaxNumber int64  `json:"maxnumber"`
+		Salt      string `json:"salt"`
+		Signature string `json:"signature"`
+	}
+	json.NewDecoder(w.Body).Decode(&challenge)
+
+	payloadJSON, _ := json.Marshal(map[string]interface{}{
+		"algorithm": challenge.Algorithm,
+		"challenge": challenge.Challenge,
+		"number":    solveChallenge(t, challenge.Challenge, challenge.Salt, challenge.MaxNumber),
+		"salt":      challenge.Salt,
+		"signature": challenge.Signature,
+		"took":      1234,
+	})
+	body, _ := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(payloadJSON)})
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	list := func(query string) ([]models.VerificationEvent, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/admin/events"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /api/admin/events%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Events     []models.VerificationEvent `json:"events"`
+			NextCursor string                     `json:"next_cursor"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Events, resp.NextCursor
+	}
+
+	events, _ := list("")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v", events)
+	}
+	replayed, verified, issued := events[0], events[1], events[2]
+	if issued.Kind != models.EventChallenge || issued.Outcome != models.EventIssued || issued.UserAgent != "test-agent" {
+		t.Errorf("unexpected challenge event: %+v", issued)
+	}
+	if verified.Outcome != models.EventOK || verified.Took != 1234 || verified.KeyID != key.KeyID {
+		t.Errorf("unexpected verify event: %+v", verified)
+	}
+	if replayed.Outcome != models.FailAlreadyUsed {
+		t.Errorf("expected already_used, got %q", replayed.Outcome)
+	}
+	if issued.ClientIP == "" || issued.ClientIP == "192.0.2.1" {
+		t.Errorf("expected a hashed client IP, got %q", issued.ClientIP)
+	}
+
+	events, _ = list("?kind=verify&outcome=ok")
+	if len(events) != 1 || events[0].ID != verified.ID {
+		t.Errorf("expected only the successful verification, got %+v", events)
+	}
+	events, _ = list("?ip=192.0.2.1")
+	if len(events) != 3 {
+		t.Errorf("expected the raw IP to match the hashed events, got %d", len(events))
+	}
+
+	page, cursor := list("?limit=2")
+	if len(page) != 2 || cursor == "" {
+		t.Fatalf("expected a first page of 2 with a cursor, got %d %q", len(page), cursor)
+	}
+	page, cursor = list("?limit=2&cursor=" + cursor)
+	if len(page) != 1 || page[0].ID != issued.ID || cursor != "" {
+		t.Errorf("expected the last event and no cursor, got %+v %q", page, cursor)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/events?kind=other", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown kind, got %d", w.Code)
+	}
+}
+
+func TestSolveTime(t *testing.T) {
+	key := &models.APIKey{ExpireSeconds: 300}
+	now := time.Now()
+
+	reported := solutionPayload{Took: 1500}
+	if ms, rep, ok := solveTime(key, reported, now); !ok || !rep || ms != 1500 {
+		t.Errorf("expected the reported 1500 ms, got %d %v %v", ms, rep, ok)
+	}
+
+	// Issued 2 seconds ago: expires in 298 seconds.
+	expires := now.Add(298 * time.Second).Unix()
+	issued := solutionPayload{Payload: lib.Payload{Salt: "abc?expires=" + strconv.FormatInt(expires, 10) + "&"}}
+	if ms, rep, ok := solveTime(key, issued, now); !ok || rep || ms < 1000 || ms > 3000 {
+		t.Errorf("expected about 2000 ms since issue, got %d %v %v", ms, rep, ok)
+	}
+
+	if _, _, ok := solveTime(key, solutionPayload{}, now); ok {
+		t.Error("expected no solve time without took or expires")
+	}
+}
+
+func TestSolutionPayload_Took(t *testing.T) {
+	tests := []struct {
+		took string
+		want tookMillis
+	}{
+		{`1500`, 1500},
+		{`1234.5`, 1235},
+		{`1234.4`, 1234},
+		{`"1500"`, 0},
+		{`-20`, 0},
+		{`1e300`, 0},
+		{`null`, 0},
+	}
+	for _, tt := range tests {
+		var payload solutionPayload
+		if err := json.Unmarshal([]byte(`{"challenge":"abc","number":1,"took":`+tt.took+`}`), &payload); err != nil {
+			t.Errorf("took %s: unexpected error %v", tt.took, err)
+			continue
+		}
+		if payload.Took != tt.want || payload.Challenge != "abc" {
+			t.Errorf("took %s: got %d (challenge %q), want %d", tt.took, payload.Took, payload.Challenge, tt.want)
+		}
+	}
+}
+
+func TestVerifyEndpoint_FractionalTook(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "")
+
+	body, _ := json.Marshal(map[string]string{"payload": withTook(t, solvedPayload(t, router, key.KeyID), 1234.5)})
+	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp verifyResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.OK {
+		t.Fatalf("expected a fractional took to verify, got %+v", resp)
+	}
+}
+
+func TestTimingViolation(t *testing.T) {
+	now := time.Now()
+	issuedAgo := func(d time.Duration, took int64) solutionPayload {
+		salt := "abc?issued=" + strconv.FormatInt(now.Add(-d).UnixMilli(), 10) + "&"
+		return solutionPayload{Payload: lib.Payload{Salt: salt}, Took: tookMillis(took)}
+	}
+
+	tests := []struct {
+		name    string
+		key     models.APIKey
+		payload solutionPayload
+		want    string
+	}{
+		{"no checks", models.APIKey{}, issuedAgo(0, 0), ""},
+		{"took below minimum", models.APIKey{MinSolveMS: 500}, issuedAgo(time.Minute, 100), reasonTooFast},
+		{"took missing on an old challenge", models.APIKey{MinSolveMS: 500}, issuedAgo(time.Minute, 0), ""},
+		{"took missing on a fresh challenge", models.APIKey{MinSolveMS: 500}, issuedAgo(100*time.Millisecond, 0), reasonTooFast},
+		{"took faked above minimum", models.APIKey{MinSolveMS: 500}, issuedAgo(100*time.Millisecond, 2000), reasonTooFast},
+		{"solved slowly enough", models.APIKey{MinSolveMS: 500}, issuedAgo(time.Second, 800), ""},
+		{"submitted too soon", models.APIKey{MinChallengeAgeMS: 3000}, issuedAgo(time.Second, 800), reasonTooFast},
+		{"submitted too late", models.APIKey{MaxChallengeAgeSeconds: 60}, issuedAgo(2*time.Minute, 800), reasonTooLate},
+		{"within age limits", models.APIKey{MinChallengeAgeMS: 3000, MaxChallengeAgeSeconds: 60}, issuedAgo(10*time.Second, 800), ""},
+		{"no issue time", models.APIKey{MaxChallengeAgeSeconds: 60}, solutionPayload{Took: 800}, ""},
+	}
+	for _, tc := range tests {
+		if got := timingViolation(&tc.key, tc.payload, now); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyEndpoint_TimingEnforcement(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "")
+	params := key.UpdateParams()
+	params.MinSolveMS = 60000
+	models.UpdateAPIKey(db, key.ID, params)
+
+	verify := func() verifyResponse {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"payload": withTook(t, solvedPayload(t, router, key.KeyID), 100)})
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp verifyResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	if resp := verify(); resp.OK || resp.Error != reasonTooFast {
+		t.Errorf("expected too_fast rejection, got %+v", resp)
+	}
+
+	params.TimingEnforcement = models.TimingEnforcementFlag
+	models.UpdateAPIKey(db, key.ID, params)
+	if resp := verify(); !resp.OK || resp.Flagged != reasonTooFast {
+		t.Errorf("expected an accepted, flagged solution, got %+v", resp)
+	}
+
+	stats, _ := models.GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 stat row, got %d", len(stats))
+	}
+	if s := stats[0]; s.FailureReasons.TooFast != 1 || s.TimingFlagged != 1 || s.VerificationsOK != 1 {
+		t.Errorf("expected one too_fast failure and one flagged success, got %+v", s)
+	}
+}
+
+func TestUpdateKey_Timing(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	path := "/api/admin/keys/" + strconv.FormatInt(key.ID, 10)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put(`{"min_solve_ms": 300, "min_challenge_age_ms": 2000, "max_challenge_age_seconds": 120, "timing_enforcement": "flag"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated models.APIKey
+	json.NewDecoder(w.Body).Decode(&updated)
+	if updated.MinSolveMS != 300 || updated.MinChallengeAgeMS != 2000 || updated.MaxChallengeAgeSeconds != 120 || updated.TimingEnforcement != models.TimingEnforcementFlag {
+		t.Errorf("unexpected timing settings: %+v", updated)
+	}
+
+	for _, body := range []string{
+		`{"timing_enforcement": "ignore"}`,
+		`{"min_solve_ms": -1}`,
+		`{"min_challenge_age_ms": 200000}`,
+	} {
+		if w := put(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestKeyStats_SolveTimes(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100000, 300, "")
+	models.RecordSolveTime(db, key.ID, 400, 20000, true)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/admin/stats/keys/"+strconv.FormatInt(key.ID, 10)+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		SolveTimes models.SolveTimeStats `json:"solve_times"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.SolveTimes.Count != 1 || resp.SolveTimes.Estimate == nil {
+		t.Fatalf("expected one solve and an estimate, got %+v", resp.SolveTimes)
+	}
+	// 20000 numbers in 400 ms: 100000 take 2 seconds at most.
+	if est := resp.SolveTimes.Estimate; est.MaxNumber != 100000 || est.MaxMS != 2000 || est.MeanMS != 1000 {
+		t.Errorf("unexpected estimate: %+v", est)
+	}
+
+	json.NewDecoder(get("?max_number=50000").Body).Decode(&resp)
+	if est := resp.SolveTimes.Estimate; est == nil || est.MaxNumber != 50000 || est.MaxMS != 1000 {
+		t.Errorf("expected an estimate at max_number 50000, got %+v", est)
+	}
+
+	if w := get("?max_number=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for max_number=0, got %d", w.Code)
+	}
+}
+
+func TestVerificationEvents_Disabled(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil))
+
+	events, _, _ := models.ListVerificationEvents(db, models.EventFilter{Scope: models.AllProjects})
+	if len(events) != 0 {
+		t.Errorf("expected no events without an event log, got %d", len(events))
+	}
 }
 
 func TestChallengeEndpoint_CustomSettings(t *testing.T) {
@@ -804,7 +1275,7 @@ func TestChallengeEndpoint_CustomSettings(t *testing.T) {
 
 func TestUpdateKey_AllFields(t *testing.T) {
 	router, db := setupTestRouter(t)
-	token := getAdminToken(t)
+	token := getAdminToken(t, db)
 
 	key, _ := models.CreateAPIKey(db, "Original", "", 0, 0, "")
 	idStr := strconv.FormatInt(key.ID, 10)
@@ -840,7 +1311,7 @@ func TestUpdateKey_AllFields(t *testing.T) {
 
 func TestUpdateKey_InvalidBody(t *testing.T) {
 	router, db := setupTestRouter(t)
-	token := getAdminToken(t)
+	token := getAdminToken(t, db)
 
 	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
 	idStr := strconv.FormatInt(key.ID, 10)
@@ -857,8 +1328,8 @@ func TestUpdateKey_InvalidBody(t *testing.T) {
 }
 
 func TestDeleteKey_NotFound(t *testing.T) {
-	router, _ := setupTestRouter(t)
-	token := getAdminToken(t)
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
 
 	req := httptest.NewRequest("DELETE", "/api/admin/keys/99999", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -873,7 +1344,7 @@ func TestDeleteKey_NotFound(t *testing.T) {
 
 func TestRotateSecret_Success(t *testing.T) {
 	router, db := setupTestRouter(t)
-	token := getAdminToken(t)
+	token := getAdminToken(t, db)
 
 	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
 	idStr := strconv.FormatInt(key.ID, 10)
@@ -899,7 +1370,7 @@ func TestRotateSecret_Success(t *testing.T) {
 
 func TestKeyStats_WithDaysParam(t *testing.T) {
 	router, db := setupTestRouter(t)
-	token := getAdminToken(t)
+	token := getAdminToken(t, db)
 
 	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
 	idStr := strconv.FormatInt(key.ID, 10)
@@ -914,6 +1385,49 @@ func TestKeyStats_WithDaysParam(t *testing.T) {
 	}
 }
 
+func TestStats_RangeAndBucket(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	models.IncrementChallengesIssued(db, key.ID)
+	models.IncrementChallengesIssued(db, key.ID)
+	hour := time.Now().UTC().Truncate(time.Hour)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	query := "?bucket=hour&from=" + url.QueryEscape(hour.Format(time.RFC3339))
+	for _, path := range []string{"/api/admin/stats/overview" + query, "/api/admin/stats/keys/" + strconv.FormatInt(key.ID, 10) + query} {
+		w := get(path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Series models.StatsSeries `json:"series"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Series.Bucket != models.BucketHour || len(resp.Series.Points) != 1 ||
+			resp.Series.Points[0].Start != hour.Format(time.RFC3339) || resp.Series.Totals.ChallengesIssued != 2 {
+			t.Errorf("GET %s: unexpected series %+v", path, resp.Series)
+		}
+	}
+
+	if w := get("/api/admin/stats/overview"); strings.Contains(w.Body.String(), `"series"`) {
+		t.Errorf("expected no series without range parameters, got %s", w.Body.String())
+	}
+	for _, query := range []string{"?bucket=minute", "?from=yesterday", "?from=2026-03-02&to=2026-03-01", "?bucket=hour&from=2025-01-01&to=2026-01-01"} {
+		if w := get("/api/admin/stats/overview" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
 func TestLogin_WithCaptchaEnabled_ValidCaptcha(t *testing.T) {
 	router, db := setupTestRouter(t)
 
@@ -998,3 +1512,1598 @@ func TestPublicLoginConfig_CaptchaEnabled(t *testing.T) {
 		t.Error("expected challenge_url when captcha enabled")
 	}
 }
+
+func TestVerifyEndpoint_IssueToken(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	payload := solvedPayload(t, router, key.KeyID)
+	body, _ := json.Marshal(map[string]interface{}{"payload": payload, "issue_token": true})
+	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp verifyResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.OK || resp.Token == "" || resp.TokenExpiresAt == nil {
+		t.Fatalf("expected OK with token, got %+v", resp)
+	}
+
+	// Validate offline against the published JWKS
+	req = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("jwks: expected 200, got %d", w.Code)
+	}
+
+	var set token.JWKS
+	json.NewDecoder(w.Body).Decode(&set)
+	claims, err := token.Parse(resp.Token, func(kid string) (ed25519.PublicKey, error) {
+		for _, k := range set.Keys {
+			if k.Kid == kid {
+				return k.PublicKey()
+			}
+		}
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	})
+	if err != nil {
+		t.Fatalf("token should validate against JWKS: %v", err)
+	}
+	if claims.KeyID != key.KeyID {
+		t.Errorf("expected key_id %s, got %s", key.KeyID, claims.KeyID)
+	}
+}
+
+func TestVerifyEndpoint_NoTokenByDefault(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	body, _ := json.Marshal(map[string]string{"payload": solvedPayload(t, router, key.KeyID)})
+	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp verifyResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.OK || resp.Token != "" {
+		t.Errorf("expected OK without token, got %+v", resp)
+	}
+}
+
+func TestSigningKeysEndpoints(t *testing.T) {
+	router, db := setupTestRouter(t)
+	adminToken := getAdminToken(t, db)
+	first, _ := models.EnsureSigningKey(db)
+
+	req := httptest.NewRequest("POST", "/api/admin/signing-keys/rotate", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate: expected 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/signing-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		Keys []models.SigningKey `json:"keys"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Keys) != 2 {
+		t.Fatalf("expected 2 signing keys, got %d", len(resp.Keys))
+	}
+	if resp.Keys[0].Status != models.SigningKeyStatusPending {
+		t.Errorf("expected the new key to be pending, got %+v", resp.Keys[0])
+	}
+	if resp.Keys[1].KID != first.KID || resp.Keys[1].Status != models.SigningKeyStatusActive {
+		t.Errorf("expected %s to stay active until promoted, got %+v", first.KID, resp.Keys[1])
+	}
+
+	// The pending key is published before it signs anything.
+	req = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var set token.JWKS
+	json.NewDecoder(w.Body).Decode(&set)
+	if len(set.Keys) != 2 || set.Keys[0].Kid != resp.Keys[0].KID {
+		t.Errorf("expected the pending key in the JWKS, got %+v", set.Keys)
+	}
+	if strings.Contains(w.Body.String(), "private") {
+		t.Error("private key material must not be exposed")
+	}
+}
+
+func TestVerifySignatureEndpoint(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	payload := solvedPayload(t, router, key.KeyID)
+	body, _ := json.Marshal(map[string]string{"payload": payload})
+	req := httptest.NewRequest("POST", "/api/v1/verify/signature?apiKey="+key.KeyID, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp serverSignatureResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Verified || resp.Payload == "" {
+		t.Fatalf("expected verified payload, got %+v", resp)
+	}
+
+	// The site backend checks the signed payload with the key's HMAC secret
+	ok, data, err := lib.VerifyServerSignature(resp.Payload, key.HMACSecret)
+	if err != nil || !ok {
+		t.Fatalf("expected server signature to verify, got ok=%v err=%v", ok, err)
+	}
+	if data.Extra["apiKey"] != key.KeyID {
+		t.Errorf("expected apiKey %s in verification data, got %v", key.KeyID, data.Extra["apiKey"])
+	}
+
+	// Replay is rejected
+	req = httptest.NewRequest("POST", "/api/v1/verify/signature?apiKey="+key.KeyID, bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var replay serverSignatureResponse
+	json.NewDecoder(w.Body).Decode(&replay)
+	if replay.Verified || replay.Payload != "" {
+		t.Errorf("expected replay to be rejected, got %+v", replay)
+	}
+	if replay.Error != "already_used" {
+		t.Errorf("expected error 'already_used', got %q", replay.Error)
+	}
+}
+
+func TestVerifySignatureEndpoint_InvalidPayload(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	body, _ := json.Marshal(map[string]string{"payload": "bm90anNvbg=="})
+	req := httptest.NewRequest("POST", "/api/v1/verify/signature?apiKey="+key.KeyID, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp serverSignatureResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Verified {
+		t.Error("expected verified=false for invalid payload")
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/verify/signature?apiKey="+key.KeyID, bytes.NewReader([]byte("{}")))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for missing payload, got %d", w.Code)
+	}
+}
+
+func TestVerifyEndpoint_MemoryReplayStore(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey, ReplayStore: replay.NewMemoryStore()})
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	body, _ := json.Marshal(map[string]string{"payload": solvedPayload(t, router, key.KeyID)})
+	var results []verifyResponse
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp verifyResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		results = append(results, resp)
+	}
+
+	if !results[0].OK {
+		t.Errorf("expected first verify to succeed, got %q", results[0].Error)
+	}
+	if results[1].OK || results[1].Error != "already_used" {
+		t.Errorf("expected replay to be rejected, got %+v", results[1])
+	}
+
+	var rows int
+	db.QueryRow(`SELECT COUNT(*) FROM consumed_challenges`).Scan(&rows)
+	if rows != 0 {
+		t.Errorf("memory store must not write to consumed_challenges, found %d rows", rows)
+	}
+}
+
+func TestChallengeEndpoint_AdaptiveDifficulty(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Adaptive", "", 1000, 0, "")
+	params := key.UpdateParams()
+	params.AdaptiveDifficulty = true
+	params.DifficultyMax = 50000
+	if err := models.UpdateAPIKey(db, key.ID, params); err != nil {
+		t.Fatalf("UpdateAPIKey failed: %v", err)
+	}
+
+	fetch := func(remoteAddr string) lib.Challenge {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var c lib.Challenge
+		json.NewDecoder(w.Body).Decode(&c)
+		return c
+	}
+
+	first := fetch("192.0.2.1:1234")
+	if first.MaxNumber != 1000 {
+		t.Errorf("expected first challenge at the minimum, got %d", first.MaxNumber)
+	}
+	if !strings.Contains(first.Salt, "client=") {
+		t.Errorf("expected client tag in salt, got %q", first.Salt)
+	}
+
+	var last lib.Challenge
+	for i := 0; i < 20; i++ {
+		last = fetch("192.0.2.1:1234")
+	}
+	if last.MaxNumber <= 1000 || last.MaxNumber > 50000 {
+		t.Errorf("expected escalated difficulty within bounds, got %d", last.MaxNumber)
+	}
+
+	if other := fetch("192.0.2.2:1234"); other.MaxNumber != 1000 {
+		t.Errorf("expected other client at the minimum, got %d", other.MaxNumber)
+	}
+}
+
+func TestVerifyEndpoint_ForgedClientTag(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Adaptive", "", 1000, 0, "")
+	params := key.UpdateParams()
+	params.AdaptiveDifficulty = true
+	params.DifficultyMax = 50000
+	models.UpdateAPIKey(db, key.ID, params)
+
+	fetch := func(remoteAddr string) lib.Challenge {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var c lib.Challenge
+		json.NewDecoder(w.Body).Decode(&c)
+		return c
+	}
+
+	// Payloads with the victim's salt but no valid signature are charged
+	// to the sender, not to the client tag they carry.
+	victim := fetch("192.0.2.1:1234")
+	forged, _ := json.Marshal(map[string]interface{}{
+		"algorithm": victim.Algorithm,
+		"challenge": victim.Challenge,
+		"number":    1,
+		"salt":      victim.Salt,
+		"signature": "forged",
+	})
+	for i := 0; i < 10; i++ {
+		body, _ := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(forged)})
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.RemoteAddr = "198.51.100.9:1234"
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if c := fetch("192.0.2.1:1234"); c.MaxNumber != 1000 {
+		t.Errorf("expected the victim's difficulty to stay at the minimum, got %d", c.MaxNumber)
+	}
+	if c := fetch("198.51.100.9:1234"); c.MaxNumber <= 1000 {
+		t.Errorf("expected the sender's difficulty to rise, got %d", c.MaxNumber)
+	}
+}
+
+func TestUpdateKey_DifficultySettings(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	idStr := strconv.FormatInt(key.ID, 10)
+
+	update := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("PUT", "/api/admin/keys/"+idStr, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := update(map[string]interface{}{
+		"adaptive_difficulty": true,
+		"difficulty_min":      5000,
+		"difficulty_max":      500000,
+		"difficulty_curve":    "exponential",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated models.APIKey
+	json.NewDecoder(w.Body).Decode(&updated)
+	if !updated.AdaptiveDifficulty || updated.DifficultyMin != 5000 ||
+		updated.DifficultyMax != 500000 || updated.DifficultyCurve != "exponential" {
+		t.Errorf("unexpected difficulty settings: %+v", updated)
+	}
+
+	if w := update(map[string]interface{}{"difficulty_curve": "cubic"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown curve, got %d", w.Code)
+	}
+	if w := update(map[string]interface{}{"difficulty_min": 900000}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for min above max, got %d", w.Code)
+	}
+}
+
+func TestVerifySignatureEndpoint_AfterRotation(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	// A challenge issued under the old secret is signed with it, so a
+	// backend that has not picked up the new secret yet still accepts it.
+	payload := solvedPayload(t, router, key.KeyID)
+	models.RotateHMACSecret(db, key.ID, time.Hour)
+
+	body, _ := json.Marshal(map[string]string{"payload": payload})
+	req := httptest.NewRequest("POST", "/api/v1/verify/signature?apiKey="+key.KeyID, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp serverSignatureResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Verified {
+		t.Fatalf("expected verified payload, got %+v", resp)
+	}
+	if ok, _, err := lib.VerifyServerSignature(resp.Payload, key.HMACSecret); err != nil || !ok {
+		t.Errorf("expected the signature to verify with the previous secret, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestRotateSecret_GracePeriod(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+	idStr := strconv.FormatInt(key.ID, 10)
+
+	rotate := func(graceSeconds int) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]int{"grace_period_seconds": graceSeconds})
+		req := httptest.NewRequest("POST", "/api/admin/keys/"+idStr+"/rotate-secret", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("rotate: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	verify := func(payload string) verifyResponse {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"payload": payload})
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, key.VerifySecret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp verifyResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	// A challenge issued before the rotation still verifies during the grace period.
+	payload := solvedPayload(t, router, key.KeyID)
+	rotate(3600)
+	if resp := verify(payload); !resp.OK {
+		t.Errorf("expected pre-rotation solution to verify, got %q", resp.Error)
+	}
+
+	// Without a grace period the old secret stops verifying immediately.
+	payload = solvedPayload(t, router, key.KeyID)
+	rotate(0)
+	if resp := verify(payload); resp.OK || resp.Error != reasonInvalidSolution {
+		t.Errorf("expected invalid_solution after immediate rotation, got %+v", resp)
+	}
+}
+
+func TestKeySecretsEndpoints(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	models.RotateHMACSecret(db, key.ID, time.Hour)
+	idStr := strconv.FormatInt(key.ID, 10)
+
+	req := httptest.NewRequest("GET", "/api/admin/keys/"+idStr+"/secrets", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), key.HMACSecret) {
+		t.Error("secret values must not be listed")
+	}
+	var list struct {
+		Secrets []models.APIKeySecret `json:"secrets"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Secrets) != 2 || list.Secrets[1].Status != models.SecretStatusPrevious {
+		t.Fatalf("unexpected secrets: %+v", list.Secrets)
+	}
+
+	retire := func(secretID int64) int {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/admin/keys/%s/secrets/%d/retire", idStr, secretID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := retire(list.Secrets[0].ID); code != http.StatusBadRequest {
+		t.Errorf("expected 400 retiring the primary secret, got %d", code)
+	}
+	if code := retire(list.Secrets[1].ID); code != http.StatusOK {
+		t.Errorf("expected 200 retiring the previous secret, got %d", code)
+	}
+	if code := retire(99999); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown secret, got %d", code)
+	}
+}
+
+func TestRegenerateVerifySecret(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "", 100, 300, "SHA-256")
+
+	req := httptest.NewRequest("POST", "/api/admin/keys/"+strconv.FormatInt(key.ID, 10)+"/verify-secret", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+	newSecret := resp["verify_secret"]
+	if !strings.HasPrefix(newSecret, "gs_") {
+		t.Fatalf("expected gs_ secret, got %q", newSecret)
+	}
+
+	verify := func(secret string) int {
+		body, _ := json.Marshal(map[string]string{"payload": solvedPayload(t, router, key.KeyID)})
+		req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, bytes.NewReader(body))
+		req.Header.Set(verifySecretHeader, secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := verify(key.VerifySecret); code != http.StatusUnauthorized {
+		t.Errorf("expected old secret to be rejected, got %d", code)
+	}
+	if code := verify(newSecret); code != http.StatusOK {
+		t.Errorf("expected new secret to be accepted, got %d", code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/admin/keys/99999/verify-secret", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown key, got %d", w.Code)
+	}
+}
+
+func TestCreateKey_ReturnsVerifySecretOnce(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	body, _ := json.Marshal(map[string]string{"name": "Site"})
+	req := httptest.NewRequest("POST", "/api/admin/keys", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var created models.APIKey
+	json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.VerifySecret, "gs_") || !created.HasVerifySecret {
+		t.Fatalf("expected verify secret in create response, got %+v", created)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/keys/"+strconv.FormatInt(created.ID, 10), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), created.VerifySecret) {
+		t.Error("verify secret must not be returned after creation")
+	}
+}
+
+func TestKeyAllowedOrigins(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/admin/keys", map[string]interface{}{
+		"name":            "Multi",
+		"allowed_origins": []string{"WWW.example.com", "*.preview.example.com", "http://localhost:5173"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.APIKey
+	json.NewDecoder(w.Body).Decode(&created)
+	if len(created.AllowedOrigins) != 3 || created.AllowedOrigins[0] != "www.example.com" || created.Domain != "www.example.com" {
+		t.Fatalf("unexpected origins: %v (domain %q)", created.AllowedOrigins, created.Domain)
+	}
+	idPath := "/api/admin/keys/" + strconv.FormatInt(created.ID, 10)
+
+	if w := send("PUT", idPath, map[string]interface{}{"allowed_origins": []string{"https://example.com/login"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for origin with a path, got %d", w.Code)
+	}
+
+	w = send("PUT", idPath, map[string]interface{}{"domain": "bücher.example"})
+	var updated models.APIKey
+	json.NewDecoder(w.Body).Decode(&updated)
+	if len(updated.AllowedOrigins) != 1 || updated.AllowedOrigins[0] != "xn--bcher-kva.example" {
+		t.Errorf("expected domain to replace the list, got %v", updated.AllowedOrigins)
+	}
+
+	w = send("PUT", idPath, map[string]interface{}{"allowed_origins": []string{}})
+	json.NewDecoder(w.Body).Decode(&updated)
+	if len(updated.AllowedOrigins) != 0 || updated.Domain != "" {
+		t.Errorf("expected origins to be cleared, got %v (domain %q)", updated.AllowedOrigins, updated.Domain)
+	}
+}
+
+func TestUpdateKey_OriginEnforcement(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	if key.OriginEnforcement != models.OriginEnforcementLenient {
+		t.Fatalf("expected new keys to default to lenient, got %q", key.OriginEnforcement)
+	}
+
+	update := func(mode string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]string{"origin_enforcement": mode})
+		req := httptest.NewRequest("PUT", "/api/admin/keys/"+strconv.FormatInt(key.ID, 10), bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := update("paranoid"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown mode, got %d", w.Code)
+	}
+	w := update(models.OriginEnforcementStrict)
+	var updated models.APIKey
+	json.NewDecoder(w.Body).Decode(&updated)
+	if w.Code != http.StatusOK || updated.OriginEnforcement != models.OriginEnforcementStrict {
+		t.Errorf("expected strict mode to be saved, got %d %q", w.Code, updated.OriginEnforcement)
+	}
+}
+
+func TestUpdateKey_CORSPolicy(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	if key.CORSMaxAge != models.DefaultCORSMaxAge {
+		t.Fatalf("expected default max-age %d, got %d", models.DefaultCORSMaxAge, key.CORSMaxAge)
+	}
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/admin/keys/"+strconv.FormatInt(key.ID, 10), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := update(`{"cors_max_age": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for negative max-age, got %d", w.Code)
+	}
+	if w := update(`{"cors_exposed_headers": ["Bad Header"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid header name, got %d", w.Code)
+	}
+
+	w := update(`{"cors_exposed_headers": ["x-request-id"], "cors_allow_credentials": true, "cors_max_age": 0}`)
+	var updated models.APIKey
+	json.NewDecoder(w.Body).Decode(&updated)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if len(updated.CORSExposedHeaders) != 1 || updated.CORSExposedHeaders[0] != "X-Request-Id" {
+		t.Errorf("expected canonical exposed header, got %v", updated.CORSExposedHeaders)
+	}
+	if !updated.CORSAllowCredentials || updated.CORSMaxAge != 0 {
+		t.Errorf("expected credentials on and max-age 0, got %v %d", updated.CORSAllowCredentials, updated.CORSMaxAge)
+	}
+}
+
+func TestWebhooks_CRUDAndDeliveryLog(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/admin/webhooks", `{"url":"ftp://example.com","events":["key.created"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for non-http URL, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/webhooks", `{"url":"https://hooks.example.com","events":["key.exploded"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown event, got %d", w.Code)
+	}
+
+	w := do("POST", "/api/admin/webhooks", `{"url":"https://hooks.example.com","events":["key.created","key.deleted"],"failure_rate_threshold":0.25}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.Webhook
+	json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.Secret, "whsec_") || created.FailureRateThreshold != 0.25 {
+		t.Errorf("unexpected webhook: %+v", created)
+	}
+	hookPath := "/api/admin/webhooks/" + strconv.FormatInt(created.ID, 10)
+
+	w = do("GET", hookPath, "")
+	var fetched models.Webhook
+	json.NewDecoder(w.Body).Decode(&fetched)
+	if fetched.Secret != "" {
+		t.Error("expected the secret to be returned only on create")
+	}
+
+	// Key events are queued for the webhook.
+	w = do("POST", "/api/admin/keys", `{"name":"Site"}`)
+	var key models.APIKey
+	json.NewDecoder(w.Body).Decode(&key)
+	do("DELETE", "/api/admin/keys/"+strconv.FormatInt(key.ID, 10), "")
+
+	w = do("GET", hookPath+"/deliveries", "")
+	var deliveryLog struct {
+		Deliveries []models.WebhookDelivery `json:"deliveries"`
+	}
+	json.NewDecoder(w.Body).Decode(&deliveryLog)
+	if len(deliveryLog.Deliveries) != 2 || deliveryLog.Deliveries[0].Event != models.EventKeyDeleted || deliveryLog.Deliveries[1].Event != models.EventKeyCreated {
+		t.Fatalf("expected created and deleted deliveries, got %+v", deliveryLog.Deliveries)
+	}
+
+	w = do("POST", hookPath+"/deliveries/"+strconv.FormatInt(deliveryLog.Deliveries[1].ID, 10)+"/resend", "")
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected 202 for resend, got %d", w.Code)
+	}
+	if w := do("POST", hookPath+"/deliveries/9999/resend", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown delivery, got %d", w.Code)
+	}
+
+	w = do("PUT", hookPath, `{"enabled":false}`)
+	var updated models.Webhook
+	json.NewDecoder(w.Body).Decode(&updated)
+	if w.Code != http.StatusOK || updated.Enabled {
+		t.Errorf("expected webhook to be disabled, got %d %+v", w.Code, updated)
+	}
+
+	if w := do("DELETE", hookPath, ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for delete, got %d", w.Code)
+	}
+	if hooks, _ := models.ListWebhooks(db, models.AllProjects); len(hooks) != 0 {
+		t.Errorf("expected no webhooks left, got %d", len(hooks))
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("POST", "/api/admin/login", `{"username":"admin","password":"wrong"}`)
+	do("POST", "/api/admin/login", `{"username":"admin","password":"password123"}`)
+	w := do("POST", "/api/admin/keys", `{"name":"Site"}`)
+	var key models.APIKey
+	json.NewDecoder(w.Body).Decode(&key)
+	keyPath := "/api/admin/keys/" + strconv.FormatInt(key.ID, 10)
+	do("PUT", keyPath, `{"name":"Renamed"}`)
+	do("POST", keyPath+"/rotate-secret", "")
+
+	type auditPage struct {
+		Entries []models.AuditEntry `json:"entries"`
+		Total   int                 `json:"total"`
+	}
+	list := func(query string) auditPage {
+		w := do("GET", "/api/admin/audit"+query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var page auditPage
+		json.NewDecoder(w.Body).Decode(&page)
+		return page
+	}
+
+	page := list("")
+	if page.Total != 5 {
+		t.Fatalf("expected 5 entries, got %d", page.Total)
+	}
+	if e := page.Entries[4]; e.Action != models.AuditLogin || e.Success || e.Actor != "admin" {
+		t.Errorf("expected a failed login first, got %+v", e)
+	}
+
+	page = list("?action=" + models.AuditKeyUpdate)
+	if page.Total != 1 {
+		t.Fatalf("expected 1 update entry, got %d", page.Total)
+	}
+	update := page.Entries[0]
+	if update.Actor != "admin" || update.TargetID != strconv.FormatInt(key.ID, 10) || update.IP == "" {
+		t.Errorf("unexpected update entry: %+v", update)
+	}
+	if c := update.Changes["name"]; c.Before != "Site" || c.After != "Renamed" {
+		t.Errorf("expected name diff, got %+v", update.Changes)
+	}
+	if _, ok := update.Changes["max_number"]; ok {
+		t.Error("expected unchanged fields to be left out of the diff")
+	}
+
+	rotate := list("?action=" + models.AuditKeyRotateSecret).Entries[0]
+	if c := rotate.Changes["hmac_secret"]; c.Before != "[redacted]" || c.After != "[redacted]" {
+		t.Errorf("expected redacted secret diff, got %+v", rotate.Changes)
+	}
+	create := list("?action=" + models.AuditKeyCreate).Entries[0]
+	if c := create.Changes["verify_secret"]; c.After != "[redacted]" {
+		t.Errorf("expected redacted verify secret, got %+v", create.Changes)
+	}
+
+	page = list("?limit=2&offset=1")
+	if page.Total != 5 || len(page.Entries) != 2 || page.Entries[0].Action != models.AuditKeyUpdate {
+		t.Errorf("unexpected page: total=%d entries=%+v", page.Total, page.Entries)
+	}
+
+	if w := do("GET", "/api/admin/audit?from=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid from, got %d", w.Code)
+	}
+}
+
+func TestRoles_ViewerIsReadOnly(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Site", "", 0, 0, "")
+	viewer, _ := auth.CreateAdminUser(db, "viewer", "secret", auth.RoleViewer)
+	token := getUserToken(t, db, "viewer")
+	// Viewers only see the keys of their projects.
+	project, _ := models.CreateProject(db, "Team", "")
+	params := key.UpdateParams()
+	params.ProjectID = project.ID
+	models.UpdateAPIKey(db, key.ID, params)
+	models.SetProjectMembers(db, project.ID, []int64{viewer.ID})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	keyPath := "/api/admin/keys/" + strconv.FormatInt(key.ID, 10)
+
+	for _, path := range []string{"/api/admin/stats/overview", "/api/admin/keys", keyPath} {
+		if w := do("GET", path, ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s: expected 200, got %d", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/api/admin/keys", keyPath} {
+		if body := do("GET", path, "").Body.String(); strings.Contains(body, key.HMACSecret) {
+			t.Errorf("GET %s: expected the HMAC secret to be hidden from viewers", path)
+		}
+	}
+
+	for _, tt := range []struct{ method, path string }{
+		{"POST", "/api/admin/keys"},
+		{"PUT", keyPath},
+		{"DELETE", keyPath},
+		{"POST", keyPath + "/rotate-secret"},
+		{"GET", keyPath + "/secrets"},
+		{"PUT", "/api/admin/settings"},
+		{"GET", "/api/admin/users"},
+	} {
+		if w := do(tt.method, tt.path, `{"name":"x"}`); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403, got %d", tt.method, tt.path, w.Code)
+		}
+	}
+
+	if w := do("GET", "/api/admin/me", ""); !strings.Contains(w.Body.String(), `"role":"viewer"`) {
+		t.Errorf("expected /me to report the viewer role, got %s", w.Body.String())
+	}
+}
+
+func TestRoles_TokenWithoutRoleRejected(t *testing.T) {
+	router, db := setupTestRouter(t)
+	admin, _ := auth.GetAdminUserByUsername(db, "admin")
+	session, _, _ := auth.CreateSession(db, admin.ID, "", "")
+	token, _, _ := auth.GenerateJWT("admin", "", session.ID, testSecretKey)
+
+	req := httptest.NewRequest("GET", "/api/admin/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a token without a role, got %d", w.Code)
+	}
+}
+
+func TestUsersCRUD(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/admin/users", `{"username":"alice","password":"secret","role":"editor"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var alice auth.AdminUser
+	json.NewDecoder(w.Body).Decode(&alice)
+	alicePath := "/api/admin/users/" + strconv.FormatInt(alice.ID, 10)
+
+	if w := do("POST", "/api/admin/users", `{"username":"alice","password":"x"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate username, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/users", `{"username":"bob","password":"x","role":"root"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown role, got %d", w.Code)
+	}
+
+	w = do("PUT", alicePath, `{"role":"owner"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"role":"owner"`) {
+		t.Errorf("expected alice to be promoted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ := json.Marshal(map[string]string{"username": "alice", "password": "secret"})
+	req := httptest.NewRequest("POST", "/api/admin/login", bytes.NewReader(body))
+	lw := httptest.NewRecorder()
+	router.ServeHTTP(lw, req)
+	if !strings.Contains(lw.Body.String(), `"role":"owner"`) {
+		t.Errorf("expected login to return alice's role, got %s", lw.Body.String())
+	}
+
+	w = do("GET", "/api/admin/users", "")
+	var list struct {
+		Users []auth.AdminUser `json:"users"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(list.Users))
+	}
+	adminPath := "/api/admin/users/" + strconv.FormatInt(list.Users[0].ID, 10)
+
+	if w := do("DELETE", alicePath, ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200 deleting alice, got %d", w.Code)
+	}
+	if w := do("DELETE", adminPath, ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 deleting the last owner, got %d", w.Code)
+	}
+	if w := do("PUT", adminPath, `{"role":"viewer"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 demoting the last owner, got %d", w.Code)
+	}
+	if w := do("GET", alicePath, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted user, got %d", w.Code)
+	}
+}
+
+func TestLogin_TOTPTwoStep(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+
+	do := func(method, path, bearer string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/admin/totp/enroll", token, nil)
+	var enrol struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	json.NewDecoder(w.Body).Decode(&enrol)
+	if enrol.Secret == "" || !strings.HasPrefix(enrol.OTPAuthURI, "otpauth://totp/") {
+		t.Fatalf("unexpected enrolment response: %d %+v", w.Code, enrol)
+	}
+
+	if w := do("POST", "/api/admin/totp/confirm", token, map[string]string{"code": "not-a-code"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a wrong confirmation code, got %d", w.Code)
+	}
+	code, _ := auth.TOTPCode(enrol.Secret, time.Now())
+	w = do("POST", "/api/admin/totp/confirm", token, map[string]string{"code": code})
+	var confirm struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.NewDecoder(w.Body).Decode(&confirm)
+	if w.Code != http.StatusOK || len(confirm.RecoveryCodes) == 0 {
+		t.Fatalf("expected recovery codes, got %d: %s", w.Code, w.Body.String())
+	}
+
+	login := func() string {
+		w := do("POST", "/api/admin/login", "", map[string]string{"username": "admin", "password": "password123"})
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["totp_required"] != true || resp["token"] != nil {
+			t.Fatalf("expected a TOTP challenge instead of a token, got %v", resp)
+		}
+		return resp["login_token"].(string)
+	}
+
+	challenge := login()
+	if w := do("GET", "/api/admin/me", challenge, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the login challenge to be rejected as a session, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/login/totp", "", map[string]string{"login_token": challenge, "code": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong code, got %d", w.Code)
+	}
+	w = do("POST", "/api/admin/login/totp", "", map[string]string{"login_token": challenge, "code": confirm.RecoveryCodes[0]})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"token"`) {
+		t.Fatalf("expected a token after the second factor, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := do("POST", "/api/admin/login/totp", "", map[string]string{"login_token": token, "code": confirm.RecoveryCodes[1]}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a session token to be rejected as a login challenge, got %d", w.Code)
+	}
+
+	page, _, _ := models.ListAuditEntries(db, models.AuditFilter{Action: models.AuditLogin})
+	if len(page) != 2 || page[0].Success != true || page[1].Success != false {
+		t.Errorf("expected a failed then a successful login in the audit log, got %+v", page)
+	}
+
+	w = do("POST", "/api/admin/totp/disable", token, map[string]string{"password": "password123", "code": confirm.RecoveryCodes[2]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 disabling TOTP, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do("POST", "/api/admin/login", "", map[string]string{"username": "admin", "password": "password123"})
+	if !strings.Contains(w.Body.String(), `"token"`) {
+		t.Errorf("expected a direct token once TOTP is disabled, got %s", w.Body.String())
+	}
+}
+
+func TestSessions_RefreshLogoutAndRevoke(t *testing.T) {
+	router, _ := setupTestRouter(t)
+
+	do := func(method, path, bearer string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	login := func() tokens {
+		w := do("POST", "/api/admin/login", "", map[string]string{"username": "admin", "password": "password123"})
+		var tk tokens
+		json.NewDecoder(w.Body).Decode(&tk)
+		if tk.Token == "" || tk.RefreshToken == "" {
+			t.Fatalf("expected access and refresh tokens, got %s", w.Body.String())
+		}
+		return tk
+	}
+
+	first := login()
+	w := do("POST", "/api/admin/refresh", "", map[string]string{"refresh_token": first.RefreshToken})
+	var refreshed tokens
+	json.NewDecoder(w.Body).Decode(&refreshed)
+	if w.Code != http.StatusOK || refreshed.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected a rotated refresh token, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/admin/me", refreshed.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("expected the refreshed access token to work, got %d", w.Code)
+	}
+
+	second := login()
+	third := login()
+	w = do("GET", "/api/admin/sessions", second.Token, nil)
+	var list struct {
+		Sessions []auth.Session `json:"sessions"`
+		Current  string         `json:"current"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Sessions) != 3 || list.Current == "" {
+		t.Fatalf("expected 3 sessions, got %s", w.Body.String())
+	}
+
+	if w := do("POST", "/api/admin/logout", third.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("expected 200 on logout, got %d", w.Code)
+	}
+	if w := do("GET", "/api/admin/me", third.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a logged out token to be rejected, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/refresh", "", map[string]string{"refresh_token": third.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a logged out refresh token to be rejected, got %d", w.Code)
+	}
+
+	if w := do("POST", "/api/admin/sessions/revoke-all", second.Token, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"revoked":1`) {
+		t.Errorf("expected 1 other session to be revoked, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/admin/me", refreshed.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked session to be rejected, got %d", w.Code)
+	}
+	if w := do("GET", "/api/admin/me", second.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("expected the current session to survive revoke-all, got %d", w.Code)
+	}
+}
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	router, db := setupTestRouter(t)
+	current := getAdminToken(t, db)
+	other := getAdminToken(t, db)
+
+	body := map[string]string{"current_password": "password123", "new_password": "newpassword"}
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/api/admin/change-password", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+current)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	for token, want := range map[string]int{current: http.StatusOK, other: http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/api/admin/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("expected %d, got %d", want, w.Code)
+		}
+	}
+}
+
+func TestLogin_Lockout(t *testing.T) {
+	router, db := setupTestRouter(t)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"username": "admin", "password": password})
+		req := httptest.NewRequest("POST", "/api/admin/login", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 10; i++ {
+		db.Exec(`UPDATE login_lockouts SET locked_until = ''`)
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	w := login("password123")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After for a locked out user, got %d", w.Code)
+	}
+
+	token := getAdminToken(t, db)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	var list struct {
+		Lockouts []auth.Lockout `json:"lockouts"`
+	}
+	json.NewDecoder(do("GET", "/api/admin/lockouts").Body).Decode(&list)
+	if len(list.Lockouts) != 2 {
+		t.Fatalf("expected user and IP entries, got %+v", list.Lockouts)
+	}
+
+	if w := do("DELETE", "/api/admin/lockouts?scope=host&subject=x"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown scope, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/admin/lockouts?scope=user&subject=admin"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 clearing the user lockout, got %d", w.Code)
+	}
+	if w := login("password123"); w.Code != http.StatusOK {
+		t.Errorf("expected login to work once the lockout is cleared, got %d", w.Code)
+	}
+}
+
+func TestLogin_CaptchaCheckedBeforePassword(t *testing.T) {
+	router, db := setupTestRouter(t)
+	models.SetSetting(db, models.SettingLoginCaptchaEnabled, "true")
+	models.EnsureLoginCaptchaAPIKey(db)
+
+	body, _ := json.Marshal(map[string]string{"username": "admin", "password": "wrong"})
+	req := httptest.NewRequest("POST", "/api/admin/login", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "captcha required") {
+		t.Errorf("expected the missing captcha to be reported before the password, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// setupOIDCRouter returns a router whose single sign-on points at an
+// in-process issuer mapping the "admins" group to owner and "devs" to
+// editor.
+func setupOIDCRouter(t *testing.T, disablePasswordLogin bool) (http.Handler, *sql.DB, *oidctest.Issuer) {
+	t.Helper()
+	db := testutil.SetupTestDB(t)
+	auth.EnsureAdminUser(db, "admin", "password123")
+	iss := oidctest.NewIssuer(t, "gatecha")
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:      iss.URL,
+		ClientID:    "gatecha",
+		RedirectURL: "http://gatecha.test/api/admin/oidc/callback",
+		Scopes:      []string{"openid", "groups"},
+	}, iss.Client())
+	router := NewRouter(db, Options{SecretKey: testSecretKey, OIDC: &OIDCOptions{
+		Provider:             provider,
+		UsernameClaim:        "preferred_username",
+		GroupsClaim:          "groups",
+		GroupRoles:           map[string]string{"admins": auth.RoleOwner, "devs": auth.RoleEditor},
+		DisablePasswordLogin: disablePasswordLogin,
+	}})
+	return router, db, iss
+}
+
+// oidcSignIn runs the browser side of a single sign-on and returns the
+// fragment of the final redirect to /login.
+func oidcSignIn(t *testing.T, router http.Handler, iss *oidctest.Issuer) url.Values {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the issuer, got %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+
+	client := iss.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect back from the issuer, got %d", resp.StatusCode)
+	}
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Path != "/login" {
+		t.Fatalf("expected redirect to /login, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	fragment, _ := url.ParseQuery(location.Fragment)
+	return fragment
+}
+
+func TestOIDC_SignIn(t *testing.T) {
+	router, db, iss := setupOIDCRouter(t, false)
+	iss.SetUser(map[string]interface{}{"sub": "idp-1", "preferred_username": "alice", "groups": []string{"staff", "devs"}})
+
+	fragment := oidcSignIn(t, router, iss)
+	if fragment.Get("error") != "" || fragment.Get("token") == "" || fragment.Get("refresh_token") == "" {
+		t.Fatalf("expected session tokens, got %v", fragment)
+	}
+	if fragment.Get("role") != auth.RoleEditor {
+		t.Errorf("expected editor role from the devs group, got %q", fragment.Get("role"))
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/me", nil)
+	req.Header.Set("Authorization", "Bearer "+fragment.Get("token"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"alice"`) {
+		t.Errorf("expected the token to authenticate alice, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Group changes at the provider apply on the next sign-in.
+	iss.SetUser(map[string]interface{}{"sub": "idp-1", "preferred_username": "alice", "groups": []string{"admins"}})
+	if fragment := oidcSignIn(t, router, iss); fragment.Get("role") != auth.RoleOwner {
+		t.Errorf("expected owner role after joining admins, got %q", fragment.Get("role"))
+	}
+	user, err := auth.GetAdminUserByUsername(db, "alice")
+	if err != nil || !user.SSO || user.Role != auth.RoleOwner {
+		t.Errorf("expected a provisioned SSO owner, got %+v (%v)", user, err)
+	}
+
+	entries, _, _ := models.ListAuditEntries(db, models.AuditFilter{Action: models.AuditLoginOIDC})
+	if len(entries) != 2 || !entries[0].Success {
+		t.Errorf("expected two successful SSO logins in the audit log, got %+v", entries)
+	}
+}
+
+func TestOIDC_SignInRejected(t *testing.T) {
+	router, db, iss := setupOIDCRouter(t, false)
+
+	iss.SetUser(map[string]interface{}{"sub": "idp-1", "preferred_username": "mallory", "groups": []string{"staff"}})
+	if fragment := oidcSignIn(t, router, iss); fragment.Get("error") == "" || fragment.Get("token") != "" {
+		t.Errorf("expected a user without a mapped group to be refused, got %v", fragment)
+	}
+	if _, err := auth.GetAdminUserByUsername(db, "mallory"); err == nil {
+		t.Error("expected no user to be provisioned")
+	}
+
+	iss.SetUser(map[string]interface{}{"sub": "idp-2", "preferred_username": "admin", "groups": []string{"admins"}})
+	if fragment := oidcSignIn(t, router, iss); fragment.Get("error") == "" {
+		t.Errorf("expected a clash with a password user to be refused, got %v", fragment)
+	}
+
+	// A callback without the state cookie is refused.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/oidc/callback?code=x&state=y", nil))
+	if !strings.Contains(w.Header().Get("Location"), "#error=") {
+		t.Errorf("expected an error redirect without the state cookie, got %q", w.Header().Get("Location"))
+	}
+}
+
+func TestOIDC_PasswordLoginDisabled(t *testing.T) {
+	router, _, _ := setupOIDCRouter(t, true)
+
+	body, _ := json.Marshal(map[string]string{"username": "admin", "password": "password123"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/admin/login", bytes.NewReader(body)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with password login disabled, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/public/login-config", nil))
+	var cfg map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&cfg)
+	if cfg["oidc_enabled"] != true || cfg["password_login_enabled"] != false {
+		t.Errorf("unexpected login config: %v", cfg)
+	}
+}
+
+func TestOIDC_NotConfigured(t *testing.T) {
+	router, _ := setupTestRouter(t)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/oidc/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without single sign-on, got %d", w.Code)
+	}
+}
+
+func TestPersonalTokens(t *testing.T) {
+	router, db := setupTestRouter(t)
+	session := getAdminToken(t, db)
+
+	do := func(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(session, "POST", "/api/admin/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"keys:admin"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown scope, got %d", w.Code)
+	}
+	w := do(session, "POST", "/api/admin/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"keys:read", "keys:write"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Token     string             `json:"token"`
+		TokenInfo auth.PersonalToken `json:"token_info"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	pat := created.Token
+
+	// The token can manage keys...
+	if w := do(pat, "POST", "/api/admin/keys", map[string]string{"name": "from-ci"}); w.Code != http.StatusCreated {
+		t.Errorf("expected the token to create a key, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(pat, "GET", "/api/admin/keys", nil); w.Code != http.StatusOK {
+		t.Errorf("expected the token to list keys, got %d", w.Code)
+	}
+	// ...but nothing outside its scopes, and nothing that needs a session.
+	for _, path := range []string{"/api/admin/stats/overview", "/api/admin/webhooks", "/api/admin/me", "/api/admin/tokens", "/api/admin/users"} {
+		if w := do(pat, "GET", path, nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s: expected 403 for the token, got %d", path, w.Code)
+		}
+	}
+
+	var list struct {
+		Tokens []auth.PersonalToken `json:"tokens"`
+	}
+	json.NewDecoder(do(session, "GET", "/api/admin/tokens", nil).Body).Decode(&list)
+	if len(list.Tokens) != 1 || list.Tokens[0].LastUsedAt == "" || strings.Contains(fmt.Sprint(list), pat) {
+		t.Errorf("expected one used token without its secret, got %+v", list.Tokens)
+	}
+
+	path := "/api/admin/tokens/" + strconv.FormatInt(created.TokenInfo.ID, 10)
+	if w := do(session, "DELETE", path, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 revoking the token, got %d", w.Code)
+	}
+	if w := do(pat, "GET", "/api/admin/keys", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a revoked token, got %d", w.Code)
+	}
+	if w := do(session, "DELETE", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking it again, got %d", w.Code)
+	}
+}
+
+func TestPersonalTokens_LimitedByRole(t *testing.T) {
+	router, db := setupTestRouter(t)
+	auth.CreateAdminUser(db, "viewer", "secret", auth.RoleViewer)
+	viewer, _ := auth.GetAdminUserByUsername(db, "viewer")
+
+	body, _ := json.Marshal(map[string]interface{}{"name": "ci", "scopes": []string{"keys:write"}})
+	req := httptest.NewRequest("POST", "/api/admin/tokens", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+getUserToken(t, db, "viewer"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a viewer asking for keys:write, got %d", w.Code)
+	}
+
+	// A token follows its owner's current role.
+	auth.SetAdminUserRole(db, viewer.ID, auth.RoleEditor)
+	_, pat, _ := auth.CreatePersonalToken(db, viewer.ID, "ci", []string{auth.ScopeKeysWrite}, 0)
+	auth.SetAdminUserRole(db, viewer.ID, auth.RoleViewer)
+
+	body, _ = json.Marshal(map[string]string{"name": "from-ci"})
+	req = httptest.NewRequest("POST", "/api/admin/keys", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+pat)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 once the owner is a viewer again, got %d", w.Code)
+	}
+}
+
+func TestProjects_CRUDAndMembers(t *testing.T) {
+	router, db := setupTestRouter(t)
+	token := getAdminToken(t, db)
+	editor, _ := auth.CreateAdminUser(db, "editor", "secret", auth.RoleEditor)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/admin/projects", map[string]string{"name": " "}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a name, got %d", w.Code)
+	}
+	w := do("POST", "/api/admin/projects", map[string]string{"name": "Acme", "description": "Acme sites"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var project models.Project
+	json.NewDecoder(w.Body).Decode(&project)
+	if w := do("POST", "/api/admin/projects", map[string]string{"name": "Acme"}); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate name, got %d", w.Code)
+	}
+
+	path := "/api/admin/projects/" + strconv.FormatInt(project.ID, 10)
+	if w := do("PUT", path, map[string]string{"name": "Acme Corp"}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Acme Corp"`) {
+		t.Errorf("expected the project to be renamed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("PUT", path+"/members", map[string][]int64{"user_ids": {99999}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown user, got %d", w.Code)
+	}
+	if w := do("PUT", path+"/members", map[string][]int64{"user_ids": {editor.ID}}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 setting members, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", path, nil); !strings.Contains(w.Body.String(), `"username":"editor"`) {
+		t.Errorf("expected the editor among the members, got %s", w.Body.String())
+	}
+
+	w = do("POST", "/api/admin/keys", map[string]interface{}{"name": "Site", "project_id": project.ID})
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"project_id":`+strconv.FormatInt(project.ID, 10)) {
+		t.Fatalf("expected a key in the project, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/admin/keys", map[string]interface{}{"name": "Site", "project_id": 99999}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown project, got %d", w.Code)
+	}
+	if w := do("DELETE", path, nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 deleting a project with keys, got %d", w.Code)
+	}
+
+	entries, _, _ := models.ListAuditEntries(db, models.AuditFilter{TargetType: auditTargetProject})
+	if len(entries) != 3 {
+		t.Errorf("expected 3 project audit entries, got %d", len(entries))
+	}
+}
+
+func TestProjects_ScopeKeysForMembers(t *testing.T) {
+	router, db := setupTestRouter(t)
+	editor, _ := auth.CreateAdminUser(db, "editor", "secret", auth.RoleEditor)
+	token := getUserToken(t, db, "editor")
+
+	mine, _ := models.CreateProject(db, "Mine", "")
+	theirs, _ := models.CreateProject(db, "Theirs", "")
+	models.SetProjectMembers(db, mine.ID, []int64{editor.ID})
+	inMine, _ := models.CreateAPIKey(db, "Mine", "", 0, 0, "")
+	inTheirs, _ := models.CreateAPIKey(db, "Theirs", "", 0, 0, "")
+	for key, projectID := range map[*models.APIKey]int64{inMine: mine.ID, inTheirs: theirs.ID} {
+		params := key.UpdateParams()
+		params.ProjectID = projectID
+		models.UpdateAPIKey(db, key.ID, params)
+	}
+	models.IncrementChallengesIssued(db, inMine.ID)
+	models.IncrementChallengesIssued(db, inTheirs.ID)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var list struct {
+		Keys []models.APIKey `json:"keys"`
+	}
+	json.NewDecoder(do("GET", "/api/admin/keys", nil).Body).Decode(&list)
+	if len(list.Keys) != 1 || list.Keys[0].ID != inMine.ID {
+		t.Errorf("expected only the key of the editor's project, got %+v", list.Keys)
+	}
+	theirsPath := "/api/admin/keys/" + strconv.FormatInt(inTheirs.ID, 10)
+	for _, tt := range []struct{ method, path string }{
+		{"GET", theirsPath},
+		{"PUT", theirsPath},
+		{"DELETE", theirsPath},
+		{"GET", "/api/admin/stats/keys/" + strconv.FormatInt(inTheirs.ID, 10)},
+		{"GET", "/api/admin/projects/" + strconv.FormatInt(theirs.ID, 10)},
+		{"GET", "/api/admin/stats/overview?project_id=" + strconv.FormatInt(theirs.ID, 10)},
+	} {
+		if w := do(tt.method, tt.path, map[string]string{"name": "x"}); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d", tt.method, tt.path, w.Code)
+		}
+	}
+
+	var overview models.StatsOverview
+	json.NewDecoder(do("GET", "/api/admin/stats/overview", nil).Body).Decode(&overview)
+	if overview.TotalChallenges != 1 {
+		t.Errorf("expected stats of the editor's project only, got %d challenges", overview.TotalChallenges)
+	}
+
+	if w := do("POST", "/api/admin/keys", map[string]string{"name": "No project"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 creating a key without a project, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/keys", map[string]interface{}{"name": "New", "project_id": theirs.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 creating a key in another project, got %d", w.Code)
+	}
+	if w := do("PUT", "/api/admin/keys/"+strconv.FormatInt(inMine.ID, 10), map[string]interface{}{"project_id": theirs.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 moving a key to another project, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/projects", map[string]string{"name": "New"}); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an editor creating a project, got %d", w.Code)
+	}
+
+	var projects struct {
+		Projects []models.Project `json:"projects"`
+	}
+	json.NewDecoder(do("GET", "/api/admin/projects", nil).Body).Decode(&projects)
+	if len(projects.Projects) != 1 || projects.Projects[0].ID != mine.ID || projects.Projects[0].KeyCount != 1 {
+		t.Errorf("expected only the editor's project, got %+v", projects.Projects)
+	}
+}
+
+func TestProjects_ScopeWebhooksForMembers(t *testing.T) {
+	router, db := setupTestRouter(t)
+	editor, _ := auth.CreateAdminUser(db, "editor", "secret", auth.RoleEditor)
+	token := getUserToken(t, db, "editor")
+
+	mine, _ := models.CreateProject(db, "Mine", "")
+	theirs, _ := models.CreateProject(db, "Theirs", "")
+	models.SetProjectMembers(db, mine.ID, []int64{editor.ID})
+	global, _ := models.CreateWebhook(db, 0, "https://hooks.example.com/all", []string{models.EventKeyCreated})
+	inTheirs, _ := models.CreateWebhook(db, theirs.ID, "https://hooks.example.com/theirs", []string{models.EventKeyCreated})
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/admin/webhooks", map[string]interface{}{
+		"url": "https://hooks.example.com/mine", "events": []string{models.EventKeyCreated}, "project_id": mine.ID,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating a webhook in the editor's project, got %d: %s", w.Code, w.Body.String())
+	}
+	var inMine models.Webhook
+	json.NewDecoder(w.Body).Decode(&inMine)
+
+	var list struct {
+		Webhooks []models.Webhook `json:"webhooks"`
+	}
+	json.NewDecoder(do("GET", "/api/admin/webhooks", nil).Body).Decode(&list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != inMine.ID {
+		t.Errorf("expected only the webhook of the editor's project, got %+v", list.Webhooks)
+	}
+
+	for _, wh := range []*models.Webhook{global, inTheirs} {
+		path := "/api/admin/webhooks/" + strconv.FormatInt(wh.ID, 10)
+		for _, tt := range []struct{ method, path string }{
+			{"GET", path},
+			{"PUT", path},
+			{"DELETE", path},
+			{"GET", path + "/deliveries"},
+			{"POST", path + "/deliveries/1/resend"},
+		} {
+			if w := do(tt.method, tt.path, map[string]string{"url": "https://evil.example.com"}); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s: expected 404, got %d", tt.method, tt.path, w.Code)
+			}
+		}
+	}
+
+	if w := do("POST", "/api/admin/webhooks", map[string]interface{}{"url": "https://hooks.example.com/x", "events": []string{models.EventKeyCreated}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 creating a webhook without a project, got %d", w.Code)
+	}
+	if w := do("PUT", "/api/admin/webhooks/"+strconv.FormatInt(inMine.ID, 10), map[string]interface{}{"project_id": theirs.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 moving a webhook to another project, got %d", w.Code)
+	}
+
+	// Key events only reach the webhooks of the key's project and global ones.
+	if w := do("POST", "/api/admin/keys", map[string]interface{}{"name": "New", "project_id": mine.ID}); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating a key, got %d", w.Code)
+	}
+	for id, want := range map[int64]int{inMine.ID: 1, global.ID: 1, inTheirs.ID: 0} {
+		if deliveries, _ := models.ListWebhookDeliveries(db, id, 10); len(deliveries) != want {
+			t.Errorf("webhook %d: expected %d deliveries, got %d", id, want, len(deliveries))
+		}
+	}
+}
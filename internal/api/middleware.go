@@ -4,20 +4,49 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/metrics"
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/origins"
+	"github.com/go-chi/chi/v5"
+	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 )
 
 const bearerPrefix = "Bearer "
 
+// verifySecretHeader carries a key's server-side verify secret.
+const verifySecretHeader = "X-GateCHA-Secret"
+
 type contextKey string
 
-const apiKeyContextKey contextKey = "apiKey"
+const (
+	apiKeyContextKey contextKey = "apiKey"
+	// corsKeyContextKey holds the key APICORSMiddleware looked up, so
+	// authenticateAPIKey does not have to query it again.
+	corsKeyContextKey contextKey = "corsKey"
+	// adminUserContextKey holds the subject of a validated admin JWT.
+	adminUserContextKey    contextKey = "adminUser"
+	adminRoleContextKey    contextKey = "adminRole"
+	adminSessionContextKey contextKey = "adminSession"
+	// adminTokenContextKey holds the personal access token a request was
+	// authenticated with, if any.
+	adminTokenContextKey contextKey = "adminToken"
+)
 
-func authenticateAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+// apiKeyIDFromRequest returns the key ID from the apiKey query parameter or
+// a Bearer token, or "" if neither carries a gk_ key.
+func apiKeyIDFromRequest(r *http.Request) string {
 	keyID := r.URL.Query().Get("apiKey")
 	if keyID == "" {
 		authHeader := r.Header.Get("Authorization")
@@ -25,27 +54,43 @@ func authenticateAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) (*ht
 			keyID = strings.TrimPrefix(authHeader, bearerPrefix)
 		}
 	}
+	if !strings.HasPrefix(keyID, "gk_") {
+		return ""
+	}
+	return keyID
+}
 
-	if keyID == "" || !strings.HasPrefix(keyID, "gk_") {
+func authenticateAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	keyID := apiKeyIDFromRequest(r)
+	if keyID == "" {
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API key"})
 		return nil, false
 	}
 
-	key, err := models.GetAPIKeyByKeyID(db, keyID)
+	key, _ := r.Context().Value(corsKeyContextKey).(*models.APIKey)
+	var err error
+	if key == nil || key.KeyID != keyID {
+		key, err = models.GetAPIKeyByKeyID(db, keyID)
+	}
 	if err != nil {
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid API key"})
 		return nil, false
 	}
 
 	if !key.Enabled {
+		metrics.RequestsRejected.Inc(key.KeyID, "key_disabled")
+		if err := models.IncrementKeyDisabledRejected(db, key.ID); err != nil {
+			slog.Error("failed to increment disabled key rejections", "error", err, "api_key_id", key.ID)
+		}
 		writeJSON(w, http.StatusForbidden, map[string]string{"error": "API key is disabled"})
 		return nil, false
 	}
 
-	if key.Domain != "" {
-		origin := r.Header.Get("Origin")
-		referer := r.Header.Get("Referer")
-		if origin != "" && !matchDomain(origin, key.Domain) && !matchDomain(referer, key.Domain) {
+	// A sent Origin decides on its own; the Referer is only a fallback
+	// for requests without one, checked by RequireOriginMiddleware.
+	if key.OriginEnforcement != models.OriginEnforcementOff && len(key.AllowedOrigins) > 0 {
+		if origin := r.Header.Get("Origin"); origin != "" && !origins.MatchAny(key.AllowedOrigins, origin) {
+			recordOriginRejected(db, key)
 			writeJSON(w, http.StatusForbidden, map[string]string{"error": "domain not allowed"})
 			return nil, false
 		}
@@ -67,29 +112,162 @@ func APIKeyMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 	}
 }
 
+func recordOriginRejected(db *sql.DB, key *models.APIKey) {
+	metrics.RequestsRejected.Inc(key.KeyID, "origin_"+key.OriginEnforcement)
+	if err := models.IncrementOriginRejected(db, key.ID, key.OriginEnforcement); err != nil {
+		slog.Error("failed to increment origin rejections", "error", err, "api_key_id", key.ID)
+	}
+}
+
+// RequireOriginMiddleware rejects requests without an Origin or Referer for
+// keys in strict enforcement mode. APIKeyMiddleware has already checked any
+// Origin that was sent, so only the Referer fallback is left to match here.
+func RequireOriginMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := GetAPIKeyFromContext(r)
+			if key != nil && key.OriginEnforcement == models.OriginEnforcementStrict && len(key.AllowedOrigins) > 0 &&
+				r.Header.Get("Origin") == "" && !origins.MatchAny(key.AllowedOrigins, r.Header.Get("Referer")) {
+				recordOriginRejected(db, key)
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin required"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// VerifySecretMiddleware requires the key's verify secret on server-side
+// endpoints, since the key ID itself is public. It must run after
+// APIKeyMiddleware. Keys created before verify secrets existed are let
+// through with a warning, at most once per key and legacyKeyWarnInterval,
+// until a secret is generated for them; with required set they are
+// rejected instead.
+func VerifySecretMiddleware(required bool) func(http.Handler) http.Handler {
+	warnings := newLogLimiter(legacyKeyWarnInterval)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := GetAPIKeyFromContext(r)
+			if key == nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing API key"})
+				return
+			}
+			if !key.HasVerifySecret {
+				if required {
+					writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "API key has no verify secret; generate one"})
+					return
+				}
+				if warnings.allow(key.KeyID) {
+					slog.Warn("verify without a secret on a key created before verify secrets; generate one and set GATECHA_REQUIRE_VERIFY_SECRET=true",
+						"api_key_id", key.ID, "key_id", key.KeyID)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !key.CheckVerifySecret(r.Header.Get(verifySecretHeader)) {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid verify secret"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func GetAPIKeyFromContext(r *http.Request) *models.APIKey {
 	key, _ := r.Context().Value(apiKeyContextKey).(*models.APIKey)
 	return key
 }
 
-func authenticateAdmin(secretKey string, w http.ResponseWriter, r *http.Request) bool {
+func authenticateAdmin(db *sql.DB, secretKey string, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, bearerPrefix) {
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing authorization"})
-		return false
+		return nil, false
 	}
 	token := strings.TrimPrefix(authHeader, bearerPrefix)
-	if _, err := auth.ValidateJWT(token, secretKey); err != nil {
+	if strings.HasPrefix(token, auth.PersonalTokenPrefix) {
+		return authenticatePersonalToken(db, token, w, r)
+	}
+	claims, err := auth.ValidateJWT(token, secretKey)
+	if err != nil {
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired token"})
-		return false
+		return nil, false
 	}
-	return true
+	sub, _ := claims.GetSubject()
+	role, _ := claims["role"].(string)
+	sessionID, _ := claims["jti"].(string)
+	if !auth.ValidRole(role) || sessionID == "" {
+		// Tokens issued before roles and sessions existed carry neither.
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired token"})
+		return nil, false
+	}
+	active, err := auth.SessionActive(db, sessionID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return nil, false
+	}
+	if !active {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "session revoked or expired"})
+		return nil, false
+	}
+	ctx := context.WithValue(r.Context(), adminUserContextKey, sub)
+	ctx = context.WithValue(ctx, adminRoleContextKey, role)
+	ctx = context.WithValue(ctx, adminSessionContextKey, sessionID)
+	return r.WithContext(ctx), true
 }
 
-func AdminAuthMiddleware(secretKey string) func(http.Handler) http.Handler {
+// authenticatePersonalToken authenticates a request as the owner of a
+// personal access token, with the owner's current role.
+func authenticatePersonalToken(db *sql.DB, token string, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	pat, user, err := auth.AuthenticatePersonalToken(db, token, time.Now())
+	if errors.Is(err, auth.ErrInvalidPersonalToken) || errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired token"})
+		return nil, false
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return nil, false
+	}
+	ctx := context.WithValue(r.Context(), adminUserContextKey, user.Username)
+	ctx = context.WithValue(ctx, adminRoleContextKey, user.Role)
+	ctx = context.WithValue(ctx, adminTokenContextKey, pat)
+	return r.WithContext(ctx), true
+}
+
+func AdminAuthMiddleware(db *sql.DB, secretKey string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !authenticateAdmin(secretKey, w, r) {
+			req, ok := authenticateAdmin(db, secretKey, w, r)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// RequireRole rejects admins whose role does not grant min. It must run
+// after AdminAuthMiddleware.
+func RequireRole(min string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.RoleAtLeast(GetAdminRoleFromContext(r), min) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "insufficient role"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope lets personal access tokens through only if they have scope.
+// Session logins are not limited by scopes. It must run after
+// AdminAuthMiddleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pat := GetAdminTokenFromContext(r); pat != nil && !pat.HasScope(scope) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "token lacks the " + scope + " scope"})
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -97,6 +275,47 @@ func AdminAuthMiddleware(secretKey string) func(http.Handler) http.Handler {
 	}
 }
 
+// RequireSession rejects personal access tokens. It guards the endpoints no
+// scope covers, such as account and user management. It must run after
+// AdminAuthMiddleware.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAdminTokenFromContext(r) != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "personal access tokens cannot use this endpoint"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetAdminTokenFromContext returns the personal access token the request
+// was authenticated with, or nil for session logins.
+func GetAdminTokenFromContext(r *http.Request) *auth.PersonalToken {
+	pat, _ := r.Context().Value(adminTokenContextKey).(*auth.PersonalToken)
+	return pat
+}
+
+// GetAdminUserFromContext returns the JWT subject of the authenticated
+// admin, or "" outside AdminAuthMiddleware.
+func GetAdminUserFromContext(r *http.Request) string {
+	user, _ := r.Context().Value(adminUserContextKey).(string)
+	return user
+}
+
+// GetAdminSessionFromContext returns the session ID of the authenticated
+// admin, or "" outside AdminAuthMiddleware.
+func GetAdminSessionFromContext(r *http.Request) string {
+	id, _ := r.Context().Value(adminSessionContextKey).(string)
+	return id
+}
+
+// GetAdminRoleFromContext returns the role of the authenticated admin, or
+// "" outside AdminAuthMiddleware.
+func GetAdminRoleFromContext(r *http.Request) string {
+	role, _ := r.Context().Value(adminRoleContextKey).(string)
+	return role
+}
+
 func CORSMiddleware(allowAll bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -121,12 +340,262 @@ func CORSMiddleware(allowAll bool) func(http.Handler) http.Handler {
 	}
 }
 
-func matchDomain(urlStr, domain string) bool {
-	urlStr = strings.TrimPrefix(urlStr, "http://")
-	urlStr = strings.TrimPrefix(urlStr, "https://")
-	parts := strings.SplitN(urlStr, "/", 2)
-	host := strings.SplitN(parts[0], ":", 2)[0]
-	return strings.EqualFold(host, domain)
+// corsAllowedMethods and corsAllowedHeaders are what browsers may use on
+// /api/v1. The verify secret header is included for setups that call
+// /api/v1/verify from their own browser-facing backend origin.
+const (
+	corsAllowedMethods = "GET, POST, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, " + verifySecretHeader
+)
+
+// corsAllowsOrigin reports whether key accepts requests from origin under
+// the same rules authenticateAPIKey applies.
+func corsAllowsOrigin(key *models.APIKey, origin string) bool {
+	if !key.Enabled {
+		return false
+	}
+	if key.OriginEnforcement == models.OriginEnforcementOff || len(key.AllowedOrigins) == 0 {
+		return true
+	}
+	return origins.MatchAny(key.AllowedOrigins, origin)
+}
+
+// keyListCache holds the API key list in memory, reloading it after a key
+// was created, updated or deleted.
+type keyListCache struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	loaded  bool
+	version uint64
+	keys    []models.APIKey
+}
+
+func (c *keyListCache) get() ([]models.APIKey, error) {
+	// Read the version first: a write racing with the reload leaves the
+	// cache behind by one version, never ahead.
+	version := models.APIKeysVersion()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded || c.version != version {
+		keys, err := models.ListAPIKeys(c.db)
+		if err != nil {
+			return nil, err
+		}
+		c.keys, c.version, c.loaded = keys, version, true
+	}
+	return c.keys, nil
+}
+
+// preflightPolicy answers a preflight that names no API key, as happens
+// when the key travels in the Authorization header. The origin is allowed
+// if any enabled key accepts it; credentials are allowed if any of those
+// keys allows them, and the shortest max-age wins.
+func preflightPolicy(cache *keyListCache, origin string) (models.APIKey, bool) {
+	keys, err := cache.get()
+	if err != nil {
+		slog.Error("failed to list API keys for preflight", "error", err)
+		return models.APIKey{}, false
+	}
+	var policy models.APIKey
+	found := false
+	for i := range keys {
+		if !corsAllowsOrigin(&keys[i], origin) {
+			continue
+		}
+		if !found || keys[i].CORSMaxAge < policy.CORSMaxAge {
+			policy.CORSMaxAge = keys[i].CORSMaxAge
+		}
+		policy.CORSAllowCredentials = policy.CORSAllowCredentials || keys[i].CORSAllowCredentials
+		found = true
+	}
+	return policy, found
+}
+
+// APICORSMiddleware applies the CORS policy of the API key a request names.
+// Origins the key does not accept get no CORS headers, so browsers block
+// the response. Preflights are answered here, before APIKeyMiddleware,
+// because browsers send them without credentials. allowAll overrides the
+// per-key policy with a wildcard origin.
+func APICORSMiddleware(db *sql.DB, allowAll bool) func(http.Handler) http.Handler {
+	keys := &keyListCache{db: db}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions
+
+			if allowAll {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if origin == "" {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+
+			var key *models.APIKey
+			if keyID := apiKeyIDFromRequest(r); keyID != "" {
+				if k, err := models.GetAPIKeyByKeyID(db, keyID); err == nil {
+					key = k
+					r = r.WithContext(context.WithValue(r.Context(), corsKeyContextKey, key))
+				}
+			}
+
+			if preflight {
+				policy, ok := models.APIKey{}, false
+				if key != nil {
+					policy, ok = *key, corsAllowsOrigin(key, origin)
+				} else if apiKeyIDFromRequest(r) == "" {
+					policy, ok = preflightPolicy(keys, origin)
+				}
+				if ok {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+					w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+					if policy.CORSMaxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.CORSMaxAge))
+					}
+					if policy.CORSAllowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if key != nil && corsAllowsOrigin(key, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if len(key.CORSExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(key.CORSExposedHeaders, ", "))
+				}
+				if key.CORSAllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RealIPMiddleware replaces the remote address with the client IP from
+// X-Forwarded-For or X-Real-IP, but only for requests from a trusted proxy;
+// anyone else could set those headers to pose as any address. In
+// X-Forwarded-For the client is the rightmost hop that is not itself a
+// trusted proxy. A malformed hop before that one leaves the peer address.
+//
+// Forwarding headers from an untrusted peer are ignored with a warning, at
+// most once per proxyWarnInterval, since they usually mean a reverse proxy
+// is missing from GATECHA_TRUSTED_PROXIES.
+func RealIPMiddleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	warnings := newLogLimiter(proxyWarnInterval)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			xff, xri := r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP")
+			peer, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !isTrusted(peer) {
+				if (xff != "" || xri != "") && warnings.allow("") {
+					slog.Warn("ignoring X-Forwarded-For/X-Real-IP from a peer outside GATECHA_TRUSTED_PROXIES; add the reverse proxy there or all clients share its IP",
+						"peer", clientIP(r))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var client netip.Addr
+			if xff != "" {
+				hops := strings.Split(xff, ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+					if err != nil {
+						client = netip.Addr{}
+						break
+					}
+					client = hop
+					if !isTrusted(hop) {
+						break
+					}
+				}
+			} else if ip, err := netip.ParseAddr(strings.TrimSpace(xri)); err == nil {
+				client = ip
+			}
+			if client.IsValid() {
+				r.RemoteAddr = client.Unmap().String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Intervals between repeats of RealIPMiddleware's and
+// VerifySecretMiddleware's warnings.
+const (
+	proxyWarnInterval     = 10 * time.Minute
+	legacyKeyWarnInterval = time.Hour
+)
+
+// logLimiter lets a repeated warning through at most once per interval
+// and key.
+type logLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	last     map[string]time.Time
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{interval: interval, last: map[string]time.Time{}}
+}
+
+// allow reports whether the warning for key may be logged now.
+func (l *logLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	l.last[key] = now
+	return true
+}
+
+// clientIP returns the caller's address as resolved by RealIPMiddleware.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// requestOrigin returns the scheme and host the request came from, taken
+// from the Origin header or, failing that, the Referer.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	u, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
 }
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -134,3 +603,23 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// RequestMetrics records the latency of every request by method, route
+// pattern and status code.
+func RequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := chiMiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), r.Method, route, strconv.Itoa(status))
+	})
+}
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/auth"
 	"github.com/Upellift99/GateCHA/internal/models"
@@ -106,6 +110,11 @@ func TestAuthenticateAPIKey_Disabled(t *testing.T) {
 	if w.Code != http.StatusForbidden {
 		t.Errorf("expected 403, got %d", w.Code)
 	}
+
+	stats, _ := models.GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 || stats[0].KeyDisabledRejected != 1 {
+		t.Errorf("expected the rejection to be counted, got %+v", stats)
+	}
 }
 
 func TestAuthenticateAPIKey_DomainAllowed(t *testing.T) {
@@ -139,6 +148,100 @@ func TestAuthenticateAPIKey_DomainBlocked(t *testing.T) {
 	}
 }
 
+func TestAuthenticateAPIKey_AllowedOrigins(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	params := key.UpdateParams()
+	params.AllowedOrigins = []string{"www.example.com", "*.preview.example.com", "http://localhost:5173"}
+	models.UpdateAPIKey(db, key.ID, params)
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"https://www.example.com", true},
+		{"https://pr-42.preview.example.com", true},
+		{"https://preview.example.com", false},
+		{"http://localhost:5173", true},
+		{"http://localhost:3000", false},
+		{"https://evil.com", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+		req.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		if _, ok := authenticateAPIKey(db, w, req); ok != tt.ok {
+			t.Errorf("origin %s: expected ok=%v, got %v (%d)", tt.origin, tt.ok, ok, w.Code)
+		}
+	}
+}
+
+func TestOriginEnforcementModes(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+
+	challenge := func(mode, origin, referer string) int {
+		params := key.UpdateParams()
+		params.OriginEnforcement = mode
+		models.UpdateAPIKey(db, key.ID, params)
+
+		req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		mode, origin, referer string
+		want                  int
+	}{
+		{models.OriginEnforcementOff, "https://evil.com", "", http.StatusOK},
+		{models.OriginEnforcementLenient, "https://evil.com", "", http.StatusForbidden},
+		{models.OriginEnforcementLenient, "", "", http.StatusOK},
+		{models.OriginEnforcementLenient, "", "https://evil.com/page", http.StatusOK},
+		{models.OriginEnforcementStrict, "https://example.com", "", http.StatusOK},
+		{models.OriginEnforcementStrict, "", "https://example.com/page", http.StatusOK},
+		{models.OriginEnforcementStrict, "", "", http.StatusForbidden},
+		{models.OriginEnforcementStrict, "", "https://evil.com/page", http.StatusForbidden},
+		{models.OriginEnforcementStrict, "https://evil.com", "", http.StatusForbidden},
+		{models.OriginEnforcementLenient, "https://evil.com", "https://example.com/page", http.StatusForbidden},
+		{models.OriginEnforcementStrict, "https://evil.com", "https://example.com/page", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := challenge(tt.mode, tt.origin, tt.referer); got != tt.want {
+			t.Errorf("%s origin=%q referer=%q: expected %d, got %d", tt.mode, tt.origin, tt.referer, tt.want, got)
+		}
+	}
+
+	stats, _ := models.GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 || stats[0].OriginRejectedLenient != 2 || stats[0].OriginRejectedStrict != 4 {
+		t.Errorf("unexpected rejection counters: %+v", stats)
+	}
+}
+
+func TestOriginEnforcement_StrictOnlyOnChallenge(t *testing.T) {
+	router, db := setupTestRouter(t)
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	params := key.UpdateParams()
+	params.OriginEnforcement = models.OriginEnforcementStrict
+	models.UpdateAPIKey(db, key.ID, params)
+
+	// Backends call verify without browser headers.
+	req := httptest.NewRequest("POST", "/api/v1/verify?apiKey="+key.KeyID, strings.NewReader(`{"payload":""}`))
+	req.Header.Set(verifySecretHeader, key.VerifySecret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code == http.StatusForbidden {
+		t.Error("expected strict mode not to require an origin on verify")
+	}
+}
+
 func TestAuthenticateAPIKey_NoDomainNoOrigin(t *testing.T) {
 	db := testutil.SetupTestDB(t)
 	key, _ := models.CreateAPIKey(db, "Test", "restricted.com", 0, 0, "")
@@ -153,25 +256,101 @@ func TestAuthenticateAPIKey_NoDomainNoOrigin(t *testing.T) {
 	}
 }
 
+func TestVerifySecretMiddleware(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := models.CreateAPIKey(db, "Test", "", 0, 0, "")
+	loaded, _ := models.GetAPIKeyByID(db, key.ID)
+
+	handler := VerifySecretMiddleware(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		secret string
+		want   int
+	}{
+		{"valid secret", key.VerifySecret, http.StatusOK},
+		{"missing secret", "", http.StatusUnauthorized},
+		{"wrong secret", "gs_wrong", http.StatusUnauthorized},
+		{"key ID as secret", key.KeyID, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/verify", nil)
+			req = req.WithContext(context.WithValue(req.Context(), apiKeyContextKey, loaded))
+			if tt.secret != "" {
+				req.Header.Set(verifySecretHeader, tt.secret)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestVerifySecretMiddleware_LegacyKey(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := models.CreateAPIKey(db, "Legacy", "", 0, 0, "")
+	db.Exec(`UPDATE api_keys SET verify_secret_hash = '' WHERE id = ?`, key.ID)
+	loaded, _ := models.GetAPIKeyByID(db, key.ID)
+
+	serve := func(required bool) int {
+		handler := VerifySecretMiddleware(required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest("POST", "/api/v1/verify", nil)
+		req = req.WithContext(context.WithValue(req.Context(), apiKeyContextKey, loaded))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve(false); code != http.StatusOK {
+		t.Errorf("expected legacy key without secret to pass, got %d", code)
+	}
+	if code := serve(true); code != http.StatusUnauthorized {
+		t.Errorf("expected legacy key to be rejected once secrets are required, got %d", code)
+	}
+}
+
 func TestAuthenticateAdmin_Valid(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	auth.EnsureAdminUser(db, "admin", "password123")
+	admin, _ := auth.GetAdminUserByUsername(db, "admin")
+	session, _, _ := auth.CreateSession(db, admin.ID, "", "")
 	secret := "test-secret"
-	token, _, _ := auth.GenerateJWT("admin", secret)
+	token, _, _ := auth.GenerateJWT("admin", auth.RoleOwner, session.ID, secret)
 
 	req := httptest.NewRequest("GET", "/api/admin/me", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	ok := authenticateAdmin(secret, w, req)
+	result, ok := authenticateAdmin(db, secret, w, req)
 	if !ok {
 		t.Fatal("expected admin authentication to succeed")
 	}
+	if user := GetAdminUserFromContext(result); user != "admin" {
+		t.Errorf("expected admin user in context, got %q", user)
+	}
+	if id := GetAdminSessionFromContext(result); id != session.ID {
+		t.Errorf("expected session %q in context, got %q", session.ID, id)
+	}
+
+	auth.RevokeSession(db, admin.ID, session.ID)
+	w = httptest.NewRecorder()
+	if _, ok := authenticateAdmin(db, secret, w, req); ok || w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked session to be rejected, got %d", w.Code)
+	}
 }
 
 func TestAuthenticateAdmin_NoHeader(t *testing.T) {
 	req := httptest.NewRequest("GET", "/api/admin/me", nil)
 	w := httptest.NewRecorder()
 
-	ok := authenticateAdmin("secret", w, req)
+	_, ok := authenticateAdmin(nil, "secret", w, req)
 	if ok {
 		t.Fatal("expected admin authentication to fail without header")
 	}
@@ -185,7 +364,7 @@ func TestAuthenticateAdmin_InvalidToken(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer invalid-token")
 	w := httptest.NewRecorder()
 
-	ok := authenticateAdmin("secret", w, req)
+	_, ok := authenticateAdmin(nil, "secret", w, req)
 	if ok {
 		t.Fatal("expected admin authentication to fail with invalid token")
 	}
@@ -237,26 +416,150 @@ func TestCORSMiddleware_Preflight(t *testing.T) {
 	}
 }
 
-func TestMatchDomain(t *testing.T) {
+func TestAPICORSMiddleware_KeyPolicy(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey})
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	params := key.UpdateParams()
+	params.CORSExposedHeaders = []string{"X-Request-Id"}
+	params.CORSAllowCredentials = true
+	params.CORSMaxAge = 120
+	models.UpdateAPIKey(db, key.ID, params)
+
+	req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+	req.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("expected origin echo, got %q", h.Get("Access-Control-Allow-Origin"))
+	}
+	if h.Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Errorf("expected exposed headers, got %q", h.Get("Access-Control-Expose-Headers"))
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("expected credentials to be allowed")
+	}
+	if h.Get("Vary") != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", h.Get("Vary"))
+	}
+}
+
+func TestAPICORSMiddleware_Preflight(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey})
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	params := key.UpdateParams()
+	params.CORSMaxAge = 120
+	models.UpdateAPIKey(db, key.ID, params)
+
 	tests := []struct {
-		url, domain string
-		expected    bool
+		name, path, origin string
+		allowed            bool
 	}{
-		{"https://example.com/path", "example.com", true},
-		{"http://example.com:8080/path", "example.com", true},
-		{"https://other.com", "example.com", false},
-		{"https://EXAMPLE.COM", "example.com", true},
-		{"example.com/path", "example.com", true},
+		{"with key", "/api/v1/verify?apiKey=" + key.KeyID, "https://example.com", true},
+		{"with key, other origin", "/api/v1/verify?apiKey=" + key.KeyID, "https://evil.com", false},
+		{"without key", "/api/v1/verify", "https://example.com", true},
+		{"without key, other origin", "/api/v1/verify", "https://evil.com", false},
+		{"unknown key", "/api/v1/verify?apiKey=gk_unknown", "https://example.com", false},
 	}
-
 	for _, tt := range tests {
-		result := matchDomain(tt.url, tt.domain)
-		if result != tt.expected {
-			t.Errorf("matchDomain(%q, %q) = %v, want %v", tt.url, tt.domain, result, tt.expected)
+		req := httptest.NewRequest("OPTIONS", tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204, got %d", tt.name, w.Code)
+		}
+		got := w.Header().Get("Access-Control-Allow-Origin") == tt.origin
+		if got != tt.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", tt.name, tt.allowed, got)
+		}
+		if tt.allowed && w.Header().Get("Access-Control-Max-Age") != "120" {
+			t.Errorf("%s: expected max-age 120, got %q", tt.name, w.Header().Get("Access-Control-Max-Age"))
 		}
 	}
 }
 
+func TestAPICORSMiddleware_PreflightCache(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey})
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+
+	allowed := func() bool {
+		req := httptest.NewRequest("OPTIONS", "/api/v1/verify", nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("Access-Control-Allow-Origin") != ""
+	}
+
+	if !allowed() {
+		t.Fatal("expected the key's origin to be allowed")
+	}
+	// Changes made behind the models' back are not seen until a key changes.
+	db.Exec(`UPDATE api_keys SET enabled = 0 WHERE id = ?`, key.ID)
+	if !allowed() {
+		t.Error("expected the cached key list to be used")
+	}
+	params := key.UpdateParams()
+	params.Enabled = false
+	models.UpdateAPIKey(db, key.ID, params)
+	if allowed() {
+		t.Error("expected the cache to reload after the key was updated")
+	}
+}
+
+func TestAPICORSMiddleware_RejectedOrigin(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	router := NewRouter(db, Options{SecretKey: testSecretKey})
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	params := key.UpdateParams()
+	params.OriginEnforcement = models.OriginEnforcementOff
+	models.UpdateAPIKey(db, key.ID, params)
+
+	// With enforcement off every origin is served, so CORS allows it too.
+	req := httptest.NewRequest("GET", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://evil.com" {
+		t.Errorf("expected origin echo with enforcement off, got %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	params.OriginEnforcement = models.OriginEnforcementLenient
+	models.UpdateAPIKey(db, key.ID, params)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected 403 without CORS headers, got %d %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestAPICORSMiddleware_AllowAllOverride(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := models.CreateAPIKey(db, "Test", "example.com", 0, 0, "")
+	handler := APICORSMiddleware(db, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/api/v1/challenge?apiKey="+key.KeyID, nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected wildcard preflight, got %d %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
 func TestWriteJSON(t *testing.T) {
 	w := httptest.NewRecorder()
 	writeJSON(w, http.StatusOK, map[string]string{"hello": "world"})
@@ -274,3 +577,57 @@ func TestWriteJSON(t *testing.T) {
 		t.Errorf("expected hello=world, got %v", body)
 	}
 }
+
+func TestLogLimiter(t *testing.T) {
+	l := newLogLimiter(time.Hour)
+	if !l.allow("a") || l.allow("a") {
+		t.Error("expected one warning per interval")
+	}
+	if !l.allow("b") {
+		t.Error("expected keys to be limited separately")
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	handler := RealIPMiddleware([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(clientIP(r)))
+		}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"spoofed header from an untrusted peer", "203.0.113.7:4000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.7"},
+		{"forwarded by a trusted proxy", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"client-supplied hops are skipped", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"real ip from a trusted proxy", "10.0.0.2:4000",
+			map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"malformed header", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "not-an-ip"}, "10.0.0.2"},
+		{"malformed hop behind a trusted proxy", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, not-an-ip, 10.0.0.3"}, "10.0.0.2"},
+		{"only trusted hops", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
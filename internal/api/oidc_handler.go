@@ -0,0 +1,201 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/oidc"
+)
+
+const (
+	oidcStateCookie = "gatecha_oidc"
+	oidcCookiePath  = "/api/admin/oidc"
+)
+
+// OIDCOptions enables admin single sign-on through an OpenID Connect
+// provider.
+type OIDCOptions struct {
+	Provider *oidc.Provider
+	// UsernameClaim and GroupsClaim name the ID token claims holding the
+	// admin username and the user's groups.
+	UsernameClaim string
+	GroupsClaim   string
+	// GroupRoles maps IdP groups to admin roles. A user in several groups
+	// gets the highest role; a user in none is refused.
+	GroupRoles map[string]string
+	// DisablePasswordLogin turns off POST /api/admin/login.
+	DisablePasswordLogin bool
+	// SecureCookie marks the login state cookie Secure.
+	SecureCookie bool
+}
+
+// roleFor returns the highest role the groups map to, or "".
+func (o *OIDCOptions) roleFor(groups []string) string {
+	role := ""
+	for _, g := range groups {
+		if r := o.GroupRoles[g]; auth.ValidRole(r) && (role == "" || auth.RoleAtLeast(r, role)) {
+			role = r
+		}
+	}
+	return role
+}
+
+// claimStrings reads a claim that is either a string or a list of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (h *AdminHandler) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.OIDC.SecureCookie,
+		// Lax so the cookie comes back on the provider's top-level redirect.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// GET /api/admin/oidc/login
+func (h *AdminHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+		return
+	}
+
+	var s auth.OIDCState
+	for _, v := range []*string{&s.State, &s.Nonce, &s.Verifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		*v = random
+	}
+	cookie, err := auth.GenerateOIDCState(s, h.SecretKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	authURL, err := h.OIDC.Provider.AuthCodeURL(r.Context(), s.State, s.Nonce, s.Verifier)
+	if err != nil {
+		slog.Error("oidc discovery failed", "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+		return
+	}
+
+	h.setOIDCCookie(w, cookie, int(auth.OIDCStateTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// GET /api/admin/oidc/callback
+//
+// The dashboard gets the session tokens, or an error, in the fragment of a
+// redirect to /login so they never reach server logs.
+func (h *AdminHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+		return
+	}
+	h.setOIDCCookie(w, "", -1)
+	fail := func(msg string) {
+		http.Redirect(w, r, "/login#"+url.Values{"error": {msg}}.Encode(), http.StatusFound)
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail("identity provider returned " + e)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		fail("sign-in expired, try again")
+		return
+	}
+	s, err := auth.ValidateOIDCState(cookie.Value, h.SecretKey)
+	if err != nil || subtle.ConstantTimeCompare([]byte(s.State), []byte(q.Get("state"))) != 1 {
+		fail("sign-in expired, try again")
+		return
+	}
+
+	rawIDToken, err := h.OIDC.Provider.Exchange(r.Context(), q.Get("code"), s.Verifier)
+	if err != nil {
+		slog.Error("oidc code exchange failed", "error", err)
+		fail("sign-in failed")
+		return
+	}
+	claims, err := h.OIDC.Provider.VerifyIDToken(r.Context(), rawIDToken, s.Nonce)
+	if err != nil {
+		slog.Error("oidc ID token rejected", "error", err)
+		fail("sign-in failed")
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[h.OIDC.UsernameClaim].(string)
+	if subject == "" || username == "" {
+		fail("identity provider did not return a username")
+		return
+	}
+	loginAudit := models.AuditEntry{Actor: username, Action: models.AuditLoginOIDC, TargetType: auditTargetUser, TargetID: username}
+
+	role := h.OIDC.roleFor(claimStrings(claims[h.OIDC.GroupsClaim]))
+	if role == "" {
+		h.audit(r, loginAudit, nil, nil)
+		fail("your account is not allowed to use this dashboard")
+		return
+	}
+	user, err := auth.ProvisionOIDCUser(h.DB, subject, username, role)
+	if errors.Is(err, auth.ErrUsernameTaken) {
+		h.audit(r, loginAudit, nil, nil)
+		fail("username " + username + " belongs to a password account")
+		return
+	}
+	if err != nil {
+		slog.Error("oidc user provisioning failed", "error", err, "user", username)
+		fail("sign-in failed")
+		return
+	}
+
+	session, refresh, err := auth.CreateSession(h.DB, user.ID, clientIP(r), r.UserAgent())
+	if err != nil {
+		fail("failed to start session")
+		return
+	}
+	token, expiresAt, err := auth.GenerateJWT(user.Username, user.Role, session.ID, h.SecretKey)
+	if err != nil {
+		fail("failed to generate token")
+		return
+	}
+	loginAudit.Success = true
+	h.audit(r, loginAudit, nil, nil)
+
+	fragment := url.Values{
+		"token":              {token},
+		"expires_at":         {expiresAt.UTC().Format(time.RFC3339)},
+		"refresh_token":      {refresh},
+		"refresh_expires_at": {session.ExpiresAt},
+		"role":               {user.Role},
+	}
+	http.Redirect(w, r, "/login#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	projectsIDRoute = "/projects/{id}"
+
+	errInvalidProjectID = "invalid project ID"
+	errProjectNotFound  = "project not found"
+)
+
+// projectScope returns the projects the authenticated admin may see.
+// Owners see every key; other admins only the keys of their projects.
+func (h *AdminHandler) projectScope(r *http.Request) (models.ProjectScope, error) {
+	if GetAdminRoleFromContext(r) == auth.RoleOwner {
+		return models.AllProjects, nil
+	}
+	user, err := auth.GetAdminUserByUsername(h.DB, GetAdminUserFromContext(r))
+	if err != nil {
+		return models.ProjectScope{}, err
+	}
+	ids, err := models.UserProjectIDs(h.DB, user.ID)
+	if err != nil {
+		return models.ProjectScope{}, err
+	}
+	return models.ProjectScope{ProjectIDs: ids}, nil
+}
+
+// checkKeyProject validates the project a key is created in or moved to.
+// It returns an error message, or "" if the admin may use the project.
+func (h *AdminHandler) checkKeyProject(scope models.ProjectScope, projectID int64) string {
+	if !scope.Includes(projectID) {
+		if projectID == 0 {
+			return "project_id is required"
+		}
+		return errProjectNotFound
+	}
+	if projectID != 0 {
+		if _, err := models.GetProject(h.DB, projectID); err != nil {
+			return errProjectNotFound
+		}
+	}
+	return ""
+}
+
+// RequireKeyAccess answers 404 for keys outside the admin's projects, as if
+// they did not exist. Invalid and unknown IDs are left to the handler.
+func (h *AdminHandler) RequireKeyAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err == nil {
+			if key, err := models.GetAPIKeyByID(h.DB, id); err == nil {
+				scope, err := h.projectScope(r)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+					return
+				}
+				if !scope.Includes(key.ProjectID) {
+					writeJSON(w, http.StatusNotFound, map[string]string{"error": errKeyNotFound})
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// projectFromURL loads the project in the URL if the admin may see it.
+func (h *AdminHandler) projectFromURL(w http.ResponseWriter, r *http.Request) (*models.Project, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidProjectID})
+		return nil, false
+	}
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return nil, false
+	}
+	project, err := models.GetProject(h.DB, id)
+	if err != nil || !scope.Includes(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errProjectNotFound})
+		return nil, false
+	}
+	return project, true
+}
+
+// projectMembers returns the users of a project.
+func (h *AdminHandler) projectMembers(projectID int64) ([]auth.AdminUser, error) {
+	ids, err := models.ListProjectMembers(h.DB, projectID)
+	if err != nil {
+		return nil, err
+	}
+	members := []auth.AdminUser{}
+	for _, id := range ids {
+		user, err := auth.GetAdminUser(h.DB, id)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *user)
+	}
+	return members, nil
+}
+
+// GET /api/admin/projects
+func (h *AdminHandler) ListProjects(w http.ResponseWriter, r *http.Request) {
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	projects, err := models.ListProjects(h.DB, scope)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list projects"})
+		return
+	}
+	if projects == nil {
+		projects = []models.Project{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"projects": projects})
+}
+
+// POST /api/admin/projects
+func (h *AdminHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+
+	project, err := models.CreateProject(h.DB, req.Name, req.Description)
+	if errors.Is(err, models.ErrProjectNameTaken) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create project"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditProjectCreate, TargetType: auditTargetProject, TargetID: strconv.FormatInt(project.ID, 10), Success: true}, nil, project)
+	writeJSON(w, http.StatusCreated, project)
+}
+
+// GET /api/admin/projects/{id}
+func (h *AdminHandler) GetProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.projectFromURL(w, r)
+	if !ok {
+		return
+	}
+	members, err := h.projectMembers(project.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list members"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"project": project,
+		"members": members,
+	})
+}
+
+// PUT /api/admin/projects/{id}
+func (h *AdminHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.projectFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	name, description := existing.Name, existing.Description
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		description = *req.Description
+	}
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+
+	err := models.UpdateProject(h.DB, existing.ID, name, description)
+	if errors.Is(err, models.ErrProjectNameTaken) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update project"})
+		return
+	}
+
+	updated, _ := models.GetProject(h.DB, existing.ID)
+	if updated != nil {
+		h.audit(r, models.AuditEntry{Action: models.AuditProjectUpdate, TargetType: auditTargetProject, TargetID: strconv.FormatInt(existing.ID, 10), Success: true}, existing, updated)
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /api/admin/projects/{id}
+func (h *AdminHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.projectFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := models.DeleteProject(h.DB, existing.ID)
+	if errors.Is(err, models.ErrProjectHasKeys) || errors.Is(err, models.ErrProjectHasHooks) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete project"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditProjectDelete, TargetType: auditTargetProject, TargetID: strconv.FormatInt(existing.ID, 10), Success: true}, existing, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// PUT /api/admin/projects/{id}/members
+func (h *AdminHandler) SetProjectMembers(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.projectFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		UserIDs []int64 `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserIDs == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	for _, id := range req.UserIDs {
+		if _, err := auth.GetAdminUser(h.DB, id); errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown user " + strconv.FormatInt(id, 10)})
+			return
+		} else if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+	}
+
+	before, _ := models.ListProjectMembers(h.DB, project.ID)
+	if err := models.SetProjectMembers(h.DB, project.ID, req.UserIDs); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update members"})
+		return
+	}
+	after, _ := models.ListProjectMembers(h.DB, project.ID)
+	members, err := h.projectMembers(project.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list members"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditProjectMembers, TargetType: auditTargetProject, TargetID: strconv.FormatInt(project.ID, 10), Success: true},
+		map[string][]int64{"user_ids": before}, map[string][]int64{"user_ids": after})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": members})
+}
@@ -3,12 +3,15 @@ package api
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
 
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/token"
 )
 
 type PublicHandler struct {
-	DB *sql.DB
+	DB   *sql.DB
+	OIDC *OIDCOptions
 }
 
 // GET /api/public/login-config
@@ -20,7 +23,9 @@ func (h *PublicHandler) LoginConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := map[string]interface{}{
-		"captcha_required": enabled,
+		"captcha_required":       enabled,
+		"oidc_enabled":           h.OIDC != nil,
+		"password_login_enabled": h.OIDC == nil || !h.OIDC.DisablePasswordLogin,
 	}
 
 	if enabled {
@@ -34,3 +39,23 @@ func (h *PublicHandler) LoginConfig(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, resp)
 }
+
+// GET /.well-known/jwks.json
+//
+// The JWKS is cached for SigningKeyPublishDelay, so a rotated key is
+// published before it signs anything.
+func (h *PublicHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := models.ListSigningKeys(h.DB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load signing keys"})
+		return
+	}
+
+	set := token.JWKS{Keys: []token.JWK{}}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, token.NewJWK(k.KID, k.PublicKey))
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(models.SigningKeyPublishDelay.Seconds())))
+	writeJSON(w, http.StatusOK, set)
+}
@@ -3,76 +3,199 @@ package api
 import (
 	"database/sql"
 	"net/http"
+	"net/netip"
+	"time"
 
+	"github.com/Upellift99/GateCHA/internal/auth"
 	"github.com/Upellift99/GateCHA/internal/dashboard"
+	"github.com/Upellift99/GateCHA/internal/difficulty"
+	"github.com/Upellift99/GateCHA/internal/replay"
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 )
 
 const keysIDRoute = "/keys/{id}"
 
-func NewRouter(db *sql.DB, secretKey string, corsAllowAll bool) http.Handler {
+// Options configures the router.
+type Options struct {
+	SecretKey    string
+	CORSAllowAll bool
+	// RequireVerifySecret rejects verification for keys without a verify
+	// secret instead of letting them through with a warning.
+	RequireVerifySecret bool
+	// ReplayStore tracks consumed challenges. Defaults to the SQLite store.
+	ReplayStore replay.Store
+	// Difficulty tracks per-client activity for adaptive difficulty.
+	Difficulty *difficulty.Tracker
+	// SecretGracePeriod is how long a rotated-out HMAC secret stays valid.
+	SecretGracePeriod time.Duration
+	// OIDC enables admin single sign-on. Nil disables it.
+	OIDC *OIDCOptions
+	// Metrics serves GET /metrics. Nil leaves the route out, e.g. when
+	// metrics have their own listener.
+	Metrics http.Handler
+	// Events enables the verification event log. Nil disables it.
+	Events *EventOptions
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP
+	// headers give the client IP. Empty ignores those headers.
+	TrustedProxies []netip.Prefix
+}
+
+func NewRouter(db *sql.DB, opts Options) http.Handler {
+	if opts.ReplayStore == nil {
+		opts.ReplayStore = replay.NewSQLiteStore(db)
+	}
+	if opts.Difficulty == nil {
+		opts.Difficulty = difficulty.NewTracker()
+	}
+	secretKey := opts.SecretKey
+
 	r := chi.NewRouter()
 
 	r.Use(chiMiddleware.Logger)
+	r.Use(RequestMetrics)
 	r.Use(chiMiddleware.Recoverer)
-	r.Use(chiMiddleware.RealIP)
-	r.Use(CORSMiddleware(corsAllowAll))
+	r.Use(RealIPMiddleware(opts.TrustedProxies))
 
-	publicHandler := &PublicHandler{DB: db}
-	challengeHandler := &ChallengeHandler{DB: db}
-	verifyHandler := &VerifyHandler{DB: db}
-	adminHandler := &AdminHandler{DB: db, SecretKey: secretKey}
+	publicHandler := &PublicHandler{DB: db, OIDC: opts.OIDC}
+	challengeHandler := &ChallengeHandler{DB: db, Difficulty: opts.Difficulty, Events: opts.Events}
+	verifyHandler := &VerifyHandler{DB: db, Replay: opts.ReplayStore, Difficulty: opts.Difficulty, Events: opts.Events}
+	adminHandler := &AdminHandler{DB: db, SecretKey: secretKey, SecretGracePeriod: opts.SecretGracePeriod, OIDC: opts.OIDC, Events: opts.Events}
 
-	// Public endpoints (no auth, used by login page)
-	r.Route("/api/public", func(r chi.Router) {
-		r.Get("/login-config", publicHandler.LoginConfig)
-	})
+	if opts.Metrics != nil {
+		r.Method(http.MethodGet, "/metrics", opts.Metrics)
+	}
 
-	// Public API (API key auth)
+	// Public API (API key auth, CORS policy from the key)
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(APICORSMiddleware(db, opts.CORSAllowAll))
 		r.Use(APIKeyMiddleware(db))
-		r.Get("/challenge", challengeHandler.ServeHTTP)
-		r.Post("/verify", verifyHandler.ServeHTTP)
+		r.With(RequireOriginMiddleware(db)).Get("/challenge", challengeHandler.ServeHTTP)
+		r.With(VerifySecretMiddleware(opts.RequireVerifySecret)).Post("/verify", verifyHandler.ServeHTTP)
+		r.Post("/verify/signature", verifyHandler.ServeServerSignature)
 	})
 
-	// Admin API
-	r.Route("/api/admin", func(r chi.Router) {
-		r.Post("/login", adminHandler.Login)
-
-		r.Group(func(r chi.Router) {
-			r.Use(AdminAuthMiddleware(secretKey))
-			r.Get("/me", adminHandler.Me)
-			r.Post("/change-password", adminHandler.ChangePassword)
-			r.Get("/settings", adminHandler.GetSettings)
-			r.Put("/settings", adminHandler.UpdateSettings)
-
-			// API Keys CRUD
-			r.Get("/keys", adminHandler.ListKeys)
-			r.Post("/keys", adminHandler.CreateKey)
-			r.Get(keysIDRoute, adminHandler.GetKey)
-			r.Put(keysIDRoute, adminHandler.UpdateKey)
-			r.Delete(keysIDRoute, adminHandler.DeleteKey)
-			r.Post(keysIDRoute+"/rotate-secret", adminHandler.RotateSecret)
-
-			// Statistics
-			r.Get("/stats/overview", adminHandler.StatsOverview)
-			r.Get("/stats/keys-summary", adminHandler.KeysStatsSummary)
-			r.Get("/stats/keys/{id}", adminHandler.KeyStats)
+	// Everything else uses the global CORS policy
+	r.Group(func(r chi.Router) {
+		r.Use(CORSMiddleware(opts.CORSAllowAll))
+
+		// Public endpoints (no auth, used by login page)
+		r.Route("/api/public", func(r chi.Router) {
+			r.Get("/login-config", publicHandler.LoginConfig)
 		})
-	})
 
-	// Health check
-	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if err := db.Ping(); err != nil {
-			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy"})
-			return
-		}
-		writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
-	})
+		// Admin API
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Post("/login", adminHandler.Login)
+			r.Post("/login/totp", adminHandler.LoginTOTP)
+			r.Post("/refresh", adminHandler.Refresh)
+			r.Get("/oidc/login", adminHandler.OIDCLogin)
+			r.Get("/oidc/callback", adminHandler.OIDCCallback)
+
+			r.Group(func(r chi.Router) {
+				r.Use(AdminAuthMiddleware(db, secretKey))
+				editor := RequireRole(auth.RoleEditor)
+				owner := RequireRole(auth.RoleOwner)
+
+				// Personal access tokens can call these with the right scope.
+				keysRead := RequireScope(auth.ScopeKeysRead)
+				keysWrite := RequireScope(auth.ScopeKeysWrite)
+				webhooksRead := RequireScope(auth.ScopeWebhooksRead)
+				webhooksWrite := RequireScope(auth.ScopeWebhooksWrite)
+				statsRead := RequireScope(auth.ScopeStatsRead)
+				// Admins other than owners only reach the keys of their projects.
+				keyAccess := adminHandler.RequireKeyAccess
+
+				// API Keys CRUD (HMAC secrets are hidden from viewers)
+				r.With(keysRead).Get("/keys", adminHandler.ListKeys)
+				r.With(editor, keysWrite).Post("/keys", adminHandler.CreateKey)
+				r.With(keysRead, keyAccess).Get(keysIDRoute, adminHandler.GetKey)
+				r.With(editor, keysWrite, keyAccess).Put(keysIDRoute, adminHandler.UpdateKey)
+				r.With(editor, keysWrite, keyAccess).Delete(keysIDRoute, adminHandler.DeleteKey)
+				r.With(editor, keysWrite, keyAccess).Post(keysIDRoute+"/rotate-secret", adminHandler.RotateSecret)
+				r.With(editor, keysWrite, keyAccess).Post(keysIDRoute+"/verify-secret", adminHandler.RegenerateVerifySecret)
+				r.With(editor, keysWrite, keyAccess).Get(keysIDRoute+"/secrets", adminHandler.ListSecrets)
+				r.With(editor, keysWrite, keyAccess).Post(keysIDRoute+"/secrets/{secretID}/retire", adminHandler.RetireSecret)
+
+				// Projects
+				r.With(keysRead).Get("/projects", adminHandler.ListProjects)
+				r.With(keysRead).Get(projectsIDRoute, adminHandler.GetProject)
+
+				// Webhooks
+				r.With(webhooksRead).Get("/webhooks", adminHandler.ListWebhooks)
+				r.With(editor, webhooksWrite).Post("/webhooks", adminHandler.CreateWebhook)
+				r.With(webhooksRead).Get(webhooksIDRoute, adminHandler.GetWebhook)
+				r.With(editor, webhooksWrite).Put(webhooksIDRoute, adminHandler.UpdateWebhook)
+				r.With(editor, webhooksWrite).Delete(webhooksIDRoute, adminHandler.DeleteWebhook)
+				r.With(webhooksRead).Get(webhooksIDRoute+"/deliveries", adminHandler.ListWebhookDeliveries)
+				r.With(editor, webhooksWrite).Post(webhooksIDRoute+"/deliveries/{deliveryID}/resend", adminHandler.ResendWebhookDelivery)
 
-	// SPA Dashboard (catch-all)
-	r.Handle("/*", dashboard.SPAHandler())
+				// Statistics
+				r.With(statsRead).Get("/stats/overview", adminHandler.StatsOverview)
+				r.With(statsRead).Get("/stats/keys-summary", adminHandler.KeysStatsSummary)
+				r.With(statsRead, keyAccess).Get("/stats/keys/{id}", adminHandler.KeyStats)
+				r.With(statsRead).Get("/events", adminHandler.ListEvents)
+
+				// Everything else needs a signed-in admin.
+				r.Group(func(r chi.Router) {
+					r.Use(RequireSession)
+
+					r.Get("/me", adminHandler.Me)
+					r.Post("/logout", adminHandler.Logout)
+					r.Get("/sessions", adminHandler.ListSessions)
+					r.Delete("/sessions/{id}", adminHandler.RevokeSession)
+					r.Post("/sessions/revoke-all", adminHandler.RevokeAllSessions)
+					r.Post("/change-password", adminHandler.ChangePassword)
+					r.Post("/totp/enroll", adminHandler.EnrollTOTP)
+					r.Post("/totp/confirm", adminHandler.ConfirmTOTP)
+					r.Post("/totp/disable", adminHandler.DisableTOTP)
+					r.Post("/totp/recovery-codes", adminHandler.RegenerateRecoveryCodes)
+					r.Get("/settings", adminHandler.GetSettings)
+					r.With(owner).Put("/settings", adminHandler.UpdateSettings)
+
+					// Personal access tokens
+					r.Get("/tokens", adminHandler.ListTokens)
+					r.Post("/tokens", adminHandler.CreateToken)
+					r.Delete("/tokens/{id}", adminHandler.RevokeToken)
+
+					// Verification token signing keys
+					r.Get("/signing-keys", adminHandler.ListSigningKeys)
+					r.With(owner).Post("/signing-keys/rotate", adminHandler.RotateSigningKey)
+
+					// Project management
+					r.With(owner).Post("/projects", adminHandler.CreateProject)
+					r.With(owner).Put(projectsIDRoute, adminHandler.UpdateProject)
+					r.With(owner).Delete(projectsIDRoute, adminHandler.DeleteProject)
+					r.With(owner).Put(projectsIDRoute+"/members", adminHandler.SetProjectMembers)
+
+					// Admin users, the audit log and login lockouts
+					r.With(owner).Get("/users", adminHandler.ListUsers)
+					r.With(owner).Post("/users", adminHandler.CreateUser)
+					r.With(owner).Get(usersIDRoute, adminHandler.GetUser)
+					r.With(owner).Put(usersIDRoute, adminHandler.UpdateUser)
+					r.With(owner).Delete(usersIDRoute, adminHandler.DeleteUser)
+					r.With(owner).Get("/audit", adminHandler.ListAudit)
+					r.With(owner).Get("/lockouts", adminHandler.ListLockouts)
+					r.With(owner).Delete("/lockouts", adminHandler.ClearLockouts)
+				})
+			})
+		})
+
+		// Public keys for offline validation of verification tokens
+		r.Get("/.well-known/jwks.json", publicHandler.JWKS)
+
+		// Health check
+		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			if err := db.Ping(); err != nil {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+		})
+
+		// SPA Dashboard (catch-all)
+		r.Handle("/*", dashboard.SPAHandler())
+	})
 
 	return r
 }
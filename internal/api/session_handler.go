@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// writeSessionTokens responds with a fresh access token for the session and
+// its current refresh token.
+func (h *AdminHandler) writeSessionTokens(w http.ResponseWriter, user *auth.AdminUser, session *auth.Session, refresh string) {
+	token, expiresAt, err := auth.GenerateJWT(user.Username, user.Role, session.ID, h.SecretKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":              token,
+		"expires_at":         expiresAt,
+		"refresh_token":      refresh,
+		"refresh_expires_at": session.ExpiresAt,
+		"role":               user.Role,
+	})
+}
+
+// POST /api/admin/refresh
+func (h *AdminHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+
+	session, refresh, err := auth.RefreshSession(h.DB, req.RefreshToken, clientIP(r), r.UserAgent())
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to refresh session"})
+		return
+	}
+
+	// The role is read again so changes apply from the next refresh.
+	user, err := auth.GetAdminUser(h.DB, session.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": auth.ErrInvalidRefreshToken.Error()})
+		return
+	}
+	h.writeSessionTokens(w, user, session, refresh)
+}
+
+// POST /api/admin/logout
+func (h *AdminHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if err := auth.RevokeSession(h.DB, user.ID, GetAdminSessionFromContext(r)); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to log out"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditLogout, TargetType: auditTargetUser, TargetID: user.Username, Success: true}, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// GET /api/admin/sessions
+func (h *AdminHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := auth.ListSessions(h.DB, user.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+		return
+	}
+	if sessions == nil {
+		sessions = []auth.Session{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+		"current":  GetAdminSessionFromContext(r),
+	})
+}
+
+// DELETE /api/admin/sessions/{id}
+func (h *AdminHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	err := auth.RevokeSession(h.DB, user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditSessionRevoke, TargetType: auditTargetUser, TargetID: user.Username, Success: true},
+		nil, map[string]string{"session": id})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// POST /api/admin/sessions/revoke-all
+func (h *AdminHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	n, err := auth.RevokeUserSessions(h.DB, user.ID, GetAdminSessionFromContext(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditSessionRevoke, TargetType: auditTargetUser, TargetID: user.Username, Success: true},
+		nil, map[string]int64{"revoked": n})
+	writeJSON(w, http.StatusOK, map[string]int64{"revoked": n})
+}
+
+// revokeSessionsFor signs out another user after an owner changed their
+// credentials or role. The acting admin's own session is kept.
+func (h *AdminHandler) revokeSessionsFor(r *http.Request, user *auth.AdminUser) {
+	if _, err := auth.RevokeUserSessions(h.DB, user.ID, GetAdminSessionFromContext(r)); err != nil {
+		slog.Error("failed to revoke sessions", "error", err, "user", user.Username)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxTokenLifetimeDays caps expires_in_days so the expiry stays a valid
+// timestamp.
+const maxTokenLifetimeDays = 3650
+
+// GET /api/admin/tokens
+func (h *AdminHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := auth.ListPersonalTokens(h.DB, user.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []auth.PersonalToken{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tokens": tokens,
+		"scopes": auth.Scopes(),
+	})
+}
+
+// POST /api/admin/tokens
+func (h *AdminHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetimeDays {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expires_in_days must be between 0 and 3650"})
+		return
+	}
+	if err := auth.CheckScopes(req.Scopes, user.Role); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	created, token, err := auth.CreatePersonalToken(h.DB, user.ID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create token"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditTokenCreate, TargetType: auditTargetToken, TargetID: strconv.FormatInt(created.ID, 10), Success: true},
+		nil, created)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"token_info": created,
+	})
+}
+
+// DELETE /api/admin/tokens/{id}
+func (h *AdminHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token ID"})
+		return
+	}
+
+	revoked, err := auth.RevokePersonalToken(h.DB, user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke token"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditTokenRevoke, TargetType: auditTargetToken, TargetID: strconv.FormatInt(id, 10), Success: true},
+		revoked, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
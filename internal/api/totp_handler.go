@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+)
+
+// currentUser loads the authenticated admin.
+func (h *AdminHandler) currentUser(w http.ResponseWriter, r *http.Request) (*auth.AdminUser, bool) {
+	user, err := auth.GetAdminUserByUsername(h.DB, GetAdminUserFromContext(r))
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unknown user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// POST /api/admin/login/totp
+func (h *AdminHandler) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LoginToken string `json:"login_token"`
+		Code       string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+
+	username, err := auth.ValidateLoginChallenge(req.LoginToken, h.SecretKey)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "login expired, sign in again"})
+		return
+	}
+	loginAudit := models.AuditEntry{Actor: username, Action: models.AuditLogin, TargetType: auditTargetUser, TargetID: username}
+
+	attempt, ok := h.beginLogin(w, r, username)
+	if !ok {
+		return
+	}
+	user, err := auth.GetAdminUserByUsername(h.DB, username)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "login expired, sign in again"})
+		return
+	}
+	if err := auth.VerifySecondFactor(h.DB, user.ID, req.Code, time.Now()); err != nil {
+		h.audit(r, loginAudit, nil, nil)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid code"})
+		return
+	}
+
+	h.completeLogin(w, r, attempt, user, loginAudit)
+}
+
+// POST /api/admin/totp/enroll
+func (h *AdminHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := auth.BeginTOTPEnrollment(h.DB, user.ID)
+	if errors.Is(err, auth.ErrTOTPAlreadyEnabled) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start enrolment"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(user.Username, secret),
+	})
+}
+
+// POST /api/admin/totp/confirm
+func (h *AdminHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+
+	codes, err := auth.ConfirmTOTPEnrollment(h.DB, user.ID, req.Code, time.Now())
+	switch {
+	case errors.Is(err, auth.ErrInvalidTOTPCode):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, auth.ErrTOTPAlreadyEnabled), errors.Is(err, auth.ErrTOTPNotEnrolled):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditTOTPEnable, TargetType: auditTargetUser, TargetID: user.Username, Success: true},
+		map[string]bool{"totp_enabled": false}, map[string]bool{"totp_enabled": true})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// POST /api/admin/totp/disable
+func (h *AdminHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	if !user.TOTPEnabled {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	if ok, err := auth.ValidateCredentials(h.DB, user.Username, req.Password); err != nil || !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid password"})
+		return
+	}
+	if err := auth.VerifySecondFactor(h.DB, user.ID, req.Code, time.Now()); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid code"})
+		return
+	}
+	if err := auth.DisableTOTP(h.DB, user.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditTOTPDisable, TargetType: auditTargetUser, TargetID: user.Username, Success: true},
+		map[string]bool{"totp_enabled": true}, map[string]bool{"totp_enabled": false})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "disabled"})
+}
+
+// POST /api/admin/totp/recovery-codes
+func (h *AdminHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	if !user.TOTPEnabled {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "two-factor authentication is not enabled"})
+		return
+	}
+	if err := auth.VerifySecondFactor(h.DB, user.ID, req.Code, time.Now()); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid code"})
+		return
+	}
+
+	codes, err := auth.RegenerateRecoveryCodes(h.DB, user.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditRecoveryCodes, TargetType: auditTargetUser, TargetID: user.Username, Success: true}, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
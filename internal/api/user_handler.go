@@ -0,0 +1,189 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	usersIDRoute = "/users/{id}"
+
+	errInvalidUserID = "invalid user ID"
+	errUserNotFound  = "user not found"
+	errInvalidRole   = "role must be owner, editor or viewer"
+)
+
+func (h *AdminHandler) userFromURL(w http.ResponseWriter, r *http.Request) (*auth.AdminUser, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidUserID})
+		return nil, false
+	}
+	user, err := auth.GetAdminUser(h.DB, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUserNotFound})
+		return nil, false
+	}
+	return user, true
+}
+
+// GET /api/admin/users
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := auth.ListAdminUsers(h.DB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list users"})
+		return
+	}
+	if users == nil {
+		users = []auth.AdminUser{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+}
+
+// POST /api/admin/users
+func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username and password are required"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+	if !auth.ValidRole(req.Role) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRole})
+		return
+	}
+
+	user, err := auth.CreateAdminUser(h.DB, req.Username, req.Password, req.Role)
+	if errors.Is(err, auth.ErrUsernameTaken) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditUserCreate, TargetType: auditTargetUser, TargetID: user.Username, Success: true}, nil, user)
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// GET /api/admin/users/{id}
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// PUT /api/admin/users/{id}
+func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role     string `json:"role"`
+		Password string `json:"password"`
+		// DisableTOTP resets two-factor authentication for a user who
+		// lost both their device and their recovery codes.
+		DisableTOTP bool `json:"disable_totp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+	if req.Role != "" && !auth.ValidRole(req.Role) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRole})
+		return
+	}
+
+	if req.Role != "" && req.Role != user.Role {
+		err := auth.SetAdminUserRole(h.DB, user.ID, req.Role)
+		if errors.Is(err, auth.ErrLastOwner) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := auth.ChangePassword(h.DB, user.Username, req.Password); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
+			return
+		}
+	}
+
+	if req.DisableTOTP && user.TOTPEnabled {
+		if err := auth.DisableTOTP(h.DB, user.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
+			return
+		}
+	}
+
+	if req.Password != "" || req.DisableTOTP || (req.Role != "" && req.Role != user.Role) {
+		h.revokeSessionsFor(r, user)
+	}
+
+	updated, err := auth.GetAdminUser(h.DB, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUserNotFound})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
+		return
+	}
+	after := map[string]interface{}{"role": updated.Role, "totp_enabled": updated.TOTPEnabled}
+	if req.Password != "" {
+		after["password_reset"] = true
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditUserUpdate, TargetType: auditTargetUser, TargetID: user.Username, Success: true},
+		map[string]interface{}{"role": user.Role, "totp_enabled": user.TOTPEnabled}, after)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /api/admin/users/{id}
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := auth.DeleteAdminUser(h.DB, user.ID)
+	if errors.Is(err, auth.ErrLastOwner) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUserNotFound})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditUserDelete, TargetType: auditTargetUser, TargetID: user.Username, Success: true}, user, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
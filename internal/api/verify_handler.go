@@ -4,35 +4,265 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/Upellift99/GateCHA/internal/altcha"
+	"github.com/Upellift99/GateCHA/internal/difficulty"
+	"github.com/Upellift99/GateCHA/internal/metrics"
 	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/replay"
+	"github.com/Upellift99/GateCHA/internal/token"
 
 	lib "github.com/altcha-org/altcha-lib-go"
 )
 
 const logMsgFailIncrement = "failed to increment verifications_fail"
 
+// Failure reasons reported to clients when a solution is rejected.
+const (
+	reasonInvalidEncoding = "invalid payload encoding"
+	reasonInvalidFormat   = "invalid payload format"
+	reasonVerifyFailed    = "verification failed"
+	reasonInvalidSolution = "invalid_solution"
+	reasonAlreadyUsed     = "already_used"
+	reasonTooFast         = "too_fast"
+	reasonTooLate         = "too_late"
+)
+
+// statsReasons maps each failure reason to the reason counted in the
+// statistics.
+var statsReasons = map[string]string{
+	reasonInvalidEncoding: models.FailInvalidEncoding,
+	reasonInvalidFormat:   models.FailInvalidFormat,
+	reasonVerifyFailed:    models.FailVerificationError,
+	reasonInvalidSolution: models.FailInvalidSolution,
+	reasonAlreadyUsed:     models.FailAlreadyUsed,
+	reasonTooFast:         models.FailTooFast,
+	reasonTooLate:         models.FailTooLate,
+}
+
 type VerifyHandler struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Replay     replay.Store
+	Difficulty *difficulty.Tracker
+	Events     *EventOptions
 }
 
 type verifyRequest struct {
-	Payload string `json:"payload"`
+	Payload    string `json:"payload"`
+	IssueToken bool   `json:"issue_token"`
 }
 
 type verifyResponse struct {
-	OK    bool   `json:"ok"`
-	Error string `json:"error,omitempty"`
+	OK             bool       `json:"ok"`
+	Error          string     `json:"error,omitempty"`
+	Flagged        string     `json:"flagged,omitempty"`
+	Token          string     `json:"token,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+}
+
+// serverSignatureResponse is the body the ALTCHA widget expects from its verifyurl.
+type serverSignatureResponse struct {
+	Verified bool   `json:"verified"`
+	Payload  string `json:"payload,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// solutionPayload is the decoded ALTCHA payload. The widget also reports
+// how long solving took, which lib.Payload leaves out.
+type solutionPayload struct {
+	lib.Payload
+	Took tookMillis `json:"took"`
+}
+
+// tookMillis is the reported solving time. Widgets may send a fractional
+// number of milliseconds, which is rounded. Anything else that is not a
+// non-negative number counts as not reported rather than failing the
+// whole payload.
+type tookMillis int64
+
+func (t *tookMillis) UnmarshalJSON(data []byte) error {
+	var ms float64
+	if err := json.Unmarshal(data, &ms); err != nil || ms < 0 || ms >= math.MaxInt64 {
+		*t = 0
+		return nil
+	}
+	*t = tookMillis(math.Round(ms))
+	return nil
+}
+
+// verificationError is returned by verifySolution when the solution is
+// rejected; Reason is safe to show to the caller.
+type verificationError struct {
+	Reason string
+}
+
+func (e *verificationError) Error() string { return e.Reason }
+
+func (h *VerifyHandler) recordFail(key *models.APIKey, reason string) {
+	metrics.Verifications.Inc(key.KeyID, statsReasons[reason])
+	if err := models.IncrementVerificationsFail(h.DB, key.ID, statsReasons[reason]); err != nil {
+		slog.Error(logMsgFailIncrement, "error", err, "api_key_id", key.ID)
+	}
+}
+
+// reject counts a failed verification and charges it to the adaptive
+// difficulty client tag, if there is one.
+func (h *VerifyHandler) reject(key *models.APIKey, tag, reason string) error {
+	h.recordFail(key, reason)
+	if h.Difficulty != nil && tag != "" {
+		h.Difficulty.RecordFailure(trackerKey(key.ID, tag))
+	}
+	return &verificationError{Reason: reason}
+}
+
+// requestTag returns the adaptive difficulty client tag of the request's
+// own IP. Failures of payloads whose signature is not verified are charged
+// to it: their client tag could be copied from anyone's challenge.
+func (h *VerifyHandler) requestTag(r *http.Request, key *models.APIKey) string {
+	if !key.AdaptiveDifficulty || h.Difficulty == nil {
+		return ""
+	}
+	return difficulty.ClientTag(key.HMACSecret, difficulty.ClientPrefix(clientIP(r)))
+}
+
+// verifySolution checks an encoded ALTCHA payload against key and consumes
+// its challenge. Rejections are counted and returned as *verificationError;
+// any other error is internal. secret is the HMAC secret of the key ring
+// that verified the solution. flagged is the timing check an accepted
+// solution failed, for keys that only flag them.
+func (h *VerifyHandler) verifySolution(r *http.Request, key *models.APIKey, encoded string) (payload solutionPayload, secret, flagged string, err error) {
+	// Decode payload to extract challenge hash for replay check
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return payload, "", "", h.reject(key, h.requestTag(r, key), reasonInvalidEncoding)
+	}
+
+	if err := json.Unmarshal(decoded, &payload); err != nil {
+		return payload, "", "", h.reject(key, h.requestTag(r, key), reasonInvalidFormat)
+	}
+
+	// Verify the solution against the key ring
+	secrets, err := models.VerificationSecrets(h.DB, key)
+	if err != nil {
+		return payload, "", "", err
+	}
+	secret, ok, err := altcha.VerifyPayloadWithSecrets(secrets, encoded)
+	if err != nil {
+		return payload, "", "", h.reject(key, h.requestTag(r, key), reasonVerifyFailed)
+	}
+
+	if !ok {
+		return payload, "", "", h.reject(key, h.requestTag(r, key), reasonInvalidSolution)
+	}
+
+	// The signature is verified from here on, so the payload's own client
+	// tag can be charged.
+	tag := lib.ExtractParams(payload.Payload).Get("client")
+
+	// Check replay and mark as consumed in one step
+	expiresAt := time.Now().Add(time.Duration(key.ExpireSeconds) * time.Second)
+	used, err := h.Replay.Consume(payload.Challenge, expiresAt)
+	if err != nil {
+		return payload, "", "", err
+	}
+	if used {
+		return payload, "", "", h.reject(key, tag, reasonAlreadyUsed)
+	}
+
+	// Timing is checked once the challenge is consumed, so a solution sent
+	// too early cannot simply be sent again later.
+	if reason := timingViolation(key, payload, time.Now()); reason != "" {
+		if key.TimingEnforcement != models.TimingEnforcementFlag {
+			return payload, "", "", h.reject(key, tag, reason)
+		}
+		flagged = reason
+		if err := models.IncrementTimingFlagged(h.DB, key.ID); err != nil {
+			slog.Error("failed to increment timing_flagged", "error", err, "api_key_id", key.ID)
+		}
+	}
+
+	metrics.Verifications.Inc(key.KeyID, "ok")
+	if err := models.IncrementVerificationsOK(h.DB, key.ID); err != nil {
+		slog.Error("failed to increment verifications_ok", "error", err, "api_key_id", key.ID)
+	}
+	if ms, reported, ok := solveTime(key, payload, time.Now()); ok {
+		if err := models.RecordSolveTime(h.DB, key.ID, ms, payload.Number, reported); err != nil {
+			slog.Error("failed to record solve time", "error", err, "api_key_id", key.ID)
+		}
+	}
+	return payload, secret, flagged, nil
 }
 
-func (h *VerifyHandler) recordFail(apiKeyID int64) {
-	if err := models.IncrementVerificationsFail(h.DB, apiKeyID); err != nil {
-		slog.Error(logMsgFailIncrement, "error", err, "api_key_id", apiKeyID)
+// timingViolation returns the reason a solution fails the key's timing
+// checks, or "". The challenge age comes from the issue time signed into
+// the salt; challenges issued before it was added skip the age checks. A
+// missing took is left to the age check rather than counted as 0 ms.
+func timingViolation(key *models.APIKey, payload solutionPayload, now time.Time) string {
+	if key.MinSolveMS > 0 && payload.Took > 0 && int64(payload.Took) < key.MinSolveMS {
+		return reasonTooFast
+	}
+	issued, err := strconv.ParseInt(lib.ExtractParams(payload.Payload).Get("issued"), 10, 64)
+	if err != nil {
+		return ""
+	}
+	age := now.Sub(time.UnixMilli(issued))
+	if age < time.Duration(max(key.MinSolveMS, key.MinChallengeAgeMS))*time.Millisecond {
+		return reasonTooFast
+	}
+	if key.MaxChallengeAgeSeconds > 0 && age > time.Duration(key.MaxChallengeAgeSeconds)*time.Second {
+		return reasonTooLate
 	}
+	return ""
+}
+
+// solveTime returns how long the solution took in milliseconds, and
+// whether the widget reported it. Without a report it falls back to the
+// time since the challenge was issued, which also covers the time before
+// the widget started solving. Challenges without a signed issue time have
+// it worked out from their expiry.
+func solveTime(key *models.APIKey, payload solutionPayload, now time.Time) (int64, bool, bool) {
+	if payload.Took > 0 {
+		return int64(payload.Took), true, true
+	}
+	params := lib.ExtractParams(payload.Payload)
+	var issued time.Time
+	if ms, err := strconv.ParseInt(params.Get("issued"), 10, 64); err == nil {
+		issued = time.UnixMilli(ms)
+	} else if expires, err := strconv.ParseInt(params.Get("expires"), 10, 64); err == nil {
+		issued = time.Unix(expires, 0).Add(-time.Duration(key.ExpireSeconds) * time.Second)
+	} else {
+		return 0, false, false
+	}
+	elapsed := now.Sub(issued)
+	if elapsed < 0 {
+		return 0, false, false
+	}
+	return elapsed.Milliseconds(), false, true
+}
+
+// recordVerification writes the outcome of verifySolution to the event
+// log. Internal errors are not recorded.
+func (h *VerifyHandler) recordVerification(r *http.Request, key *models.APIKey, payload solutionPayload, err error) {
+	outcome := models.EventOK
+	var verr *verificationError
+	if errors.As(err, &verr) {
+		outcome = statsReasons[verr.Reason]
+	} else if err != nil {
+		return
+	}
+	recordEvent(h.DB, h.Events, r, key, models.VerificationEvent{
+		Kind:    models.EventVerify,
+		Outcome: outcome,
+		Origin:  lib.ExtractParams(payload.Payload).Get("origin"),
+		Took:    int64(payload.Took),
+	})
 }
 
 func (h *VerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -55,57 +285,103 @@ func (h *VerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decode payload to extract challenge hash for replay check
-	decoded, err := base64.StdEncoding.DecodeString(req.Payload)
+	payload, _, flagged, err := h.verifySolution(r, key, req.Payload)
+	h.recordVerification(r, key, payload, err)
+	var verr *verificationError
+	if errors.As(err, &verr) {
+		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: verr.Reason})
+		return
+	}
 	if err != nil {
-		h.recordFail(key.ID)
-		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: "invalid payload encoding"})
+		slog.Error("failed to verify solution", "error", err, "api_key_id", key.ID)
+		writeJSON(w, http.StatusInternalServerError, verifyResponse{OK: false, Error: "internal error"})
 		return
 	}
 
-	var payload lib.Payload
-	if err := json.Unmarshal(decoded, &payload); err != nil {
-		h.recordFail(key.ID)
-		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: "invalid payload format"})
-		return
+	resp := verifyResponse{OK: true, Flagged: flagged}
+	if req.IssueToken {
+		origin := lib.ExtractParams(payload.Payload).Get("origin")
+		if origin == "" {
+			origin = requestOrigin(r)
+		}
+		signed, expiresAt, err := h.issueToken(key, payload.Challenge, origin)
+		if err != nil {
+			slog.Error("failed to issue verification token", "error", err, "api_key_id", key.ID)
+			writeJSON(w, http.StatusInternalServerError, verifyResponse{OK: false, Error: "internal error"})
+			return
+		}
+		resp.Token = signed
+		resp.TokenExpiresAt = &expiresAt
 	}
 
-	// Verify the solution
-	ok, err := altcha.VerifyPayload(key.HMACSecret, req.Payload)
-	if err != nil {
-		h.recordFail(key.ID)
-		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: "verification failed"})
+	slog.Debug("verify success", "api_key_id", key.ID)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ServeServerSignature is the widget-facing verifyurl endpoint. It answers
+// like ALTCHA Sentinel: the solution is checked here and the widget receives
+// a payload signed with the key's HMAC secret that issued the challenge,
+// which the site backend checks offline with verifyServerSignature.
+func (h *VerifyHandler) ServeServerSignature(w http.ResponseWriter, r *http.Request) {
+	key := GetAPIKeyFromContext(r)
+	if key == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing API key"})
 		return
 	}
 
-	if !ok {
-		h.recordFail(key.ID)
-		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: "invalid_solution"})
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, serverSignatureResponse{Error: "invalid request body"})
+		return
+	}
+	if req.Payload == "" {
+		writeJSON(w, http.StatusBadRequest, serverSignatureResponse{Error: "missing payload"})
 		return
 	}
 
-	// Check replay
-	consumed, err := models.IsConsumed(h.DB, payload.Challenge)
-	if err != nil {
-		slog.Error("failed to check consumed", "error", err, "api_key_id", key.ID)
-		writeJSON(w, http.StatusInternalServerError, verifyResponse{OK: false, Error: "internal error"})
+	payload, secret, flagged, err := h.verifySolution(r, key, req.Payload)
+	h.recordVerification(r, key, payload, err)
+	var verr *verificationError
+	if errors.As(err, &verr) {
+		writeJSON(w, http.StatusOK, serverSignatureResponse{Verified: false, Error: verr.Reason})
 		return
 	}
-	if consumed {
-		h.recordFail(key.ID)
-		writeJSON(w, http.StatusOK, verifyResponse{OK: false, Error: "already_used"})
+	if err != nil {
+		slog.Error("failed to verify solution", "error", err, "api_key_id", key.ID)
+		writeJSON(w, http.StatusInternalServerError, serverSignatureResponse{Error: "internal error"})
 		return
 	}
 
-	// Mark as consumed
-	expiresAt := time.Now().Add(time.Duration(key.ExpireSeconds) * time.Second)
-	if err := models.MarkConsumed(h.DB, payload.Challenge, key.ID, expiresAt); err != nil {
-		slog.Error("failed to mark consumed", "error", err, "api_key_id", key.ID)
+	now := time.Now()
+	data := url.Values{}
+	data.Set("verified", "true")
+	data.Set("time", strconv.FormatInt(now.Unix(), 10))
+	data.Set("expire", strconv.FormatInt(now.Add(time.Duration(key.ExpireSeconds)*time.Second).Unix(), 10))
+	data.Set("apiKey", key.KeyID)
+	if flagged != "" {
+		data.Set("flagged", flagged)
 	}
-	if err := models.IncrementVerificationsOK(h.DB, key.ID); err != nil {
-		slog.Error("failed to increment verifications_ok", "error", err, "api_key_id", key.ID)
+	if origin := lib.ExtractParams(payload.Payload).Get("origin"); origin != "" {
+		data.Set("origin", origin)
 	}
 
-	slog.Debug("verify success", "api_key_id", key.ID)
-	writeJSON(w, http.StatusOK, verifyResponse{OK: true})
+	// Signed with the secret the challenge was issued under, so a backend
+	// still holding a rotated-out secret keeps working during its grace
+	// period.
+	signed, err := altcha.CreateServerSignature(secret, key.Algorithm, data)
+	if err != nil {
+		slog.Error("failed to sign verification", "error", err, "api_key_id", key.ID)
+		writeJSON(w, http.StatusInternalServerError, serverSignatureResponse{Error: "internal error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serverSignatureResponse{Verified: true, Payload: signed})
+}
+
+func (h *VerifyHandler) issueToken(key *models.APIKey, challenge, origin string) (string, time.Time, error) {
+	signingKey, err := models.EnsureSigningKey(h.DB)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token.Sign(signingKey.KID, signingKey.PrivateKey, key.KeyID, challenge, origin, time.Now())
 }
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	webhooksIDRoute = "/webhooks/{id}"
+
+	errInvalidWebhookID = "invalid webhook ID"
+	errWebhookNotFound  = "webhook not found"
+
+	// deliveryLogLimit is how many deliveries the log endpoint returns.
+	deliveryLogLimit = 100
+)
+
+// emit queues a webhook event for the webhooks of projectIDs and those
+// outside every project. Failures are logged; the admin action that
+// triggered the event has already succeeded.
+func (h *AdminHandler) emit(event string, data interface{}, projectIDs ...int64) {
+	if err := models.EnqueueWebhookEvent(h.DB, event, data, projectIDs...); err != nil {
+		slog.Error("failed to queue webhook event", "error", err, "event", event)
+	}
+}
+
+// keyEventData is the data of key.* webhook events.
+func keyEventData(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{"id": key.ID, "key_id": key.KeyID, "name": key.Name, "project_id": key.ProjectID}
+}
+
+// validateWebhook checks a webhook's URL, events and failure rate settings.
+func validateWebhook(params models.UpdateWebhookParams) string {
+	u, err := url.Parse(params.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	if len(params.Events) == 0 {
+		return "at least one event is required"
+	}
+	for _, e := range params.Events {
+		if !models.IsWebhookEvent(e) {
+			return "unknown event: " + e
+		}
+	}
+	if params.FailureRateThreshold <= 0 || params.FailureRateThreshold > 1 {
+		return "failure_rate_threshold must be greater than 0 and at most 1"
+	}
+	if params.FailureRateMinVerifications < 1 {
+		return "failure_rate_min_verifications must be at least 1"
+	}
+	return ""
+}
+
+// webhookFromURL loads the webhook in the URL if the admin may see it;
+// webhooks outside the admin's projects answer 404.
+func (h *AdminHandler) webhookFromURL(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidWebhookID})
+		return nil, false
+	}
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return nil, false
+	}
+	wh, err := models.GetWebhook(h.DB, id)
+	if err != nil || !scope.Includes(wh.ProjectID) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errWebhookNotFound})
+		return nil, false
+	}
+	return wh, true
+}
+
+// GET /api/admin/webhooks
+func (h *AdminHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	hooks, err := models.ListWebhooks(h.DB, scope)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list webhooks"})
+		return
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"webhooks": hooks, "events": models.WebhookEvents})
+}
+
+// POST /api/admin/webhooks
+func (h *AdminHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ProjectID                   int64    `json:"project_id"`
+		URL                         string   `json:"url"`
+		Events                      []string `json:"events"`
+		FailureRateThreshold        *float64 `json:"failure_rate_threshold"`
+		FailureRateMinVerifications *int     `json:"failure_rate_min_verifications"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+
+	scope, err := h.projectScope(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	if msg := h.checkKeyProject(scope, req.ProjectID); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	params := models.UpdateWebhookParams{
+		ProjectID:                   req.ProjectID,
+		URL:                         req.URL,
+		Events:                      req.Events,
+		Enabled:                     true,
+		FailureRateThreshold:        models.DefaultFailureRateThreshold,
+		FailureRateMinVerifications: models.DefaultFailureRateMinVerifications,
+	}
+	if req.FailureRateThreshold != nil {
+		params.FailureRateThreshold = *req.FailureRateThreshold
+	}
+	if req.FailureRateMinVerifications != nil {
+		params.FailureRateMinVerifications = *req.FailureRateMinVerifications
+	}
+	if msg := validateWebhook(params); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	wh, err := models.CreateWebhook(h.DB, params.ProjectID, params.URL, params.Events)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create webhook"})
+		return
+	}
+	if params.FailureRateThreshold != wh.FailureRateThreshold || params.FailureRateMinVerifications != wh.FailureRateMinVerifications {
+		if err := models.UpdateWebhook(h.DB, wh.ID, params); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create webhook"})
+			return
+		}
+		wh.FailureRateThreshold = params.FailureRateThreshold
+		wh.FailureRateMinVerifications = params.FailureRateMinVerifications
+	}
+
+	h.audit(r, models.AuditEntry{Action: models.AuditWebhookCreate, TargetType: auditTargetWebhook, TargetID: strconv.FormatInt(wh.ID, 10), Success: true}, nil, wh)
+	writeJSON(w, http.StatusCreated, wh)
+}
+
+// GET /api/admin/webhooks/{id}
+func (h *AdminHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	wh.Secret = ""
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// PUT /api/admin/webhooks/{id}
+func (h *AdminHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ProjectID                   *int64   `json:"project_id"`
+		URL                         string   `json:"url"`
+		Events                      []string `json:"events"`
+		Enabled                     *bool    `json:"enabled"`
+		FailureRateThreshold        *float64 `json:"failure_rate_threshold"`
+		FailureRateMinVerifications *int     `json:"failure_rate_min_verifications"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidRequest})
+		return
+	}
+
+	params := wh.UpdateParams()
+	if req.ProjectID != nil && *req.ProjectID != wh.ProjectID {
+		scope, err := h.projectScope(r)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if msg := h.checkKeyProject(scope, *req.ProjectID); msg != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+			return
+		}
+		params.ProjectID = *req.ProjectID
+	}
+	if req.URL != "" {
+		params.URL = req.URL
+	}
+	if req.Events != nil {
+		params.Events = req.Events
+	}
+	if req.Enabled != nil {
+		params.Enabled = *req.Enabled
+	}
+	if req.FailureRateThreshold != nil {
+		params.FailureRateThreshold = *req.FailureRateThreshold
+	}
+	if req.FailureRateMinVerifications != nil {
+		params.FailureRateMinVerifications = *req.FailureRateMinVerifications
+	}
+	if msg := validateWebhook(params); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	if err := models.UpdateWebhook(h.DB, wh.ID, params); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update webhook"})
+		return
+	}
+
+	updated, err := models.GetWebhook(h.DB, wh.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errWebhookNotFound})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update webhook"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditWebhookUpdate, TargetType: auditTargetWebhook, TargetID: strconv.FormatInt(wh.ID, 10), Success: true}, wh, updated)
+	updated.Secret = ""
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /api/admin/webhooks/{id}
+func (h *AdminHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteWebhook(h.DB, existing.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete webhook"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditWebhookDelete, TargetType: auditTargetWebhook, TargetID: strconv.FormatInt(existing.ID, 10), Success: true}, existing, nil)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// GET /api/admin/webhooks/{id}/deliveries
+func (h *AdminHandler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := models.ListWebhookDeliveries(h.DB, wh.ID, deliveryLogLimit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list deliveries"})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deliveries": deliveries})
+}
+
+// POST /api/admin/webhooks/{id}/deliveries/{deliveryID}/resend
+func (h *AdminHandler) ResendWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid delivery ID"})
+		return
+	}
+
+	delivery, err := models.ResendWebhookDelivery(h.DB, wh.ID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "delivery not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to resend delivery"})
+		return
+	}
+	h.audit(r, models.AuditEntry{Action: models.AuditWebhookResend, TargetType: auditTargetWebhook, TargetID: strconv.FormatInt(wh.ID, 10), Success: true},
+		nil, map[string]int64{"delivery_id": deliveryID, "resent_as": delivery.ID})
+	writeJSON(w, http.StatusAccepted, delivery)
+}
@@ -43,12 +43,16 @@ func ValidateCredentials(db *sql.DB, username, password string) (bool, error) {
 	return true, nil
 }
 
-func GenerateJWT(username, secretKey string) (string, time.Time, error) {
-	expiresAt := time.Now().Add(24 * time.Hour)
+// GenerateJWT issues an access token for a session. The session ID is the
+// token's jti.
+func GenerateJWT(username, role, sessionID, secretKey string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(AccessTokenTTL)
 	claims := jwt.MapClaims{
-		"sub": username,
-		"exp": expiresAt.Unix(),
-		"iat": time.Now().Unix(),
+		"sub":  username,
+		"role": role,
+		"jti":  sessionID,
+		"exp":  expiresAt.Unix(),
+		"iat":  time.Now().Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signed, err := token.SignedString([]byte(secretKey))
@@ -58,6 +62,43 @@ func GenerateJWT(username, secretKey string) (string, time.Time, error) {
 	return signed, expiresAt, nil
 }
 
+// loginChallengePurpose marks tokens that only prove the password step of a
+// two-factor login. They carry no role, so AdminAuthMiddleware rejects them.
+const loginChallengePurpose = "totp_login"
+
+// LoginChallengeTTL is how long a user has to enter their second factor.
+const LoginChallengeTTL = 5 * time.Minute
+
+// GenerateLoginChallenge returns a short-lived token for the second step of
+// a two-factor login.
+func GenerateLoginChallenge(username, secretKey string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(LoginChallengeTTL)
+	claims := jwt.MapClaims{
+		"sub":     username,
+		"purpose": loginChallengePurpose,
+		"exp":     expiresAt.Unix(),
+		"iat":     time.Now().Unix(),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secretKey))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// ValidateLoginChallenge returns the username a login challenge was issued
+// for.
+func ValidateLoginChallenge(tokenStr, secretKey string) (string, error) {
+	claims, err := ValidateJWT(tokenStr, secretKey)
+	if err != nil {
+		return "", err
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != loginChallengePurpose {
+		return "", fmt.Errorf("not a login challenge")
+	}
+	return claims.GetSubject()
+}
+
 func ValidateJWT(tokenStr, secretKey string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -57,7 +57,7 @@ func TestValidateCredentials(t *testing.T) {
 func TestGenerateAndValidateJWT(t *testing.T) {
 	secret := "test-secret-key"
 
-	token, expiresAt, err := GenerateJWT("admin", secret)
+	token, expiresAt, err := GenerateJWT("admin", RoleOwner, "session-id", secret)
 	if err != nil {
 		t.Fatalf("GenerateJWT failed: %v", err)
 	}
@@ -78,7 +78,7 @@ func TestGenerateAndValidateJWT(t *testing.T) {
 }
 
 func TestValidateJWT_WrongSecret(t *testing.T) {
-	token, _, _ := GenerateJWT("admin", "correct-secret")
+	token, _, _ := GenerateJWT("admin", RoleOwner, "session-id", "correct-secret")
 
 	_, err := ValidateJWT(token, "wrong-secret")
 	if err == nil {
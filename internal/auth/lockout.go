@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lockout scopes. Failed logins are counted per username and per client IP.
+const (
+	LockoutScopeUser = "user"
+	LockoutScopeIP   = "ip"
+)
+
+// lockoutPolicy turns a failure count into how long further attempts are
+// refused. The first freeAttempts failures cost nothing, then the delay
+// doubles from one second with each failure until lockoutAfter, which locks
+// the subject out for lockoutDuration.
+type lockoutPolicy struct {
+	freeAttempts    int
+	lockoutAfter    int
+	lockoutDuration time.Duration
+}
+
+var lockoutPolicies = map[string]lockoutPolicy{
+	LockoutScopeUser: {freeAttempts: 3, lockoutAfter: 10, lockoutDuration: 15 * time.Minute},
+	// An IP may be shared by several admins, so it gets more headroom.
+	LockoutScopeIP: {freeAttempts: 10, lockoutAfter: 30, lockoutDuration: 15 * time.Minute},
+}
+
+// LoginFailureWindow is how long failures are remembered. A failure after
+// a quiet period this long starts counting from one again.
+const LoginFailureWindow = time.Hour
+
+func (p lockoutPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures >= p.lockoutAfter:
+		return p.lockoutDuration
+	case failures <= p.freeAttempts:
+		return 0
+	}
+	d := time.Second << (failures - p.freeAttempts - 1)
+	if d > p.lockoutDuration {
+		d = p.lockoutDuration
+	}
+	return d
+}
+
+// Lockout is the failed login state of a username or IP.
+type Lockout struct {
+	Scope         string `json:"scope"`
+	Subject       string `json:"subject"`
+	Failures      int    `json:"failures"`
+	LastFailureAt string `json:"last_failure_at"`
+	LockedUntil   string `json:"locked_until"`
+}
+
+const lockoutColumns = `scope, subject, failures, last_failure_at, locked_until`
+
+func scanLockout(scan func(dest ...interface{}) error) (*Lockout, error) {
+	var l Lockout
+	if err := scan(&l.Scope, &l.Subject, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// LoginAttempt is a login attempt counted as a failure against its
+// username and IP before the credentials are checked, so that parallel
+// guesses cannot all pass a check made before any of them has failed.
+// Forgive takes back the parts that succeeded.
+type LoginAttempt struct {
+	db       *sql.DB
+	reserved []loginReservation
+}
+
+type loginReservation struct {
+	scope, subject, lockedUntil string
+}
+
+// BeginLoginAttempt counts an attempt against the username, when known,
+// and the IP, and starts their delay or lockout as if it failed. If either
+// is still delayed or locked out, nothing is counted and it returns how
+// long to wait instead.
+func BeginLoginAttempt(db *sql.DB, username, ip string, now time.Time) (*LoginAttempt, time.Duration, error) {
+	a := &LoginAttempt{db: db}
+	for _, s := range []struct{ scope, subject string }{{LockoutScopeUser, username}, {LockoutScopeIP, ip}} {
+		if s.subject == "" {
+			continue
+		}
+		lockedUntil, wait, err := reserveAttempt(db, s.scope, s.subject, now)
+		if err == nil && wait == 0 {
+			a.reserved = append(a.reserved, loginReservation{s.scope, s.subject, lockedUntil})
+			continue
+		}
+		if ferr := a.Forgive(LockoutScopeUser, LockoutScopeIP); err == nil {
+			err = ferr
+		}
+		return nil, wait, err
+	}
+	return a, 0, nil
+}
+
+// reserveAttempt adds one failure in a single statement, so concurrent
+// attempts each see the count left by the previous one. The upsert does
+// nothing while the subject is locked, which then returns how long is left.
+func reserveAttempt(db *sql.DB, scope, subject string, now time.Time) (string, time.Duration, error) {
+	p := lockoutPolicies[scope]
+	initialLock := ""
+	if d := p.delay(1); d > 0 {
+		initialLock = now.Add(d).UTC().Format(time.RFC3339)
+	}
+	failures := "CASE WHEN last_failure_at >= ?4 THEN failures + 1 ELSE 1 END"
+
+	var lockedUntil string
+	err := db.QueryRow(`
+		INSERT INTO login_lockouts (scope, subject, failures, last_failure_at, locked_until)
+		VALUES (?1, ?2, 1, ?3, ?5)
+		ON CONFLICT(scope, subject) DO UPDATE SET
+			failures = `+failures+`,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = `+p.lockedUntilSQL(failures, "excluded.last_failure_at")+`
+		WHERE locked_until <= excluded.last_failure_at
+		RETURNING locked_until
+	`, scope, subject, now.UTC().Format(time.RFC3339), now.Add(-LoginFailureWindow).UTC().Format(time.RFC3339), initialLock).Scan(&lockedUntil)
+	if err == nil {
+		return lockedUntil, 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", 0, err
+	}
+
+	if err := db.QueryRow(`SELECT locked_until FROM login_lockouts WHERE scope = ? AND subject = ?`, scope, subject).Scan(&lockedUntil); err != nil {
+		return "", 0, err
+	}
+	wait := time.Second
+	if until, err := time.Parse(time.RFC3339, lockedUntil); err == nil && until.Sub(now) > wait {
+		wait = until.Sub(now)
+	}
+	return "", wait, nil
+}
+
+// lockedUntilSQL is delay as an SQL expression: the time the failures
+// expression locks the subject until, counted from the from expression, or
+// an empty string for no delay.
+func (p lockoutPolicy) lockedUntilSQL(failures, from string) string {
+	delay := fmt.Sprintf(`CASE WHEN %[1]s >= %[2]d THEN %[4]d WHEN %[1]s <= %[3]d THEN 0 ELSE MIN(1 << (%[1]s - %[3]d - 1), %[4]d) END`,
+		failures, p.lockoutAfter, p.freeAttempts, int(p.lockoutDuration.Seconds()))
+	return fmt.Sprintf(`CASE WHEN (%[1]s) > 0 THEN strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s, '+' || (%[1]s) || ' seconds') ELSE '' END`,
+		delay, from)
+}
+
+// Forgive takes back the failure the attempt counted against the given
+// scopes. The delay it started is lifted too, unless a later attempt has
+// replaced it with its own.
+func (a *LoginAttempt) Forgive(scopes ...string) error {
+	for i := 0; i < len(a.reserved); i++ {
+		res := a.reserved[i]
+		if !containsScope(scopes, res.scope) {
+			continue
+		}
+		_, err := a.db.Exec(`
+			UPDATE login_lockouts SET
+				failures = MAX(failures - 1, 0),
+				locked_until = CASE WHEN locked_until = ? THEN '' ELSE locked_until END
+			WHERE scope = ? AND subject = ?
+		`, res.lockedUntil, res.scope, res.subject)
+		if err != nil {
+			return err
+		}
+		a.reserved = append(a.reserved[:i], a.reserved[i+1:]...)
+		i--
+	}
+	return nil
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearLoginFailures forgets the failures of one scope and subject.
+func ClearLoginFailures(db *sql.DB, scope, subject string) (int64, error) {
+	result, err := db.Exec(`DELETE FROM login_lockouts WHERE scope = ? AND subject = ?`, scope, subject)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClearAllLoginFailures forgets every failure.
+func ClearAllLoginFailures(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`DELETE FROM login_lockouts`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListLockouts returns the usernames and IPs with recent failures, most
+// recent first.
+func ListLockouts(db *sql.DB, now time.Time) ([]Lockout, error) {
+	rows, err := db.Query(`
+		SELECT `+lockoutColumns+` FROM login_lockouts
+		WHERE last_failure_at > ? OR locked_until > ?
+		ORDER BY last_failure_at DESC
+	`, now.Add(-LoginFailureWindow).UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []Lockout
+	for rows.Next() {
+		l, err := scanLockout(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, *l)
+	}
+	return lockouts, rows.Err()
+}
+
+// DeleteStaleLockouts removes entries whose failures were forgotten and
+// whose lockout has ended.
+func DeleteStaleLockouts(db *sql.DB, now time.Time) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM login_lockouts WHERE last_failure_at <= ? AND locked_until <= ?
+	`, now.Add(-LoginFailureWindow).UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	p := lockoutPolicy{freeAttempts: 3, lockoutAfter: 10, lockoutDuration: 15 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{9, 32 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// failLogin counts an attempt that is never forgiven, ignoring any delay.
+func failLogin(t *testing.T, db *sql.DB, username, ip string, now time.Time) {
+	t.Helper()
+	db.Exec(`UPDATE login_lockouts SET locked_until = ''`)
+	if _, wait, err := BeginLoginAttempt(db, username, ip, now); err != nil || wait != 0 {
+		t.Fatalf("BeginLoginAttempt: wait %v, err %v", wait, err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i := 0; i < 3; i++ {
+		failLogin(t, db, "admin", "192.0.2.1", now)
+	}
+	attempt, wait, _ := BeginLoginAttempt(db, "admin", "192.0.2.1", now)
+	if attempt == nil || wait != 0 {
+		t.Fatalf("expected the fourth attempt to go ahead, got wait %v", wait)
+	}
+	if _, wait, _ := BeginLoginAttempt(db, "admin", "198.51.100.1", now); wait != time.Second {
+		t.Errorf("expected the username to be delayed from any IP, got %v", wait)
+	}
+	if _, wait, _ := BeginLoginAttempt(db, "other", "192.0.2.1", now); wait != 0 {
+		t.Errorf("expected the IP to be below its own threshold, got %v", wait)
+	}
+
+	// A forgiven attempt lifts the delay it started.
+	if err := attempt.Forgive(LockoutScopeUser, LockoutScopeIP); err != nil {
+		t.Fatalf("Forgive: %v", err)
+	}
+	if _, wait, _ := BeginLoginAttempt(db, "admin", "", now); wait != 0 {
+		t.Errorf("expected no delay after forgiving the attempt, got %v", wait)
+	}
+
+	for i := 0; i < 6; i++ {
+		failLogin(t, db, "admin", "192.0.2.1", now)
+	}
+	if _, wait, _ := BeginLoginAttempt(db, "admin", "", now); wait != 15*time.Minute {
+		t.Errorf("expected a lockout after 10 failures, got %v", wait)
+	}
+	if _, wait, _ := BeginLoginAttempt(db, "admin", "", now.Add(16*time.Minute)); wait != 0 {
+		t.Errorf("expected the lockout to end, got %v", wait)
+	}
+
+	// A failure after the window starts counting again.
+	failLogin(t, db, "admin", "", now.Add(2*LoginFailureWindow))
+	lockouts, _ := ListLockouts(db, now.Add(2*LoginFailureWindow))
+	if len(lockouts) != 1 || lockouts[0].Scope != LockoutScopeUser || lockouts[0].Failures != 1 {
+		t.Errorf("expected one fresh user entry, got %+v", lockouts)
+	}
+
+	if n, _ := ClearLoginFailures(db, LockoutScopeUser, "admin"); n != 1 {
+		t.Errorf("expected 1 cleared entry, got %d", n)
+	}
+	if n, _ := DeleteStaleLockouts(db, now.Add(2*LoginFailureWindow)); n != 2 {
+		t.Errorf("expected the stale entries to be deleted, got %d", n)
+	}
+}
+
+func TestLoginLockout_DelayMatchesPolicy(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, scope := range []string{LockoutScopeUser, LockoutScopeIP} {
+		p := lockoutPolicies[scope]
+		for failures := 1; failures <= p.lockoutAfter+1; failures++ {
+			db.Exec(`UPDATE login_lockouts SET locked_until = ''`)
+			lockedUntil, _, err := reserveAttempt(db, scope, "subject", now)
+			if err != nil {
+				t.Fatalf("reserveAttempt: %v", err)
+			}
+			want := ""
+			if d := p.delay(failures); d > 0 {
+				want = now.Add(d).Format(time.RFC3339)
+			}
+			if lockedUntil != want {
+				t.Errorf("%s failure %d: locked until %q, want %q", scope, failures, lockedUntil, want)
+			}
+		}
+	}
+}
+
+func TestLoginLockout_ConcurrentAttempts(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if a, _, err := BeginLoginAttempt(db, "admin", "", now); err == nil && a != nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The fourth attempt starts a delay, so the rest of the burst is refused.
+	if admitted != 4 {
+		t.Errorf("expected 4 attempts admitted from a parallel burst, got %d", admitted)
+	}
+	var failures int
+	db.QueryRow(`SELECT failures FROM login_lockouts WHERE scope = ? AND subject = ?`, LockoutScopeUser, "admin").Scan(&failures)
+	if failures != admitted {
+		t.Errorf("expected %d failures counted, got %d", admitted, failures)
+	}
+}
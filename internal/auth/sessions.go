@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// AccessTokenTTL is how long an admin JWT is valid. Clients renew it
+	// with their refresh token.
+	AccessTokenTTL = 15 * time.Minute
+	// SessionIdleTTL is how long a refresh token stays valid unused. Each
+	// refresh extends the session by this much.
+	SessionIdleTTL = 7 * 24 * time.Hour
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Session is a signed-in admin. Its ID is the jti of every access token
+// issued for it, so revoking the session revokes those tokens.
+type Session struct {
+	ID         string `json:"id"`
+	UserID     int64  `json:"user_id"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	RevokedAt  string `json:"revoked_at,omitempty"`
+}
+
+const sessionColumns = `id, user_id, ip, user_agent, created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(scan func(dest ...interface{}) error) (*Session, error) {
+	var s Session
+	if err := scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Active reports whether the session can still be used at now.
+func (s *Session) Active(now time.Time) bool {
+	if s.RevokedAt != "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, s.ExpiresAt)
+	return err == nil && now.Before(expires)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret hashes a high-entropy secret for storage. Unlike passwords these
+// need no slow hash.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a refresh token for the session and the hash to
+// store. The token is "<session ID>.<secret>"; only the secret's hash is kept.
+func newRefreshToken(sessionID string) (string, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return sessionID + "." + secret, hashSecret(secret), nil
+}
+
+// CreateSession starts a session for the user and returns it with its first
+// refresh token.
+func CreateSession(db *sql.DB, userID int64, ip, userAgent string) (*Session, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`
+		INSERT INTO admin_sessions (id, user_id, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, userID, hash, ip, userAgent, now.Format(time.RFC3339), now.Format(time.RFC3339), now.Add(SessionIdleTTL).Format(time.RFC3339))
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := GetSession(db, id)
+	return s, refresh, err
+}
+
+func GetSession(db *sql.DB, id string) (*Session, error) {
+	row := db.QueryRow(`SELECT `+sessionColumns+` FROM admin_sessions WHERE id = ?`, id)
+	return scanSession(row.Scan)
+}
+
+// RefreshSession swaps a refresh token for a new one and extends the
+// session. Refresh tokens work once: presenting an already rotated token
+// means it was copied, so the whole session is revoked.
+func RefreshSession(db *sql.DB, refreshToken, ip, userAgent string) (*Session, string, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	var storedHash string
+	if err := db.QueryRow(`SELECT refresh_hash FROM admin_sessions WHERE id = ?`, id).Scan(&storedHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrInvalidRefreshToken
+		}
+		return nil, "", err
+	}
+	s, err := GetSession(db, id)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	if !s.Active(now) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(storedHash)) != 1 {
+		if err := RevokeSession(db, s.UserID, s.ID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return nil, "", err
+	}
+	// Matching on the old hash makes concurrent refreshes with the same
+	// token race for a single winner.
+	result, err := db.Exec(`
+		UPDATE admin_sessions SET refresh_hash = ?, ip = ?, user_agent = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_hash = ?
+	`, hash, ip, userAgent, now.Format(time.RFC3339), now.Add(SessionIdleTTL).Format(time.RFC3339), id, storedHash)
+	if err != nil {
+		return nil, "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	s, err = GetSession(db, id)
+	return s, refresh, err
+}
+
+// SessionActive reports whether the session exists and is neither revoked
+// nor expired.
+func SessionActive(db *sql.DB, id string) (bool, error) {
+	s, err := GetSession(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.Active(time.Now()), nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func ListSessions(db *sql.DB, userID int64) ([]Session, error) {
+	rows, err := db.Query(`
+		SELECT `+sessionColumns+` FROM admin_sessions
+		WHERE user_id = ? AND revoked_at = '' AND expires_at > ?
+		ORDER BY last_used_at DESC
+	`, userID, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one of the user's sessions. It returns sql.ErrNoRows
+// if the user has no such active session.
+func RevokeSession(db *sql.DB, userID int64, id string) error {
+	result, err := db.Exec(`
+		UPDATE admin_sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at = ''
+	`, time.Now().UTC().Format(time.RFC3339), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeUserSessions ends all of the user's sessions except keepID, which
+// may be empty, and returns how many were revoked.
+func RevokeUserSessions(db *sql.DB, userID int64, keepID string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE admin_sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at = ''
+	`, time.Now().UTC().Format(time.RFC3339), userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteEndedSessions deletes expired and revoked sessions.
+func DeleteEndedSessions(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM admin_sessions WHERE expires_at < ? OR revoked_at != ''
+	`, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
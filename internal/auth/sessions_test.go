@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestRefreshSession_Rotation(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	admin, _ := GetAdminUserByUsername(db, "admin")
+
+	session, refresh, err := CreateSession(db, admin.ID, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	refreshed, next, err := RefreshSession(db, refresh, "192.0.2.2", "test")
+	if err != nil {
+		t.Fatalf("RefreshSession failed: %v", err)
+	}
+	if refreshed.ID != session.ID || next == refresh || refreshed.IP != "192.0.2.2" {
+		t.Errorf("expected the same session with a new refresh token, got %+v", refreshed)
+	}
+
+	// Reusing the rotated token revokes the session.
+	if _, _, err := RefreshSession(db, refresh, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken for a reused token, got %v", err)
+	}
+	if active, _ := SessionActive(db, session.ID); active {
+		t.Error("expected refresh token reuse to revoke the session")
+	}
+	if _, _, err := RefreshSession(db, next, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the latest token to stop working too, got %v", err)
+	}
+
+	for _, bad := range []string{"", "no-dot", "unknown.secret"} {
+		if _, _, err := RefreshSession(db, bad, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession(%q): expected ErrInvalidRefreshToken, got %v", bad, err)
+		}
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	admin, _ := GetAdminUserByUsername(db, "admin")
+
+	keep, _, _ := CreateSession(db, admin.ID, "", "")
+	CreateSession(db, admin.ID, "", "")
+	CreateSession(db, admin.ID, "", "")
+
+	if n, err := RevokeUserSessions(db, admin.ID, keep.ID); err != nil || n != 2 {
+		t.Fatalf("expected 2 revoked sessions, got %d (%v)", n, err)
+	}
+	sessions, _ := ListSessions(db, admin.ID)
+	if len(sessions) != 1 || sessions[0].ID != keep.ID {
+		t.Errorf("expected only the kept session to be listed, got %+v", sessions)
+	}
+	if err := RevokeSession(db, admin.ID+1, keep.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected ErrNoRows revoking another user's session, got %v", err)
+	}
+
+	if n, _ := DeleteEndedSessions(db); n != 2 {
+		t.Errorf("expected 2 ended sessions to be deleted, got %d", n)
+	}
+}
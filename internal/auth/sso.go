@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oidcStatePurpose marks tokens that carry an OpenID Connect login in
+// progress. Like login challenges they carry no role.
+const oidcStatePurpose = "oidc_login"
+
+// OIDCStateTTL is how long a user has to sign in at the identity provider.
+const OIDCStateTTL = 10 * time.Minute
+
+// OIDCState is what the callback of an OpenID Connect login must match.
+type OIDCState struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// GenerateOIDCState signs s so it can be kept in a cookie between the
+// redirect to the identity provider and the callback.
+func GenerateOIDCState(s OIDCState, secretKey string) (string, error) {
+	claims := jwt.MapClaims{
+		"purpose":  oidcStatePurpose,
+		"state":    s.State,
+		"nonce":    s.Nonce,
+		"verifier": s.Verifier,
+		"exp":      time.Now().Add(OIDCStateTTL).Unix(),
+		"iat":      time.Now().Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secretKey))
+}
+
+// ValidateOIDCState returns the login state signed by GenerateOIDCState.
+func ValidateOIDCState(tokenStr, secretKey string) (*OIDCState, error) {
+	claims, err := ValidateJWT(tokenStr, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != oidcStatePurpose {
+		return nil, fmt.Errorf("not an OIDC login state")
+	}
+	var s OIDCState
+	s.State, _ = claims["state"].(string)
+	s.Nonce, _ = claims["nonce"].(string)
+	s.Verifier, _ = claims["verifier"].(string)
+	if s.State == "" || s.Verifier == "" {
+		return nil, fmt.Errorf("incomplete OIDC login state")
+	}
+	return &s, nil
+}
+
+// ProvisionOIDCUser returns the admin user linked to an OpenID Connect
+// subject, creating it on first sign-in and syncing its role from the
+// identity provider on later ones. Existing password users are never linked
+// to a subject: a username clash fails with ErrUsernameTaken.
+func ProvisionOIDCUser(db *sql.DB, subject, username, role string) (*AdminUser, error) {
+	if subject == "" || username == "" {
+		return nil, errors.New("subject and username are required")
+	}
+
+	var id int64
+	err := db.QueryRow(`SELECT id FROM admin_users WHERE oidc_subject = ?`, subject).Scan(&id)
+	if err == nil {
+		user, err := GetAdminUser(db, id)
+		if err != nil {
+			return nil, err
+		}
+		if user.Role == role {
+			return user, nil
+		}
+		if err := SetAdminUserRole(db, id, role); errors.Is(err, ErrLastOwner) {
+			slog.Warn("oidc: keeping the last owner", "user", user.Username, "idp_role", role)
+			return user, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return GetAdminUser(db, id)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if _, err := GetAdminUserByUsername(db, username); err == nil {
+		return nil, ErrUsernameTaken
+	}
+	// SSO users sign in through the provider only, so their password is a
+	// random one nobody knows.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(b)), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	result, err := db.Exec(`INSERT INTO admin_users (username, password_hash, role, oidc_subject) VALUES (?, ?, ?, ?)`,
+		username, string(hash), role, subject)
+	if err != nil {
+		return nil, err
+	}
+	id, _ = result.LastInsertId()
+	return GetAdminUser(db, id)
+}
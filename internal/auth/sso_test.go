@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestProvisionOIDCUser(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+
+	user, err := ProvisionOIDCUser(db, "sub-1", "alice", RoleViewer)
+	if err != nil {
+		t.Fatalf("ProvisionOIDCUser failed: %v", err)
+	}
+	if user.Username != "alice" || user.Role != RoleViewer || !user.SSO {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	again, err := ProvisionOIDCUser(db, "sub-1", "alice-renamed", RoleEditor)
+	if err != nil {
+		t.Fatalf("ProvisionOIDCUser failed: %v", err)
+	}
+	if again.ID != user.ID || again.Role != RoleEditor {
+		t.Errorf("expected the same user promoted to editor, got %+v", again)
+	}
+
+	if _, err := ProvisionOIDCUser(db, "sub-2", "admin", RoleOwner); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("expected ErrUsernameTaken for a password user, got %v", err)
+	}
+}
+
+func TestProvisionOIDCUser_KeepsLastOwner(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	user, err := ProvisionOIDCUser(db, "sub-1", "alice", RoleOwner)
+	if err != nil {
+		t.Fatalf("ProvisionOIDCUser failed: %v", err)
+	}
+	user, err = ProvisionOIDCUser(db, "sub-1", "alice", RoleViewer)
+	if err != nil {
+		t.Fatalf("ProvisionOIDCUser failed: %v", err)
+	}
+	if user.Role != RoleOwner {
+		t.Errorf("expected the last owner to stay owner, got %q", user.Role)
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	want := OIDCState{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	token, err := GenerateOIDCState(want, "secret")
+	if err != nil {
+		t.Fatalf("GenerateOIDCState failed: %v", err)
+	}
+
+	got, err := ValidateOIDCState(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateOIDCState failed: %v", err)
+	}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+
+	if _, err := ValidateOIDCState(token, "other-secret"); err == nil {
+		t.Error("expected error for a different secret")
+	}
+	challenge, _, _ := GenerateLoginChallenge("admin", "secret")
+	if _, err := ValidateOIDCState(challenge, "secret"); err == nil {
+		t.Error("expected error for a login challenge")
+	}
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Personal access token scopes. A token can only call the endpoints its
+// scopes cover, and never more than its owner's role allows.
+const (
+	ScopeKeysRead      = "keys:read"
+	ScopeKeysWrite     = "keys:write"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+	ScopeStatsRead     = "stats:read"
+)
+
+// scopeRoles is the least role that may hold each scope.
+var scopeRoles = map[string]string{
+	ScopeKeysRead:      RoleViewer,
+	ScopeKeysWrite:     RoleEditor,
+	ScopeWebhooksRead:  RoleViewer,
+	ScopeWebhooksWrite: RoleEditor,
+	ScopeStatsRead:     RoleViewer,
+}
+
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from session JWTs.
+const PersonalTokenPrefix = "gat_"
+
+var (
+	ErrInvalidPersonalToken = errors.New("invalid or expired token")
+	ErrInvalidScope         = errors.New("invalid scope")
+)
+
+// Scopes returns every personal access token scope, sorted.
+func Scopes() []string {
+	scopes := make([]string, 0, len(scopeRoles))
+	for s := range scopeRoles {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// CheckScopes returns an error unless every scope exists and role may hold
+// it.
+func CheckScopes(scopes []string, role string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, s := range scopes {
+		min, ok := scopeRoles[s]
+		if !ok {
+			return fmt.Errorf("%w: unknown scope %q", ErrInvalidScope, s)
+		}
+		if !RoleAtLeast(role, min) {
+			return fmt.Errorf("%w: %s requires the %s role", ErrInvalidScope, s, min)
+		}
+	}
+	return nil
+}
+
+// PersonalToken is a long-lived credential for scripting the admin API.
+// Only a hash of the token is stored; Prefix identifies it in listings.
+type PersonalToken struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"`
+}
+
+const personalTokenColumns = `id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at`
+
+func scanPersonalToken(scan func(dest ...interface{}) error) (*PersonalToken, error) {
+	var t PersonalToken
+	var scopes string
+	if err := scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	return &t, nil
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *PersonalToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token has passed its expiry at now. Tokens
+// without one never expire.
+func (t *PersonalToken) Expired(now time.Time) bool {
+	if t.ExpiresAt == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, t.ExpiresAt)
+	return err != nil || !now.Before(expires)
+}
+
+// CreatePersonalToken issues a token for the user and returns it with the
+// plaintext token, which cannot be recovered later. A zero ttl never
+// expires. Scopes must have passed CheckScopes.
+func CreatePersonalToken(db *sql.DB, userID int64, name string, scopes []string, ttl time.Duration) (*PersonalToken, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := PersonalTokenPrefix + secret
+	now := time.Now().UTC()
+	expiresAt := ""
+	if ttl > 0 {
+		expiresAt = now.Add(ttl).Format(time.RFC3339)
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO admin_tokens (user_id, name, prefix, token_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, name, token[:len(PersonalTokenPrefix)+6], hashSecret(token), strings.Join(scopes, " "), now.Format(time.RFC3339), expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	id, _ := result.LastInsertId()
+	row := db.QueryRow(`SELECT `+personalTokenColumns+` FROM admin_tokens WHERE id = ?`, id)
+	t, err := scanPersonalToken(row.Scan)
+	return t, token, err
+}
+
+// AuthenticatePersonalToken returns the token and its owner, and records
+// that it was used.
+func AuthenticatePersonalToken(db *sql.DB, token string, now time.Time) (*PersonalToken, *AdminUser, error) {
+	if !strings.HasPrefix(token, PersonalTokenPrefix) {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+	row := db.QueryRow(`SELECT `+personalTokenColumns+` FROM admin_tokens WHERE token_hash = ?`, hashSecret(token))
+	t, err := scanPersonalToken(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Expired(now) {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+	user, err := GetAdminUser(db, t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.LastUsedAt = now.UTC().Format(time.RFC3339)
+	if _, err := db.Exec(`UPDATE admin_tokens SET last_used_at = ? WHERE id = ?`, t.LastUsedAt, t.ID); err != nil {
+		return nil, nil, err
+	}
+	return t, user, nil
+}
+
+// ListPersonalTokens returns the user's tokens, newest first.
+func ListPersonalTokens(db *sql.DB, userID int64) ([]PersonalToken, error) {
+	rows, err := db.Query(`SELECT `+personalTokenColumns+` FROM admin_tokens WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []PersonalToken
+	for rows.Next() {
+		t, err := scanPersonalToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokePersonalToken deletes one of the user's tokens and returns it. It
+// returns sql.ErrNoRows if the user has no such token.
+func RevokePersonalToken(db *sql.DB, userID, id int64) (*PersonalToken, error) {
+	row := db.QueryRow(`SELECT `+personalTokenColumns+` FROM admin_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	t, err := scanPersonalToken(row.Scan)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`DELETE FROM admin_tokens WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DeleteExpiredPersonalTokens removes tokens whose expiry has passed.
+func DeleteExpiredPersonalTokens(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM admin_tokens WHERE expires_at != '' AND expires_at <= ?
+	`, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
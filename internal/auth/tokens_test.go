@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestCheckScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		role   string
+		ok     bool
+	}{
+		{[]string{ScopeKeysRead, ScopeStatsRead}, RoleViewer, true},
+		{[]string{ScopeKeysWrite}, RoleEditor, true},
+		{[]string{ScopeKeysWrite}, RoleViewer, false},
+		{[]string{"keys:admin"}, RoleOwner, false},
+		{nil, RoleOwner, false},
+	}
+	for _, tt := range tests {
+		err := CheckScopes(tt.scopes, tt.role)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckScopes(%v, %q) = %v, want ok=%v", tt.scopes, tt.role, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("expected ErrInvalidScope, got %v", err)
+		}
+	}
+}
+
+func TestPersonalTokens(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	admin, _ := GetAdminUserByUsername(db, "admin")
+
+	created, token, err := CreatePersonalToken(db, admin.ID, "ci", []string{ScopeKeysRead}, 0)
+	if err != nil {
+		t.Fatalf("CreatePersonalToken failed: %v", err)
+	}
+	if !strings.HasPrefix(token, created.Prefix) || created.ExpiresAt != "" {
+		t.Errorf("unexpected token %q for %+v", token, created)
+	}
+	var stored int
+	db.QueryRow(`SELECT COUNT(*) FROM admin_tokens WHERE token_hash = ?`, token).Scan(&stored)
+	if stored != 0 {
+		t.Error("expected the token to be stored hashed")
+	}
+
+	got, user, err := AuthenticatePersonalToken(db, token, time.Now())
+	if err != nil {
+		t.Fatalf("AuthenticatePersonalToken failed: %v", err)
+	}
+	if got.ID != created.ID || user.Username != "admin" || !got.HasScope(ScopeKeysRead) || got.HasScope(ScopeKeysWrite) {
+		t.Errorf("unexpected token %+v for %s", got, user.Username)
+	}
+	if got.LastUsedAt == "" {
+		t.Error("expected last_used_at to be set")
+	}
+
+	for _, bad := range []string{"", "gat_unknown", token + "x", strings.TrimPrefix(token, PersonalTokenPrefix)} {
+		if _, _, err := AuthenticatePersonalToken(db, bad, time.Now()); !errors.Is(err, ErrInvalidPersonalToken) {
+			t.Errorf("expected ErrInvalidPersonalToken for %q, got %v", bad, err)
+		}
+	}
+
+	if _, err := RevokePersonalToken(db, admin.ID+1, created.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows revoking another user's token, got %v", err)
+	}
+	if _, err := RevokePersonalToken(db, admin.ID, created.ID); err != nil {
+		t.Fatalf("RevokePersonalToken failed: %v", err)
+	}
+	if _, _, err := AuthenticatePersonalToken(db, token, time.Now()); !errors.Is(err, ErrInvalidPersonalToken) {
+		t.Errorf("expected a revoked token to fail, got %v", err)
+	}
+}
+
+func TestPersonalTokens_Expiry(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	admin, _ := GetAdminUserByUsername(db, "admin")
+
+	_, token, err := CreatePersonalToken(db, admin.ID, "short", []string{ScopeStatsRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreatePersonalToken failed: %v", err)
+	}
+	if _, _, err := AuthenticatePersonalToken(db, token, time.Now()); err != nil {
+		t.Errorf("expected a fresh token to work, got %v", err)
+	}
+	if _, _, err := AuthenticatePersonalToken(db, token, time.Now().Add(2*time.Hour)); !errors.Is(err, ErrInvalidPersonalToken) {
+		t.Errorf("expected an expired token to fail, got %v", err)
+	}
+
+	db.Exec(`UPDATE admin_tokens SET expires_at = ?`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	if n, err := DeleteExpiredPersonalTokens(db); err != nil || n != 1 {
+		t.Errorf("expected one expired token deleted, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters. These are the RFC 6238 defaults, which is what
+// authenticator apps assume when they scan an otpauth URI.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many periods either side of now a code stays valid,
+	// to allow for clock drift on the user's device.
+	totpSkew   = 1
+	totpIssuer = "GateCHA"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("two-factor enrolment has not been started")
+	ErrInvalidTOTPCode    = errors.New("invalid two-factor code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new base32-encoded 160-bit TOTP secret.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth URI authenticator apps enrol from, usually
+// shown as a QR code.
+func TOTPURI(username, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPCode returns the code for secret at t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+// hotp is the RFC 4226 HMAC-based one-time password for counter.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// matchTOTP returns the time step code is valid for, searching totpSkew
+// steps either side of t.
+func matchTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// BeginTOTPEnrollment stores a new pending secret for the user. It only
+// takes effect once ConfirmTOTPEnrollment accepts a code for it.
+func BeginTOTPEnrollment(db *sql.DB, userID int64) (string, error) {
+	user, err := GetAdminUser(db, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.TOTPEnabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`UPDATE admin_users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?`, secret, userID)
+	return secret, err
+}
+
+// ConfirmTOTPEnrollment enables TOTP once code matches the pending secret,
+// and returns a fresh set of recovery codes.
+func ConfirmTOTPEnrollment(db *sql.DB, userID int64, code string, now time.Time) ([]string, error) {
+	var secret string
+	var enabled int
+	if err := db.QueryRow(`SELECT totp_secret, totp_enabled FROM admin_users WHERE id = ?`, userID).Scan(&secret, &enabled); err != nil {
+		return nil, err
+	}
+	if enabled == 1 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if secret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := matchTOTP(secret, code, now)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if _, err := db.Exec(`UPDATE admin_users SET totp_enabled = 1, totp_last_step = ?, updated_at = datetime('now') WHERE id = ?`, step, userID); err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(db, userID)
+}
+
+// DisableTOTP turns TOTP off and removes the secret and recovery codes.
+func DisableTOTP(db *sql.DB, userID int64) error {
+	if _, err := db.Exec(`
+		UPDATE admin_users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updated_at = datetime('now')
+		WHERE id = ?
+	`, userID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM admin_recovery_codes WHERE user_id = ?`, userID)
+	return err
+}
+
+// VerifySecondFactor checks a TOTP code or an unused recovery code for a
+// user with TOTP enabled. Each TOTP code and recovery code works once.
+func VerifySecondFactor(db *sql.DB, userID int64, code string, now time.Time) error {
+	var secret string
+	var enabled int
+	var lastStep int64
+	if err := db.QueryRow(`SELECT totp_secret, totp_enabled, totp_last_step FROM admin_users WHERE id = ?`, userID).Scan(&secret, &enabled, &lastStep); err != nil {
+		return err
+	}
+	if enabled != 1 {
+		return ErrTOTPNotEnrolled
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, ok := matchTOTP(secret, code, now); ok {
+		// Only move forward, so a code cannot be replayed within its window.
+		result, err := db.Exec(`UPDATE admin_users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	result, err := db.Exec(`
+		UPDATE admin_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at = ''
+	`, now.UTC().Format(time.RFC3339), userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. Only their
+// hashes are stored; the returned codes cannot be shown again.
+func RegenerateRecoveryCodes(db *sql.DB, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = s[:4] + "-" + s[4:]
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM admin_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(`INSERT INTO admin_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashRecoveryCode(c)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has.
+func RemainingRecoveryCodes(db *sql.DB, userID int64) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM admin_recovery_codes WHERE user_id = ? AND used_at = ''`, userID).Scan(&n)
+	return n, err
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
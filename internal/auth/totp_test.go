@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("alice", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/GateCHA:alice?") || !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=GateCHA") {
+		t.Errorf("unexpected URI: %s", uri)
+	}
+}
+
+func TestTOTPEnrollmentAndVerification(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	user, _ := GetAdminUserByUsername(db, "admin")
+	now := time.Now()
+
+	if err := VerifySecondFactor(db, user.ID, "123456", now); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("expected ErrTOTPNotEnrolled before enrolment, got %v", err)
+	}
+	if _, err := ConfirmTOTPEnrollment(db, user.ID, "123456", now); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("expected ErrTOTPNotEnrolled without a pending secret, got %v", err)
+	}
+
+	secret, err := BeginTOTPEnrollment(db, user.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment failed: %v", err)
+	}
+	if _, err := ConfirmTOTPEnrollment(db, user.ID, "000000", now.Add(-time.Hour)); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected ErrInvalidTOTPCode for a wrong code, got %v", err)
+	}
+	code, _ := TOTPCode(secret, now)
+	codes, err := ConfirmTOTPEnrollment(db, user.ID, code, now)
+	if err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+	if _, err := BeginTOTPEnrollment(db, user.ID); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("expected ErrTOTPAlreadyEnabled, got %v", err)
+	}
+
+	if err := VerifySecondFactor(db, user.ID, code, now); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected the confirmation code not to be reusable, got %v", err)
+	}
+	next, _ := TOTPCode(secret, now.Add(totpPeriod*time.Second))
+	if err := VerifySecondFactor(db, user.ID, next, now.Add(totpPeriod*time.Second)); err != nil {
+		t.Errorf("expected the next code to verify, got %v", err)
+	}
+
+	if err := VerifySecondFactor(db, user.ID, strings.ToUpper(codes[0]), now); err != nil {
+		t.Errorf("expected a recovery code to verify, got %v", err)
+	}
+	if err := VerifySecondFactor(db, user.ID, codes[0], now); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected a recovery code to work only once, got %v", err)
+	}
+	if n, _ := RemainingRecoveryCodes(db, user.ID); n != recoveryCodeCount-1 {
+		t.Errorf("expected %d remaining recovery codes, got %d", recoveryCodeCount-1, n)
+	}
+
+	if err := DisableTOTP(db, user.ID); err != nil {
+		t.Fatalf("DisableTOTP failed: %v", err)
+	}
+	if user, _ := GetAdminUser(db, user.ID); user.TOTPEnabled {
+		t.Error("expected TOTP to be disabled")
+	}
+}
+
+func TestLoginChallenge(t *testing.T) {
+	challenge, _, err := GenerateLoginChallenge("alice", "secret")
+	if err != nil {
+		t.Fatalf("GenerateLoginChallenge failed: %v", err)
+	}
+	if username, err := ValidateLoginChallenge(challenge, "secret"); err != nil || username != "alice" {
+		t.Errorf("expected alice, got %q (%v)", username, err)
+	}
+
+	session, _, _ := GenerateJWT("alice", RoleOwner, "session-id", "secret")
+	if _, err := ValidateLoginChallenge(session, "secret"); err == nil {
+		t.Error("expected a session token to be rejected as a login challenge")
+	}
+}
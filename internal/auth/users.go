@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Admin roles, from least to most privileged. Viewers can read keys and
+// stats without secrets, editors can also change keys and webhooks, and
+// owners can additionally manage users, settings and signing keys.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+var (
+	ErrLastOwner     = errors.New("at least one owner must remain")
+	ErrUsernameTaken = errors.New("username already exists")
+)
+
+// ValidRole reports whether role is one of the admin roles.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// RoleAtLeast reports whether role grants everything min does.
+func RoleAtLeast(role, min string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[min]
+}
+
+type AdminUser struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// SSO is set for users provisioned through OpenID Connect.
+	SSO       bool   `json:"sso"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+const adminUserColumns = `id, username, role, totp_enabled, oidc_subject != '', created_at, updated_at`
+
+func scanAdminUser(scan func(dest ...interface{}) error) (*AdminUser, error) {
+	var u AdminUser
+	var totpEnabled, sso int
+	if err := scan(&u.ID, &u.Username, &u.Role, &totpEnabled, &sso, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	u.TOTPEnabled = totpEnabled == 1
+	u.SSO = sso == 1
+	return &u, nil
+}
+
+func GetAdminUser(db *sql.DB, id int64) (*AdminUser, error) {
+	row := db.QueryRow(`SELECT `+adminUserColumns+` FROM admin_users WHERE id = ?`, id)
+	return scanAdminUser(row.Scan)
+}
+
+func GetAdminUserByUsername(db *sql.DB, username string) (*AdminUser, error) {
+	row := db.QueryRow(`SELECT `+adminUserColumns+` FROM admin_users WHERE username = ?`, username)
+	return scanAdminUser(row.Scan)
+}
+
+func ListAdminUsers(db *sql.DB) ([]AdminUser, error) {
+	rows, err := db.Query(`SELECT ` + adminUserColumns + ` FROM admin_users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []AdminUser
+	for rows.Next() {
+		u, err := scanAdminUser(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func CreateAdminUser(db *sql.DB, username, password, role string) (*AdminUser, error) {
+	if _, err := GetAdminUserByUsername(db, username); err == nil {
+		return nil, ErrUsernameTaken
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	result, err := db.Exec(`INSERT INTO admin_users (username, password_hash, role) VALUES (?, ?, ?)`, username, string(hash), role)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetAdminUser(db, id)
+}
+
+// SetAdminUserRole changes a user's role. Demoting the last owner fails
+// with ErrLastOwner.
+func SetAdminUserRole(db *sql.DB, id int64, role string) error {
+	result, err := db.Exec(`
+		UPDATE admin_users SET role = ?, updated_at = datetime('now')
+		WHERE id = ? AND (? = ? OR `+otherOwnerGuard+`)
+	`, role, id, role, RoleOwner, RoleOwner, RoleOwner)
+	if err != nil {
+		return err
+	}
+	return guardedWriteResult(db, result, id)
+}
+
+// DeleteAdminUser removes a user. Deleting the last owner fails with
+// ErrLastOwner.
+func DeleteAdminUser(db *sql.DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM admin_users WHERE id = ? AND `+otherOwnerGuard, id, RoleOwner, RoleOwner)
+	if err != nil {
+		return err
+	}
+	return guardedWriteResult(db, result, id)
+}
+
+// otherOwnerGuard matches a user who is no owner, or an owner besides whom
+// another one remains. Checking it in the statement that demotes or
+// deletes the user keeps concurrent requests from removing every owner.
+// It takes RoleOwner twice as arguments.
+const otherOwnerGuard = `(role != ? OR EXISTS (SELECT 1 FROM admin_users o WHERE o.role = ? AND o.id != admin_users.id))`
+
+// guardedWriteResult tells why a write guarded by otherOwnerGuard changed
+// nothing: the user is gone, or is the last owner.
+func guardedWriteResult(db *sql.DB, result sql.Result, id int64) error {
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	if _, err := GetAdminUser(db, id); err != nil {
+		return err
+	}
+	return ErrLastOwner
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role, min string
+		want      bool
+	}{
+		{RoleOwner, RoleViewer, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleViewer, RoleEditor, false},
+		{RoleEditor, RoleOwner, false},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := RoleAtLeast(tt.role, tt.min); got != tt.want {
+			t.Errorf("RoleAtLeast(%q, %q) = %v, want %v", tt.role, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestCreateAdminUser(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+
+	user, err := CreateAdminUser(db, "alice", "secret", RoleViewer)
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	if user.Role != RoleViewer {
+		t.Errorf("expected viewer role, got %q", user.Role)
+	}
+	if ok, _ := ValidateCredentials(db, "alice", "secret"); !ok {
+		t.Error("expected the new user to be able to log in")
+	}
+	if _, err := CreateAdminUser(db, "alice", "other", RoleEditor); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("expected ErrUsernameTaken, got %v", err)
+	}
+
+	admin, _ := GetAdminUserByUsername(db, "admin")
+	if admin.Role != RoleOwner {
+		t.Errorf("expected the bootstrap admin to be an owner, got %q", admin.Role)
+	}
+}
+
+func TestLastOwnerGuard(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureAdminUser(db, "admin", "password123")
+	admin, _ := GetAdminUserByUsername(db, "admin")
+
+	if err := SetAdminUserRole(db, admin.ID, RoleEditor); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when demoting the last owner, got %v", err)
+	}
+	if err := DeleteAdminUser(db, admin.ID); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when deleting the last owner, got %v", err)
+	}
+
+	CreateAdminUser(db, "bob", "secret", RoleOwner)
+	if err := SetAdminUserRole(db, admin.ID, RoleEditor); err != nil {
+		t.Fatalf("expected demotion to succeed with another owner, got %v", err)
+	}
+	if err := DeleteAdminUser(db, admin.ID); err != nil {
+		t.Fatalf("expected deletion of a non-owner to succeed, got %v", err)
+	}
+	if users, _ := ListAdminUsers(db); len(users) != 1 || users[0].Username != "bob" {
+		t.Errorf("expected only bob to remain, got %+v", users)
+	}
+}
+
+func TestLastOwnerGuard_Concurrent(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	db.SetMaxOpenConns(1) // every connection to :memory: is its own database
+
+	for i := 0; i < 20; i++ {
+		a, _ := CreateAdminUser(db, fmt.Sprintf("a%d", i), "secret", RoleOwner)
+		b, _ := CreateAdminUser(db, fmt.Sprintf("b%d", i), "secret", RoleOwner)
+		db.Exec(`UPDATE admin_users SET role = ? WHERE id NOT IN (?, ?)`, RoleViewer, a.ID, b.ID)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); SetAdminUserRole(db, a.ID, RoleEditor) }()
+		go func() { defer wg.Done(); DeleteAdminUser(db, b.ID) }()
+		wg.Wait()
+
+		var owners int
+		db.QueryRow(`SELECT COUNT(*) FROM admin_users WHERE role = ?`, RoleOwner).Scan(&owners)
+		if owners != 1 {
+			t.Fatalf("expected exactly one owner to remain, got %d", owners)
+		}
+	}
+}
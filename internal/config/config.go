@@ -4,9 +4,13 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/Upellift99/GateCHA/internal/auth"
 )
 
 type Config struct {
@@ -17,18 +21,99 @@ type Config struct {
 	AdminPassword   string
 	LogLevel        string
 	CleanupInterval time.Duration
-	CORSAllowAll   bool
+	CORSAllowAll    bool
+
+	// RequireVerifySecret rejects server-side verification for keys created
+	// before verify secrets existed until one is generated for them.
+	RequireVerifySecret bool
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are believed for the client IP.
+	TrustedProxies []netip.Prefix
+
+	// SigningKeyRotation is the maximum age of the verification token
+	// signing key before the cleanup worker rotates it. Zero disables it.
+	SigningKeyRotation time.Duration
+
+	// SecretGracePeriod is how long a rotated-out API key HMAC secret
+	// keeps verifying solutions.
+	SecretGracePeriod time.Duration
+
+	// WebhookInterval is how often the webhook worker sends due deliveries.
+	WebhookInterval time.Duration
+
+	// WebhookAllowedNetworks are loopback, link-local or private ranges
+	// webhooks may still be delivered to. Other internal addresses are
+	// refused.
+	WebhookAllowedNetworks []netip.Prefix
+
+	// OIDC configures single sign-on. It is enabled when OIDC.Issuer is set.
+	OIDC OIDCConfig
+
+	// MetricsAddr is a separate listen address for /metrics. MetricsToken
+	// guards /metrics; without MetricsAddr it is served on ListenAddr. With
+	// neither, metrics are not served.
+	MetricsAddr  string
+	MetricsToken string
+
+	// EventSampleRate is the share of challenge and verify requests written
+	// to the verification event log, from 0 (off) to 1. The cleanup worker
+	// removes events older than EventRetention. EventIPPolicy says how
+	// client IPs are stored: raw, hash or none.
+	EventSampleRate float64
+	EventRetention  time.Duration
+	EventIPPolicy   string
+
+	// StatsRetention is how long hourly and daily statistics and solve
+	// times are kept. The cleanup worker then rolls days up into months, which are kept for
+	// StatsMonthlyRetention months. Zero keeps them forever.
+	StatsRetention        time.Duration
+	StatsMonthlyRetention int
+
+	// ReplayStore selects the replay protection backend: sqlite, memory or bloom.
+	ReplayStore         string
+	ReplayBloomCapacity uint
+	ReplayBloomFPRate   float64
+	ReplayBloomWindow   time.Duration
+}
+
+// OIDCConfig configures admin single sign-on through an OpenID Connect
+// provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim and GroupsClaim name the ID token claims holding the
+	// admin username and the user's groups.
+	UsernameClaim string
+	GroupsClaim   string
+	// GroupRoles maps IdP groups to admin roles.
+	GroupRoles map[string]string
+	// DisablePasswordLogin turns off POST /api/admin/login.
+	DisablePasswordLogin bool
+}
+
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
 }
 
 func Load() (*Config, error) {
 	cfg := &Config{
-		ListenAddr:      envOrDefault("GATECHA_LISTEN_ADDR", ":8080"),
-		DBPath:          envOrDefault("GATECHA_DB_PATH", "./data/gatecha.db"),
-		SecretKey:       os.Getenv("GATECHA_SECRET_KEY"),
-		AdminUsername:   envOrDefault("GATECHA_ADMIN_USERNAME", "admin"),
-		AdminPassword:   os.Getenv("GATECHA_ADMIN_PASSWORD"),
-		LogLevel:        envOrDefault("GATECHA_LOG_LEVEL", "info"),
-		CORSAllowAll:    envOrDefault("GATECHA_CORS_ALLOW_ALL", "false") == "true",
+		ListenAddr:    envOrDefault("GATECHA_LISTEN_ADDR", ":8080"),
+		DBPath:        envOrDefault("GATECHA_DB_PATH", "./data/gatecha.db"),
+		SecretKey:     os.Getenv("GATECHA_SECRET_KEY"),
+		AdminUsername: envOrDefault("GATECHA_ADMIN_USERNAME", "admin"),
+		AdminPassword: os.Getenv("GATECHA_ADMIN_PASSWORD"),
+		LogLevel:      envOrDefault("GATECHA_LOG_LEVEL", "info"),
+		CORSAllowAll:  envOrDefault("GATECHA_CORS_ALLOW_ALL", "false") == "true",
+		ReplayStore:   envOrDefault("GATECHA_REPLAY_STORE", "sqlite"),
+		MetricsAddr:   os.Getenv("GATECHA_METRICS_ADDR"),
+		MetricsToken:  os.Getenv("GATECHA_METRICS_TOKEN"),
+		EventIPPolicy: envOrDefault("GATECHA_EVENT_IP_POLICY", "hash"),
+
+		RequireVerifySecret: envOrDefault("GATECHA_REQUIRE_VERIFY_SECRET", "false") == "true",
 	}
 
 	intervalStr := envOrDefault("GATECHA_CLEANUP_INTERVAL", "10")
@@ -38,6 +123,89 @@ func Load() (*Config, error) {
 	}
 	cfg.CleanupInterval = time.Duration(intervalMin) * time.Minute
 
+	rotationDays, err := strconv.Atoi(envOrDefault("GATECHA_SIGNING_KEY_ROTATION_DAYS", "30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATECHA_SIGNING_KEY_ROTATION_DAYS: %w", err)
+	}
+	cfg.SigningKeyRotation = time.Duration(rotationDays) * 24 * time.Hour
+
+	graceMin, err := strconv.Atoi(envOrDefault("GATECHA_SECRET_GRACE_PERIOD", "60"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATECHA_SECRET_GRACE_PERIOD: %w", err)
+	}
+	cfg.SecretGracePeriod = time.Duration(graceMin) * time.Minute
+
+	webhookSec, err := strconv.Atoi(envOrDefault("GATECHA_WEBHOOK_INTERVAL", "10"))
+	if err != nil || webhookSec <= 0 {
+		return nil, fmt.Errorf("invalid GATECHA_WEBHOOK_INTERVAL: %q", os.Getenv("GATECHA_WEBHOOK_INTERVAL"))
+	}
+	cfg.WebhookInterval = time.Duration(webhookSec) * time.Second
+
+	cfg.EventSampleRate, err = strconv.ParseFloat(envOrDefault("GATECHA_EVENT_SAMPLE_RATE", "0"), 64)
+	if err != nil || cfg.EventSampleRate < 0 || cfg.EventSampleRate > 1 {
+		return nil, fmt.Errorf("invalid GATECHA_EVENT_SAMPLE_RATE: %q (want 0 to 1)", os.Getenv("GATECHA_EVENT_SAMPLE_RATE"))
+	}
+
+	retentionDays, err := strconv.Atoi(envOrDefault("GATECHA_EVENT_RETENTION_DAYS", "7"))
+	if err != nil || retentionDays <= 0 {
+		return nil, fmt.Errorf("invalid GATECHA_EVENT_RETENTION_DAYS: %q", os.Getenv("GATECHA_EVENT_RETENTION_DAYS"))
+	}
+	cfg.EventRetention = time.Duration(retentionDays) * 24 * time.Hour
+
+	statsDays, err := strconv.Atoi(envOrDefault("GATECHA_STATS_RETENTION_DAYS", "90"))
+	if err != nil || statsDays < 0 {
+		return nil, fmt.Errorf("invalid GATECHA_STATS_RETENTION_DAYS: %q", os.Getenv("GATECHA_STATS_RETENTION_DAYS"))
+	}
+	cfg.StatsRetention = time.Duration(statsDays) * 24 * time.Hour
+
+	cfg.StatsMonthlyRetention, err = strconv.Atoi(envOrDefault("GATECHA_STATS_MONTHLY_RETENTION_MONTHS", "24"))
+	if err != nil || cfg.StatsMonthlyRetention < 0 {
+		return nil, fmt.Errorf("invalid GATECHA_STATS_MONTHLY_RETENTION_MONTHS: %q", os.Getenv("GATECHA_STATS_MONTHLY_RETENTION_MONTHS"))
+	}
+
+	switch cfg.EventIPPolicy {
+	case "raw", "hash", "none":
+	default:
+		return nil, fmt.Errorf("invalid GATECHA_EVENT_IP_POLICY: %q (want raw, hash or none)", cfg.EventIPPolicy)
+	}
+
+	cfg.TrustedProxies, err = parsePrefixes("GATECHA_TRUSTED_PROXIES")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.WebhookAllowedNetworks, err = parsePrefixes("GATECHA_WEBHOOK_ALLOWED_NETWORKS")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadOIDC(&cfg.OIDC); err != nil {
+		return nil, err
+	}
+
+	switch cfg.ReplayStore {
+	case "sqlite", "memory", "bloom":
+	default:
+		return nil, fmt.Errorf("invalid GATECHA_REPLAY_STORE: %q (want sqlite, memory or bloom)", cfg.ReplayStore)
+	}
+
+	capacity, err := strconv.ParseUint(envOrDefault("GATECHA_REPLAY_BLOOM_CAPACITY", "1000000"), 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATECHA_REPLAY_BLOOM_CAPACITY: %w", err)
+	}
+	cfg.ReplayBloomCapacity = uint(capacity)
+
+	cfg.ReplayBloomFPRate, err = strconv.ParseFloat(envOrDefault("GATECHA_REPLAY_BLOOM_FP_RATE", "0.0001"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATECHA_REPLAY_BLOOM_FP_RATE: %w", err)
+	}
+
+	windowMin, err := strconv.Atoi(envOrDefault("GATECHA_REPLAY_BLOOM_WINDOW", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATECHA_REPLAY_BLOOM_WINDOW: %w", err)
+	}
+	cfg.ReplayBloomWindow = time.Duration(windowMin) * time.Minute
+
 	if cfg.SecretKey == "" {
 		key, err := generateRandomHex(32)
 		if err != nil {
@@ -60,6 +228,66 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+func loadOIDC(c *OIDCConfig) error {
+	c.Issuer = os.Getenv("GATECHA_OIDC_ISSUER")
+	c.ClientID = os.Getenv("GATECHA_OIDC_CLIENT_ID")
+	c.ClientSecret = os.Getenv("GATECHA_OIDC_CLIENT_SECRET")
+	c.RedirectURL = os.Getenv("GATECHA_OIDC_REDIRECT_URL")
+	c.Scopes = strings.Fields(envOrDefault("GATECHA_OIDC_SCOPES", "openid profile email groups"))
+	c.UsernameClaim = envOrDefault("GATECHA_OIDC_USERNAME_CLAIM", "preferred_username")
+	c.GroupsClaim = envOrDefault("GATECHA_OIDC_GROUPS_CLAIM", "groups")
+	c.DisablePasswordLogin = envOrDefault("GATECHA_OIDC_DISABLE_PASSWORD_LOGIN", "false") == "true"
+
+	c.GroupRoles = map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("GATECHA_OIDC_GROUP_ROLES"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok || group == "" || !auth.ValidRole(role) {
+			return fmt.Errorf("invalid GATECHA_OIDC_GROUP_ROLES entry %q (want group=owner|editor|viewer)", pair)
+		}
+		c.GroupRoles[group] = role
+	}
+
+	if !c.Enabled() {
+		if c.DisablePasswordLogin {
+			return fmt.Errorf("GATECHA_OIDC_DISABLE_PASSWORD_LOGIN requires GATECHA_OIDC_ISSUER")
+		}
+		return nil
+	}
+	if c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("GATECHA_OIDC_ISSUER requires GATECHA_OIDC_CLIENT_ID and GATECHA_OIDC_REDIRECT_URL")
+	}
+	if len(c.GroupRoles) == 0 {
+		return fmt.Errorf("GATECHA_OIDC_ISSUER requires GATECHA_OIDC_GROUP_ROLES")
+	}
+	return nil
+}
+
+// parsePrefixes reads the environment variable name as a comma-separated
+// list of IPs and CIDR ranges.
+func parsePrefixes(name string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(entry)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid %s entry %q (want an IP or CIDR range)", name, entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
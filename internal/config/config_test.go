@@ -15,6 +15,10 @@ func TestLoad_Defaults(t *testing.T) {
 	os.Unsetenv("GATECHA_LOG_LEVEL")
 	os.Unsetenv("GATECHA_CLEANUP_INTERVAL")
 	os.Unsetenv("GATECHA_CORS_ALLOW_ALL")
+	os.Unsetenv("GATECHA_SIGNING_KEY_ROTATION_DAYS")
+	os.Unsetenv("GATECHA_SECRET_GRACE_PERIOD")
+	os.Unsetenv("GATECHA_WEBHOOK_INTERVAL")
+	os.Unsetenv("GATECHA_REPLAY_STORE")
 
 	cfg, err := Load()
 	if err != nil {
@@ -36,9 +40,24 @@ func TestLoad_Defaults(t *testing.T) {
 	if cfg.CORSAllowAll {
 		t.Error("expected CORSAllowAll to be false by default")
 	}
+	if cfg.RequireVerifySecret {
+		t.Error("expected RequireVerifySecret to be false by default")
+	}
 	if cfg.CleanupInterval != 10*time.Minute {
 		t.Errorf("expected 10m, got %v", cfg.CleanupInterval)
 	}
+	if cfg.SigningKeyRotation != 30*24*time.Hour {
+		t.Errorf("expected 30 days, got %v", cfg.SigningKeyRotation)
+	}
+	if cfg.SecretGracePeriod != time.Hour {
+		t.Errorf("expected 1h secret grace period, got %v", cfg.SecretGracePeriod)
+	}
+	if cfg.WebhookInterval != 10*time.Second {
+		t.Errorf("expected 10s webhook interval, got %v", cfg.WebhookInterval)
+	}
+	if cfg.ReplayStore != "sqlite" {
+		t.Errorf("expected sqlite replay store, got %s", cfg.ReplayStore)
+	}
 	if cfg.SecretKey == "" {
 		t.Error("expected auto-generated SecretKey")
 	}
@@ -56,6 +75,7 @@ func TestLoad_CustomEnv(t *testing.T) {
 	t.Setenv("GATECHA_LOG_LEVEL", "debug")
 	t.Setenv("GATECHA_CLEANUP_INTERVAL", "5")
 	t.Setenv("GATECHA_CORS_ALLOW_ALL", "true")
+	t.Setenv("GATECHA_REQUIRE_VERIFY_SECRET", "true")
 
 	cfg, err := Load()
 	if err != nil {
@@ -80,6 +100,9 @@ func TestLoad_CustomEnv(t *testing.T) {
 	if !cfg.CORSAllowAll {
 		t.Error("expected CORSAllowAll to be true")
 	}
+	if !cfg.RequireVerifySecret {
+		t.Error("expected RequireVerifySecret to be true")
+	}
 	if cfg.CleanupInterval != 5*time.Minute {
 		t.Errorf("expected 5m, got %v", cfg.CleanupInterval)
 	}
@@ -94,6 +117,62 @@ func TestLoad_InvalidCleanupInterval(t *testing.T) {
 	}
 }
 
+func TestLoad_InvalidSigningKeyRotation(t *testing.T) {
+	t.Setenv("GATECHA_SIGNING_KEY_ROTATION_DAYS", "soon")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for invalid signing key rotation")
+	}
+}
+
+func TestLoad_InvalidSecretGracePeriod(t *testing.T) {
+	t.Setenv("GATECHA_SECRET_GRACE_PERIOD", "1h")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for invalid secret grace period")
+	}
+}
+
+func TestLoad_InvalidWebhookInterval(t *testing.T) {
+	t.Setenv("GATECHA_WEBHOOK_INTERVAL", "0")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for a zero webhook interval")
+	}
+}
+
+func TestLoad_ReplayStore(t *testing.T) {
+	t.Setenv("GATECHA_REPLAY_STORE", "bloom")
+	t.Setenv("GATECHA_REPLAY_BLOOM_CAPACITY", "5000")
+	t.Setenv("GATECHA_REPLAY_BLOOM_WINDOW", "3")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ReplayStore != "bloom" {
+		t.Errorf("expected bloom, got %s", cfg.ReplayStore)
+	}
+	if cfg.ReplayBloomCapacity != 5000 {
+		t.Errorf("expected capacity 5000, got %d", cfg.ReplayBloomCapacity)
+	}
+	if cfg.ReplayBloomWindow != 3*time.Minute {
+		t.Errorf("expected 3m window, got %v", cfg.ReplayBloomWindow)
+	}
+}
+
+func TestLoad_InvalidReplayStore(t *testing.T) {
+	t.Setenv("GATECHA_REPLAY_STORE", "redis")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for unknown replay store")
+	}
+}
+
 func TestEnvOrDefault(t *testing.T) {
 	key := "TEST_GATECHA_ENV_OR_DEFAULT"
 	os.Unsetenv(key)
@@ -107,3 +186,162 @@ func TestEnvOrDefault(t *testing.T) {
 		t.Errorf("expected custom, got %s", v)
 	}
 }
+
+func TestLoad_OIDC(t *testing.T) {
+	t.Setenv("GATECHA_OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("GATECHA_OIDC_CLIENT_ID", "gatecha")
+	t.Setenv("GATECHA_OIDC_REDIRECT_URL", "https://gatecha.example.com/api/admin/oidc/callback")
+	t.Setenv("GATECHA_OIDC_GROUP_ROLES", "admins=owner, devs=editor")
+	t.Setenv("GATECHA_OIDC_DISABLE_PASSWORD_LOGIN", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.OIDC.Enabled() {
+		t.Fatal("expected OIDC to be enabled")
+	}
+	if cfg.OIDC.GroupRoles["admins"] != "owner" || cfg.OIDC.GroupRoles["devs"] != "editor" {
+		t.Errorf("unexpected group roles: %v", cfg.OIDC.GroupRoles)
+	}
+	if len(cfg.OIDC.Scopes) != 4 || cfg.OIDC.UsernameClaim != "preferred_username" || cfg.OIDC.GroupsClaim != "groups" {
+		t.Errorf("unexpected defaults: %+v", cfg.OIDC)
+	}
+	if !cfg.OIDC.DisablePasswordLogin {
+		t.Error("expected password login to be disabled")
+	}
+}
+
+func TestLoad_InvalidOIDC(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing client": {
+			"GATECHA_OIDC_ISSUER":      "https://idp.example.com",
+			"GATECHA_OIDC_GROUP_ROLES": "admins=owner",
+		},
+		"unknown role": {
+			"GATECHA_OIDC_ISSUER":       "https://idp.example.com",
+			"GATECHA_OIDC_CLIENT_ID":    "gatecha",
+			"GATECHA_OIDC_REDIRECT_URL": "https://gatecha.example.com/api/admin/oidc/callback",
+			"GATECHA_OIDC_GROUP_ROLES":  "admins=root",
+		},
+		"password login off without issuer": {
+			"GATECHA_OIDC_DISABLE_PASSWORD_LOGIN": "true",
+		},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := Load(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestLoad_Metrics(t *testing.T) {
+	t.Setenv("GATECHA_METRICS_ADDR", "127.0.0.1:9090")
+	t.Setenv("GATECHA_METRICS_TOKEN", "scrape-me")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.MetricsAddr != "127.0.0.1:9090" || cfg.MetricsToken != "scrape-me" {
+		t.Errorf("unexpected metrics config: %q %q", cfg.MetricsAddr, cfg.MetricsToken)
+	}
+}
+
+func TestLoad_Events(t *testing.T) {
+	t.Setenv("GATECHA_EVENT_SAMPLE_RATE", "0.25")
+	t.Setenv("GATECHA_EVENT_RETENTION_DAYS", "3")
+	t.Setenv("GATECHA_EVENT_IP_POLICY", "raw")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.EventSampleRate != 0.25 || cfg.EventRetention != 72*time.Hour || cfg.EventIPPolicy != "raw" {
+		t.Errorf("unexpected event config: %v %v %q", cfg.EventSampleRate, cfg.EventRetention, cfg.EventIPPolicy)
+	}
+}
+
+func TestLoad_InvalidEvents(t *testing.T) {
+	for name, env := range map[string][2]string{
+		"sample rate": {"GATECHA_EVENT_SAMPLE_RATE", "1.5"},
+		"retention":   {"GATECHA_EVENT_RETENTION_DAYS", "0"},
+		"ip policy":   {"GATECHA_EVENT_IP_POLICY", "masked"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%s", env[0], env[1])
+			}
+		})
+	}
+}
+
+func TestLoad_StatsRetention(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.StatsRetention != 90*24*time.Hour || cfg.StatsMonthlyRetention != 24 {
+		t.Errorf("unexpected default stats retention: %v %d", cfg.StatsRetention, cfg.StatsMonthlyRetention)
+	}
+
+	t.Setenv("GATECHA_STATS_RETENTION_DAYS", "0")
+	t.Setenv("GATECHA_STATS_MONTHLY_RETENTION_MONTHS", "6")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.StatsRetention != 0 || cfg.StatsMonthlyRetention != 6 {
+		t.Errorf("unexpected stats retention: %v %d", cfg.StatsRetention, cfg.StatsMonthlyRetention)
+	}
+
+	t.Setenv("GATECHA_STATS_MONTHLY_RETENTION_MONTHS", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for a negative monthly retention")
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	t.Setenv("GATECHA_TRUSTED_PROXIES", "10.0.0.1, 172.16.0.0/12,2001:db8::/32")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := []string{"10.0.0.1/32", "172.16.0.0/12", "2001:db8::/32"}
+	if len(cfg.TrustedProxies) != len(want) {
+		t.Fatalf("unexpected trusted proxies: %v", cfg.TrustedProxies)
+	}
+	for i, p := range cfg.TrustedProxies {
+		if p.String() != want[i] {
+			t.Errorf("proxy %d = %s, want %s", i, p, want[i])
+		}
+	}
+
+	t.Setenv("GATECHA_TRUSTED_PROXIES", "proxy.internal")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for a hostname")
+	}
+}
+
+func TestLoad_WebhookAllowedNetworks(t *testing.T) {
+	t.Setenv("GATECHA_WEBHOOK_ALLOWED_NETWORKS", "10.1.0.0/16,::1")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.WebhookAllowedNetworks) != 2 || cfg.WebhookAllowedNetworks[1].String() != "::1/128" {
+		t.Errorf("unexpected allowed networks: %v", cfg.WebhookAllowedNetworks)
+	}
+
+	t.Setenv("GATECHA_WEBHOOK_ALLOWED_NETWORKS", "intranet")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for a hostname")
+	}
+}
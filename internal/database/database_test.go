@@ -1,6 +1,7 @@
 package database
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"testing"
@@ -17,7 +18,7 @@ func TestOpen(t *testing.T) {
 	defer db.Close()
 
 	// Verify tables exist
-	tables := []string{"admin_users", "api_keys", "consumed_challenges", "daily_stats", "settings"}
+	tables := []string{"admin_users", "api_keys", "consumed_challenges", "daily_stats", "hourly_stats", "monthly_stats", "solve_times", "settings", "signing_keys", "api_key_secrets", "webhooks", "webhook_deliveries", "audit_log", "admin_recovery_codes", "admin_sessions", "admin_tokens", "login_lockouts", "projects", "project_members", "verification_events"}
 	for _, table := range tables {
 		var name string
 		err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&name)
@@ -67,3 +68,60 @@ func TestRunMigrations_Idempotent(t *testing.T) {
 		t.Fatalf("RunMigrations (2nd call) failed: %v", err)
 	}
 }
+
+func TestRunMigrations_RelaxesLegacyConsumedChallenges(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Schema as created by earlier releases
+	_, err = db.Exec(`
+		CREATE TABLE api_keys (id INTEGER PRIMARY KEY AUTOINCREMENT, key_id TEXT NOT NULL UNIQUE, hmac_secret TEXT NOT NULL, domain TEXT NOT NULL DEFAULT '');
+		CREATE TABLE consumed_challenges (
+		    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		    challenge     TEXT    NOT NULL UNIQUE,
+		    api_key_id    INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		    expires_at    TEXT    NOT NULL,
+		    consumed_at   TEXT    NOT NULL DEFAULT (datetime('now'))
+		);
+		INSERT INTO api_keys (key_id, hmac_secret, domain) VALUES ('gk_legacy', 'secret', 'legacy.example.com');
+		INSERT INTO consumed_challenges (challenge, api_key_id, expires_at) VALUES ('legacy-hash', 1, '2099-01-01T00:00:00Z');
+	`)
+	if err != nil {
+		t.Fatalf("legacy schema failed: %v", err)
+	}
+
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("RunMigrations failed: %v", err)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM consumed_challenges WHERE challenge = 'legacy-hash'`).Scan(&count)
+	if count != 1 {
+		t.Error("expected legacy rows to be preserved")
+	}
+	if _, err := db.Exec(`INSERT INTO consumed_challenges (challenge, expires_at) VALUES ('keyless', '2099-01-01T00:00:00Z')`); err != nil {
+		t.Errorf("expected api_key_id to be nullable after migration: %v", err)
+	}
+
+	var secret string
+	db.QueryRow(`SELECT secret FROM api_key_secrets WHERE api_key_id = 1 AND status = 'primary'`).Scan(&secret)
+	if secret != "secret" {
+		t.Errorf("expected existing key to get a primary secret, got %q", secret)
+	}
+	var allowed string
+	db.QueryRow(`SELECT allowed_origins FROM api_keys WHERE id = 1`).Scan(&allowed)
+	if allowed != `["legacy.example.com"]` {
+		t.Errorf("expected domain to become the allowed origin list, got %s", allowed)
+	}
+
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("RunMigrations (2nd call) failed: %v", err)
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM api_key_secrets`).Scan(&count)
+	if count != 1 {
+		t.Errorf("expected secrets to be seeded once, got %d", count)
+	}
+}
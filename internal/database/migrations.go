@@ -6,6 +6,27 @@ func RunMigrations(db *sql.DB) error {
 	if _, err := db.Exec(schema); err != nil {
 		return err
 	}
+	if err := relaxConsumedChallengesKey(db); err != nil {
+		return err
+	}
+	for _, c := range addedColumns {
+		if err := addColumnIfMissing(db, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	if err := seedAPIKeySecrets(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_keys_project ON api_keys(project_id)`); err != nil {
+		return err
+	}
+	// Keys from before allowed_origins existed keep their single domain.
+	if _, err := db.Exec(`
+		UPDATE api_keys SET allowed_origins = json_array(domain)
+		WHERE domain != '' AND allowed_origins = '[]'
+	`); err != nil {
+		return err
+	}
 	// Fix any NULL counter values from a previous bug.
 	_, err := db.Exec(`
 		UPDATE daily_stats SET
@@ -19,13 +40,174 @@ func RunMigrations(db *sql.DB) error {
 	return err
 }
 
+// addedColumns lists columns introduced after a table was first released.
+// Fresh databases get them from schema; older ones are altered in place.
+var addedColumns = []struct {
+	table, name, definition string
+}{
+	// Admins from before roles existed keep full access.
+	{"admin_users", "role", "TEXT NOT NULL DEFAULT 'owner'"},
+	{"admin_users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"admin_users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"admin_users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"admin_users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+	{"api_keys", "adaptive_difficulty", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "difficulty_min", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "difficulty_max", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "difficulty_curve", "TEXT NOT NULL DEFAULT 'linear'"},
+	// Keys created before verify secrets have none until one is generated.
+	{"api_keys", "verify_secret_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"api_keys", "allowed_origins", "TEXT NOT NULL DEFAULT '[]'"},
+	{"api_keys", "origin_enforcement", "TEXT NOT NULL DEFAULT 'lenient'"},
+	{"api_keys", "cors_exposed_headers", "TEXT NOT NULL DEFAULT '[]'"},
+	{"api_keys", "cors_allow_credentials", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "cors_max_age", "INTEGER NOT NULL DEFAULT 600"},
+	{"api_keys", "project_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "min_solve_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "min_challenge_age_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "max_challenge_age_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_keys", "timing_enforcement", "TEXT NOT NULL DEFAULT 'reject'"},
+	{"daily_stats", "origin_rejected_lenient", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "origin_rejected_strict", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "key_disabled_rejected", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_invalid_encoding", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_invalid_format", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_verification_error", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_invalid_solution", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_already_used", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "key_disabled_rejected", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_invalid_encoding", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_invalid_format", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_verification_error", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_invalid_solution", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_already_used", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "timing_flagged", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_too_fast", "INTEGER NOT NULL DEFAULT 0"},
+	{"daily_stats", "fail_too_late", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "timing_flagged", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_too_fast", "INTEGER NOT NULL DEFAULT 0"},
+	{"hourly_stats", "fail_too_late", "INTEGER NOT NULL DEFAULT 0"},
+	{"webhooks", "project_id", "INTEGER NOT NULL DEFAULT 0"},
+	// Solves without a reported time used to be counted with the others.
+	{"solve_times", "fallback_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"solve_times", "fallback_sum_ms", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// seedAPIKeySecrets gives keys created before key rings existed a primary
+// entry matching their current hmac_secret.
+func seedAPIKeySecrets(db *sql.DB) error {
+	_, err := db.Exec(`
+		INSERT INTO api_key_secrets (api_key_id, secret, status)
+		SELECT id, hmac_secret, 'primary' FROM api_keys
+		WHERE id NOT IN (SELECT api_key_id FROM api_key_secrets WHERE status = 'primary')
+	`)
+	return err
+}
+
+// relaxConsumedChallengesKey rebuilds consumed_challenges from databases
+// created before replay stores became pluggable, where api_key_id was
+// NOT NULL. The SQLite replay store only knows the challenge hash.
+func relaxConsumedChallengesKey(db *sql.DB) error {
+	var notNull int
+	err := db.QueryRow(`SELECT "notnull" FROM pragma_table_info('consumed_challenges') WHERE name = 'api_key_id'`).Scan(&notNull)
+	if err != nil || notNull == 0 {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		CREATE TABLE consumed_challenges_new (
+		    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		    challenge     TEXT    NOT NULL UNIQUE,
+		    api_key_id    INTEGER REFERENCES api_keys(id) ON DELETE CASCADE,
+		    expires_at    TEXT    NOT NULL,
+		    consumed_at   TEXT    NOT NULL DEFAULT (datetime('now'))
+		);
+		INSERT INTO consumed_challenges_new (id, challenge, api_key_id, expires_at, consumed_at)
+		    SELECT id, challenge, api_key_id, expires_at, consumed_at FROM consumed_challenges;
+		DROP TABLE consumed_challenges;
+		ALTER TABLE consumed_challenges_new RENAME TO consumed_challenges;
+		CREATE INDEX IF NOT EXISTS idx_consumed_challenges_challenge ON consumed_challenges(challenge);
+		CREATE INDEX IF NOT EXISTS idx_consumed_challenges_expires ON consumed_challenges(expires_at);
+	`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 const schema = `
 CREATE TABLE IF NOT EXISTS admin_users (
     id            INTEGER PRIMARY KEY AUTOINCREMENT,
     username      TEXT    NOT NULL UNIQUE DEFAULT 'admin',
     password_hash TEXT    NOT NULL,
+    role          TEXT    NOT NULL DEFAULT 'owner',
     created_at    TEXT    NOT NULL DEFAULT (datetime('now')),
-    updated_at    TEXT    NOT NULL DEFAULT (datetime('now'))
+    updated_at    TEXT    NOT NULL DEFAULT (datetime('now')),
+    totp_secret    TEXT    NOT NULL DEFAULT '',
+    totp_enabled   INTEGER NOT NULL DEFAULT 0,
+    totp_last_step INTEGER NOT NULL DEFAULT 0,
+    oidc_subject   TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id   INTEGER NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    code_hash TEXT    NOT NULL,
+    used_at   TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_recovery_codes_user ON admin_recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id           TEXT    PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    refresh_hash TEXT    NOT NULL,
+    ip           TEXT    NOT NULL DEFAULT '',
+    user_agent   TEXT    NOT NULL DEFAULT '',
+    created_at   TEXT    NOT NULL,
+    last_used_at TEXT    NOT NULL,
+    expires_at   TEXT    NOT NULL,
+    revoked_at   TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_user ON admin_sessions(user_id);
+
+CREATE TABLE IF NOT EXISTS admin_tokens (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    name         TEXT    NOT NULL,
+    prefix       TEXT    NOT NULL,
+    token_hash   TEXT    NOT NULL UNIQUE,
+    scopes       TEXT    NOT NULL,
+    created_at   TEXT    NOT NULL,
+    expires_at   TEXT    NOT NULL DEFAULT '',
+    last_used_at TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_tokens_user ON admin_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    scope           TEXT    NOT NULL,
+    subject         TEXT    NOT NULL,
+    failures        INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TEXT    NOT NULL,
+    locked_until    TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (scope, subject)
 );
 
 CREATE TABLE IF NOT EXISTS api_keys (
@@ -39,7 +221,22 @@ CREATE TABLE IF NOT EXISTS api_keys (
     algorithm       TEXT    NOT NULL DEFAULT 'SHA-256',
     enabled         INTEGER NOT NULL DEFAULT 1,
     created_at      TEXT    NOT NULL DEFAULT (datetime('now')),
-    updated_at      TEXT    NOT NULL DEFAULT (datetime('now'))
+    updated_at      TEXT    NOT NULL DEFAULT (datetime('now')),
+    adaptive_difficulty INTEGER NOT NULL DEFAULT 0,
+    difficulty_min      INTEGER NOT NULL DEFAULT 0,
+    difficulty_max      INTEGER NOT NULL DEFAULT 0,
+    difficulty_curve    TEXT    NOT NULL DEFAULT 'linear',
+    verify_secret_hash  TEXT    NOT NULL DEFAULT '',
+    allowed_origins     TEXT    NOT NULL DEFAULT '[]',
+    origin_enforcement  TEXT    NOT NULL DEFAULT 'lenient',
+    cors_exposed_headers   TEXT    NOT NULL DEFAULT '[]',
+    cors_allow_credentials INTEGER NOT NULL DEFAULT 0,
+    cors_max_age           INTEGER NOT NULL DEFAULT 600,
+    project_id             INTEGER NOT NULL DEFAULT 0,
+    min_solve_ms              INTEGER NOT NULL DEFAULT 0,
+    min_challenge_age_ms      INTEGER NOT NULL DEFAULT 0,
+    max_challenge_age_seconds INTEGER NOT NULL DEFAULT 0,
+    timing_enforcement        TEXT    NOT NULL DEFAULT 'reject'
 );
 
 CREATE INDEX IF NOT EXISTS idx_api_keys_key_id ON api_keys(key_id);
@@ -47,7 +244,7 @@ CREATE INDEX IF NOT EXISTS idx_api_keys_key_id ON api_keys(key_id);
 CREATE TABLE IF NOT EXISTS consumed_challenges (
     id            INTEGER PRIMARY KEY AUTOINCREMENT,
     challenge     TEXT    NOT NULL UNIQUE,
-    api_key_id    INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    api_key_id    INTEGER REFERENCES api_keys(id) ON DELETE CASCADE,
     expires_at    TEXT    NOT NULL,
     consumed_at   TEXT    NOT NULL DEFAULT (datetime('now'))
 );
@@ -62,14 +259,191 @@ CREATE TABLE IF NOT EXISTS daily_stats (
     challenges_issued   INTEGER NOT NULL DEFAULT 0,
     verifications_ok    INTEGER NOT NULL DEFAULT 0,
     verifications_fail  INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_lenient INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_strict  INTEGER NOT NULL DEFAULT 0,
+    key_disabled_rejected   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_encoding   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_format     INTEGER NOT NULL DEFAULT 0,
+    fail_verification_error INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_solution   INTEGER NOT NULL DEFAULT 0,
+    fail_already_used       INTEGER NOT NULL DEFAULT 0,
+    timing_flagged          INTEGER NOT NULL DEFAULT 0,
+    fail_too_fast           INTEGER NOT NULL DEFAULT 0,
+    fail_too_late           INTEGER NOT NULL DEFAULT 0,
     UNIQUE(api_key_id, date)
 );
 
 CREATE INDEX IF NOT EXISTS idx_daily_stats_key_date ON daily_stats(api_key_id, date);
 
+CREATE TABLE IF NOT EXISTS hourly_stats (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id          INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    hour                TEXT    NOT NULL,
+    challenges_issued   INTEGER NOT NULL DEFAULT 0,
+    verifications_ok    INTEGER NOT NULL DEFAULT 0,
+    verifications_fail  INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_lenient INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_strict  INTEGER NOT NULL DEFAULT 0,
+    key_disabled_rejected   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_encoding   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_format     INTEGER NOT NULL DEFAULT 0,
+    fail_verification_error INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_solution   INTEGER NOT NULL DEFAULT 0,
+    fail_already_used       INTEGER NOT NULL DEFAULT 0,
+    timing_flagged          INTEGER NOT NULL DEFAULT 0,
+    fail_too_fast           INTEGER NOT NULL DEFAULT 0,
+    fail_too_late           INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(api_key_id, hour)
+);
+
+CREATE INDEX IF NOT EXISTS idx_hourly_stats_hour ON hourly_stats(hour);
+
+CREATE TABLE IF NOT EXISTS monthly_stats (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id          INTEGER NOT NULL,
+    project_id          INTEGER NOT NULL DEFAULT 0,
+    month               TEXT    NOT NULL,
+    last_date           TEXT    NOT NULL,
+    challenges_issued   INTEGER NOT NULL DEFAULT 0,
+    verifications_ok    INTEGER NOT NULL DEFAULT 0,
+    verifications_fail  INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_lenient INTEGER NOT NULL DEFAULT 0,
+    origin_rejected_strict  INTEGER NOT NULL DEFAULT 0,
+    key_disabled_rejected   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_encoding   INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_format     INTEGER NOT NULL DEFAULT 0,
+    fail_verification_error INTEGER NOT NULL DEFAULT 0,
+    fail_invalid_solution   INTEGER NOT NULL DEFAULT 0,
+    fail_already_used       INTEGER NOT NULL DEFAULT 0,
+    timing_flagged          INTEGER NOT NULL DEFAULT 0,
+    fail_too_fast           INTEGER NOT NULL DEFAULT 0,
+    fail_too_late           INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(api_key_id, month)
+);
+
+CREATE INDEX IF NOT EXISTS idx_monthly_stats_month ON monthly_stats(month);
+
+CREATE TABLE IF NOT EXISTS solve_times (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id        INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    date              TEXT    NOT NULL,
+    le_ms             INTEGER NOT NULL,
+    count             INTEGER NOT NULL DEFAULT 0,
+    sum_ms            INTEGER NOT NULL DEFAULT 0,
+    reported_numbers  INTEGER NOT NULL DEFAULT 0,
+    reported_ms       INTEGER NOT NULL DEFAULT 0,
+    fallback_count    INTEGER NOT NULL DEFAULT 0,
+    fallback_sum_ms   INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(api_key_id, date, le_ms)
+);
+
 CREATE TABLE IF NOT EXISTS settings (
     key        TEXT NOT NULL PRIMARY KEY,
     value      TEXT NOT NULL DEFAULT '',
     updated_at TEXT NOT NULL DEFAULT (datetime('now'))
 );
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    kid         TEXT    NOT NULL UNIQUE,
+    algorithm   TEXT    NOT NULL DEFAULT 'EdDSA',
+    public_key  TEXT    NOT NULL,
+    private_key TEXT    NOT NULL,
+    status      TEXT    NOT NULL DEFAULT 'active',
+    created_at  TEXT    NOT NULL DEFAULT (datetime('now')),
+    retired_at  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_status ON signing_keys(status);
+
+CREATE TABLE IF NOT EXISTS api_key_secrets (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id  INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    secret      TEXT    NOT NULL,
+    status      TEXT    NOT NULL DEFAULT 'primary',
+    created_at  TEXT    NOT NULL DEFAULT (datetime('now')),
+    expires_at  TEXT    NOT NULL DEFAULT '',
+    retired_at  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_secrets_key ON api_key_secrets(api_key_id, status);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT '',
+    created_at  TEXT    NOT NULL,
+    updated_at  TEXT    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS project_members (
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    PRIMARY KEY (project_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_members_user ON project_members(user_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id                              INTEGER PRIMARY KEY AUTOINCREMENT,
+    url                             TEXT    NOT NULL,
+    secret                          TEXT    NOT NULL,
+    events                          TEXT    NOT NULL DEFAULT '[]',
+    enabled                         INTEGER NOT NULL DEFAULT 1,
+    failure_rate_threshold          REAL    NOT NULL DEFAULT 0.5,
+    failure_rate_min_verifications  INTEGER NOT NULL DEFAULT 20,
+    project_id                      INTEGER NOT NULL DEFAULT 0,
+    created_at                      TEXT    NOT NULL DEFAULT (datetime('now')),
+    updated_at                      TEXT    NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id        INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event             TEXT    NOT NULL,
+    data              TEXT    NOT NULL DEFAULT '{}',
+    status            TEXT    NOT NULL DEFAULT 'pending',
+    attempts          INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at   TEXT    NOT NULL DEFAULT '',
+    last_status_code  INTEGER NOT NULL DEFAULT 0,
+    last_error        TEXT    NOT NULL DEFAULT '',
+    dedupe_key        TEXT,
+    created_at        TEXT    NOT NULL DEFAULT (datetime('now')),
+    delivered_at      TEXT    NOT NULL DEFAULT '',
+    UNIQUE(webhook_id, dedupe_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at   TEXT    NOT NULL DEFAULT (datetime('now')),
+    actor        TEXT    NOT NULL DEFAULT '',
+    action       TEXT    NOT NULL,
+    target_type  TEXT    NOT NULL DEFAULT '',
+    target_id    TEXT    NOT NULL DEFAULT '',
+    ip           TEXT    NOT NULL DEFAULT '',
+    success      INTEGER NOT NULL DEFAULT 1,
+    changes      TEXT    NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS verification_events (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at   TEXT    NOT NULL,
+    api_key_id   INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    kind         TEXT    NOT NULL,
+    outcome      TEXT    NOT NULL,
+    client_ip    TEXT    NOT NULL DEFAULT '',
+    origin       TEXT    NOT NULL DEFAULT '',
+    user_agent   TEXT    NOT NULL DEFAULT '',
+    took         INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_verification_events_key ON verification_events(api_key_id, id);
+CREATE INDEX IF NOT EXISTS idx_verification_events_created ON verification_events(created_at);
 `
@@ -0,0 +1,146 @@
+package difficulty
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// HalfLife is how quickly a client's recorded activity is forgotten.
+	HalfLife = 10 * time.Minute
+	// FreeRequests is the decayed request count a client may reach before
+	// its difficulty starts to rise.
+	FreeRequests = 5
+	// FailureWeight is how much one failed verification counts compared
+	// to one extra challenge request.
+	FailureWeight = 3
+	// LinearSaturation is the pressure at which the linear curve reaches
+	// the maximum difficulty.
+	LinearSaturation = 30
+	// ExponentialDoubling is the pressure that doubles difficulty on the
+	// exponential curve.
+	ExponentialDoubling = 3
+
+	CurveLinear      = "linear"
+	CurveExponential = "exponential"
+)
+
+// Tracker keeps exponentially decaying request and failure counters per
+// client. Counters decay continuously, so memory per client is constant
+// and no sliding window bookkeeping is needed.
+type Tracker struct {
+	mu      sync.Mutex
+	clients map[string]*clientActivity
+	now     func() time.Time
+}
+
+type clientActivity struct {
+	requests float64
+	failures float64
+	updated  time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{clients: make(map[string]*clientActivity), now: time.Now}
+}
+
+func (a *clientActivity) decay(now time.Time) {
+	factor := math.Pow(0.5, float64(now.Sub(a.updated))/float64(HalfLife))
+	a.requests *= factor
+	a.failures *= factor
+	a.updated = now
+}
+
+func (t *Tracker) activity(client string, now time.Time) *clientActivity {
+	a, ok := t.clients[client]
+	if !ok {
+		a = &clientActivity{updated: now}
+		t.clients[client] = a
+	}
+	a.decay(now)
+	return a
+}
+
+// RecordChallenge counts a challenge request and returns the client's
+// pressure including it.
+func (t *Tracker) RecordChallenge(client string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.activity(client, t.now())
+	a.requests++
+	return pressure(a)
+}
+
+// RecordFailure counts a failed verification for the client.
+func (t *Tracker) RecordFailure(client string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.activity(client, t.now()).failures++
+}
+
+// Prune forgets clients whose activity has decayed to nothing and returns
+// how many were removed.
+func (t *Tracker) Prune() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	removed := 0
+	for client, a := range t.clients {
+		a.decay(now)
+		if a.requests < 0.01 && a.failures < 0.01 {
+			delete(t.clients, client)
+			removed++
+		}
+	}
+	return removed
+}
+
+func pressure(a *clientActivity) float64 {
+	return math.Max(0, a.requests-FreeRequests) + FailureWeight*a.failures
+}
+
+// Scale maps a client's pressure onto [min, max] along curve.
+func Scale(p float64, min, max int64, curve string) int64 {
+	if max <= min {
+		return min
+	}
+	var n float64
+	switch curve {
+	case CurveExponential:
+		n = float64(min) * math.Pow(2, p/ExponentialDoubling)
+	default:
+		n = float64(min) + float64(max-min)*math.Min(1, p/LinearSaturation)
+	}
+	if n > float64(max) {
+		return max
+	}
+	return int64(n)
+}
+
+// ClientPrefix groups addresses the way a single client usually shows up:
+// the full address for IPv4 and the /64 network for IPv6.
+func ClientPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// ClientTag derives an opaque identifier for a client prefix. It is
+// embedded in signed challenge params so verification failures can be
+// attributed to the client that solved the challenge. Keyed with the API
+// key's secret, it cannot be forged to raise another client's difficulty.
+func ClientTag(secret, prefix string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(prefix))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
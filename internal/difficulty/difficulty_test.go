@@ -0,0 +1,131 @@
+package difficulty
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTracker(now *time.Time) *Tracker {
+	t := NewTracker()
+	t.now = func() time.Time { return *now }
+	return t
+}
+
+func TestTracker_FreeRequests(t *testing.T) {
+	now := time.Now()
+	tr := newTestTracker(&now)
+
+	for i := 0; i < FreeRequests; i++ {
+		if p := tr.RecordChallenge("client"); p != 0 {
+			t.Fatalf("request %d: expected zero pressure, got %f", i+1, p)
+		}
+	}
+	if p := tr.RecordChallenge("client"); p <= 0 {
+		t.Errorf("expected pressure once free requests are used, got %f", p)
+	}
+	if p := tr.RecordChallenge("other"); p != 0 {
+		t.Errorf("expected clients to be tracked separately, got %f", p)
+	}
+}
+
+func TestTracker_FailuresRaisePressure(t *testing.T) {
+	now := time.Now()
+	tr := newTestTracker(&now)
+
+	base := tr.RecordChallenge("client")
+	tr.RecordFailure("client")
+	tr.RecordFailure("client")
+	p := tr.RecordChallenge("client")
+	if p < base+2*FailureWeight-0.01 {
+		t.Errorf("expected failures to add %d pressure each, got %f", FailureWeight, p)
+	}
+}
+
+func TestTracker_Decay(t *testing.T) {
+	now := time.Now()
+	tr := newTestTracker(&now)
+
+	var p float64
+	for i := 0; i < 20; i++ {
+		p = tr.RecordChallenge("client")
+	}
+	now = now.Add(HalfLife * 10)
+	if later := tr.RecordChallenge("client"); later >= p {
+		t.Errorf("expected pressure to decay, was %f now %f", p, later)
+	}
+}
+
+func TestTracker_Prune(t *testing.T) {
+	now := time.Now()
+	tr := newTestTracker(&now)
+
+	tr.RecordChallenge("old")
+	now = now.Add(HalfLife * 20)
+	tr.RecordChallenge("recent")
+
+	if removed := tr.Prune(); removed != 1 {
+		t.Errorf("expected 1 pruned client, got %d", removed)
+	}
+	if _, ok := tr.clients["recent"]; !ok {
+		t.Error("expected recent client to be kept")
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		pressure float64
+		curve    string
+		want     int64
+	}{
+		{"linear none", 0, CurveLinear, 1000},
+		{"linear half", LinearSaturation / 2, CurveLinear, 5500},
+		{"linear saturated", LinearSaturation * 4, CurveLinear, 10000},
+		{"exponential none", 0, CurveExponential, 1000},
+		{"exponential doubled", ExponentialDoubling, CurveExponential, 2000},
+		{"exponential capped", ExponentialDoubling * 10, CurveExponential, 10000},
+		{"unknown curve is linear", LinearSaturation, "", 10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Scale(tt.pressure, 1000, 10000, tt.curve); got != tt.want {
+				t.Errorf("Scale() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := Scale(50, 1000, 1000, CurveLinear); got != 1000 {
+		t.Errorf("expected equal bounds to return min, got %d", got)
+	}
+}
+
+func TestClientPrefix(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.10":           "192.0.2.10",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::1": "2001:db8:1:2::/64",
+		"not-an-ip":            "not-an-ip",
+		"::ffff:198.51.100.7":  "198.51.100.7",
+	}
+	for in, want := range tests {
+		if got := ClientPrefix(in); got != want {
+			t.Errorf("ClientPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestClientTag(t *testing.T) {
+	a := ClientTag("secret", "192.0.2.10")
+	if len(a) != 16 {
+		t.Fatalf("expected 16 character tag, got %q", a)
+	}
+	if a != ClientTag("secret", "192.0.2.10") {
+		t.Error("expected tag to be deterministic")
+	}
+	if a == ClientTag("other-secret", "192.0.2.10") {
+		t.Error("expected tag to depend on the secret")
+	}
+	if a == ClientTag("secret", "192.0.2.11") {
+		t.Error("expected tag to depend on the prefix")
+	}
+}
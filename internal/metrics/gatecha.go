@@ -0,0 +1,40 @@
+package metrics
+
+import "database/sql"
+
+// Default is the registry GateCHA serves on /metrics.
+var Default = NewRegistry()
+
+// GateCHA's metrics. Keys are labelled with their public key ID.
+var (
+	ChallengesIssued = Default.Counter("gatecha_challenges_issued_total",
+		"Challenges issued, by API key.", "key")
+	Verifications = Default.Counter("gatecha_verifications_total",
+		"Solution verifications, by API key and result: ok or the failure reason.", "key", "result")
+	RequestsRejected = Default.Counter("gatecha_requests_rejected_total",
+		"Public API requests refused before reaching the handler, by API key and reason.", "key", "reason")
+	RequestDuration = Default.Histogram("gatecha_http_request_duration_seconds",
+		"HTTP request latency, by method, route and status code.", DefaultBuckets, "method", "route", "code")
+	CleanupRuns = Default.Counter("gatecha_cleanup_runs_total",
+		"Runs of the cleanup worker.")
+	CleanupDeleted = Default.Counter("gatecha_cleanup_deleted_total",
+		"Rows and entries removed by the cleanup worker, by task.", "task")
+	CleanupErrors = Default.Counter("gatecha_cleanup_errors_total",
+		"Failed cleanup worker tasks, by task.", "task")
+)
+
+// RegisterDBStats adds the connection pool statistics of db to r.
+func RegisterDBStats(r *Registry, db *sql.DB) {
+	r.GaugeFunc("gatecha_db_max_open_connections", "Maximum number of open database connections.",
+		func() float64 { return float64(db.Stats().MaxOpenConnections) })
+	r.GaugeFunc("gatecha_db_open_connections", "Open database connections, in use and idle.",
+		func() float64 { return float64(db.Stats().OpenConnections) })
+	r.GaugeFunc("gatecha_db_in_use_connections", "Database connections in use.",
+		func() float64 { return float64(db.Stats().InUse) })
+	r.GaugeFunc("gatecha_db_idle_connections", "Idle database connections.",
+		func() float64 { return float64(db.Stats().Idle) })
+	r.CounterFunc("gatecha_db_wait_count_total", "Times a query waited for a database connection.",
+		func() float64 { return float64(db.Stats().WaitCount) })
+	r.CounterFunc("gatecha_db_wait_duration_seconds_total", "Time spent waiting for database connections.",
+		func() float64 { return db.Stats().WaitDuration.Seconds() })
+}
@@ -0,0 +1,276 @@
+// Package metrics keeps counters, histograms and gauges and exposes them in
+// the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of latency histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were added.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics. A non-empty token must be sent as
+// "Authorization: Bearer <token>".
+func (r *Registry) Handler(token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// series is one labelled value of a vector.
+type series struct {
+	labels []string
+	value  float64
+	// Histograms only: counts per bucket (not cumulative) and the sum.
+	buckets []uint64
+	sum     float64
+}
+
+// vec holds the series of a counter or histogram by label values.
+type vec struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help, kind string, buckets []float64, labels []string) *vec {
+	return &vec{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+}
+
+// get returns the series for the label values; v.mu must be held.
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if v.kind == "histogram" {
+			s.buckets = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// lookup returns the value of the series for the label values, or 0 if it
+// does not exist yet.
+func (v *vec) lookup(values []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[strings.Join(values, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		if v.kind != "histogram" {
+			writeSample(w, v.name, v.labels, s.labels, "", "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range v.buckets {
+			cumulative += s.buckets[i]
+			writeSample(w, v.name+"_bucket", v.labels, s.labels, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, v.name+"_bucket", v.labels, s.labels, "le", "+Inf", s.value)
+		writeSample(w, v.name+"_sum", v.labels, s.labels, "", "", s.sum)
+		writeSample(w, v.name+"_count", v.labels, s.labels, "", "", s.value)
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	v *vec
+}
+
+// Counter adds a counter with the given label names. Counter names should
+// end in _total.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", nil, labels)}
+	r.add(c.v)
+	return c
+}
+
+// Inc adds one to the series with the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the series with the label
+// values.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.get(values).value += delta
+}
+
+// Value returns the current value of the series with the label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	return c.v.lookup(values)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	v *vec
+}
+
+// Histogram adds a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{v: newVec(name, help, "histogram", buckets, labels)}
+	r.add(h.v)
+	return h
+}
+
+// Observe records a value in the series with the label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.get(values)
+	s.value++
+	s.sum += value
+	if i := sort.SearchFloat64s(h.v.buckets, value); i < len(s.buckets) {
+		s.buckets[i]++
+	}
+}
+
+// Count returns how many values the series with the label values recorded.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	return uint64(h.v.lookup(values))
+}
+
+// funcMetric reads its value when the metrics are written.
+type funcMetric struct {
+	name, help, kind string
+	fn               func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+// GaugeFunc adds a gauge whose value fn returns.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(&funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// CounterFunc adds a counter whose value fn returns, for totals kept
+// elsewhere.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.add(&funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeSample writes one sample line. extraName and extraValue add a label
+// after the series labels, such as a histogram's le.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, labelEscaper.Replace(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_events_total", "Events.", "key", "result")
+	c.Inc("k1", "ok")
+	c.Add(2, "k1", "ok")
+	c.Inc("k2", `bad "quote"`)
+
+	if got := c.Value("k1", "ok"); got != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+	if got := c.Value("k3", "ok"); got != 0 {
+		t.Errorf("expected 0 for an unknown series, got %v", got)
+	}
+
+	out := render(t, r)
+	for _, want := range []string{
+		"# HELP test_events_total Events.\n",
+		"# TYPE test_events_total counter\n",
+		`test_events_total{key="k1",result="ok"} 3` + "\n",
+		`test_events_total{key="k2",result="bad \"quote\""} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(3, "/a")
+
+	if got := h.Count("/a"); got != 4 {
+		t.Errorf("expected 4 observations, got %d", got)
+	}
+
+	out := render(t, r)
+	for _, want := range []string{
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{route="/a",le="0.1"} 2` + "\n",
+		`test_duration_seconds_bucket{route="/a",le="1"} 3` + "\n",
+		`test_duration_seconds_bucket{route="/a",le="+Inf"} 4` + "\n",
+		`test_duration_seconds_sum{route="/a"} 3.65` + "\n",
+		`test_duration_seconds_count{route="/a"} 4` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	n := 1.0
+	r.GaugeFunc("test_entries", "Entries.", func() float64 { return n })
+	n = 7
+
+	if out := render(t, r); !strings.Contains(out, "# TYPE test_entries gauge\ntest_entries 7\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHandler_Token(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Test.").Inc()
+	handler := r.Handler("secret")
+
+	do := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", rec.Code)
+	}
+	if rec := do("Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", rec.Code)
+	}
+	rec := do("Bearer secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Errorf("unexpected body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 without a configured token, got %d", rec.Code)
+	}
+}
@@ -2,12 +2,26 @@ package models
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
+// apiKeysVersion counts the creations, updates and deletions of API keys,
+// so in-memory copies of the key list know when to reload.
+var apiKeysVersion atomic.Uint64
+
+// APIKeysVersion returns a number that changes whenever an API key is
+// created, updated or deleted.
+func APIKeysVersion() uint64 {
+	return apiKeysVersion.Load()
+}
+
 type APIKey struct {
 	ID            int64  `json:"id"`
 	KeyID         string `json:"key_id"`
@@ -20,16 +34,197 @@ type APIKey struct {
 	Enabled       bool   `json:"enabled"`
 	CreatedAt     string `json:"created_at"`
 	UpdatedAt     string `json:"updated_at"`
+
+	// ProjectID is the project owning the key, or 0 for none.
+	ProjectID int64 `json:"project_id"`
+
+	// AllowedOrigins are the origin patterns (see package origins) that
+	// may use the key. Domain mirrors the first entry for older clients.
+	// OriginEnforcement decides how they are applied: off, lenient (only
+	// requests that send an Origin are checked) or strict.
+	AllowedOrigins    []string `json:"allowed_origins"`
+	OriginEnforcement string   `json:"origin_enforcement"`
+
+	// CORS policy for /api/v1 responses to allowed origins. CORSMaxAge is
+	// how many seconds browsers may cache a preflight answer.
+	CORSExposedHeaders   []string `json:"cors_exposed_headers"`
+	CORSAllowCredentials bool     `json:"cors_allow_credentials"`
+	CORSMaxAge           int      `json:"cors_max_age"`
+
+	// VerifySecret is the server-side secret required by /api/v1/verify.
+	// Only a hash is stored, so it is set only when the secret has just
+	// been generated.
+	VerifySecret     string `json:"verify_secret,omitempty"`
+	HasVerifySecret  bool   `json:"has_verify_secret"`
+	verifySecretHash string
+
+	// Adaptive difficulty: when enabled, each client's MaxNumber scales
+	// from DifficultyMin to DifficultyMax along DifficultyCurve.
+	AdaptiveDifficulty bool   `json:"adaptive_difficulty"`
+	DifficultyMin      int64  `json:"difficulty_min"`
+	DifficultyMax      int64  `json:"difficulty_max"`
+	DifficultyCurve    string `json:"difficulty_curve"`
+
+	// Timing checks against solutions that come back faster than a browser
+	// could solve them or long after the challenge was issued. Zero turns a
+	// check off. MinSolveMS applies to the solving time the widget reports,
+	// the challenge age limits to the time since the server issued the
+	// challenge. TimingEnforcement decides whether failing solutions are
+	// rejected or only flagged.
+	MinSolveMS             int64  `json:"min_solve_ms"`
+	MinChallengeAgeMS      int64  `json:"min_challenge_age_ms"`
+	MaxChallengeAgeSeconds int    `json:"max_challenge_age_seconds"`
+	TimingEnforcement      string `json:"timing_enforcement"`
 }
 
 // UpdateAPIKeyParams holds the fields for updating an API key.
 type UpdateAPIKeyParams struct {
-	Name          string
-	Domain        string
-	MaxNumber     int64
-	ExpireSeconds int
-	Algorithm     string
-	Enabled       bool
+	ProjectID            int64
+	Name                 string
+	Domain               string
+	AllowedOrigins       []string
+	OriginEnforcement    string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           int
+	MaxNumber            int64
+	ExpireSeconds        int
+	Algorithm            string
+	Enabled              bool
+	AdaptiveDifficulty   bool
+	DifficultyMin        int64
+	DifficultyMax        int64
+	DifficultyCurve      string
+
+	MinSolveMS             int64
+	MinChallengeAgeMS      int64
+	MaxChallengeAgeSeconds int
+	TimingEnforcement      string
+}
+
+const (
+	DifficultyCurveLinear      = "linear"
+	DifficultyCurveExponential = "exponential"
+
+	OriginEnforcementOff     = "off"
+	OriginEnforcementLenient = "lenient"
+	OriginEnforcementStrict  = "strict"
+
+	TimingEnforcementFlag   = "flag"
+	TimingEnforcementReject = "reject"
+
+	// DefaultCORSMaxAge is the preflight cache lifetime of new keys.
+	DefaultCORSMaxAge = 600
+)
+
+// DifficultyBounds returns the MaxNumber range used by adaptive difficulty.
+// An unset minimum falls back to MaxNumber and an unset maximum to ten
+// times the minimum.
+func (k *APIKey) DifficultyBounds() (int64, int64) {
+	min := k.DifficultyMin
+	if min <= 0 {
+		min = k.MaxNumber
+	}
+	max := k.DifficultyMax
+	if max <= 0 {
+		max = min * 10
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// UpdateParams returns the key's current settings, ready to be modified
+// and passed to UpdateAPIKey.
+func (k *APIKey) UpdateParams() UpdateAPIKeyParams {
+	return UpdateAPIKeyParams{
+		ProjectID:            k.ProjectID,
+		Name:                 k.Name,
+		Domain:               k.Domain,
+		AllowedOrigins:       k.AllowedOrigins,
+		OriginEnforcement:    k.OriginEnforcement,
+		CORSExposedHeaders:   k.CORSExposedHeaders,
+		CORSAllowCredentials: k.CORSAllowCredentials,
+		CORSMaxAge:           k.CORSMaxAge,
+		MaxNumber:            k.MaxNumber,
+		ExpireSeconds:        k.ExpireSeconds,
+		Algorithm:            k.Algorithm,
+		Enabled:              k.Enabled,
+		AdaptiveDifficulty:   k.AdaptiveDifficulty,
+		DifficultyMin:        k.DifficultyMin,
+		DifficultyMax:        k.DifficultyMax,
+		DifficultyCurve:      k.DifficultyCurve,
+
+		MinSolveMS:             k.MinSolveMS,
+		MinChallengeAgeMS:      k.MinChallengeAgeMS,
+		MaxChallengeAgeSeconds: k.MaxChallengeAgeSeconds,
+		TimingEnforcement:      k.TimingEnforcement,
+	}
+}
+
+const apiKeyColumns = `id, key_id, hmac_secret, name, domain, max_number, expire_seconds, algorithm, enabled, created_at, updated_at,
+		adaptive_difficulty, difficulty_min, difficulty_max, difficulty_curve, verify_secret_hash, allowed_origins, origin_enforcement,
+		cors_exposed_headers, cors_allow_credentials, cors_max_age, project_id,
+		min_solve_ms, min_challenge_age_ms, max_challenge_age_seconds, timing_enforcement`
+
+func scanAPIKey(scan func(dest ...interface{}) error) (*APIKey, error) {
+	var k APIKey
+	var enabled, adaptive, credentials int
+	var allowedOrigins, exposedHeaders string
+	err := scan(&k.ID, &k.KeyID, &k.HMACSecret, &k.Name, &k.Domain, &k.MaxNumber, &k.ExpireSeconds, &k.Algorithm, &enabled, &k.CreatedAt, &k.UpdatedAt,
+		&adaptive, &k.DifficultyMin, &k.DifficultyMax, &k.DifficultyCurve, &k.verifySecretHash, &allowedOrigins, &k.OriginEnforcement,
+		&exposedHeaders, &credentials, &k.CORSMaxAge, &k.ProjectID,
+		&k.MinSolveMS, &k.MinChallengeAgeMS, &k.MaxChallengeAgeSeconds, &k.TimingEnforcement)
+	if err != nil {
+		return nil, err
+	}
+	if k.AllowedOrigins, err = decodeList(allowedOrigins); err != nil {
+		return nil, fmt.Errorf("invalid allowed_origins for %s: %w", k.KeyID, err)
+	}
+	if k.CORSExposedHeaders, err = decodeList(exposedHeaders); err != nil {
+		return nil, fmt.Errorf("invalid cors_exposed_headers for %s: %w", k.KeyID, err)
+	}
+	k.Enabled = enabled == 1
+	k.CORSAllowCredentials = credentials == 1
+	k.AdaptiveDifficulty = adaptive == 1
+	k.HasVerifySecret = k.verifySecretHash != ""
+	return &k, nil
+}
+
+// CheckVerifySecret reports whether secret is the key's verify secret.
+func (k *APIKey) CheckVerifySecret(secret string) bool {
+	if k.verifySecretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashVerifySecret(secret)), []byte(k.verifySecretHash)) == 1
+}
+
+// encodeList stores a string list as a JSON array column.
+func encodeList(list []string) string {
+	if len(list) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+func decodeList(s string) ([]string, error) {
+	list := []string{}
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []string{}
+	}
+	return list, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func GenerateKeyID() (string, error) {
@@ -40,6 +235,19 @@ func GenerateKeyID() (string, error) {
 	return "gk_" + hex.EncodeToString(b), nil
 }
 
+func GenerateVerifySecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gs_" + hex.EncodeToString(b), nil
+}
+
+func hashVerifySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
 func GenerateHMACSecret() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
@@ -48,7 +256,29 @@ func GenerateHMACSecret() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// CreateAPIKeyParams holds the fields of a new API key. The first allowed
+// origin is also stored as its domain.
+type CreateAPIKeyParams struct {
+	ProjectID      int64
+	Name           string
+	AllowedOrigins []string
+	MaxNumber      int64
+	ExpireSeconds  int
+	Algorithm      string
+}
+
 func CreateAPIKey(db *sql.DB, name, domain string, maxNumber int64, expireSeconds int, algorithm string) (*APIKey, error) {
+	params := CreateAPIKeyParams{Name: name, MaxNumber: maxNumber, ExpireSeconds: expireSeconds, Algorithm: algorithm}
+	if domain != "" {
+		params.AllowedOrigins = []string{domain}
+	}
+	return CreateAPIKeyWithParams(db, params)
+}
+
+// CreateAPIKeyWithParams creates a key with its origins and project in a
+// single transaction.
+func CreateAPIKeyWithParams(db *sql.DB, p CreateAPIKeyParams) (*APIKey, error) {
+	name, maxNumber, expireSeconds, algorithm := p.Name, p.MaxNumber, p.ExpireSeconds, p.Algorithm
 	keyID, err := GenerateKeyID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate key ID: %w", err)
@@ -59,6 +289,11 @@ func CreateAPIKey(db *sql.DB, name, domain string, maxNumber int64, expireSecond
 		return nil, fmt.Errorf("failed to generate HMAC secret: %w", err)
 	}
 
+	verifySecret, err := GenerateVerifySecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate verify secret: %w", err)
+	}
+
 	if maxNumber <= 0 {
 		maxNumber = 100000
 	}
@@ -69,20 +304,40 @@ func CreateAPIKey(db *sql.DB, name, domain string, maxNumber int64, expireSecond
 		algorithm = "SHA-256"
 	}
 
+	allowedOrigins, domain := []string{}, ""
+	if len(p.AllowedOrigins) > 0 {
+		allowedOrigins, domain = p.AllowedOrigins, p.AllowedOrigins[0]
+	}
+
 	now := time.Now().UTC().Format(time.RFC3339)
-	result, err := db.Exec(`
-		INSERT INTO api_keys (key_id, hmac_secret, name, domain, max_number, expire_seconds, algorithm, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, keyID, hmacSecret, name, domain, maxNumber, expireSeconds, algorithm, now, now)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO api_keys (key_id, hmac_secret, verify_secret_hash, project_id, name, domain, allowed_origins, max_number, expire_seconds, algorithm, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, keyID, hmacSecret, hashVerifySecret(verifySecret), p.ProjectID, name, domain, encodeList(allowedOrigins), maxNumber, expireSeconds, algorithm, now, now)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert API key: %w", err)
 	}
 
 	id, _ := result.LastInsertId()
+	if err := insertPrimarySecret(tx, id, hmacSecret, now); err != nil {
+		return nil, fmt.Errorf("failed to insert HMAC secret: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	apiKeysVersion.Add(1)
+
 	return &APIKey{
 		ID:            id,
 		KeyID:         keyID,
 		HMACSecret:    hmacSecret,
+		ProjectID:     p.ProjectID,
 		Name:          name,
 		Domain:        domain,
 		MaxNumber:     maxNumber,
@@ -91,42 +346,41 @@ func CreateAPIKey(db *sql.DB, name, domain string, maxNumber int64, expireSecond
 		Enabled:       true,
 		CreatedAt:     now,
 		UpdatedAt:     now,
+
+		AllowedOrigins:    allowedOrigins,
+		OriginEnforcement: OriginEnforcementLenient,
+
+		CORSExposedHeaders: []string{},
+		CORSMaxAge:         DefaultCORSMaxAge,
+
+		VerifySecret:     verifySecret,
+		HasVerifySecret:  true,
+		verifySecretHash: hashVerifySecret(verifySecret),
+		DifficultyCurve:  DifficultyCurveLinear,
+
+		TimingEnforcement: TimingEnforcementReject,
 	}, nil
 }
 
 func GetAPIKeyByKeyID(db *sql.DB, keyID string) (*APIKey, error) {
-	var k APIKey
-	var enabled int
-	err := db.QueryRow(`
-		SELECT id, key_id, hmac_secret, name, domain, max_number, expire_seconds, algorithm, enabled, created_at, updated_at
-		FROM api_keys WHERE key_id = ?
-	`, keyID).Scan(&k.ID, &k.KeyID, &k.HMACSecret, &k.Name, &k.Domain, &k.MaxNumber, &k.ExpireSeconds, &k.Algorithm, &enabled, &k.CreatedAt, &k.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	k.Enabled = enabled == 1
-	return &k, nil
+	row := db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_id = ?`, keyID)
+	return scanAPIKey(row.Scan)
 }
 
 func GetAPIKeyByID(db *sql.DB, id int64) (*APIKey, error) {
-	var k APIKey
-	var enabled int
-	err := db.QueryRow(`
-		SELECT id, key_id, hmac_secret, name, domain, max_number, expire_seconds, algorithm, enabled, created_at, updated_at
-		FROM api_keys WHERE id = ?
-	`, id).Scan(&k.ID, &k.KeyID, &k.HMACSecret, &k.Name, &k.Domain, &k.MaxNumber, &k.ExpireSeconds, &k.Algorithm, &enabled, &k.CreatedAt, &k.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	k.Enabled = enabled == 1
-	return &k, nil
+	row := db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id)
+	return scanAPIKey(row.Scan)
 }
 
 func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
-	rows, err := db.Query(`
-		SELECT id, key_id, hmac_secret, name, domain, max_number, expire_seconds, algorithm, enabled, created_at, updated_at
-		FROM api_keys ORDER BY created_at DESC
-	`)
+	return ListAPIKeysInScope(db, AllProjects)
+}
+
+// ListAPIKeysInScope returns the keys of the projects in scope, newest
+// first.
+func ListAPIKeysInScope(db *sql.DB, scope ProjectScope) ([]APIKey, error) {
+	cond, args := scope.where("project_id")
+	rows, err := db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE `+cond+` ORDER BY created_at DESC`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -134,44 +388,124 @@ func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
 
 	var keys []APIKey
 	for rows.Next() {
-		var k APIKey
-		var enabled int
-		if err := rows.Scan(&k.ID, &k.KeyID, &k.HMACSecret, &k.Name, &k.Domain, &k.MaxNumber, &k.ExpireSeconds, &k.Algorithm, &enabled, &k.CreatedAt, &k.UpdatedAt); err != nil {
+		k, err := scanAPIKey(rows.Scan)
+		if err != nil {
 			return nil, err
 		}
-		k.Enabled = enabled == 1
-		keys = append(keys, k)
+		keys = append(keys, *k)
 	}
 	return keys, nil
 }
 
 func UpdateAPIKey(db *sql.DB, id int64, params UpdateAPIKeyParams) error {
-	enabledInt := 0
-	if params.Enabled {
-		enabledInt = 1
+	if params.DifficultyCurve == "" {
+		params.DifficultyCurve = DifficultyCurveLinear
+	}
+	if params.OriginEnforcement == "" {
+		params.OriginEnforcement = OriginEnforcementLenient
+	}
+	if params.TimingEnforcement == "" {
+		params.TimingEnforcement = TimingEnforcementReject
 	}
 	now := time.Now().UTC().Format(time.RFC3339)
 	_, err := db.Exec(`
-		UPDATE api_keys SET name = ?, domain = ?, max_number = ?, expire_seconds = ?, algorithm = ?, enabled = ?, updated_at = ?
+		UPDATE api_keys SET name = ?, domain = ?, allowed_origins = ?, max_number = ?, expire_seconds = ?, algorithm = ?, enabled = ?, updated_at = ?,
+			adaptive_difficulty = ?, difficulty_min = ?, difficulty_max = ?, difficulty_curve = ?, origin_enforcement = ?,
+			cors_exposed_headers = ?, cors_allow_credentials = ?, cors_max_age = ?, project_id = ?,
+			min_solve_ms = ?, min_challenge_age_ms = ?, max_challenge_age_seconds = ?, timing_enforcement = ?
 		WHERE id = ?
-	`, params.Name, params.Domain, params.MaxNumber, params.ExpireSeconds, params.Algorithm, enabledInt, now, id)
-	return err
+	`, params.Name, params.Domain, encodeList(params.AllowedOrigins), params.MaxNumber, params.ExpireSeconds, params.Algorithm, boolToInt(params.Enabled), now,
+		boolToInt(params.AdaptiveDifficulty), params.DifficultyMin, params.DifficultyMax, params.DifficultyCurve, params.OriginEnforcement,
+		encodeList(params.CORSExposedHeaders), boolToInt(params.CORSAllowCredentials), params.CORSMaxAge, params.ProjectID,
+		params.MinSolveMS, params.MinChallengeAgeMS, params.MaxChallengeAgeSeconds, params.TimingEnforcement, id)
+	if err != nil {
+		return err
+	}
+	apiKeysVersion.Add(1)
+	return nil
 }
 
+// DeleteAPIKey deletes the key. Its statistics are rolled up into months
+// first, so they keep counting towards the totals.
 func DeleteAPIKey(db *sql.DB, id int64) error {
-	_, err := db.Exec(`DELETE FROM api_keys WHERE id = ?`, id)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := rollUpDailyStats(tx, "9999-12-31", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM api_keys WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	apiKeysVersion.Add(1)
+	return nil
 }
 
-func RotateHMACSecret(db *sql.DB, id int64) (string, error) {
-	secret, err := GenerateHMACSecret()
+// RegenerateVerifySecret replaces the key's verify secret. The old secret
+// stops working immediately. Keys without a verify secret get their first.
+func RegenerateVerifySecret(db *sql.DB, id int64) (string, error) {
+	secret, err := GenerateVerifySecret()
 	if err != nil {
 		return "", err
 	}
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err = db.Exec(`UPDATE api_keys SET hmac_secret = ?, updated_at = ? WHERE id = ?`, secret, now, id)
+	result, err := db.Exec(`UPDATE api_keys SET verify_secret_hash = ?, updated_at = ? WHERE id = ?`, hashVerifySecret(secret), now, id)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return secret, nil
+}
+
+// RotateHMACSecret makes a new secret primary. The old primary secret keeps
+// verifying solutions for grace; a zero grace retires it immediately.
+func RotateHMACSecret(db *sql.DB, id int64, grace time.Duration) (string, error) {
+	secret, err := GenerateHMACSecret()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	nowStr := now.Format(time.RFC3339)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE api_keys SET hmac_secret = ?, updated_at = ? WHERE id = ?`, secret, nowStr, id)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	if grace > 0 {
+		_, err = tx.Exec(`
+			UPDATE api_key_secrets SET status = ?, expires_at = ? WHERE api_key_id = ? AND status = ?
+		`, SecretStatusPrevious, now.Add(grace).Format(time.RFC3339), id, SecretStatusPrimary)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE api_key_secrets SET status = ?, retired_at = ? WHERE api_key_id = ? AND status = ?
+		`, SecretStatusRetired, nowStr, id, SecretStatusPrimary)
+	}
 	if err != nil {
 		return "", err
 	}
+	if err := insertPrimarySecret(tx, id, secret, nowStr); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return secret, nil
 }
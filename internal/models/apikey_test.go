@@ -86,6 +86,25 @@ func TestCreateAPIKey_Defaults(t *testing.T) {
 	}
 }
 
+func TestCreateAPIKeyWithParams(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	p, _ := CreateProject(db, "Acme", "")
+
+	key, err := CreateAPIKeyWithParams(db, CreateAPIKeyParams{
+		ProjectID:      p.ID,
+		Name:           "Test",
+		AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKeyWithParams failed: %v", err)
+	}
+
+	stored, _ := GetAPIKeyByID(db, key.ID)
+	if stored.ProjectID != p.ID || len(stored.AllowedOrigins) != 2 || stored.Domain != "https://a.example.com" {
+		t.Errorf("expected project and origins to be stored with the key, got %+v", stored)
+	}
+}
+
 func TestGetAPIKeyByKeyID(t *testing.T) {
 	db := testutil.SetupTestDB(t)
 	created, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
@@ -210,7 +229,7 @@ func TestRotateHMACSecret(t *testing.T) {
 	created, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
 	oldSecret := created.HMACSecret
 
-	newSecret, err := RotateHMACSecret(db, created.ID)
+	newSecret, err := RotateHMACSecret(db, created.ID, 0)
 	if err != nil {
 		t.Fatalf("RotateHMACSecret failed: %v", err)
 	}
@@ -223,3 +242,35 @@ func TestRotateHMACSecret(t *testing.T) {
 		t.Error("expected stored secret to match returned secret")
 	}
 }
+
+func TestVerifySecret(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	created, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	if !strings.HasPrefix(created.VerifySecret, "gs_") {
+		t.Fatalf("expected gs_ prefix, got %q", created.VerifySecret)
+	}
+
+	loaded, _ := GetAPIKeyByID(db, created.ID)
+	if loaded.VerifySecret != "" {
+		t.Error("expected plaintext secret not to be stored")
+	}
+	if !loaded.HasVerifySecret || !loaded.CheckVerifySecret(created.VerifySecret) {
+		t.Error("expected stored hash to match the secret")
+	}
+	if loaded.CheckVerifySecret("gs_wrong") || loaded.CheckVerifySecret("") {
+		t.Error("expected wrong secrets to be rejected")
+	}
+
+	newSecret, err := RegenerateVerifySecret(db, created.ID)
+	if err != nil {
+		t.Fatalf("RegenerateVerifySecret failed: %v", err)
+	}
+	loaded, _ = GetAPIKeyByID(db, created.ID)
+	if loaded.CheckVerifySecret(created.VerifySecret) || !loaded.CheckVerifySecret(newSecret) {
+		t.Error("expected only the regenerated secret to match")
+	}
+
+	if _, err := RegenerateVerifySecret(db, 99999); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditLogin            = "login"
+	AuditLoginOIDC        = "login.oidc"
+	AuditLogout           = "logout"
+	AuditSessionRevoke    = "session.revoke"
+	AuditLockoutClear     = "lockout.clear"
+	AuditTokenCreate      = "token.create"
+	AuditTokenRevoke      = "token.revoke"
+	AuditPasswordChange   = "password.change"
+	AuditSettingsUpdate   = "settings.update"
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserDelete       = "user.delete"
+	AuditTOTPEnable       = "totp.enable"
+	AuditTOTPDisable      = "totp.disable"
+	AuditRecoveryCodes    = "totp.regenerate_recovery_codes"
+	AuditKeyCreate        = "key.create"
+	AuditKeyUpdate        = "key.update"
+	AuditKeyDelete        = "key.delete"
+	AuditKeyRotateSecret  = "key.rotate_secret"
+	AuditKeyVerifySecret  = "key.regenerate_verify_secret"
+	AuditKeyRetireSecret  = "key.retire_secret"
+	AuditSigningKeyRotate = "signing_key.rotate"
+	AuditProjectCreate    = "project.create"
+	AuditProjectUpdate    = "project.update"
+	AuditProjectDelete    = "project.delete"
+	AuditProjectMembers   = "project.members"
+	AuditWebhookCreate    = "webhook.create"
+	AuditWebhookUpdate    = "webhook.update"
+	AuditWebhookDelete    = "webhook.delete"
+	AuditWebhookResend    = "webhook.resend"
+)
+
+const (
+	auditRedacted        = "[redacted]"
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// redactedAuditFields are never written to the audit log in clear text.
+var redactedAuditFields = map[string]bool{
+	"hmac_secret":   true,
+	"verify_secret": true,
+	"secret":        true,
+	"password":      true,
+	"private_key":   true,
+}
+
+// AuditChange is the before and after value of one changed field.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry records one admin action.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	CreatedAt  string                 `json:"created_at"`
+	Actor      string                 `json:"actor"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   string                 `json:"target_id,omitempty"`
+	IP         string                 `json:"ip"`
+	Success    bool                   `json:"success"`
+	Changes    map[string]AuditChange `json:"changes"`
+}
+
+// AuditFilter narrows ListAuditEntries. Empty fields match everything;
+// From and To are RFC 3339 timestamps.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       string
+	To         string
+	Limit      int
+	Offset     int
+}
+
+// AuditDiff returns the fields that differ between before and after, which
+// may be nil for creations and deletions. Both are compared in their JSON
+// form; secret fields are redacted.
+func AuditDiff(before, after interface{}) map[string]AuditChange {
+	b, a := auditFields(before), auditFields(after)
+	changes := map[string]AuditChange{}
+	for name, old := range b {
+		if cur, ok := a[name]; !ok || !reflect.DeepEqual(old, cur) {
+			changes[name] = AuditChange{Before: old, After: cur}
+		}
+	}
+	for name, cur := range a {
+		if _, ok := b[name]; !ok {
+			changes[name] = AuditChange{After: cur}
+		}
+	}
+	for name, c := range changes {
+		if redactedAuditFields[name] {
+			if c.Before != nil {
+				c.Before = auditRedacted
+			}
+			if c.After != nil {
+				c.After = auditRedacted
+			}
+			changes[name] = c
+		}
+	}
+	return changes
+}
+
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return fields
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+// InsertAuditEntry stores e. CreatedAt defaults to now.
+func InsertAuditEntry(db *sql.DB, e AuditEntry) error {
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if e.Changes == nil {
+		e.Changes = map[string]AuditChange{}
+	}
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO audit_log (created_at, actor, action, target_type, target_id, ip, success, changes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.CreatedAt, e.Actor, e.Action, e.TargetType, e.TargetID, e.IP, boolToInt(e.Success), string(changes))
+	return err
+}
+
+// ListAuditEntries returns one page of matching entries, newest first, and
+// the total number of matches.
+func ListAuditEntries(db *sql.DB, f AuditFilter) ([]AuditEntry, int, error) {
+	if f.Limit <= 0 {
+		f.Limit = defaultAuditPageSize
+	}
+	if f.Limit > maxAuditPageSize {
+		f.Limit = maxAuditPageSize
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	var where []string
+	var args []interface{}
+	for _, c := range []struct {
+		clause, value string
+	}{
+		{"actor = ?", f.Actor},
+		{"action = ?", f.Action},
+		{"target_type = ?", f.TargetType},
+		{"target_id = ?", f.TargetID},
+		{"datetime(created_at) >= datetime(?)", f.From},
+		{"datetime(created_at) <= datetime(?)", f.To},
+	} {
+		if c.value != "" {
+			where = append(where, c.clause)
+			args = append(args, c.value)
+		}
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM audit_log`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, created_at, actor, action, target_type, target_id, ip, success, changes
+		FROM audit_log`+cond+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var success int
+		var changes string
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, &e.IP, &success, &changes); err != nil {
+			return nil, 0, err
+		}
+		e.Success = success == 1
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
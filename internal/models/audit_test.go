@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := map[string]interface{}{"name": "a", "max_number": 100, "hmac_secret": "old"}
+	after := map[string]interface{}{"name": "b", "max_number": 100, "hmac_secret": "new", "enabled": true}
+
+	changes := AuditDiff(before, after)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	if c := changes["name"]; c.Before != "a" || c.After != "b" {
+		t.Errorf("unexpected name change: %+v", c)
+	}
+	if c := changes["hmac_secret"]; c.Before != auditRedacted || c.After != auditRedacted {
+		t.Errorf("expected redacted secret, got %+v", c)
+	}
+	if c := changes["enabled"]; c.Before != nil || c.After != true {
+		t.Errorf("unexpected added field: %+v", c)
+	}
+
+	var key *APIKey
+	if changes := AuditDiff(key, nil); len(changes) != 0 {
+		t.Errorf("expected no changes for nil values, got %+v", changes)
+	}
+}
+
+func TestListAuditEntries_Filter(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	InsertAuditEntry(db, AuditEntry{Actor: "alice", Action: AuditKeyCreate, TargetType: "api_key", TargetID: "1", Success: true, CreatedAt: "2026-01-01T10:00:00Z"})
+	InsertAuditEntry(db, AuditEntry{Actor: "bob", Action: AuditKeyDelete, TargetType: "api_key", TargetID: "1", Success: true, CreatedAt: "2026-01-02T10:00:00Z"})
+	InsertAuditEntry(db, AuditEntry{Actor: "alice", Action: AuditLogin, CreatedAt: "2026-01-03T10:00:00Z"})
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"all", AuditFilter{}, 3},
+		{"actor", AuditFilter{Actor: "alice"}, 2},
+		{"target", AuditFilter{TargetType: "api_key", TargetID: "1"}, 2},
+		{"range", AuditFilter{From: "2026-01-02T00:00:00Z", To: "2026-01-02T23:59:59Z"}, 1},
+	}
+	for _, tt := range tests {
+		entries, total, err := ListAuditEntries(db, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: ListAuditEntries failed: %v", tt.name, err)
+		}
+		if total != tt.want || len(entries) != tt.want {
+			t.Errorf("%s: expected %d entries, got %d (total %d)", tt.name, tt.want, len(entries), total)
+		}
+	}
+}
@@ -22,6 +22,31 @@ func MarkConsumed(db *sql.DB, challenge string, apiKeyID int64, expiresAt time.T
 	return err
 }
 
+// ConsumeChallenge records a challenge as used in a single statement and
+// reports whether it had already been consumed.
+func ConsumeChallenge(db *sql.DB, challenge string, expiresAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO consumed_challenges (challenge, expires_at)
+		VALUES (?, ?)
+	`, challenge, expiresAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted == 0, nil
+}
+
+// CountConsumedChallenges returns the number of consumed challenges kept
+// for replay protection.
+func CountConsumedChallenges(db *sql.DB) (int64, error) {
+	var n int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM consumed_challenges`).Scan(&n)
+	return n, err
+}
+
 func CleanupExpired(db *sql.DB) (int64, error) {
 	result, err := db.Exec(`DELETE FROM consumed_challenges WHERE datetime(expires_at) < datetime('now')`)
 	if err != nil {
@@ -81,3 +81,29 @@ func TestCleanupExpired(t *testing.T) {
 		t.Error("expected valid challenge to remain")
 	}
 }
+
+func TestConsumeChallenge(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	expiresAt := time.Now().Add(5 * time.Minute)
+
+	used, err := ConsumeChallenge(db, "consume-hash", expiresAt)
+	if err != nil {
+		t.Fatalf("ConsumeChallenge failed: %v", err)
+	}
+	if used {
+		t.Error("expected first consume to succeed")
+	}
+
+	used, err = ConsumeChallenge(db, "consume-hash", expiresAt)
+	if err != nil {
+		t.Fatalf("ConsumeChallenge (2nd) failed: %v", err)
+	}
+	if !used {
+		t.Error("expected second consume to report already used")
+	}
+
+	consumed, _ := IsConsumed(db, "consume-hash")
+	if !consumed {
+		t.Error("expected IsConsumed to see the consumed challenge")
+	}
+}
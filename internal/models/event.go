@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Verification event kinds.
+const (
+	EventChallenge = "challenge"
+	EventVerify    = "verify"
+)
+
+// Verification event outcomes. Rejected solutions use the failure reason,
+// such as FailInvalidSolution.
+const (
+	EventIssued = "issued"
+	EventOK     = "ok"
+)
+
+const (
+	defaultEventPageSize = 50
+	maxEventPageSize     = 500
+	maxEventUserAgent    = 512
+)
+
+// VerificationEvent is one sampled challenge or verification request.
+type VerificationEvent struct {
+	ID        int64  `json:"id"`
+	CreatedAt string `json:"created_at"`
+	APIKeyID  int64  `json:"api_key_id"`
+	KeyID     string `json:"key_id"`
+	Kind      string `json:"kind"`
+	Outcome   string `json:"outcome"`
+	// ClientIP is raw or hashed, depending on the configured IP policy.
+	ClientIP  string `json:"client_ip"`
+	Origin    string `json:"origin"`
+	UserAgent string `json:"user_agent"`
+	// Took is the solving time in milliseconds reported by the widget.
+	Took int64 `json:"took,omitempty"`
+}
+
+// EventFilter narrows ListVerificationEvents. Empty fields match
+// everything. Before is the cursor: only events with a smaller ID match.
+type EventFilter struct {
+	Scope    ProjectScope
+	APIKeyID int64
+	Kind     string
+	Outcome  string
+	ClientIP string
+	Origin   string
+	From     time.Time
+	To       time.Time
+	Before   int64
+	Limit    int
+}
+
+// InsertVerificationEvent stores e. CreatedAt defaults to now.
+func InsertVerificationEvent(db *sql.DB, e VerificationEvent) error {
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if len(e.UserAgent) > maxEventUserAgent {
+		e.UserAgent = e.UserAgent[:maxEventUserAgent]
+	}
+	_, err := db.Exec(`
+		INSERT INTO verification_events (created_at, api_key_id, kind, outcome, client_ip, origin, user_agent, took)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.CreatedAt, e.APIKeyID, e.Kind, e.Outcome, e.ClientIP, e.Origin, e.UserAgent, e.Took)
+	return err
+}
+
+// ListVerificationEvents returns one page of matching events, newest
+// first, and the cursor of the next page, or 0 on the last page.
+func ListVerificationEvents(db *sql.DB, f EventFilter) ([]VerificationEvent, int64, error) {
+	if f.Limit <= 0 {
+		f.Limit = defaultEventPageSize
+	}
+	if f.Limit > maxEventPageSize {
+		f.Limit = maxEventPageSize
+	}
+
+	scopeCond, args := f.Scope.where("k.project_id")
+	where := []string{scopeCond}
+	for _, c := range []struct {
+		clause string
+		value  interface{}
+		set    bool
+	}{
+		{"e.api_key_id = ?", f.APIKeyID, f.APIKeyID != 0},
+		{"e.kind = ?", f.Kind, f.Kind != ""},
+		{"e.outcome = ?", f.Outcome, f.Outcome != ""},
+		{"e.client_ip = ?", f.ClientIP, f.ClientIP != ""},
+		{"e.origin = ?", f.Origin, f.Origin != ""},
+		{"e.created_at >= ?", f.From.UTC().Format(time.RFC3339), !f.From.IsZero()},
+		{"e.created_at <= ?", f.To.UTC().Format(time.RFC3339), !f.To.IsZero()},
+		{"e.id < ?", f.Before, f.Before > 0},
+	} {
+		if c.set {
+			where = append(where, c.clause)
+			args = append(args, c.value)
+		}
+	}
+
+	// One extra row tells whether there is a next page.
+	rows, err := db.Query(`
+		SELECT e.id, e.created_at, e.api_key_id, k.key_id, e.kind, e.outcome, e.client_ip, e.origin, e.user_agent, e.took
+		FROM verification_events e
+		JOIN api_keys k ON k.id = e.api_key_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY e.id DESC LIMIT ?`, append(args, f.Limit+1)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []VerificationEvent
+	for rows.Next() {
+		var e VerificationEvent
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.APIKeyID, &e.KeyID, &e.Kind, &e.Outcome, &e.ClientIP, &e.Origin, &e.UserAgent, &e.Took); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if len(events) > f.Limit {
+		events = events[:f.Limit]
+		next = events[len(events)-1].ID
+	}
+	return events, next, nil
+}
+
+// DeleteOldVerificationEvents removes events older than maxAge.
+func DeleteOldVerificationEvents(db *sql.DB, maxAge time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-maxAge).UTC().Format(time.RFC3339)
+	result, err := db.Exec(`DELETE FROM verification_events WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
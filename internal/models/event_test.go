@@ -0,0 +1,93 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestListVerificationEvents(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	other, _ := CreateAPIKey(db, "Other", "", 0, 0, "")
+
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventChallenge, Outcome: EventIssued, Origin: "https://a.example"})
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventVerify, Outcome: FailInvalidSolution, ClientIP: "198.51.100.7"})
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventVerify, Outcome: EventOK, Took: 800})
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: other.ID, Kind: EventVerify, Outcome: EventOK})
+
+	all, next, err := ListVerificationEvents(db, EventFilter{Scope: AllProjects})
+	if err != nil {
+		t.Fatalf("ListVerificationEvents failed: %v", err)
+	}
+	if len(all) != 4 || next != 0 {
+		t.Fatalf("expected 4 events and no next page, got %d (next %d)", len(all), next)
+	}
+	if all[0].APIKeyID != other.ID || all[0].KeyID != other.KeyID {
+		t.Errorf("expected newest event first, got %+v", all[0])
+	}
+
+	for name, tc := range map[string]struct {
+		filter EventFilter
+		want   int
+	}{
+		"key":     {EventFilter{APIKeyID: key.ID}, 3},
+		"kind":    {EventFilter{Kind: EventVerify}, 3},
+		"outcome": {EventFilter{Outcome: FailInvalidSolution}, 1},
+		"ip":      {EventFilter{ClientIP: "198.51.100.7"}, 1},
+		"origin":  {EventFilter{Origin: "https://a.example"}, 1},
+		"from":    {EventFilter{From: time.Now().Add(time.Hour)}, 0},
+		"to":      {EventFilter{To: time.Now().Add(time.Hour)}, 4},
+	} {
+		tc.filter.Scope = AllProjects
+		got, _, _ := ListVerificationEvents(db, tc.filter)
+		if len(got) != tc.want {
+			t.Errorf("%s: expected %d events, got %d", name, tc.want, len(got))
+		}
+	}
+
+	page, next, _ := ListVerificationEvents(db, EventFilter{Scope: AllProjects, Limit: 3})
+	if len(page) != 3 || next != page[2].ID {
+		t.Fatalf("expected 3 events and a cursor, got %d (next %d)", len(page), next)
+	}
+	page, next, _ = ListVerificationEvents(db, EventFilter{Scope: AllProjects, Limit: 3, Before: next})
+	if len(page) != 1 || next != 0 || page[0].ID != all[3].ID {
+		t.Errorf("expected the oldest event on the last page, got %+v (next %d)", page, next)
+	}
+
+	none, _, _ := ListVerificationEvents(db, EventFilter{Scope: ProjectScope{}})
+	if len(none) != 0 {
+		t.Errorf("expected no events for an empty scope, got %d", len(none))
+	}
+}
+
+func TestInsertVerificationEvent_TruncatesUserAgent(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventChallenge, Outcome: EventIssued, UserAgent: strings.Repeat("a", 2000)})
+
+	events, _, _ := ListVerificationEvents(db, EventFilter{Scope: AllProjects})
+	if len(events) != 1 || len(events[0].UserAgent) != maxEventUserAgent {
+		t.Errorf("expected user agent truncated to %d bytes", maxEventUserAgent)
+	}
+}
+
+func TestDeleteOldVerificationEvents(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventVerify, Outcome: EventOK, CreatedAt: old})
+	InsertVerificationEvent(db, VerificationEvent{APIKeyID: key.ID, Kind: EventVerify, Outcome: EventOK})
+
+	deleted, err := DeleteOldVerificationEvents(db, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("DeleteOldVerificationEvents failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted, got %d", deleted)
+	}
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SecretStatusPrimary  = "primary"
+	SecretStatusPrevious = "previous"
+	SecretStatusRetired  = "retired"
+	// SecretStatusExpired is reported for previous secrets whose grace
+	// period has run out but that the cleanup worker has not removed yet.
+	SecretStatusExpired = "expired"
+)
+
+// ErrPrimarySecret is returned when retiring the secret that currently
+// signs challenges. Rotate the key instead.
+var ErrPrimarySecret = errors.New("cannot retire the primary secret")
+
+// APIKeySecret is one entry of an API key's HMAC key ring. The primary
+// secret signs new challenges; previous secrets are still accepted for
+// verification until ExpiresAt, so challenges issued before a rotation
+// can still be solved.
+type APIKeySecret struct {
+	ID        int64  `json:"id"`
+	APIKeyID  int64  `json:"api_key_id"`
+	Secret    string `json:"-"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	RetiredAt string `json:"retired_at,omitempty"`
+}
+
+func insertPrimarySecret(tx *sql.Tx, apiKeyID int64, secret, now string) error {
+	_, err := tx.Exec(`
+		INSERT INTO api_key_secrets (api_key_id, secret, status, created_at)
+		VALUES (?, ?, ?, ?)
+	`, apiKeyID, secret, SecretStatusPrimary, now)
+	return err
+}
+
+// ListAPIKeySecrets returns an API key's key ring, newest first.
+func ListAPIKeySecrets(db *sql.DB, apiKeyID int64) ([]APIKeySecret, error) {
+	rows, err := db.Query(`
+		SELECT id, api_key_id, secret, status, created_at, expires_at, retired_at
+		FROM api_key_secrets WHERE api_key_id = ? ORDER BY id DESC
+	`, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var secrets []APIKeySecret
+	for rows.Next() {
+		var s APIKeySecret
+		if err := rows.Scan(&s.ID, &s.APIKeyID, &s.Secret, &s.Status, &s.CreatedAt, &s.ExpiresAt, &s.RetiredAt); err != nil {
+			return nil, err
+		}
+		if s.Status == SecretStatusPrevious {
+			if expires, err := time.Parse(time.RFC3339, s.ExpiresAt); err == nil && !now.Before(expires) {
+				s.Status = SecretStatusExpired
+			}
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets, rows.Err()
+}
+
+// VerificationSecrets returns every secret a solution for key may be signed
+// with: the primary secret first, then previous secrets still in their
+// grace period.
+func VerificationSecrets(db *sql.DB, key *APIKey) ([]string, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	rows, err := db.Query(`
+		SELECT secret FROM api_key_secrets
+		WHERE api_key_id = ? AND status = ? AND datetime(expires_at) > datetime(?)
+		ORDER BY id DESC
+	`, key.ID, SecretStatusPrevious, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []string{key.HMACSecret}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		if s != key.HMACSecret {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets, rows.Err()
+}
+
+// RetireAPIKeySecret ends a previous secret's grace period immediately.
+// Returns sql.ErrNoRows if the secret does not belong to the key.
+func RetireAPIKeySecret(db *sql.DB, apiKeyID, secretID int64) error {
+	var status string
+	err := db.QueryRow(`SELECT status FROM api_key_secrets WHERE id = ? AND api_key_id = ?`, secretID, apiKeyID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status == SecretStatusPrimary {
+		return ErrPrimarySecret
+	}
+	if status == SecretStatusRetired {
+		return nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = db.Exec(`UPDATE api_key_secrets SET status = ?, retired_at = ? WHERE id = ?`, SecretStatusRetired, now, secretID)
+	if err != nil {
+		return fmt.Errorf("failed to retire secret: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredAPIKeySecrets removes retired secrets and previous secrets
+// whose grace period has ended.
+func DeleteExpiredAPIKeySecrets(db *sql.DB) (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		DELETE FROM api_key_secrets
+		WHERE status = ? OR (status = ? AND datetime(expires_at) <= datetime(?))
+	`, SecretStatusRetired, SecretStatusPrevious, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestCreateAPIKey_PrimarySecret(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	secrets, err := ListAPIKeySecrets(db, key.ID)
+	if err != nil {
+		t.Fatalf("ListAPIKeySecrets failed: %v", err)
+	}
+	if len(secrets) != 1 {
+		t.Fatalf("expected 1 secret, got %d", len(secrets))
+	}
+	if secrets[0].Status != SecretStatusPrimary || secrets[0].Secret != key.HMACSecret {
+		t.Errorf("expected primary secret matching key, got %+v", secrets[0])
+	}
+}
+
+func TestRotateHMACSecret_GracePeriod(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	oldSecret := key.HMACSecret
+
+	newSecret, err := RotateHMACSecret(db, key.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateHMACSecret failed: %v", err)
+	}
+
+	updated, _ := GetAPIKeyByID(db, key.ID)
+	secrets, err := VerificationSecrets(db, updated)
+	if err != nil {
+		t.Fatalf("VerificationSecrets failed: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0] != newSecret || secrets[1] != oldSecret {
+		t.Errorf("expected [new, old] secrets, got %v", secrets)
+	}
+
+	ring, _ := ListAPIKeySecrets(db, key.ID)
+	if len(ring) != 2 || ring[0].Status != SecretStatusPrimary || ring[1].Status != SecretStatusPrevious {
+		t.Fatalf("unexpected key ring: %+v", ring)
+	}
+	if ring[1].ExpiresAt == "" {
+		t.Error("expected previous secret to have an expiry")
+	}
+}
+
+func TestRotateHMACSecret_NoGrace(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	RotateHMACSecret(db, key.ID, 0)
+
+	updated, _ := GetAPIKeyByID(db, key.ID)
+	secrets, _ := VerificationSecrets(db, updated)
+	if len(secrets) != 1 {
+		t.Errorf("expected only the new secret, got %d", len(secrets))
+	}
+	ring, _ := ListAPIKeySecrets(db, key.ID)
+	if len(ring) != 2 || ring[1].Status != SecretStatusRetired {
+		t.Errorf("expected old secret retired, got %+v", ring)
+	}
+}
+
+func TestRotateHMACSecret_NotFound(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	if _, err := RotateHMACSecret(db, 99999, time.Hour); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestRetireAPIKeySecret(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	RotateHMACSecret(db, key.ID, time.Hour)
+
+	ring, _ := ListAPIKeySecrets(db, key.ID)
+	primary, previous := ring[0], ring[1]
+
+	if err := RetireAPIKeySecret(db, key.ID, primary.ID); !errors.Is(err, ErrPrimarySecret) {
+		t.Errorf("expected ErrPrimarySecret, got %v", err)
+	}
+	if err := RetireAPIKeySecret(db, key.ID+1, previous.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for another key, got %v", err)
+	}
+	if err := RetireAPIKeySecret(db, key.ID, previous.ID); err != nil {
+		t.Fatalf("RetireAPIKeySecret failed: %v", err)
+	}
+
+	updated, _ := GetAPIKeyByID(db, key.ID)
+	secrets, _ := VerificationSecrets(db, updated)
+	if len(secrets) != 1 {
+		t.Errorf("expected retired secret to stop verifying, got %d secrets", len(secrets))
+	}
+}
+
+func TestDeleteExpiredAPIKeySecrets(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	RotateHMACSecret(db, key.ID, time.Hour)
+	RotateHMACSecret(db, key.ID, 0)
+
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	db.Exec(`UPDATE api_key_secrets SET expires_at = ? WHERE status = ?`, past, SecretStatusPrevious)
+
+	ring, _ := ListAPIKeySecrets(db, key.ID)
+	if ring[2].Status != SecretStatusExpired {
+		t.Errorf("expected lapsed secret reported as expired, got %s", ring[2].Status)
+	}
+
+	deleted, err := DeleteExpiredAPIKeySecrets(db)
+	if err != nil {
+		t.Fatalf("DeleteExpiredAPIKeySecrets failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted, got %d", deleted)
+	}
+	ring, _ = ListAPIKeySecrets(db, key.ID)
+	if len(ring) != 1 || ring[0].Status != SecretStatusPrimary {
+		t.Errorf("expected only the primary secret left, got %+v", ring)
+	}
+}
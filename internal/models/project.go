@@ -0,0 +1,203 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrProjectNameTaken = errors.New("project name already exists")
+	ErrProjectHasKeys   = errors.New("project still has API keys")
+	ErrProjectHasHooks  = errors.New("project still has webhooks")
+)
+
+// Project groups the API keys of one tenant. Admins who are not owners
+// only see the keys of the projects they are members of.
+type Project struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	KeyCount    int    `json:"key_count"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+const projectColumns = `id, name, description, (SELECT COUNT(*) FROM api_keys WHERE project_id = projects.id), created_at, updated_at`
+
+func scanProject(scan func(dest ...interface{}) error) (*Project, error) {
+	var p Project
+	if err := scan(&p.ID, &p.Name, &p.Description, &p.KeyCount, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ProjectScope limits key and statistics queries to the keys of some
+// projects. Keys outside every project are only in AllProjects.
+type ProjectScope struct {
+	All        bool
+	ProjectIDs []int64
+}
+
+// AllProjects covers every key, with or without a project.
+var AllProjects = ProjectScope{All: true}
+
+// Includes reports whether keys of the project are in scope.
+func (s ProjectScope) Includes(projectID int64) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// where returns an SQL condition on column, which holds a project ID, and
+// its arguments.
+func (s ProjectScope) where(column string) (string, []interface{}) {
+	if s.All {
+		return "1 = 1", nil
+	}
+	if len(s.ProjectIDs) == 0 {
+		return "0 = 1", nil
+	}
+	args := make([]interface{}, len(s.ProjectIDs))
+	for i, id := range s.ProjectIDs {
+		args[i] = id
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(args)-1) + ")", args
+}
+
+func CreateProject(db *sql.DB, name, description string) (*Project, error) {
+	if _, err := GetProjectByName(db, name); err == nil {
+		return nil, ErrProjectNameTaken
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`INSERT INTO projects (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+		name, description, now, now)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetProject(db, id)
+}
+
+func GetProject(db *sql.DB, id int64) (*Project, error) {
+	row := db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = ?`, id)
+	return scanProject(row.Scan)
+}
+
+func GetProjectByName(db *sql.DB, name string) (*Project, error) {
+	row := db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE name = ?`, name)
+	return scanProject(row.Scan)
+}
+
+// ListProjects returns the projects in scope, by name.
+func ListProjects(db *sql.DB, scope ProjectScope) ([]Project, error) {
+	cond, args := scope.where("id")
+	rows, err := db.Query(`SELECT `+projectColumns+` FROM projects WHERE `+cond+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		p, err := scanProject(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, *p)
+	}
+	return projects, rows.Err()
+}
+
+func UpdateProject(db *sql.DB, id int64, name, description string) error {
+	if other, err := GetProjectByName(db, name); err == nil && other.ID != id {
+		return ErrProjectNameTaken
+	}
+	result, err := db.Exec(`UPDATE projects SET name = ?, description = ?, updated_at = ? WHERE id = ?`,
+		name, description, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteProject removes an empty project. Projects that still own keys or
+// webhooks fail with ErrProjectHasKeys or ErrProjectHasHooks; move or
+// delete them first.
+func DeleteProject(db *sql.DB, id int64) error {
+	p, err := GetProject(db, id)
+	if err != nil {
+		return err
+	}
+	if p.KeyCount > 0 {
+		return ErrProjectHasKeys
+	}
+	var hooks int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM webhooks WHERE project_id = ?`, id).Scan(&hooks); err != nil {
+		return err
+	}
+	if hooks > 0 {
+		return ErrProjectHasHooks
+	}
+	_, err = db.Exec(`DELETE FROM projects WHERE id = ?`, id)
+	return err
+}
+
+// ListProjectMembers returns the IDs of the admin users in the project.
+func ListProjectMembers(db *sql.DB, projectID int64) ([]int64, error) {
+	return queryIDs(db, `SELECT user_id FROM project_members WHERE project_id = ? ORDER BY user_id`, projectID)
+}
+
+// UserProjectIDs returns the IDs of the projects the admin user is a
+// member of.
+func UserProjectIDs(db *sql.DB, userID int64) ([]int64, error) {
+	return queryIDs(db, `SELECT project_id FROM project_members WHERE user_id = ? ORDER BY project_id`, userID)
+}
+
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetProjectMembers replaces the project's members.
+func SetProjectMembers(db *sql.DB, projectID int64, userIDs []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM project_members WHERE project_id = ?`, projectID); err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO project_members (project_id, user_id) VALUES (?, ?)`, projectID, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func moveKeyToProject(t *testing.T, db *sql.DB, key *APIKey, projectID int64) {
+	t.Helper()
+	params := key.UpdateParams()
+	params.ProjectID = projectID
+	if err := UpdateAPIKey(db, key.ID, params); err != nil {
+		t.Fatalf("UpdateAPIKey failed: %v", err)
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	p, err := CreateProject(db, "Acme", "Acme Corp sites")
+	if err != nil {
+		t.Fatalf("CreateProject failed: %v", err)
+	}
+	if p.ID == 0 || p.Name != "Acme" || p.Description != "Acme Corp sites" {
+		t.Errorf("unexpected project: %+v", p)
+	}
+	if p.CreatedAt == "" || p.UpdatedAt == "" {
+		t.Error("expected timestamps to be set")
+	}
+
+	if _, err := CreateProject(db, "Acme", ""); !errors.Is(err, ErrProjectNameTaken) {
+		t.Errorf("expected ErrProjectNameTaken, got %v", err)
+	}
+}
+
+func TestUpdateProject(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	a, _ := CreateProject(db, "A", "")
+	CreateProject(db, "B", "")
+
+	if err := UpdateProject(db, a.ID, "Renamed", "desc"); err != nil {
+		t.Fatalf("UpdateProject failed: %v", err)
+	}
+	got, _ := GetProject(db, a.ID)
+	if got.Name != "Renamed" || got.Description != "desc" {
+		t.Errorf("unexpected project after update: %+v", got)
+	}
+
+	if err := UpdateProject(db, a.ID, "B", ""); !errors.Is(err, ErrProjectNameTaken) {
+		t.Errorf("expected ErrProjectNameTaken, got %v", err)
+	}
+	if err := UpdateProject(db, 99999, "C", ""); err == nil {
+		t.Error("expected error for nonexistent project")
+	}
+}
+
+func TestDeleteProject(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	p, _ := CreateProject(db, "Acme", "")
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	moveKeyToProject(t, db, key, p.ID)
+
+	if err := DeleteProject(db, p.ID); !errors.Is(err, ErrProjectHasKeys) {
+		t.Fatalf("expected ErrProjectHasKeys, got %v", err)
+	}
+
+	DeleteAPIKey(db, key.ID)
+	wh, _ := CreateWebhook(db, p.ID, "https://hooks.example.com", []string{EventKeyCreated})
+	if err := DeleteProject(db, p.ID); !errors.Is(err, ErrProjectHasHooks) {
+		t.Fatalf("expected ErrProjectHasHooks, got %v", err)
+	}
+
+	DeleteWebhook(db, wh.ID)
+	if err := DeleteProject(db, p.ID); err != nil {
+		t.Fatalf("DeleteProject failed: %v", err)
+	}
+	if _, err := GetProject(db, p.ID); err == nil {
+		t.Error("expected project to be deleted")
+	}
+}
+
+func TestListProjects_Scope(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	CreateProject(db, "A", "")
+	b, _ := CreateProject(db, "B", "")
+
+	all, err := ListProjects(db, AllProjects)
+	if err != nil {
+		t.Fatalf("ListProjects failed: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expected 2 projects, got %d", len(all))
+	}
+
+	scoped, _ := ListProjects(db, ProjectScope{ProjectIDs: []int64{b.ID}})
+	if len(scoped) != 1 || scoped[0].ID != b.ID {
+		t.Errorf("expected only project B, got %+v", scoped)
+	}
+
+	none, _ := ListProjects(db, ProjectScope{})
+	if len(none) != 0 {
+		t.Errorf("expected no projects for empty scope, got %d", len(none))
+	}
+}
+
+func TestProjectMembers(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	a, _ := CreateProject(db, "A", "")
+	b, _ := CreateProject(db, "B", "")
+	db.Exec(`INSERT INTO admin_users (id, username, password_hash, role, created_at, updated_at) VALUES (1, 'alice', '', 'viewer', '', ''), (2, 'bob', '', 'viewer', '', '')`)
+
+	if err := SetProjectMembers(db, a.ID, []int64{1, 2, 2}); err != nil {
+		t.Fatalf("SetProjectMembers failed: %v", err)
+	}
+	SetProjectMembers(db, b.ID, []int64{1})
+
+	members, err := ListProjectMembers(db, a.ID)
+	if err != nil {
+		t.Fatalf("ListProjectMembers failed: %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("expected 2 members, got %v", members)
+	}
+
+	ids, _ := UserProjectIDs(db, 1)
+	if len(ids) != 2 {
+		t.Errorf("expected alice in 2 projects, got %v", ids)
+	}
+
+	SetProjectMembers(db, a.ID, []int64{})
+	ids, _ = UserProjectIDs(db, 2)
+	if len(ids) != 0 {
+		t.Errorf("expected bob in no projects, got %v", ids)
+	}
+}
+
+func TestListAPIKeysInScope(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	p, _ := CreateProject(db, "Acme", "")
+	inProject, _ := CreateAPIKey(db, "In project", "", 0, 0, "")
+	CreateAPIKey(db, "Unassigned", "", 0, 0, "")
+	moveKeyToProject(t, db, inProject, p.ID)
+
+	keys, err := ListAPIKeysInScope(db, ProjectScope{ProjectIDs: []int64{p.ID}})
+	if err != nil {
+		t.Fatalf("ListAPIKeysInScope failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != inProject.ID || keys[0].ProjectID != p.ID {
+		t.Errorf("expected only the project's key, got %+v", keys)
+	}
+
+	all, _ := ListAPIKeysInScope(db, AllProjects)
+	if len(all) != 2 {
+		t.Errorf("expected 2 keys, got %d", len(all))
+	}
+}
+
+func TestGetStatsOverview_Scope(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	p, _ := CreateProject(db, "Acme", "")
+	inProject, _ := CreateAPIKey(db, "In project", "", 0, 0, "")
+	other, _ := CreateAPIKey(db, "Other", "", 0, 0, "")
+	moveKeyToProject(t, db, inProject, p.ID)
+
+	IncrementChallengesIssued(db, inProject.ID)
+	IncrementChallengesIssued(db, other.ID)
+	IncrementChallengesIssued(db, other.ID)
+
+	overview, err := GetStatsOverview(db, 30, ProjectScope{ProjectIDs: []int64{p.ID}})
+	if err != nil {
+		t.Fatalf("GetStatsOverview failed: %v", err)
+	}
+	if overview.TotalChallenges != 1 {
+		t.Errorf("expected 1 challenge in project, got %d", overview.TotalChallenges)
+	}
+	if overview.ActiveKeys != 1 {
+		t.Errorf("expected 1 active key in project, got %d", overview.ActiveKeys)
+	}
+
+	summary, _ := GetAllKeysStatsSummary(db, ProjectScope{ProjectIDs: []int64{p.ID}})
+	if len(summary) != 1 {
+		t.Errorf("expected 1 key summary in project, got %d", len(summary))
+	}
+}
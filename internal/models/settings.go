@@ -1,7 +1,9 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"strconv"
 	"time"
@@ -10,6 +12,7 @@ import (
 const (
 	SettingLoginCaptchaEnabled  = "login_captcha_enabled"
 	SettingLoginCaptchaAPIKeyID = "login_captcha_api_key_id"
+	SettingEventIPHashKey       = "event_ip_hash_key"
 )
 
 // GetSetting retrieves a single setting value by key.
@@ -40,6 +43,22 @@ func GetLoginCaptchaEnabled(db *sql.DB) (bool, error) {
 	return v == "true", err
 }
 
+// EnsureEventIPHashKey returns the key that hashes client IPs in the event
+// log, generating it on first use. It is kept in the database so hashes
+// stay comparable across restarts, whatever the secret key.
+func EnsureEventIPHashKey(db *sql.DB) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES (?, ?, ?)`,
+		SettingEventIPHashKey, hex.EncodeToString(b), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	return GetSetting(db, SettingEventIPHashKey)
+}
+
 // EnsureLoginCaptchaAPIKey returns the existing login CAPTCHA API key,
 // or creates a dedicated one if none exists yet.
 func EnsureLoginCaptchaAPIKey(db *sql.DB) (*APIKey, error) {
@@ -105,3 +105,15 @@ func TestEnsureLoginCaptchaAPIKey_RecreatesAfterDeletion(t *testing.T) {
 		t.Error("expected a new key after deletion")
 	}
 }
+
+func TestEnsureEventIPHashKey(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	key, err := EnsureEventIPHashKey(db)
+	if err != nil || len(key) != 64 {
+		t.Fatalf("expected a 32 byte hex key, got %q (%v)", key, err)
+	}
+	if again, _ := EnsureEventIPHashKey(db); again != key {
+		t.Errorf("expected the stored key to be kept, got %q then %q", key, again)
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SigningKeyStatusPending = "pending"
+	SigningKeyStatusActive  = "active"
+	SigningKeyStatusRetired = "retired"
+
+	signingKeyAlgorithm = "EdDSA"
+)
+
+// SigningKeyPublishDelay is how long a pending key is published in the
+// JWKS before it starts signing tokens. It must be at least the JWKS cache
+// lifetime, so verifiers know the key by the time they see its tokens.
+const SigningKeyPublishDelay = 5 * time.Minute
+
+// SigningKey is an Ed25519 key pair used to sign verification tokens.
+// Only one key is active at a time. A rotation first publishes the next key
+// as pending; retired keys stay published in the JWKS until every token
+// they signed has expired.
+type SigningKey struct {
+	ID         int64              `json:"id"`
+	KID        string             `json:"kid"`
+	Algorithm  string             `json:"algorithm"`
+	Status     string             `json:"status"`
+	CreatedAt  string             `json:"created_at"`
+	RetiredAt  string             `json:"retired_at,omitempty"`
+	PublicKey  ed25519.PublicKey  `json:"-"`
+	PrivateKey ed25519.PrivateKey `json:"-"`
+}
+
+func generateKID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sk_" + hex.EncodeToString(b), nil
+}
+
+func scanSigningKey(scan func(dest ...interface{}) error) (*SigningKey, error) {
+	var k SigningKey
+	var pub, priv string
+	if err := scan(&k.ID, &k.KID, &k.Algorithm, &pub, &priv, &k.Status, &k.CreatedAt, &k.RetiredAt); err != nil {
+		return nil, err
+	}
+	pubBytes, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key for %s: %w", k.KID, err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(priv)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid private key for %s", k.KID)
+	}
+	k.PublicKey = ed25519.PublicKey(pubBytes)
+	k.PrivateKey = ed25519.NewKeyFromSeed(seed)
+	return &k, nil
+}
+
+const signingKeyColumns = `id, kid, algorithm, public_key, private_key, status, created_at, retired_at`
+
+// RotateSigningKey publishes a new pending signing key, which
+// PromoteSigningKey makes active once SigningKeyPublishDelay has passed. A
+// key already pending is returned as is. Without an active key, the new
+// key is active right away: no verifier can hold tokens it has not seen.
+func RotateSigningKey(db *sql.DB) (*SigningKey, error) {
+	row := db.QueryRow(`SELECT `+signingKeyColumns+` FROM signing_keys WHERE status = ? ORDER BY id DESC LIMIT 1`, SigningKeyStatusPending)
+	if key, err := scanSigningKey(row.Scan); err == nil {
+		return key, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	status := SigningKeyStatusPending
+	if _, err := GetActiveSigningKey(db); errors.Is(err, sql.ErrNoRows) {
+		status = SigningKeyStatusActive
+	} else if err != nil {
+		return nil, err
+	}
+	return insertSigningKey(db, status)
+}
+
+func insertSigningKey(db *sql.DB, status string) (*SigningKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	kid, err := generateKID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		INSERT INTO signing_keys (kid, algorithm, public_key, private_key, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, kid, signingKeyAlgorithm,
+		base64.StdEncoding.EncodeToString(pub),
+		base64.StdEncoding.EncodeToString(priv.Seed()),
+		status, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert signing key: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &SigningKey{
+		ID:         id,
+		KID:        kid,
+		Algorithm:  signingKeyAlgorithm,
+		Status:     status,
+		CreatedAt:  now,
+		PublicKey:  pub,
+		PrivateKey: priv,
+	}, nil
+}
+
+// GetActiveSigningKey returns the key currently used to sign tokens.
+// Returns sql.ErrNoRows if no key has been generated yet.
+func GetActiveSigningKey(db *sql.DB) (*SigningKey, error) {
+	row := db.QueryRow(`SELECT `+signingKeyColumns+` FROM signing_keys WHERE status = ? ORDER BY id DESC LIMIT 1`, SigningKeyStatusActive)
+	return scanSigningKey(row.Scan)
+}
+
+// EnsureSigningKey returns the active signing key, generating one on first use.
+func EnsureSigningKey(db *sql.DB) (*SigningKey, error) {
+	key, err := GetActiveSigningKey(db)
+	if errors.Is(err, sql.ErrNoRows) {
+		return insertSigningKey(db, SigningKeyStatusActive)
+	}
+	return key, err
+}
+
+// PromoteSigningKey makes the pending key active and retires the previous
+// one, once the pending key has been published for at least delay. It
+// reports whether a key was promoted.
+func PromoteSigningKey(db *sql.DB, delay time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		SELECT id FROM signing_keys WHERE status = ? AND datetime(created_at) <= datetime(?) ORDER BY id LIMIT 1
+	`, SigningKeyStatusPending, now.Add(-delay).Format(time.RFC3339)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE signing_keys SET status = ?, retired_at = ? WHERE status = ?
+	`, SigningKeyStatusRetired, now.Format(time.RFC3339), SigningKeyStatusActive); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE signing_keys SET status = ? WHERE id = ?`, SigningKeyStatusActive, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ListSigningKeys returns the pending key, the active key and every
+// retired key still kept for validation, newest first.
+func ListSigningKeys(db *sql.DB) ([]SigningKey, error) {
+	rows, err := db.Query(`SELECT ` + signingKeyColumns + ` FROM signing_keys ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		k, err := scanSigningKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKeyIfOlder publishes a pending key when the active key is
+// older than maxAge and no rotation is pending yet. A maxAge of zero
+// disables automatic rotation.
+func RotateSigningKeyIfOlder(db *sql.DB, maxAge time.Duration) (bool, error) {
+	if maxAge <= 0 {
+		return false, nil
+	}
+	key, err := GetActiveSigningKey(db)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	created, err := time.Parse(time.RFC3339, key.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("invalid created_at for %s: %w", key.KID, err)
+	}
+	if time.Since(created) < maxAge {
+		return false, nil
+	}
+	var pending int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM signing_keys WHERE status = ?`, SigningKeyStatusPending).Scan(&pending); err != nil || pending > 0 {
+		return false, err
+	}
+	if _, err := insertSigningKey(db, SigningKeyStatusPending); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteRetiredSigningKeys removes retired keys that were retired more than
+// `after` ago, i.e. once no token they signed can still be valid.
+func DeleteRetiredSigningKeys(db *sql.DB, after time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-after).UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		DELETE FROM signing_keys WHERE status = ? AND datetime(retired_at) < datetime(?)
+	`, SigningKeyStatusRetired, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
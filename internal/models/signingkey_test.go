@@ -0,0 +1,157 @@
+package models
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestEnsureSigningKey(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	key, err := EnsureSigningKey(db)
+	if err != nil {
+		t.Fatalf("EnsureSigningKey failed: %v", err)
+	}
+	if key.Status != SigningKeyStatusActive {
+		t.Errorf("expected active key, got %s", key.Status)
+	}
+
+	again, err := EnsureSigningKey(db)
+	if err != nil {
+		t.Fatalf("EnsureSigningKey (2nd) failed: %v", err)
+	}
+	if again.KID != key.KID {
+		t.Errorf("expected same key, got %s and %s", key.KID, again.KID)
+	}
+	if !again.PublicKey.Equal(key.PublicKey) {
+		t.Error("expected public key to round-trip through the database")
+	}
+
+	sig := ed25519.Sign(again.PrivateKey, []byte("msg"))
+	if !ed25519.Verify(key.PublicKey, []byte("msg"), sig) {
+		t.Error("expected stored private key to match public key")
+	}
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	first, _ := EnsureSigningKey(db)
+	second, err := RotateSigningKey(db)
+	if err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+	if second.Status != SigningKeyStatusPending {
+		t.Errorf("expected the new key to be pending, got %s", second.Status)
+	}
+	if again, _ := RotateSigningKey(db); again.KID != second.KID {
+		t.Errorf("expected the pending key %s to be reused, got %s", second.KID, again.KID)
+	}
+	if active, _ := GetActiveSigningKey(db); active.KID != first.KID {
+		t.Errorf("expected %s to stay active until promoted, got %s", first.KID, active.KID)
+	}
+
+	promoted, err := PromoteSigningKey(db, time.Hour)
+	if err != nil {
+		t.Fatalf("PromoteSigningKey failed: %v", err)
+	}
+	if promoted {
+		t.Error("expected a freshly published key not to be promoted")
+	}
+
+	old := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	db.Exec(`UPDATE signing_keys SET created_at = ? WHERE id = ?`, old, second.ID)
+	if promoted, _ := PromoteSigningKey(db, time.Hour); !promoted {
+		t.Fatal("expected the pending key to be promoted")
+	}
+	if active, _ := GetActiveSigningKey(db); active.KID != second.KID {
+		t.Errorf("expected %s to be active, got %s", second.KID, active.KID)
+	}
+
+	keys, err := ListSigningKeys(db)
+	if err != nil {
+		t.Fatalf("ListSigningKeys failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	for _, k := range keys {
+		if k.KID == first.KID && (k.Status != SigningKeyStatusRetired || k.RetiredAt == "") {
+			t.Errorf("expected previous key to be retired, got %+v", k)
+		}
+	}
+}
+
+func TestRotateSigningKey_First(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	key, err := RotateSigningKey(db)
+	if err != nil {
+		t.Fatalf("RotateSigningKey failed: %v", err)
+	}
+	if key.Status != SigningKeyStatusActive {
+		t.Errorf("expected the first key to be active right away, got %s", key.Status)
+	}
+}
+
+func TestRotateSigningKeyIfOlder(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := EnsureSigningKey(db)
+
+	rotated, err := RotateSigningKeyIfOlder(db, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateSigningKeyIfOlder failed: %v", err)
+	}
+	if rotated {
+		t.Error("expected fresh key not to be rotated")
+	}
+
+	old := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	db.Exec(`UPDATE signing_keys SET created_at = ? WHERE id = ?`, old, key.ID)
+
+	rotated, _ = RotateSigningKeyIfOlder(db, time.Hour)
+	if !rotated {
+		t.Error("expected old key to be rotated")
+	}
+	rotated, _ = RotateSigningKeyIfOlder(db, time.Hour)
+	if rotated {
+		t.Error("expected no second pending key")
+	}
+	if active, _ := GetActiveSigningKey(db); active.KID != key.KID {
+		t.Errorf("expected %s to stay active until promoted, got %s", key.KID, active.KID)
+	}
+
+	rotated, _ = RotateSigningKeyIfOlder(db, 0)
+	if rotated {
+		t.Error("expected zero max age to disable rotation")
+	}
+}
+
+func TestDeleteRetiredSigningKeys(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	EnsureSigningKey(db)
+	RotateSigningKey(db)
+	PromoteSigningKey(db, 0)
+
+	deleted, err := DeleteRetiredSigningKeys(db, time.Hour)
+	if err != nil {
+		t.Fatalf("DeleteRetiredSigningKeys failed: %v", err)
+	}
+	if deleted != 0 {
+		t.Errorf("expected recently retired key to be kept, deleted %d", deleted)
+	}
+
+	old := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	db.Exec(`UPDATE signing_keys SET retired_at = ? WHERE status = ?`, old, SigningKeyStatusRetired)
+
+	deleted, _ = DeleteRetiredSigningKeys(db, time.Hour)
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted, got %d", deleted)
+	}
+	if _, err := GetActiveSigningKey(db); err != nil {
+		t.Errorf("expected active key to remain: %v", err)
+	}
+}
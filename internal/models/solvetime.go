@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+)
+
+// SolveTimeBuckets are the upper bounds, in milliseconds, of the solve time
+// histogram. Slower solves fall into an overflow bucket.
+var SolveTimeBuckets = []int64{50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 15000, 30000, 60000}
+
+// solveTimeOverflow is the le_ms of the overflow bucket in solve_times.
+const solveTimeOverflow = 0
+
+// SolveTimeBucket counts solves up to LeMS milliseconds (and above the
+// previous bucket). LeMS is nil for the overflow bucket.
+type SolveTimeBucket struct {
+	LeMS  *int64 `json:"le_ms"`
+	Count int64  `json:"count"`
+}
+
+// SolveTimeDay summarises one day's solve times.
+type SolveTimeDay struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+	P50MS int64  `json:"p50_ms"`
+	P90MS int64  `json:"p90_ms"`
+	P99MS int64  `json:"p99_ms"`
+}
+
+// SolveTimeEstimate predicts solve times at a MaxNumber from the hash rate
+// the widgets reported. The number a solver must find is uniform between 0
+// and MaxNumber, so the mean is half the time to search the full range.
+type SolveTimeEstimate struct {
+	MaxNumber int64 `json:"max_number"`
+	// HashRate is the numbers searched per second.
+	HashRate float64 `json:"hash_rate"`
+	MeanMS   int64   `json:"mean_ms"`
+	P90MS    int64   `json:"p90_ms"`
+	MaxMS    int64   `json:"max_ms"`
+}
+
+// SolveTimeStats summarises the solve times the widgets reported for a
+// key's successful verifications. Percentiles are interpolated within
+// histogram buckets. Solves without a reported time only measure the time
+// since the challenge was issued, so they are counted apart in
+// FallbackCount and FallbackMeanMS.
+type SolveTimeStats struct {
+	Count          int64              `json:"count"`
+	MeanMS         int64              `json:"mean_ms"`
+	P50MS          int64              `json:"p50_ms"`
+	P90MS          int64              `json:"p90_ms"`
+	P99MS          int64              `json:"p99_ms"`
+	Histogram      []SolveTimeBucket  `json:"histogram"`
+	Daily          []SolveTimeDay     `json:"daily"`
+	Estimate       *SolveTimeEstimate `json:"estimate"`
+	FallbackCount  int64              `json:"fallback_count"`
+	FallbackMeanMS int64              `json:"fallback_mean_ms"`
+
+	// Work the widgets reported: numbers searched and the milliseconds
+	// it took. Solves without a reported time are left out.
+	reportedNumbers int64
+	reportedMS      int64
+}
+
+// solveTimeBucket returns the le_ms of the bucket ms falls into.
+func solveTimeBucket(ms int64) int64 {
+	if i := sort.Search(len(SolveTimeBuckets), func(i int) bool { return SolveTimeBuckets[i] >= ms }); i < len(SolveTimeBuckets) {
+		return SolveTimeBuckets[i]
+	}
+	return solveTimeOverflow
+}
+
+// RecordSolveTime adds a successful verification that took ms to the key's
+// histogram for today. reported says the widget measured ms while
+// searching up to number; otherwise ms is the time since the challenge was
+// issued, which is only counted as a fallback, apart from the histogram
+// and the hash rate.
+func RecordSolveTime(db *sql.DB, apiKeyID, ms, number int64, reported bool) error {
+	if ms < 0 {
+		ms = 0
+	}
+	var count, sumMS, numbers, fallbackCount, fallbackMS int64
+	if reported {
+		count, sumMS, numbers = 1, ms, number
+	} else {
+		fallbackCount, fallbackMS = 1, ms
+	}
+	_, err := db.Exec(`
+		INSERT INTO solve_times (api_key_id, date, le_ms, count, sum_ms, reported_numbers, reported_ms, fallback_count, fallback_sum_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(api_key_id, date, le_ms) DO UPDATE SET
+			count = count + excluded.count,
+			sum_ms = sum_ms + excluded.sum_ms,
+			reported_numbers = reported_numbers + excluded.reported_numbers,
+			reported_ms = reported_ms + excluded.reported_ms,
+			fallback_count = fallback_count + excluded.fallback_count,
+			fallback_sum_ms = fallback_sum_ms + excluded.fallback_sum_ms
+	`, apiKeyID, time.Now().UTC().Format(dateFormatYMD), solveTimeBucket(ms), count, sumMS, numbers, sumMS, fallbackCount, fallbackMS)
+	return err
+}
+
+// GetSolveTimeStats returns the key's solve times between the dates from
+// and to (YYYY-MM-DD, inclusive), with one entry per day, newest first.
+func GetSolveTimeStats(db *sql.DB, apiKeyID int64, from, to string) (*SolveTimeStats, error) {
+	rows, err := db.Query(`
+		SELECT date, le_ms, count, sum_ms, reported_numbers, reported_ms, fallback_count, fallback_sum_ms
+		FROM solve_times
+		WHERE api_key_id = ? AND date BETWEEN ? AND ?
+		ORDER BY date DESC
+	`, apiKeyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &SolveTimeStats{Daily: []SolveTimeDay{}}
+	total := map[int64]int64{}
+	var sum, fallbackSum int64
+	var dates []string
+	daily := map[string]map[int64]int64{}
+	for rows.Next() {
+		var date string
+		var le, count, sumMS, numbers, reportedMS, fallbackCount, fallbackMS int64
+		if err := rows.Scan(&date, &le, &count, &sumMS, &numbers, &reportedMS, &fallbackCount, &fallbackMS); err != nil {
+			return nil, err
+		}
+		stats.FallbackCount += fallbackCount
+		fallbackSum += fallbackMS
+		if count == 0 {
+			continue
+		}
+		if daily[date] == nil {
+			daily[date] = map[int64]int64{}
+			dates = append(dates, date)
+		}
+		daily[date][le] += count
+		total[le] += count
+		sum += sumMS
+		stats.Count += count
+		stats.reportedNumbers += numbers
+		stats.reportedMS += reportedMS
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.Histogram = solveTimeHistogram(total)
+	if stats.Count > 0 {
+		stats.MeanMS = sum / stats.Count
+		stats.P50MS = solveTimePercentile(stats.Histogram, stats.Count, 0.5)
+		stats.P90MS = solveTimePercentile(stats.Histogram, stats.Count, 0.9)
+		stats.P99MS = solveTimePercentile(stats.Histogram, stats.Count, 0.99)
+	}
+	if stats.FallbackCount > 0 {
+		stats.FallbackMeanMS = fallbackSum / stats.FallbackCount
+	}
+	for _, date := range dates {
+		h := solveTimeHistogram(daily[date])
+		var count int64
+		for _, b := range h {
+			count += b.Count
+		}
+		stats.Daily = append(stats.Daily, SolveTimeDay{
+			Date:  date,
+			Count: count,
+			P50MS: solveTimePercentile(h, count, 0.5),
+			P90MS: solveTimePercentile(h, count, 0.9),
+			P99MS: solveTimePercentile(h, count, 0.99),
+		})
+	}
+	return stats, nil
+}
+
+// DeleteOldSolveTimes removes the solve times of days older than maxAge.
+// They are not rolled up: they only serve the statistics of a single key,
+// and go along with the key when it is deleted.
+func DeleteOldSolveTimes(db *sql.DB, maxAge time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-maxAge).UTC().Format(dateFormatYMD)
+	result, err := db.Exec(`DELETE FROM solve_times WHERE date < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// solveTimeHistogram lays out counts by le_ms over every bucket, in order.
+func solveTimeHistogram(counts map[int64]int64) []SolveTimeBucket {
+	h := make([]SolveTimeBucket, 0, len(SolveTimeBuckets)+1)
+	for _, le := range SolveTimeBuckets {
+		le := le
+		h = append(h, SolveTimeBucket{LeMS: &le, Count: counts[le]})
+	}
+	return append(h, SolveTimeBucket{Count: counts[solveTimeOverflow]})
+}
+
+// solveTimePercentile interpolates the q-th quantile of the histogram,
+// assuming solves spread evenly within a bucket. Quantiles in the overflow
+// bucket are reported as the largest bound.
+func solveTimePercentile(h []SolveTimeBucket, count int64, q float64) int64 {
+	if count == 0 {
+		return 0
+	}
+	rank := q * float64(count)
+	var seen, lower int64
+	for _, b := range h {
+		if b.LeMS == nil {
+			break
+		}
+		if b.Count > 0 && float64(seen+b.Count) >= rank {
+			frac := (rank - float64(seen)) / float64(b.Count)
+			return lower + int64(math.Round(frac*float64(*b.LeMS-lower)))
+		}
+		seen += b.Count
+		lower = *b.LeMS
+	}
+	return lower
+}
+
+// EstimateFor sets Estimate for maxNumber, if the widgets reported any
+// solve times.
+func (s *SolveTimeStats) EstimateFor(maxNumber int64) {
+	if s.reportedMS <= 0 || s.reportedNumbers <= 0 {
+		return
+	}
+	perMS := float64(s.reportedNumbers) / float64(s.reportedMS)
+	full := float64(maxNumber) / perMS
+	s.Estimate = &SolveTimeEstimate{
+		MaxNumber: maxNumber,
+		HashRate:  math.Round(perMS * 1000),
+		MeanMS:    int64(math.Round(full / 2)),
+		P90MS:     int64(math.Round(full * 0.9)),
+		MaxMS:     int64(math.Round(full)),
+	}
+}
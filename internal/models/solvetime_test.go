@@ -0,0 +1,102 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestSolveTimeBucket(t *testing.T) {
+	for ms, want := range map[int64]int64{0: 50, 50: 50, 51: 100, 999: 1000, 60000: 60000, 60001: solveTimeOverflow} {
+		if got := solveTimeBucket(ms); got != want {
+			t.Errorf("solveTimeBucket(%d) = %d, want %d", ms, got, want)
+		}
+	}
+}
+
+func TestGetSolveTimeStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	today := time.Now().UTC().Format(dateFormatYMD)
+
+	// 90 solves between 500 and 750 ms, 10 slow ones, and 5 without a
+	// reported time that must stay out of the histogram.
+	for i := 0; i < 90; i++ {
+		RecordSolveTime(db, key.ID, 600, 30000, true)
+	}
+	for i := 0; i < 10; i++ {
+		RecordSolveTime(db, key.ID, 90000, 4500000, true)
+	}
+	for i := 0; i < 5; i++ {
+		RecordSolveTime(db, key.ID, 1200, 0, false)
+	}
+
+	stats, err := GetSolveTimeStats(db, key.ID, today, today)
+	if err != nil {
+		t.Fatalf("GetSolveTimeStats failed: %v", err)
+	}
+	if stats.Count != 100 {
+		t.Fatalf("expected 100 solves, got %d", stats.Count)
+	}
+	if stats.MeanMS != (90*600+10*90000)/100 {
+		t.Errorf("unexpected mean: %d", stats.MeanMS)
+	}
+	if stats.P50MS <= 500 || stats.P50MS > 750 {
+		t.Errorf("expected p50 in the 500-750 ms bucket, got %d", stats.P50MS)
+	}
+	if stats.P90MS != 750 {
+		t.Errorf("expected p90 at the top of the 750 ms bucket, got %d", stats.P90MS)
+	}
+	if stats.P99MS != 60000 {
+		t.Errorf("expected p99 in the overflow bucket to report the largest bound, got %d", stats.P99MS)
+	}
+	if n := len(stats.Histogram); n != len(SolveTimeBuckets)+1 || stats.Histogram[n-1].LeMS != nil || stats.Histogram[n-1].Count != 10 {
+		t.Errorf("unexpected histogram: %+v", stats.Histogram)
+	}
+	if len(stats.Daily) != 1 || stats.Daily[0].Date != today || stats.Daily[0].Count != 100 {
+		t.Errorf("unexpected daily solve times: %+v", stats.Daily)
+	}
+	if stats.FallbackCount != 5 || stats.FallbackMeanMS != 1200 {
+		t.Errorf("expected 5 fallback solves of 1200 ms, got %d of %d ms", stats.FallbackCount, stats.FallbackMeanMS)
+	}
+
+	// Every reported solve searched 50 numbers per millisecond.
+	stats.EstimateFor(100000)
+	if stats.Estimate == nil {
+		t.Fatal("expected an estimate")
+	}
+	if stats.Estimate.HashRate != 50000 || stats.Estimate.MeanMS != 1000 || stats.Estimate.MaxMS != 2000 {
+		t.Errorf("unexpected estimate: %+v", stats.Estimate)
+	}
+}
+
+func TestGetSolveTimeStats_Empty(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	stats, err := GetSolveTimeStats(db, key.ID, "2026-01-01", "2026-01-31")
+	if err != nil {
+		t.Fatalf("GetSolveTimeStats failed: %v", err)
+	}
+	stats.EstimateFor(100000)
+	if stats.Count != 0 || stats.P50MS != 0 || stats.Estimate != nil || len(stats.Daily) != 0 {
+		t.Errorf("expected empty solve times, got %+v", stats)
+	}
+}
+
+func TestDeleteOldSolveTimes(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	RecordSolveTime(db, key.ID, 600, 30000, true)
+	old := time.Now().UTC().AddDate(0, 0, -100).Format(dateFormatYMD)
+	db.Exec(`INSERT INTO solve_times (api_key_id, date, le_ms, count, sum_ms) VALUES (?, ?, 750, 1, 600)`, key.ID, old)
+
+	if n, err := DeleteOldSolveTimes(db, 90*24*time.Hour); err != nil || n != 1 {
+		t.Fatalf("expected 1 old row removed, got %d (%v)", n, err)
+	}
+	today := time.Now().UTC().Format(dateFormatYMD)
+	if stats, _ := GetSolveTimeStats(db, key.ID, old, today); stats.Count != 1 {
+		t.Errorf("expected today's solve time to be kept, got %d", stats.Count)
+	}
+}
@@ -2,83 +2,257 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
 const dateFormatYMD = "2006-01-02"
 
+// Reasons a verification fails, as counted in the statistics.
+const (
+	FailInvalidEncoding   = "invalid_encoding"
+	FailInvalidFormat     = "invalid_format"
+	FailVerificationError = "verification_error"
+	FailInvalidSolution   = "invalid_solution"
+	FailAlreadyUsed       = "already_used"
+	FailTooFast           = "too_fast"
+	FailTooLate           = "too_late"
+)
+
+// failReasonColumns maps each failure reason to its statistics column.
+var failReasonColumns = map[string]string{
+	FailInvalidEncoding:   "fail_invalid_encoding",
+	FailInvalidFormat:     "fail_invalid_format",
+	FailVerificationError: "fail_verification_error",
+	FailInvalidSolution:   "fail_invalid_solution",
+	FailAlreadyUsed:       "fail_already_used",
+	FailTooFast:           "fail_too_fast",
+	FailTooLate:           "fail_too_late",
+}
+
+// FailureReasons breaks failed verifications down by reason. A rise in
+// already_used points at replayed solutions, one in invalid_format or
+// verification_error more likely at a broken integration, and too_fast or
+// too_late at the key's timing checks. Failures counted
+// before reasons were recorded are in none of them.
+type FailureReasons struct {
+	InvalidEncoding   int `json:"invalid_encoding"`
+	InvalidFormat     int `json:"invalid_format"`
+	VerificationError int `json:"verification_error"`
+	InvalidSolution   int `json:"invalid_solution"`
+	AlreadyUsed       int `json:"already_used"`
+	TooFast           int `json:"too_fast"`
+	TooLate           int `json:"too_late"`
+}
+
+// failReasonSums selects the totals of the failure reason columns, in the
+// order of FailureReasons.dest.
+const failReasonSums = `COALESCE(SUM(fail_invalid_encoding), 0), COALESCE(SUM(fail_invalid_format), 0),
+	COALESCE(SUM(fail_verification_error), 0), COALESCE(SUM(fail_invalid_solution), 0), COALESCE(SUM(fail_already_used), 0),
+	COALESCE(SUM(fail_too_fast), 0), COALESCE(SUM(fail_too_late), 0)`
+
+// statSums selects the totals of all counters, in the order of
+// DailyStat.dest without the date.
+const statSums = `COALESCE(SUM(challenges_issued), 0), COALESCE(SUM(verifications_ok), 0), COALESCE(SUM(verifications_fail), 0),
+	COALESCE(SUM(origin_rejected_lenient), 0), COALESCE(SUM(origin_rejected_strict), 0), COALESCE(SUM(key_disabled_rejected), 0),
+	COALESCE(SUM(timing_flagged), 0), ` + failReasonSums
+
+// statColumns are the counter columns shared by daily_stats, hourly_stats
+// and monthly_stats.
+var statColumns = []string{
+	"challenges_issued", "verifications_ok", "verifications_fail",
+	"origin_rejected_lenient", "origin_rejected_strict", "key_disabled_rejected", "timing_flagged",
+	"fail_invalid_encoding", "fail_invalid_format", "fail_verification_error", "fail_invalid_solution", "fail_already_used",
+	"fail_too_fast", "fail_too_late",
+}
+
+func (f *FailureReasons) dest() []interface{} {
+	return []interface{}{&f.InvalidEncoding, &f.InvalidFormat, &f.VerificationError, &f.InvalidSolution, &f.AlreadyUsed, &f.TooFast, &f.TooLate}
+}
+
+func (f *FailureReasons) add(o FailureReasons) {
+	f.InvalidEncoding += o.InvalidEncoding
+	f.InvalidFormat += o.InvalidFormat
+	f.VerificationError += o.VerificationError
+	f.InvalidSolution += o.InvalidSolution
+	f.AlreadyUsed += o.AlreadyUsed
+	f.TooFast += o.TooFast
+	f.TooLate += o.TooLate
+}
+
+// DailyStat is one day's statistics. Approximate marks the first day of a
+// rolled-up month, which holds the whole month.
 type DailyStat struct {
-	Date              string `json:"date"`
-	ChallengesIssued  int    `json:"challenges_issued"`
-	VerificationsOK   int    `json:"verifications_ok"`
-	VerificationsFail int    `json:"verifications_fail"`
+	Date                  string         `json:"date"`
+	ChallengesIssued      int            `json:"challenges_issued"`
+	VerificationsOK       int            `json:"verifications_ok"`
+	VerificationsFail     int            `json:"verifications_fail"`
+	OriginRejectedLenient int            `json:"origin_rejected_lenient"`
+	OriginRejectedStrict  int            `json:"origin_rejected_strict"`
+	KeyDisabledRejected   int            `json:"key_disabled_rejected"`
+	TimingFlagged         int            `json:"timing_flagged"`
+	FailureReasons        FailureReasons `json:"failure_reasons"`
+	Approximate           bool           `json:"approximate,omitempty"`
+}
+
+// dest scans a row of date, statSums and whether any rolled-up month was
+// summed in.
+func (s *DailyStat) dest() []interface{} {
+	dest := append([]interface{}{&s.Date, &s.ChallengesIssued, &s.VerificationsOK, &s.VerificationsFail,
+		&s.OriginRejectedLenient, &s.OriginRejectedStrict, &s.KeyDisabledRejected, &s.TimingFlagged}, s.FailureReasons.dest()...)
+	return append(dest, &s.Approximate)
 }
 
 type StatsOverview struct {
-	TotalChallenges        int         `json:"total_challenges"`
-	TotalVerificationsOK   int         `json:"total_verifications_ok"`
-	TotalVerificationsFail int         `json:"total_verifications_fail"`
-	ActiveKeys             int         `json:"active_keys"`
-	Daily                  []DailyStat `json:"daily"`
+	TotalChallenges            int            `json:"total_challenges"`
+	TotalVerificationsOK       int            `json:"total_verifications_ok"`
+	TotalVerificationsFail     int            `json:"total_verifications_fail"`
+	TotalOriginRejectedLenient int            `json:"total_origin_rejected_lenient"`
+	TotalOriginRejectedStrict  int            `json:"total_origin_rejected_strict"`
+	TotalKeyDisabledRejected   int            `json:"total_key_disabled_rejected"`
+	TotalTimingFlagged         int            `json:"total_timing_flagged"`
+	TotalFailureReasons        FailureReasons `json:"total_failure_reasons"`
+	ActiveKeys                 int            `json:"active_keys"`
+	Daily                      []DailyStat    `json:"daily"`
+	Series                     *StatsSeries   `json:"series,omitempty"`
 }
 
 func IncrementChallengesIssued(db *sql.DB, apiKeyID int64) error {
-	date := time.Now().UTC().Format(dateFormatYMD)
-	_, err := db.Exec(`
-		INSERT INTO daily_stats (api_key_id, date, challenges_issued, verifications_ok, verifications_fail)
-		VALUES (?, ?, 1, 0, 0)
-		ON CONFLICT(api_key_id, date)
-		DO UPDATE SET challenges_issued = challenges_issued + 1
-	`, apiKeyID, date)
-	return err
+	return incrementStat(db, apiKeyID, "challenges_issued")
 }
 
 func IncrementVerificationsOK(db *sql.DB, apiKeyID int64) error {
-	date := time.Now().UTC().Format(dateFormatYMD)
-	_, err := db.Exec(`
-		INSERT INTO daily_stats (api_key_id, date, challenges_issued, verifications_ok, verifications_fail)
-		VALUES (?, ?, 0, 1, 0)
-		ON CONFLICT(api_key_id, date)
-		DO UPDATE SET verifications_ok = verifications_ok + 1
-	`, apiKeyID, date)
-	return err
+	return incrementStat(db, apiKeyID, "verifications_ok")
 }
 
-func IncrementVerificationsFail(db *sql.DB, apiKeyID int64) error {
-	date := time.Now().UTC().Format(dateFormatYMD)
-	_, err := db.Exec(`
-		INSERT INTO daily_stats (api_key_id, date, challenges_issued, verifications_ok, verifications_fail)
-		VALUES (?, ?, 0, 0, 1)
-		ON CONFLICT(api_key_id, date)
-		DO UPDATE SET verifications_fail = verifications_fail + 1
-	`, apiKeyID, date)
-	return err
+// IncrementVerificationsFail counts a failed verification and its reason,
+// one of the Fail constants.
+func IncrementVerificationsFail(db *sql.DB, apiKeyID int64, reason string) error {
+	column, ok := failReasonColumns[reason]
+	if !ok {
+		return fmt.Errorf("unknown failure reason: %s", reason)
+	}
+	return incrementStat(db, apiKeyID, "verifications_fail", column)
+}
+
+// IncrementKeyDisabledRejected counts a request refused because the key is
+// disabled.
+func IncrementKeyDisabledRejected(db *sql.DB, apiKeyID int64) error {
+	return incrementStat(db, apiKeyID, "key_disabled_rejected")
+}
+
+// IncrementTimingFlagged counts a verification that failed the key's
+// timing checks but was accepted because the key only flags them.
+func IncrementTimingFlagged(db *sql.DB, apiKeyID int64) error {
+	return incrementStat(db, apiKeyID, "timing_flagged")
 }
 
-func GetStatsOverview(db *sql.DB, days int) (*StatsOverview, error) {
+// IncrementOriginRejected counts a request refused by the key's origin
+// check, under the enforcement mode that refused it.
+func IncrementOriginRejected(db *sql.DB, apiKeyID int64, mode string) error {
+	switch mode {
+	case OriginEnforcementLenient:
+		return incrementStat(db, apiKeyID, "origin_rejected_lenient")
+	case OriginEnforcementStrict:
+		return incrementStat(db, apiKeyID, "origin_rejected_strict")
+	}
+	return fmt.Errorf("unknown origin enforcement mode: %s", mode)
+}
+
+// incrementStat adds one to counters of the key's current hour and day, so
+// the daily rows always hold the sum of the hourly ones.
+func incrementStat(db *sql.DB, apiKeyID int64, columns ...string) error {
+	var names, values, updates []string
+	for _, c := range columns {
+		names = append(names, ", "+c)
+		values = append(values, ", 1")
+		updates = append(updates, c+" = "+c+" + 1")
+	}
+
+	now := time.Now().UTC()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, row := range []struct{ table, bucket, value string }{
+		{"hourly_stats", "hour", now.Truncate(time.Hour).Format(time.RFC3339)},
+		{"daily_stats", "date", now.Format(dateFormatYMD)},
+	} {
+		_, err := tx.Exec(`
+			INSERT INTO `+row.table+` (api_key_id, `+row.bucket+strings.Join(names, "")+`)
+			VALUES (?, ?`+strings.Join(values, "")+`)
+			ON CONFLICT(api_key_id, `+row.bucket+`)
+			DO UPDATE SET `+strings.Join(updates, ", ")+`
+		`, apiKeyID, row.value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scopedKeyIDs is a subquery for the IDs of the keys in scope.
+func scopedKeyIDs(scope ProjectScope) (string, []interface{}) {
+	cond, args := scope.where("project_id")
+	return `SELECT id FROM api_keys WHERE ` + cond, args
+}
+
+// monthlyStatsProject is the project of a monthly_stats row: that of its
+// key, or the one the key was in when it was deleted.
+const monthlyStatsProject = `COALESCE((SELECT project_id FROM api_keys WHERE api_keys.id = monthly_stats.api_key_id), monthly_stats.project_id)`
+
+// statsSource is a subquery of the daily statistics of the keys in scope,
+// or of a single key if apiKeyID is not 0, together with their rolled-up
+// months. A month's row is dated on its first day, with rolled_up set;
+// last_date is its last day with traffic, and the day itself for daily
+// rows.
+func statsSource(scope ProjectScope, apiKeyID int64) (string, []interface{}) {
+	daily, args := "api_key_id = ?", []interface{}{apiKeyID}
+	monthly, monthlyArgs := daily, args
+	if apiKeyID == 0 {
+		keyIDs, keyArgs := scopedKeyIDs(scope)
+		daily, args = statsKeyFilter(scope, keyIDs), keyArgs
+		monthly, monthlyArgs = scope.where(monthlyStatsProject)
+	}
+	columns := strings.Join(statColumns, ", ")
+	return `(
+		SELECT date, date AS last_date, 0 AS rolled_up, api_key_id, ` + columns + ` FROM daily_stats WHERE ` + daily + `
+		UNION ALL
+		SELECT month, last_date, 1, api_key_id, ` + columns + ` FROM monthly_stats WHERE ` + monthly + `
+	)`, append(append([]interface{}{}, args...), monthlyArgs...)
+}
+
+// GetStatsOverview aggregates the statistics of the keys in scope. Totals
+// include rolled-up months and keys deleted since; in Daily, a rolled-up
+// month is one entry on its first day.
+func GetStatsOverview(db *sql.DB, days int, scope ProjectScope) (*StatsOverview, error) {
 	overview := &StatsOverview{}
+	source, sourceArgs := statsSource(scope, 0)
+	cond, condArgs := scope.where("project_id")
 
-	err := db.QueryRow(`
-		SELECT COALESCE(SUM(challenges_issued), 0), COALESCE(SUM(verifications_ok), 0), COALESCE(SUM(verifications_fail), 0)
-		FROM daily_stats
-	`).Scan(&overview.TotalChallenges, &overview.TotalVerificationsOK, &overview.TotalVerificationsFail)
+	err := db.QueryRow(`SELECT `+statSums+` FROM `+source, sourceArgs...).Scan(append([]interface{}{&overview.TotalChallenges, &overview.TotalVerificationsOK, &overview.TotalVerificationsFail,
+		&overview.TotalOriginRejectedLenient, &overview.TotalOriginRejectedStrict, &overview.TotalKeyDisabledRejected, &overview.TotalTimingFlagged},
+		overview.TotalFailureReasons.dest()...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE enabled = 1`).Scan(&overview.ActiveKeys)
+	err = db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE enabled = 1 AND `+cond, condArgs...).Scan(&overview.ActiveKeys)
 	if err != nil {
 		return nil, err
 	}
 
 	rows, err := db.Query(`
-		SELECT date, COALESCE(SUM(challenges_issued), 0), COALESCE(SUM(verifications_ok), 0), COALESCE(SUM(verifications_fail), 0)
-		FROM daily_stats
-		WHERE date >= date('now', ?)
+		SELECT date, `+statSums+`, MAX(rolled_up)
+		FROM `+source+`
+		WHERE last_date >= date('now', ?)
 		GROUP BY date
 		ORDER BY date DESC
-	`, fmt.Sprintf("-%d days", days))
+	`, append(sourceArgs, fmt.Sprintf("-%d days", days))...)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +260,7 @@ func GetStatsOverview(db *sql.DB, days int) (*StatsOverview, error) {
 
 	for rows.Next() {
 		var s DailyStat
-		if err := rows.Scan(&s.Date, &s.ChallengesIssued, &s.VerificationsOK, &s.VerificationsFail); err != nil {
+		if err := rows.Scan(s.dest()...); err != nil {
 			return nil, err
 		}
 		overview.Daily = append(overview.Daily, s)
@@ -97,24 +271,38 @@ func GetStatsOverview(db *sql.DB, days int) (*StatsOverview, error) {
 
 // KeyStatsSummary holds all-time totals for a single API key.
 type KeyStatsSummary struct {
-	APIKeyID          int64  `json:"api_key_id"`
-	ChallengesIssued  int    `json:"challenges_issued"`
-	VerificationsOK   int    `json:"verifications_ok"`
-	VerificationsFail int    `json:"verifications_fail"`
-	LastUsedAt        string `json:"last_used_at"`
+	APIKeyID              int64          `json:"api_key_id"`
+	ChallengesIssued      int            `json:"challenges_issued"`
+	VerificationsOK       int            `json:"verifications_ok"`
+	VerificationsFail     int            `json:"verifications_fail"`
+	OriginRejectedLenient int            `json:"origin_rejected_lenient"`
+	OriginRejectedStrict  int            `json:"origin_rejected_strict"`
+	KeyDisabledRejected   int            `json:"key_disabled_rejected"`
+	TimingFlagged         int            `json:"timing_flagged"`
+	FailureReasons        FailureReasons `json:"failure_reasons"`
+	LastUsedAt            string         `json:"last_used_at"`
 }
 
-// GetAllKeysStatsSummary returns all-time totals grouped by API key ID.
-func GetAllKeysStatsSummary(db *sql.DB) (map[int64]KeyStatsSummary, error) {
+// statsKeyFilter restricts daily_stats rows to the keys of keyIDs, skipping
+// the subquery when every key is in scope.
+func statsKeyFilter(scope ProjectScope, keyIDs string) string {
+	if scope.All {
+		return "1 = 1"
+	}
+	return "api_key_id IN (" + keyIDs + ")"
+}
+
+// GetAllKeysStatsSummary returns all-time totals of the keys in scope,
+// including rolled-up months, grouped by API key ID. Deleted keys are left
+// out.
+func GetAllKeysStatsSummary(db *sql.DB, scope ProjectScope) (map[int64]KeyStatsSummary, error) {
+	source, sourceArgs := statsSource(scope, 0)
 	rows, err := db.Query(`
-		SELECT api_key_id,
-		       COALESCE(SUM(challenges_issued), 0),
-		       COALESCE(SUM(verifications_ok), 0),
-		       COALESCE(SUM(verifications_fail), 0),
-		       COALESCE(MAX(date), '')
-		FROM daily_stats
+		SELECT api_key_id, `+statSums+`, COALESCE(MAX(last_date), '')
+		FROM `+source+`
+		WHERE api_key_id IN (SELECT id FROM api_keys)
 		GROUP BY api_key_id
-	`)
+	`, sourceArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +311,9 @@ func GetAllKeysStatsSummary(db *sql.DB) (map[int64]KeyStatsSummary, error) {
 	result := make(map[int64]KeyStatsSummary)
 	for rows.Next() {
 		var s KeyStatsSummary
-		if err := rows.Scan(&s.APIKeyID, &s.ChallengesIssued, &s.VerificationsOK, &s.VerificationsFail, &s.LastUsedAt); err != nil {
+		dest := []interface{}{&s.APIKeyID, &s.ChallengesIssued, &s.VerificationsOK, &s.VerificationsFail, &s.OriginRejectedLenient, &s.OriginRejectedStrict, &s.KeyDisabledRejected, &s.TimingFlagged}
+		dest = append(dest, s.FailureReasons.dest()...)
+		if err := rows.Scan(append(dest, &s.LastUsedAt)...); err != nil {
 			return nil, err
 		}
 		result[s.APIKeyID] = s
@@ -131,13 +321,17 @@ func GetAllKeysStatsSummary(db *sql.DB) (map[int64]KeyStatsSummary, error) {
 	return result, nil
 }
 
+// GetKeyStats returns the key's statistics of the last days days, newest
+// first. A rolled-up month is one entry on its first day.
 func GetKeyStats(db *sql.DB, apiKeyID int64, days int) ([]DailyStat, error) {
+	source, sourceArgs := statsSource(AllProjects, apiKeyID)
 	rows, err := db.Query(`
-		SELECT date, COALESCE(challenges_issued, 0), COALESCE(verifications_ok, 0), COALESCE(verifications_fail, 0)
-		FROM daily_stats
-		WHERE api_key_id = ? AND date >= date('now', ?)
+		SELECT date, `+statSums+`, MAX(rolled_up)
+		FROM `+source+`
+		WHERE last_date >= date('now', ?)
+		GROUP BY date
 		ORDER BY date DESC
-	`, apiKeyID, fmt.Sprintf("-%d days", days))
+	`, append(sourceArgs, fmt.Sprintf("-%d days", days))...)
 	if err != nil {
 		return nil, err
 	}
@@ -146,10 +340,246 @@ func GetKeyStats(db *sql.DB, apiKeyID int64, days int) ([]DailyStat, error) {
 	var stats []DailyStat
 	for rows.Next() {
 		var s DailyStat
-		if err := rows.Scan(&s.Date, &s.ChallengesIssued, &s.VerificationsOK, &s.VerificationsFail); err != nil {
+		if err := rows.Scan(s.dest()...); err != nil {
 			return nil, err
 		}
 		stats = append(stats, s)
 	}
 	return stats, nil
 }
+
+// Statistics buckets. Hourly buckets come from hourly_stats, the others are
+// built from daily_stats and the rolled-up months of monthly_stats.
+const (
+	BucketHour  = "hour"
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// MaxHourlyRange is the longest range that can be split into hourly buckets.
+const MaxHourlyRange = 31 * 24 * time.Hour
+
+// StatsRange selects the statistics from From up to, but not including, To,
+// grouped into buckets of Bucket.
+type StatsRange struct {
+	From   time.Time
+	To     time.Time
+	Bucket string
+}
+
+// Validate checks the bucket and that the range is neither empty nor too
+// long for hourly buckets.
+func (r StatsRange) Validate() error {
+	switch r.Bucket {
+	case BucketHour, BucketDay, BucketWeek, BucketMonth:
+	default:
+		return errors.New("bucket must be one of hour, day, week, month")
+	}
+	if !r.From.Before(r.To) {
+		return errors.New("from must be before to")
+	}
+	if r.Bucket == BucketHour && r.To.Sub(r.From) > MaxHourlyRange {
+		return fmt.Errorf("hourly buckets cover at most %d days", int(MaxHourlyRange.Hours()/24))
+	}
+	return nil
+}
+
+// StatCounts are the counters of one or more keys over some time.
+type StatCounts struct {
+	ChallengesIssued      int            `json:"challenges_issued"`
+	VerificationsOK       int            `json:"verifications_ok"`
+	VerificationsFail     int            `json:"verifications_fail"`
+	OriginRejectedLenient int            `json:"origin_rejected_lenient"`
+	OriginRejectedStrict  int            `json:"origin_rejected_strict"`
+	KeyDisabledRejected   int            `json:"key_disabled_rejected"`
+	TimingFlagged         int            `json:"timing_flagged"`
+	FailureReasons        FailureReasons `json:"failure_reasons"`
+}
+
+func (c *StatCounts) add(o StatCounts) {
+	c.ChallengesIssued += o.ChallengesIssued
+	c.VerificationsOK += o.VerificationsOK
+	c.VerificationsFail += o.VerificationsFail
+	c.OriginRejectedLenient += o.OriginRejectedLenient
+	c.OriginRejectedStrict += o.OriginRejectedStrict
+	c.KeyDisabledRejected += o.KeyDisabledRejected
+	c.TimingFlagged += o.TimingFlagged
+	c.FailureReasons.add(o.FailureReasons)
+}
+
+// StatPoint holds the counters of one bucket. Start is the bucket's first
+// hour in RFC 3339, or its first day as YYYY-MM-DD. Weeks start on Monday.
+// Approximate marks a bucket holding a rolled-up month that the bucket or
+// the range only partly covers.
+type StatPoint struct {
+	Start string `json:"start"`
+	StatCounts
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// StatsSeries is the statistics of a range, bucket by bucket. Approximate
+// is set when any point is.
+type StatsSeries struct {
+	Bucket      string      `json:"bucket"`
+	From        string      `json:"from"`
+	To          string      `json:"to"`
+	Totals      StatCounts  `json:"totals"`
+	Points      []StatPoint `json:"points"`
+	Approximate bool        `json:"approximate,omitempty"`
+}
+
+// GetStatsSeries returns the statistics of the keys in scope over the range,
+// or those of a single key if apiKeyID is not 0. Points are oldest first;
+// buckets without any traffic are left out.
+//
+// Buckets other than hours are built from whole days: a range starting or
+// ending within a day includes that day, and a week or month cut by the
+// range only sums its days inside it. Rolled-up months count as a whole,
+// on their first day, when the range covers any of their traffic; their
+// points are approximate unless the bucket is a month and the range covers
+// all of it.
+func GetStatsSeries(db *sql.DB, rng StatsRange, scope ProjectScope, apiKeyID int64) (*StatsSeries, error) {
+	from, last := rng.From.UTC(), rng.To.UTC().Add(-time.Nanosecond)
+	var source, where string
+	var args, approximateArgs []interface{}
+	start, approximate := "date", "0"
+	if rng.Bucket == BucketHour {
+		filter, filterArgs := "api_key_id = ?", []interface{}{apiKeyID}
+		if apiKeyID == 0 {
+			keyIDs, keyArgs := scopedKeyIDs(scope)
+			filter, filterArgs = statsKeyFilter(scope, keyIDs), keyArgs
+		}
+		source, where, start = "hourly_stats", "hour BETWEEN ? AND ? AND "+filter, "hour"
+		args = append([]interface{}{from.Truncate(time.Hour).Format(time.RFC3339), last.Truncate(time.Hour).Format(time.RFC3339)}, filterArgs...)
+	} else {
+		source, args = statsSource(scope, apiKeyID)
+		where = "last_date >= ? AND date <= ?"
+		args = append(args, from.Format(dateFormatYMD), last.Format(dateFormatYMD))
+		approximate = "MAX(rolled_up)"
+		if rng.Bucket == BucketMonth {
+			approximate = "MAX(rolled_up = 1 AND (date < ? OR date(date, '+1 month', '-1 day') > ?))"
+			approximateArgs = []interface{}{from.Format(dateFormatYMD), last.Format(dateFormatYMD)}
+		}
+	}
+	switch rng.Bucket {
+	case BucketWeek:
+		start = "date(date, 'weekday 0', '-6 days')"
+	case BucketMonth:
+		start = "strftime('%Y-%m-01', date)"
+	}
+
+	rows, err := db.Query(`
+		SELECT `+start+`, `+statSums+`, `+approximate+`
+		FROM `+source+`
+		WHERE `+where+`
+		GROUP BY 1
+		ORDER BY 1
+	`, append(approximateArgs, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := &StatsSeries{
+		Bucket: rng.Bucket,
+		From:   rng.From.UTC().Format(time.RFC3339),
+		To:     rng.To.UTC().Format(time.RFC3339),
+		Points: []StatPoint{},
+	}
+	for rows.Next() {
+		var p StatPoint
+		dest := []interface{}{&p.Start, &p.ChallengesIssued, &p.VerificationsOK, &p.VerificationsFail, &p.OriginRejectedLenient, &p.OriginRejectedStrict, &p.KeyDisabledRejected, &p.TimingFlagged}
+		if err := rows.Scan(append(append(dest, p.FailureReasons.dest()...), &p.Approximate)...); err != nil {
+			return nil, err
+		}
+		series.Totals.add(p.StatCounts)
+		series.Approximate = series.Approximate || p.Approximate
+		series.Points = append(series.Points, p)
+	}
+	return series, rows.Err()
+}
+
+// statsExecer runs statements on a database or within a transaction.
+type statsExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// rollUpDailyStats moves daily statistics before the date before
+// (YYYY-MM-DD) into monthly_stats, adding to months already rolled up, or
+// only those of one key if apiKeyID is not 0. It returns the number of
+// daily rows removed. monthly_stats has no foreign key, so its rows outlive
+// their key and keep the project it was last in.
+func rollUpDailyStats(db statsExecer, before string, apiKeyID int64) (int64, error) {
+	var sums, updates []string
+	for _, c := range statColumns {
+		sums = append(sums, "COALESCE(SUM(d."+c+"), 0)")
+		updates = append(updates, c+" = "+c+" + excluded."+c)
+	}
+	filter, args := "1 = 1", []interface{}{before}
+	if apiKeyID != 0 {
+		filter, args = "api_key_id = ?", append(args, apiKeyID)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO monthly_stats (api_key_id, project_id, month, last_date, `+strings.Join(statColumns, ", ")+`)
+		SELECT d.api_key_id, COALESCE(MAX(k.project_id), 0), strftime('%Y-%m-01', d.date), MAX(d.date), `+strings.Join(sums, ", ")+`
+		FROM daily_stats d LEFT JOIN api_keys k ON k.id = d.api_key_id
+		WHERE date < ? AND `+filter+`
+		GROUP BY d.api_key_id, strftime('%Y-%m-01', d.date)
+		ON CONFLICT(api_key_id, month) DO UPDATE SET
+			project_id = excluded.project_id,
+			last_date = MAX(last_date, excluded.last_date),
+			`+strings.Join(updates, ", "), args...)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec(`DELETE FROM daily_stats WHERE date < ? AND `+filter, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RollUpDailyStats folds the daily statistics of every month that ended
+// more than retention ago into one row per key and month. It returns the
+// number of daily rows removed.
+func RollUpDailyStats(db *sql.DB, retention time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-retention).UTC()
+	monthStart := time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	removed, err := rollUpDailyStats(tx, monthStart.Format(dateFormatYMD), 0)
+	if err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// DeleteOldHourlyStats removes hourly statistics older than maxAge. The
+// daily statistics still hold their sums.
+func DeleteOldHourlyStats(db *sql.DB, maxAge time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-maxAge).UTC().Truncate(time.Hour).Format(time.RFC3339)
+	result, err := db.Exec(`DELETE FROM hourly_stats WHERE hour < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteOldMonthlyStats removes rolled-up months that ended more than
+// months months before the current one.
+func DeleteOldMonthlyStats(db *sql.DB, months int) (int64, error) {
+	now := time.Now().UTC()
+	cutoff := time.Date(now.Year(), now.Month()-time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	result, err := db.Exec(`DELETE FROM monthly_stats WHERE month < ?`, cutoff.Format(dateFormatYMD))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
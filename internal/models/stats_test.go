@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/Upellift99/GateCHA/internal/testutil"
 )
@@ -50,7 +51,7 @@ func TestIncrementVerificationsFail(t *testing.T) {
 	db := testutil.SetupTestDB(t)
 	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
 
-	if err := IncrementVerificationsFail(db, key.ID); err != nil {
+	if err := IncrementVerificationsFail(db, key.ID, FailInvalidSolution); err != nil {
 		t.Fatalf("IncrementVerificationsFail failed: %v", err)
 	}
 
@@ -63,6 +64,61 @@ func TestIncrementVerificationsFail(t *testing.T) {
 	}
 }
 
+func TestIncrementVerificationsFail_Reasons(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	IncrementVerificationsFail(db, key.ID, FailAlreadyUsed)
+	IncrementVerificationsFail(db, key.ID, FailAlreadyUsed)
+	IncrementVerificationsFail(db, key.ID, FailVerificationError)
+	IncrementKeyDisabledRejected(db, key.ID)
+	if err := IncrementVerificationsFail(db, key.ID, "bogus"); err == nil {
+		t.Error("expected error for an unknown reason")
+	}
+
+	want := FailureReasons{AlreadyUsed: 2, VerificationError: 1}
+	overview, _ := GetStatsOverview(db, 30, AllProjects)
+	if overview.TotalVerificationsFail != 3 || overview.TotalFailureReasons != want || overview.TotalKeyDisabledRejected != 1 {
+		t.Errorf("unexpected overview totals: %+v", overview)
+	}
+	if len(overview.Daily) != 1 || overview.Daily[0].FailureReasons != want {
+		t.Errorf("unexpected daily reasons: %+v", overview.Daily)
+	}
+
+	summary, _ := GetAllKeysStatsSummary(db, AllProjects)
+	if summary[key.ID].FailureReasons != want || summary[key.ID].KeyDisabledRejected != 1 {
+		t.Errorf("unexpected summary: %+v", summary[key.ID])
+	}
+
+	now := time.Now().UTC()
+	series, _ := GetStatsSeries(db, StatsRange{now.Add(-time.Hour), now.Add(time.Hour), BucketHour}, AllProjects, key.ID)
+	if series.Totals.FailureReasons != want || series.Totals.KeyDisabledRejected != 1 {
+		t.Errorf("unexpected hourly totals: %+v", series.Totals)
+	}
+}
+
+func TestIncrementOriginRejected(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	IncrementOriginRejected(db, key.ID, OriginEnforcementLenient)
+	IncrementOriginRejected(db, key.ID, OriginEnforcementStrict)
+	IncrementOriginRejected(db, key.ID, OriginEnforcementStrict)
+	if err := IncrementOriginRejected(db, key.ID, OriginEnforcementOff); err == nil {
+		t.Error("expected error for a mode that never rejects")
+	}
+
+	stats, _ := GetKeyStats(db, key.ID, 1)
+	if len(stats) != 1 || stats[0].OriginRejectedLenient != 1 || stats[0].OriginRejectedStrict != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	overview, _ := GetStatsOverview(db, 1, AllProjects)
+	if overview.TotalOriginRejectedLenient != 1 || overview.TotalOriginRejectedStrict != 2 {
+		t.Errorf("unexpected overview totals: %+v", overview)
+	}
+}
+
 func TestGetStatsOverview(t *testing.T) {
 	db := testutil.SetupTestDB(t)
 	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
@@ -70,9 +126,9 @@ func TestGetStatsOverview(t *testing.T) {
 	IncrementChallengesIssued(db, key.ID)
 	IncrementChallengesIssued(db, key.ID)
 	IncrementVerificationsOK(db, key.ID)
-	IncrementVerificationsFail(db, key.ID)
+	IncrementVerificationsFail(db, key.ID, FailInvalidSolution)
 
-	overview, err := GetStatsOverview(db, 30)
+	overview, err := GetStatsOverview(db, 30, AllProjects)
 	if err != nil {
 		t.Fatalf("GetStatsOverview failed: %v", err)
 	}
@@ -102,7 +158,7 @@ func TestGetAllKeysStatsSummary(t *testing.T) {
 	IncrementChallengesIssued(db, key2.ID)
 	IncrementChallengesIssued(db, key2.ID)
 
-	summary, err := GetAllKeysStatsSummary(db)
+	summary, err := GetAllKeysStatsSummary(db, AllProjects)
 	if err != nil {
 		t.Fatalf("GetAllKeysStatsSummary failed: %v", err)
 	}
@@ -129,3 +185,227 @@ func TestGetKeyStats_Empty(t *testing.T) {
 		t.Errorf("expected empty stats, got %d", len(stats))
 	}
 }
+
+func TestIncrementWritesHourlyStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	IncrementChallengesIssued(db, key.ID)
+	IncrementVerificationsOK(db, key.ID)
+	IncrementOriginRejected(db, key.ID, OriginEnforcementStrict)
+
+	var hourly StatCounts
+	err := db.QueryRow(`SELECT challenges_issued, verifications_ok, origin_rejected_strict FROM hourly_stats WHERE api_key_id = ? AND hour = ?`,
+		key.ID, time.Now().UTC().Truncate(time.Hour).Format(time.RFC3339)).
+		Scan(&hourly.ChallengesIssued, &hourly.VerificationsOK, &hourly.OriginRejectedStrict)
+	if err != nil {
+		t.Fatalf("expected an hourly row: %v", err)
+	}
+	if hourly.ChallengesIssued != 1 || hourly.VerificationsOK != 1 || hourly.OriginRejectedStrict != 1 {
+		t.Errorf("unexpected hourly counters: %+v", hourly)
+	}
+}
+
+func TestGetStatsSeries(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	other, _ := CreateAPIKey(db, "Other", "", 0, 0, "")
+
+	for _, row := range []struct {
+		key        int64
+		hour       string
+		challenges int
+	}{
+		{key.ID, "2026-03-01T10:00:00Z", 1}, // Sunday
+		{key.ID, "2026-03-02T10:00:00Z", 2}, // Monday
+		{key.ID, "2026-03-02T11:00:00Z", 3},
+		{key.ID, "2026-04-01T00:00:00Z", 4},
+		{other.ID, "2026-03-02T10:00:00Z", 5},
+	} {
+		db.Exec(`INSERT INTO hourly_stats (api_key_id, hour, challenges_issued) VALUES (?, ?, ?)`, row.key, row.hour, row.challenges)
+		db.Exec(`INSERT INTO daily_stats (api_key_id, date, challenges_issued) VALUES (?, ?, ?)
+			ON CONFLICT(api_key_id, date) DO UPDATE SET challenges_issued = challenges_issued + excluded.challenges_issued`,
+			row.key, row.hour[:10], row.challenges)
+	}
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+
+	tests := []struct {
+		name     string
+		rng      StatsRange
+		keyID    int64
+		starts   []string
+		counts   []int
+		expected int
+	}{
+		{"hours of one key", StatsRange{at("2026-03-02T10:00:00Z"), at("2026-03-02T12:00:00Z"), BucketHour}, key.ID,
+			[]string{"2026-03-02T10:00:00Z", "2026-03-02T11:00:00Z"}, []int{2, 3}, 5},
+		{"to is exclusive", StatsRange{at("2026-03-02T10:00:00Z"), at("2026-03-02T11:00:00Z"), BucketHour}, key.ID,
+			[]string{"2026-03-02T10:00:00Z"}, []int{2}, 2},
+		{"days of all keys", StatsRange{at("2026-03-01T00:00:00Z"), at("2026-03-03T00:00:00Z"), BucketDay}, 0,
+			[]string{"2026-03-01", "2026-03-02"}, []int{1, 10}, 11},
+		{"weeks start on Monday", StatsRange{at("2026-02-20T00:00:00Z"), at("2026-04-02T00:00:00Z"), BucketWeek}, key.ID,
+			[]string{"2026-02-23", "2026-03-02", "2026-03-30"}, []int{1, 5, 4}, 10},
+		{"months", StatsRange{at("2026-01-01T00:00:00Z"), at("2026-05-01T00:00:00Z"), BucketMonth}, key.ID,
+			[]string{"2026-03-01", "2026-04-01"}, []int{6, 4}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, err := GetStatsSeries(db, tt.rng, AllProjects, tt.keyID)
+			if err != nil {
+				t.Fatalf("GetStatsSeries failed: %v", err)
+			}
+			if len(series.Points) != len(tt.starts) {
+				t.Fatalf("expected %d points, got %+v", len(tt.starts), series.Points)
+			}
+			for i, p := range series.Points {
+				if p.Start != tt.starts[i] || p.ChallengesIssued != tt.counts[i] {
+					t.Errorf("point %d: expected %s=%d, got %s=%d", i, tt.starts[i], tt.counts[i], p.Start, p.ChallengesIssued)
+				}
+			}
+			if series.Totals.ChallengesIssued != tt.expected {
+				t.Errorf("expected %d challenges in total, got %d", tt.expected, series.Totals.ChallengesIssued)
+			}
+		})
+	}
+}
+
+func TestStatsRange_Validate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		rng   StatsRange
+		valid bool
+	}{
+		{"day", StatsRange{now.AddDate(0, 0, -30), now, BucketDay}, true},
+		{"unknown bucket", StatsRange{now.Add(-time.Hour), now, "minute"}, false},
+		{"empty range", StatsRange{now, now, BucketDay}, false},
+		{"long hourly range", StatsRange{now.AddDate(0, 0, -32), now, BucketHour}, false},
+		{"long daily range", StatsRange{now.AddDate(-2, 0, 0), now, BucketDay}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rng.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestRollUpDailyStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	for _, row := range []struct {
+		date       string
+		challenges int
+	}{{"2025-01-10", 1}, {"2025-01-20", 2}, {"2025-02-03", 4}} {
+		db.Exec(`INSERT INTO daily_stats (api_key_id, date, challenges_issued, verifications_fail, fail_already_used) VALUES (?, ?, ?, 1, 1)`,
+			key.ID, row.date, row.challenges)
+	}
+	IncrementChallengesIssued(db, key.ID)
+
+	removed, err := RollUpDailyStats(db, 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("RollUpDailyStats failed: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("expected 3 daily rows rolled up, got %d", removed)
+	}
+	var months int
+	db.QueryRow(`SELECT COUNT(*) FROM monthly_stats WHERE api_key_id = ?`, key.ID).Scan(&months)
+	if months != 2 {
+		t.Errorf("expected 2 monthly rows, got %d", months)
+	}
+
+	overview, _ := GetStatsOverview(db, 30, AllProjects)
+	if overview.TotalChallenges != 8 || overview.TotalFailureReasons.AlreadyUsed != 3 {
+		t.Errorf("expected totals to include rolled-up months, got %+v", overview)
+	}
+	if len(overview.Daily) != 1 || overview.Daily[0].Approximate {
+		t.Errorf("expected only today in the last 30 days, got %+v", overview.Daily)
+	}
+	summary, _ := GetAllKeysStatsSummary(db, AllProjects)
+	if summary[key.ID].ChallengesIssued != 8 {
+		t.Errorf("expected 8 challenges in the summary, got %d", summary[key.ID].ChallengesIssued)
+	}
+
+	rng := StatsRange{time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), BucketDay}
+	series, err := GetStatsSeries(db, rng, AllProjects, key.ID)
+	if err != nil {
+		t.Fatalf("GetStatsSeries failed: %v", err)
+	}
+	if len(series.Points) != 2 || series.Points[0].Start != "2025-01-01" || series.Points[0].ChallengesIssued != 3 ||
+		series.Points[1].Start != "2025-02-01" || series.Points[1].ChallengesIssued != 4 {
+		t.Errorf("expected one point per rolled-up month, got %+v", series.Points)
+	}
+	if !series.Approximate || !series.Points[0].Approximate || !series.Points[1].Approximate {
+		t.Errorf("expected rolled-up months in day buckets to be approximate, got %+v", series)
+	}
+
+	// In month buckets only the month the range cuts is approximate.
+	rng.Bucket = BucketMonth
+	series, _ = GetStatsSeries(db, rng, AllProjects, key.ID)
+	if len(series.Points) != 2 || !series.Points[0].Approximate || series.Points[1].Approximate {
+		t.Errorf("expected only January to be approximate, got %+v", series.Points)
+	}
+	rng.From = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if series, _ = GetStatsSeries(db, rng, AllProjects, key.ID); series.Approximate {
+		t.Errorf("expected whole months to be exact, got %+v", series.Points)
+	}
+
+	// Rolling up again adds nothing.
+	if removed, _ := RollUpDailyStats(db, 90*24*time.Hour); removed != 0 {
+		t.Errorf("expected nothing left to roll up, got %d", removed)
+	}
+}
+
+func TestDeleteAPIKey_KeepsStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	p, _ := CreateProject(db, "Acme", "")
+	other, _ := CreateProject(db, "Other", "")
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	moveKeyToProject(t, db, key, p.ID)
+
+	IncrementChallengesIssued(db, key.ID)
+	IncrementVerificationsOK(db, key.ID)
+	if err := DeleteAPIKey(db, key.ID); err != nil {
+		t.Fatalf("DeleteAPIKey failed: %v", err)
+	}
+
+	overview, _ := GetStatsOverview(db, 30, ProjectScope{ProjectIDs: []int64{p.ID}})
+	if overview.TotalChallenges != 1 || overview.TotalVerificationsOK != 1 {
+		t.Errorf("expected the deleted key's statistics in its project, got %+v", overview)
+	}
+	if overview, _ := GetStatsOverview(db, 30, ProjectScope{ProjectIDs: []int64{other.ID}}); overview.TotalChallenges != 0 {
+		t.Errorf("expected no statistics in another project, got %d", overview.TotalChallenges)
+	}
+	if summary, _ := GetAllKeysStatsSummary(db, AllProjects); len(summary) != 0 {
+		t.Errorf("expected deleted keys to be left out of the summary, got %+v", summary)
+	}
+}
+
+func TestDeleteOldStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+
+	IncrementChallengesIssued(db, key.ID)
+	db.Exec(`INSERT INTO hourly_stats (api_key_id, hour, challenges_issued) VALUES (?, '2025-01-10T10:00:00Z', 1)`, key.ID)
+	thisMonth := time.Now().UTC().Format("2006-01") + "-01"
+	for _, month := range []string{"2020-01-01", thisMonth} {
+		db.Exec(`INSERT INTO monthly_stats (api_key_id, month, last_date, challenges_issued) VALUES (?, ?, ?, 1)`, key.ID, month, month)
+	}
+
+	if removed, err := DeleteOldHourlyStats(db, 90*24*time.Hour); err != nil || removed != 1 {
+		t.Errorf("expected 1 hourly row removed, got %d (%v)", removed, err)
+	}
+	if removed, err := DeleteOldMonthlyStats(db, 24); err != nil || removed != 1 {
+		t.Errorf("expected 1 monthly row removed, got %d (%v)", removed, err)
+	}
+	var hours, months int
+	db.QueryRow(`SELECT COUNT(*) FROM hourly_stats`).Scan(&hours)
+	db.QueryRow(`SELECT COUNT(*) FROM monthly_stats`).Scan(&months)
+	if hours != 1 || months != 1 {
+		t.Errorf("expected recent statistics to stay, got %d hours and %d months", hours, months)
+	}
+}
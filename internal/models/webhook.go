@@ -0,0 +1,451 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventKeyCreated         = "key.created"
+	EventKeyUpdated         = "key.updated"
+	EventKeyDeleted         = "key.deleted"
+	EventKeySecretRotated   = "key.secret_rotated"
+	EventFailureRateCrossed = "verification.failure_rate_exceeded"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{
+	EventKeyCreated,
+	EventKeyUpdated,
+	EventKeyDeleted,
+	EventKeySecretRotated,
+	EventFailureRateCrossed,
+}
+
+// Delivery statuses.
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+// Webhook is an outbound subscription. Deliveries are signed with Secret,
+// which is only returned when the webhook is created. A webhook in a
+// project only hears about that project's keys; one outside every project
+// hears about all of them.
+type Webhook struct {
+	ID        int64    `json:"id"`
+	ProjectID int64    `json:"project_id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	Enabled   bool     `json:"enabled"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+
+	// A failure rate event fires once per key and day when the share of
+	// failed verifications reaches FailureRateThreshold after at least
+	// FailureRateMinVerifications verifications.
+	FailureRateThreshold        float64 `json:"failure_rate_threshold"`
+	FailureRateMinVerifications int     `json:"failure_rate_min_verifications"`
+}
+
+// WebhookDelivery is one attempt series to send an event to a webhook.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Data           json.RawMessage `json:"data"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  string          `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    string          `json:"delivered_at,omitempty"`
+}
+
+// UpdateWebhookParams holds the fields for updating a webhook.
+type UpdateWebhookParams struct {
+	ProjectID                   int64
+	URL                         string
+	Events                      []string
+	Enabled                     bool
+	FailureRateThreshold        float64
+	FailureRateMinVerifications int
+}
+
+const (
+	DefaultFailureRateThreshold        = 0.5
+	DefaultFailureRateMinVerifications = 20
+)
+
+// IsWebhookEvent reports whether event is one of WebhookEvents.
+func IsWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribes reports whether the webhook receives event.
+func (wh *Webhook) Subscribes(event string) bool {
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether the webhook hears about keys in any of projectIDs.
+func (wh *Webhook) Covers(projectIDs ...int64) bool {
+	if wh.ProjectID == 0 {
+		return true
+	}
+	for _, id := range projectIDs {
+		if id == wh.ProjectID {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateParams returns the webhook's current settings, ready to be modified
+// and passed to UpdateWebhook.
+func (wh *Webhook) UpdateParams() UpdateWebhookParams {
+	return UpdateWebhookParams{
+		ProjectID:                   wh.ProjectID,
+		URL:                         wh.URL,
+		Events:                      wh.Events,
+		Enabled:                     wh.Enabled,
+		FailureRateThreshold:        wh.FailureRateThreshold,
+		FailureRateMinVerifications: wh.FailureRateMinVerifications,
+	}
+}
+
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+const webhookColumns = `id, project_id, url, secret, events, enabled, failure_rate_threshold, failure_rate_min_verifications, created_at, updated_at`
+
+func scanWebhook(scan func(dest ...interface{}) error) (*Webhook, error) {
+	var wh Webhook
+	var enabled int
+	var events string
+	err := scan(&wh.ID, &wh.ProjectID, &wh.URL, &wh.Secret, &events, &enabled, &wh.FailureRateThreshold, &wh.FailureRateMinVerifications, &wh.CreatedAt, &wh.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if wh.Events, err = decodeList(events); err != nil {
+		return nil, fmt.Errorf("invalid events for webhook %d: %w", wh.ID, err)
+	}
+	wh.Enabled = enabled == 1
+	return &wh, nil
+}
+
+// CreateWebhook adds a webhook to a project, or outside every project when
+// projectID is 0.
+func CreateWebhook(db *sql.DB, projectID int64, url string, events []string) (*Webhook, error) {
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		INSERT INTO webhooks (project_id, url, secret, events, failure_rate_threshold, failure_rate_min_verifications, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, projectID, url, secret, encodeList(events), DefaultFailureRateThreshold, DefaultFailureRateMinVerifications, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert webhook: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &Webhook{
+		ID:                          id,
+		ProjectID:                   projectID,
+		URL:                         url,
+		Secret:                      secret,
+		Events:                      events,
+		Enabled:                     true,
+		CreatedAt:                   now,
+		UpdatedAt:                   now,
+		FailureRateThreshold:        DefaultFailureRateThreshold,
+		FailureRateMinVerifications: DefaultFailureRateMinVerifications,
+	}, nil
+}
+
+// GetWebhook returns a webhook including its signing secret.
+func GetWebhook(db *sql.DB, id int64) (*Webhook, error) {
+	row := db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	return scanWebhook(row.Scan)
+}
+
+// ListWebhooks returns the webhooks in scope including their signing
+// secrets. Webhooks outside every project are only in AllProjects.
+func ListWebhooks(db *sql.DB, scope ProjectScope) ([]Webhook, error) {
+	cond, args := scope.where("project_id")
+	rows, err := db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE `+cond+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		wh, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *wh)
+	}
+	return hooks, rows.Err()
+}
+
+func UpdateWebhook(db *sql.DB, id int64, params UpdateWebhookParams) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := db.Exec(`
+		UPDATE webhooks SET project_id = ?, url = ?, events = ?, enabled = ?, failure_rate_threshold = ?, failure_rate_min_verifications = ?, updated_at = ?
+		WHERE id = ?
+	`, params.ProjectID, params.URL, encodeList(params.Events), boolToInt(params.Enabled), params.FailureRateThreshold, params.FailureRateMinVerifications, now, id)
+	return err
+}
+
+func DeleteWebhook(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+// EnqueueWebhookEvent queues a delivery of event to every enabled webhook
+// subscribed to it that covers one of projectIDs, the projects of the key
+// the event is about. data is marshalled to JSON.
+func EnqueueWebhookEvent(db *sql.DB, event string, data interface{}, projectIDs ...int64) error {
+	hooks, err := ListWebhooks(db, AllProjects)
+	if err != nil {
+		return err
+	}
+	for i := range hooks {
+		if !hooks[i].Enabled || !hooks[i].Subscribes(event) || !hooks[i].Covers(projectIDs...) {
+			continue
+		}
+		if _, err := insertWebhookDelivery(db, hooks[i].ID, event, data, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertWebhookDelivery reports whether a delivery was queued; it is not
+// when dedupeKey was already used for the webhook.
+func insertWebhookDelivery(db *sql.DB, webhookID int64, event string, data interface{}, dedupeKey string) (bool, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+	var dedupe interface{}
+	if dedupeKey != "" {
+		dedupe = dedupeKey
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO webhook_deliveries (webhook_id, event, data, status, next_attempt_at, dedupe_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, webhookID, event, string(body), DeliveryStatusPending, now, dedupe, now)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event, data, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(scan func(dest ...interface{}) error) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var data string
+	err := scan(&d.ID, &d.WebhookID, &d.Event, &data, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Data = json.RawMessage(data)
+	return &d, nil
+}
+
+func queryWebhookDeliveries(db *sql.DB, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ListWebhookDeliveries returns a webhook's most recent deliveries, newest first.
+func ListWebhookDeliveries(db *sql.DB, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(db, `WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit)
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries of enabled
+// webhooks whose next attempt is due. Deliveries of a disabled webhook wait
+// until it is enabled again.
+func DueWebhookDeliveries(db *sql.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(db, `
+		WHERE status = ? AND datetime(next_attempt_at) <= datetime(?)
+		  AND webhook_id IN (SELECT id FROM webhooks WHERE enabled = 1)
+		ORDER BY id LIMIT ?`,
+		DeliveryStatusPending, now.UTC().Format(time.RFC3339), limit)
+}
+
+// ResendWebhookDelivery queues a new delivery with the same event and data
+// as an earlier one. Returns sql.ErrNoRows if the delivery does not belong
+// to the webhook.
+func ResendWebhookDelivery(db *sql.DB, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event, data, status, next_attempt_at, created_at)
+		SELECT webhook_id, event, data, ?, ?, ? FROM webhook_deliveries WHERE id = ? AND webhook_id = ?
+	`, DeliveryStatusPending, now, now, deliveryID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	id, _ := result.LastInsertId()
+	row := db.QueryRow(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id)
+	return scanWebhookDelivery(row.Scan)
+}
+
+// RecordWebhookDeliveryAttempt stores the outcome of an attempt. A zero
+// retryAt with a failed attempt marks the delivery as failed for good.
+func RecordWebhookDeliveryAttempt(db *sql.DB, id int64, delivered bool, statusCode int, errMsg string, retryAt time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	status, next, deliveredAt := DeliveryStatusPending, "", ""
+	switch {
+	case delivered:
+		status, deliveredAt = DeliveryStatusDelivered, now
+	case retryAt.IsZero():
+		status = DeliveryStatusFailed
+	default:
+		next = retryAt.UTC().Format(time.RFC3339)
+	}
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ?
+		WHERE id = ?
+	`, status, next, statusCode, errMsg, deliveredAt, id)
+	return err
+}
+
+// FailureRate is a key's verification outcome for one day.
+type FailureRate struct {
+	APIKeyID  int64  `json:"api_key_id"`
+	KeyID     string `json:"key_id"`
+	Name      string `json:"name"`
+	ProjectID int64  `json:"project_id"`
+	Date      string `json:"date"`
+	OK        int    `json:"verifications_ok"`
+	Fail      int    `json:"verifications_fail"`
+}
+
+// Rate returns the share of failed verifications.
+func (f FailureRate) Rate() float64 {
+	if f.OK+f.Fail == 0 {
+		return 0
+	}
+	return float64(f.Fail) / float64(f.OK+f.Fail)
+}
+
+// EnqueueFailureRateEvents queues a failure rate event for every key whose
+// failure rate today has reached the threshold of a subscribed webhook
+// covering its project. Each webhook hears about a key at most once per day.
+func EnqueueFailureRateEvents(db *sql.DB) (int, error) {
+	hooks, err := ListWebhooks(db, AllProjects)
+	if err != nil {
+		return 0, err
+	}
+
+	date := time.Now().UTC().Format(dateFormatYMD)
+	rows, err := db.Query(`
+		SELECT k.id, k.key_id, k.name, k.project_id, s.verifications_ok, s.verifications_fail
+		FROM daily_stats s JOIN api_keys k ON k.id = s.api_key_id
+		WHERE s.date = ? AND s.verifications_fail > 0
+	`, date)
+	if err != nil {
+		return 0, err
+	}
+	var rates []FailureRate
+	for rows.Next() {
+		f := FailureRate{Date: date}
+		if err := rows.Scan(&f.APIKeyID, &f.KeyID, &f.Name, &f.ProjectID, &f.OK, &f.Fail); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rates = append(rates, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, wh := range hooks {
+		if !wh.Enabled || !wh.Subscribes(EventFailureRateCrossed) {
+			continue
+		}
+		for _, f := range rates {
+			if !wh.Covers(f.ProjectID) || f.OK+f.Fail < wh.FailureRateMinVerifications || f.Rate() < wh.FailureRateThreshold {
+				continue
+			}
+			data := map[string]interface{}{
+				"key":               f,
+				"failure_rate":      f.Rate(),
+				"threshold":         wh.FailureRateThreshold,
+				"min_verifications": wh.FailureRateMinVerifications,
+			}
+			dedupe := fmt.Sprintf("%s:%d:%s", EventFailureRateCrossed, f.APIKeyID, date)
+			inserted, err := insertWebhookDelivery(db, wh.ID, EventFailureRateCrossed, data, dedupe)
+			if err != nil {
+				return queued, err
+			}
+			if inserted {
+				queued++
+			}
+		}
+	}
+	return queued, nil
+}
+
+// DeleteOldWebhookDeliveries removes finished deliveries older than maxAge.
+func DeleteOldWebhookDeliveries(db *sql.DB, maxAge time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-maxAge).UTC().Format(time.RFC3339)
+	result, err := db.Exec(`
+		DELETE FROM webhook_deliveries
+		WHERE status != ? AND datetime(created_at) < datetime(?)
+	`, DeliveryStatusPending, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func TestEnqueueWebhookEvent_Subscriptions(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	created, _ := CreateWebhook(db, 0, "https://hooks.example.com/a", []string{EventKeyCreated})
+	other, _ := CreateWebhook(db, 0, "https://hooks.example.com/b", []string{EventKeyDeleted})
+	disabled, _ := CreateWebhook(db, 0, "https://hooks.example.com/c", []string{EventKeyCreated})
+	params := disabled.UpdateParams()
+	params.Enabled = false
+	UpdateWebhook(db, disabled.ID, params)
+
+	if err := EnqueueWebhookEvent(db, EventKeyCreated, map[string]string{"key_id": "gk_test"}); err != nil {
+		t.Fatalf("EnqueueWebhookEvent failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		id   int64
+		want int
+	}{{created.ID, 1}, {other.ID, 0}, {disabled.ID, 0}} {
+		deliveries, _ := ListWebhookDeliveries(db, tt.id, 10)
+		if len(deliveries) != tt.want {
+			t.Errorf("webhook %d: expected %d deliveries, got %d", tt.id, tt.want, len(deliveries))
+		}
+	}
+}
+
+func TestEnqueueWebhookEvent_Projects(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	acme, _ := CreateProject(db, "Acme", "")
+	globex, _ := CreateProject(db, "Globex", "")
+	global, _ := CreateWebhook(db, 0, "https://hooks.example.com/all", []string{EventKeyCreated})
+	inAcme, _ := CreateWebhook(db, acme.ID, "https://hooks.example.com/acme", []string{EventKeyCreated})
+	inGlobex, _ := CreateWebhook(db, globex.ID, "https://hooks.example.com/globex", []string{EventKeyCreated})
+
+	EnqueueWebhookEvent(db, EventKeyCreated, map[string]string{"key_id": "gk_acme"}, acme.ID)
+	for _, tt := range []struct {
+		id   int64
+		want int
+	}{{global.ID, 1}, {inAcme.ID, 1}, {inGlobex.ID, 0}} {
+		deliveries, _ := ListWebhookDeliveries(db, tt.id, 10)
+		if len(deliveries) != tt.want {
+			t.Errorf("webhook %d: expected %d deliveries, got %d", tt.id, tt.want, len(deliveries))
+		}
+	}
+
+	if hooks, _ := ListWebhooks(db, ProjectScope{ProjectIDs: []int64{acme.ID}}); len(hooks) != 1 || hooks[0].ID != inAcme.ID {
+		t.Errorf("expected only the project's webhook in scope, got %+v", hooks)
+	}
+}
+
+func TestResendWebhookDelivery(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	wh, _ := CreateWebhook(db, 0, "https://hooks.example.com", []string{EventKeyCreated})
+	EnqueueWebhookEvent(db, EventKeyCreated, map[string]string{"key_id": "gk_test"})
+	deliveries, _ := ListWebhookDeliveries(db, wh.ID, 10)
+	RecordWebhookDeliveryAttempt(db, deliveries[0].ID, true, 200, "", time.Time{})
+
+	resent, err := ResendWebhookDelivery(db, wh.ID, deliveries[0].ID)
+	if err != nil {
+		t.Fatalf("ResendWebhookDelivery failed: %v", err)
+	}
+	if resent.ID == deliveries[0].ID || resent.Status != DeliveryStatusPending || string(resent.Data) != string(deliveries[0].Data) {
+		t.Errorf("expected a new pending copy, got %+v", resent)
+	}
+
+	if _, err := ResendWebhookDelivery(db, wh.ID+1, deliveries[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected ErrNoRows for another webhook's delivery, got %v", err)
+	}
+}
+
+func TestEnqueueFailureRateEvents(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	wh, _ := CreateWebhook(db, 0, "https://hooks.example.com", []string{EventFailureRateCrossed})
+	params := wh.UpdateParams()
+	params.FailureRateThreshold = 0.5
+	params.FailureRateMinVerifications = 4
+	UpdateWebhook(db, wh.ID, params)
+	key, _ := CreateAPIKey(db, "Test", "", 0, 0, "")
+	other, _ := CreateProject(db, "Other", "")
+	elsewhere, _ := CreateWebhook(db, other.ID, "https://hooks.example.com/other", []string{EventFailureRateCrossed})
+
+	IncrementVerificationsOK(db, key.ID)
+	IncrementVerificationsFail(db, key.ID, FailInvalidSolution)
+	IncrementVerificationsFail(db, key.ID, FailInvalidSolution)
+	if n, _ := EnqueueFailureRateEvents(db); n != 0 {
+		t.Errorf("expected no event below the minimum volume, got %d", n)
+	}
+
+	IncrementVerificationsFail(db, key.ID, FailInvalidSolution)
+	if n, err := EnqueueFailureRateEvents(db); err != nil || n != 1 {
+		t.Errorf("expected 1 event once the threshold is crossed, got %d (%v)", n, err)
+	}
+	if n, _ := EnqueueFailureRateEvents(db); n != 0 {
+		t.Errorf("expected the event only once per day, got %d", n)
+	}
+	if deliveries, _ := ListWebhookDeliveries(db, elsewhere.ID, 10); len(deliveries) != 0 {
+		t.Errorf("expected no event for another project's webhook, got %d", len(deliveries))
+	}
+}
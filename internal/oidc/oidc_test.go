@@ -0,0 +1,86 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Upellift99/GateCHA/internal/oidc"
+	"github.com/Upellift99/GateCHA/internal/oidc/oidctest"
+)
+
+// authorize runs the issuer's authorization endpoint and returns the code
+// it redirects back with.
+func authorize(t *testing.T, iss *oidctest.Issuer, authURL string) url.Values {
+	t.Helper()
+	client := iss.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize failed: %v", err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect, got %d", resp.StatusCode)
+	}
+	return loc.Query()
+}
+
+func TestProvider_CodeFlow(t *testing.T) {
+	iss := oidctest.NewIssuer(t, "gatecha")
+	iss.SetUser(map[string]interface{}{"sub": "user-1", "preferred_username": "alice"})
+	p := oidc.NewProvider(oidc.Config{
+		Issuer:      iss.URL,
+		ClientID:    "gatecha",
+		RedirectURL: "https://gatecha.example.com/callback",
+		Scopes:      []string{"openid", "profile"},
+	}, iss.Client())
+	ctx := context.Background()
+
+	verifier, _ := oidc.RandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	back := authorize(t, iss, authURL)
+	if back.Get("state") != "state-1" {
+		t.Errorf("expected the state to round-trip, got %q", back.Get("state"))
+	}
+
+	if _, err := p.Exchange(ctx, back.Get("code"), "wrong-verifier"); err == nil {
+		t.Error("expected the exchange to fail with the wrong PKCE verifier")
+	}
+
+	back = authorize(t, iss, authURL)
+	raw, err := p.Exchange(ctx, back.Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+	if claims["preferred_username"] != "alice" || claims["sub"] != "user-1" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	if _, err := p.VerifyIDToken(ctx, raw, "other-nonce"); !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Errorf("expected ErrNonceMismatch, got %v", err)
+	}
+
+	other := oidc.NewProvider(oidc.Config{Issuer: iss.URL, ClientID: "someone-else"}, iss.Client())
+	if _, err := other.VerifyIDToken(ctx, raw, "nonce-1"); err == nil {
+		t.Error("expected a token for another audience to be rejected")
+	}
+}
+
+func TestProvider_IssuerMismatch(t *testing.T) {
+	iss := oidctest.NewIssuer(t, "gatecha")
+	p := oidc.NewProvider(oidc.Config{Issuer: iss.URL + "/other", ClientID: "gatecha"}, iss.Client())
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("expected discovery to fail for an unknown issuer")
+	}
+}
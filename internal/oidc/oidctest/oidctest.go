@@ -0,0 +1,167 @@
+// Package oidctest provides an in-process OpenID Connect issuer for tests.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "oidctest"
+
+type grant struct {
+	clientID, redirectURI, challenge, nonce string
+	claims                                  map[string]interface{}
+}
+
+// Issuer is a minimal OpenID Connect provider. Its authorization endpoint
+// signs the user in immediately and redirects back with a code.
+type Issuer struct {
+	URL      string
+	ClientID string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+	codes  map[string]grant
+}
+
+// NewIssuer starts an issuer that accepts clientID. It is closed when the
+// test ends.
+func NewIssuer(t testing.TB, clientID string) *Issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	iss := &Issuer{ClientID: clientID, key: key, claims: map[string]interface{}{}, codes: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/authorize", iss.authorize)
+	mux.HandleFunc("/token", iss.token)
+	mux.HandleFunc("/jwks", iss.jwks)
+	iss.server = httptest.NewServer(mux)
+	iss.URL = iss.server.URL
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// SetUser sets the claims of the next ID tokens, such as sub,
+// preferred_username and groups.
+func (iss *Issuer) SetUser(claims map[string]interface{}) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.claims = claims
+}
+
+// Client returns an HTTP client for the issuer.
+func (iss *Issuer) Client() *http.Client {
+	return iss.server.Client()
+}
+
+func (iss *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 iss.URL,
+		"authorization_endpoint": iss.URL + "/authorize",
+		"token_endpoint":         iss.URL + "/token",
+		"jwks_uri":               iss.URL + "/jwks",
+	})
+}
+
+func (iss *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != iss.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := oidc.RandomString()
+
+	iss.mu.Lock()
+	iss.codes[code] = grant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		claims:      iss.claims,
+	}
+	iss.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (iss *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	iss.mu.Lock()
+	g, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": msg})
+	}
+	switch {
+	case !ok:
+		fail("unknown code")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		fail("redirect_uri mismatch")
+		return
+	case oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != g.challenge:
+		fail("PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   iss.URL,
+		"aud":   g.clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": g.nonce,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = keyID
+	signed, err := tok.SignedString(iss.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "access_token": "unused", "token_type": "Bearer"})
+}
+
+func (iss *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,215 @@
+// Package origins parses and matches the allowed-origin entries of an API
+// key. An entry is an optional scheme, a host and an optional port:
+//
+//	example.com                  any scheme, any port
+//	https://example.com          https only
+//	http://localhost:5173        exact scheme and port
+//	*.preview.example.com        any subdomain of preview.example.com
+//	bücher.example               stored as xn--bcher-kva.example
+//	*                            any origin
+package origins
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Pattern is a parsed allowed-origin entry.
+type Pattern struct {
+	// Scheme is http or https; empty matches both.
+	Scheme string
+	// Host is the ASCII (punycode) host. A leading "*." matches any
+	// subdomain; "*" alone matches every host.
+	Host string
+	// Port is empty to match any port.
+	Port string
+}
+
+var errEmpty = errors.New("empty origin")
+
+// Parse validates and normalises an allowed-origin entry.
+func Parse(entry string) (Pattern, error) {
+	s := strings.TrimSpace(entry)
+	if s == "" {
+		return Pattern{}, errEmpty
+	}
+	if s == "*" {
+		return Pattern{Host: "*"}, nil
+	}
+
+	var p Pattern
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		p.Scheme = strings.ToLower(scheme)
+		if p.Scheme != "http" && p.Scheme != "https" {
+			return Pattern{}, fmt.Errorf("%s: unsupported scheme %q", entry, scheme)
+		}
+		s = rest
+	}
+	s = strings.TrimSuffix(s, "/")
+	if strings.ContainsAny(s, "/?#@") {
+		return Pattern{}, fmt.Errorf("%s: origins cannot contain a path, query or credentials", entry)
+	}
+
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		if !validPort(port) {
+			return Pattern{}, fmt.Errorf("%s: invalid port %q", entry, port)
+		}
+		host, p.Port = h, port
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	wildcard := strings.HasPrefix(host, "*.")
+	if wildcard {
+		host = host[2:]
+	}
+	ascii, err := normalizeHost(host)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("%s: %w", entry, err)
+	}
+	if wildcard {
+		if net.ParseIP(ascii) != nil {
+			return Pattern{}, fmt.Errorf("%s: wildcards need a domain name", entry)
+		}
+		ascii = "*." + ascii
+	}
+	p.Host = ascii
+	return p, nil
+}
+
+func validPort(port string) bool {
+	if port == "" || len(port) > 5 {
+		return false
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errEmpty
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q: %w", host, err)
+	}
+	return ascii, nil
+}
+
+// String returns the canonical form of the entry.
+func (p Pattern) String() string {
+	host := p.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if p.Port != "" {
+		host += ":" + p.Port
+	}
+	if p.Scheme != "" {
+		return p.Scheme + "://" + host
+	}
+	return host
+}
+
+// Match reports whether origin, a URL such as an Origin or Referer header,
+// is covered by the pattern.
+func (p Pattern) Match(origin string) bool {
+	scheme, host, port, ok := splitOrigin(origin)
+	if !ok {
+		return false
+	}
+	return p.match(scheme, host, port)
+}
+
+func (p Pattern) match(scheme, host, port string) bool {
+	if p.Host == "*" {
+		return true
+	}
+	if p.Scheme != "" && p.Scheme != scheme {
+		return false
+	}
+	if p.Port != "" && p.Port != port {
+		return false
+	}
+	if base, ok := strings.CutPrefix(p.Host, "*."); ok {
+		return strings.HasSuffix(host, "."+base)
+	}
+	return host == p.Host
+}
+
+// splitOrigin extracts the scheme, ASCII host and effective port of a URL.
+// URLs without a scheme are accepted for hand-written entries.
+func splitOrigin(origin string) (scheme, host, port string, ok bool) {
+	if origin == "" || origin == "null" {
+		return "", "", "", false
+	}
+	if !strings.Contains(origin, "://") {
+		origin = "//" + origin
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return "", "", "", false
+	}
+	host, err = normalizeHost(u.Hostname())
+	if err != nil {
+		return "", "", "", false
+	}
+	scheme = strings.ToLower(u.Scheme)
+	port = u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return scheme, host, port, true
+}
+
+// Normalize parses entries and returns their canonical forms without
+// duplicates, in the order given.
+func Normalize(entries []string) ([]string, error) {
+	out := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		p, err := Parse(e)
+		if err != nil {
+			return nil, err
+		}
+		s := p.String()
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// MatchAny reports whether origin matches any of entries. Invalid entries
+// never match.
+func MatchAny(entries []string, origin string) bool {
+	scheme, host, port, ok := splitOrigin(origin)
+	if !ok {
+		return false
+	}
+	for _, e := range entries {
+		if p, err := Parse(e); err == nil && p.match(scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
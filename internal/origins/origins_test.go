@@ -0,0 +1,117 @@
+package origins
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{"example.com", "example.com"},
+		{"  EXAMPLE.com/ ", "example.com"},
+		{"https://example.com", "https://example.com"},
+		{"HTTP://localhost:5173", "http://localhost:5173"},
+		{"*.preview.example.com", "*.preview.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"127.0.0.1:8080", "127.0.0.1:8080"},
+		{"[::1]:3000", "[::1]:3000"},
+		{"*", "*"},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.entry)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.entry, err)
+			continue
+		}
+		if got := p.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, entry := range []string{
+		"",
+		"ftp://example.com",
+		"https://example.com/path",
+		"user@example.com",
+		"example.com:http",
+		"*.127.0.0.1",
+		"exa mple.com",
+	} {
+		if _, err := Parse(entry); err == nil {
+			t.Errorf("expected Parse(%q) to fail", entry)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		// Plain hosts match any scheme and port, as domains always have.
+		{"example.com", "https://example.com/path", true},
+		{"example.com", "http://example.com:8080/path", true},
+		{"example.com", "https://EXAMPLE.COM", true},
+		{"example.com", "example.com/path", true},
+		{"example.com", "https://other.com", false},
+		{"example.com", "https://www.example.com", false},
+
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com:443", "https://example.com", true},
+		{"http://localhost:5173", "http://localhost:5173", true},
+		{"http://localhost:5173", "http://localhost:3000", false},
+		{"localhost", "http://localhost:3000", true},
+
+		{"*.example.com", "https://a.example.com", true},
+		{"*.example.com", "https://a.b.example.com", true},
+		{"*.example.com", "https://example.com", false},
+		{"*.example.com", "https://badexample.com", false},
+
+		{"bücher.example", "https://xn--bcher-kva.example", true},
+		{"xn--bcher-kva.example", "https://bücher.example", true},
+
+		{"*", "https://anything.test", true},
+		{"example.com", "", false},
+		{"example.com", "null", false},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.pattern)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.origin); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got, err := Normalize([]string{"Example.com", "example.com", "https://www.example.com/"})
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if len(got) != 2 || got[0] != "example.com" || got[1] != "https://www.example.com" {
+		t.Errorf("unexpected result: %v", got)
+	}
+
+	if _, err := Normalize([]string{"example.com", "ftp://bad"}); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	entries := []string{"www.example.com", "*.preview.example.com"}
+	if !MatchAny(entries, "https://x.preview.example.com") {
+		t.Error("expected wildcard entry to match")
+	}
+	if MatchAny(entries, "https://example.com") {
+		t.Error("expected bare domain not to match")
+	}
+	if MatchAny(nil, "https://example.com") {
+		t.Error("expected empty list not to match")
+	}
+}
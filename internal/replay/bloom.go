@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBloomCapacity = 1_000_000
+	defaultBloomFPRate   = 0.0001
+	defaultBloomWindow   = 10 * time.Minute
+)
+
+// BloomStore keeps consumed challenges in a chain of bloom filters. A new
+// filter is started every window and a filter is dropped once every
+// challenge it holds has expired, so memory stays bounded regardless of
+// volume. False positives reject a fresh solution as already used at
+// roughly the configured rate; there are no false negatives.
+type BloomStore struct {
+	mu          sync.Mutex
+	seed1       maphash.Seed
+	seed2       maphash.Seed
+	bits        uint64
+	hashes      uint64
+	window      time.Duration
+	generations []*bloomGeneration
+}
+
+type bloomGeneration struct {
+	bits      []uint64
+	count     int64
+	createdAt time.Time
+	maxExpiry time.Time
+}
+
+// NewBloomStore sizes each filter to hold capacity challenges per window
+// at the given false-positive rate. Zero values pick the defaults.
+func NewBloomStore(capacity uint, fpRate float64, window time.Duration) *BloomStore {
+	if capacity == 0 {
+		capacity = defaultBloomCapacity
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = defaultBloomFPRate
+	}
+	if window <= 0 {
+		window = defaultBloomWindow
+	}
+
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+
+	return &BloomStore{
+		seed1:  maphash.MakeSeed(),
+		seed2:  maphash.MakeSeed(),
+		bits:   uint64(m),
+		hashes: uint64(k),
+		window: window,
+	}
+}
+
+func (s *BloomStore) newGeneration(now time.Time) *bloomGeneration {
+	return &bloomGeneration{
+		bits:      make([]uint64, (s.bits+63)/64),
+		createdAt: now,
+	}
+}
+
+// positions derives the k bit indexes for challenge by double hashing.
+func (s *BloomStore) positions(challenge string) []uint64 {
+	h1 := maphash.String(s.seed1, challenge)
+	h2 := maphash.String(s.seed2, challenge) | 1
+	pos := make([]uint64, s.hashes)
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % s.bits
+	}
+	return pos
+}
+
+func (g *bloomGeneration) contains(pos []uint64) bool {
+	for _, p := range pos {
+		if g.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *bloomGeneration) add(pos []uint64) {
+	for _, p := range pos {
+		g.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+func (s *BloomStore) Consume(challenge string, expiresAt time.Time) (bool, error) {
+	pos := s.positions(challenge)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.generations {
+		if g.contains(pos) {
+			return true, nil
+		}
+	}
+
+	if len(s.generations) == 0 || now.Sub(s.generations[len(s.generations)-1].createdAt) >= s.window {
+		s.generations = append(s.generations, s.newGeneration(now))
+	}
+	current := s.generations[len(s.generations)-1]
+	current.add(pos)
+	current.count++
+	if expiresAt.After(current.maxExpiry) {
+		current.maxExpiry = expiresAt
+	}
+	return false, nil
+}
+
+// Cleanup drops filters that are no longer written to and whose entries
+// have all expired. It returns the number of entries dropped.
+func (s *BloomStore) Cleanup() (int64, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	kept := s.generations[:0]
+	for i, g := range s.generations {
+		current := i == len(s.generations)-1 && now.Sub(g.createdAt) < s.window
+		if !current && !now.Before(g.maxExpiry) {
+			removed += g.count
+			continue
+		}
+		kept = append(kept, g)
+	}
+	s.generations = kept
+	return removed, nil
+}
+
+// Len returns the number of challenges added to the filters still kept.
+func (s *BloomStore) Len() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, g := range s.generations {
+		n += g.count
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const memoryShards = 32
+
+// MemoryStore is an in-process TTL map split into independently locked
+// shards. Entries are lost on restart, so a restart reopens a replay
+// window of at most the longest challenge expiry.
+type MemoryStore struct {
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{seed: maphash.MakeSeed()}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]time.Time)
+	}
+	return s
+}
+
+func (s *MemoryStore) shard(challenge string) *memoryShard {
+	return &s.shards[maphash.String(s.seed, challenge)%memoryShards]
+}
+
+func (s *MemoryStore) Consume(challenge string, expiresAt time.Time) (bool, error) {
+	sh := s.shard(challenge)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if exp, ok := sh.entries[challenge]; ok && time.Now().Before(exp) {
+		return true, nil
+	}
+	sh.entries[challenge] = expiresAt
+	return false, nil
+}
+
+func (s *MemoryStore) Cleanup() (int64, error) {
+	now := time.Now()
+	var removed int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for challenge, exp := range sh.entries {
+			if !now.Before(exp) {
+				delete(sh.entries, challenge)
+				removed++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) Len() (int64, error) {
+	var n int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += int64(len(sh.entries))
+		sh.mu.Unlock()
+	}
+	return n, nil
+}
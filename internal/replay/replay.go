@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+)
+
+const (
+	BackendSQLite = "sqlite"
+	BackendMemory = "memory"
+	BackendBloom  = "bloom"
+)
+
+// Store tracks consumed challenges to reject replayed solutions.
+type Store interface {
+	// Consume atomically marks challenge as used until expiresAt and
+	// reports whether it had already been used.
+	Consume(challenge string, expiresAt time.Time) (alreadyUsed bool, err error)
+	// Cleanup drops entries past their expiry and returns how many were removed.
+	Cleanup() (int64, error)
+	// Len returns how many consumed challenges the store holds, including
+	// expired ones not cleaned up yet.
+	Len() (int64, error)
+}
+
+// SQLiteStore keeps consumed challenges in the consumed_challenges table.
+// It survives restarts but costs a database write per verification.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{DB: db}
+}
+
+func (s *SQLiteStore) Consume(challenge string, expiresAt time.Time) (bool, error) {
+	return models.ConsumeChallenge(s.DB, challenge, expiresAt)
+}
+
+func (s *SQLiteStore) Cleanup() (int64, error) {
+	return models.CleanupExpired(s.DB)
+}
+
+func (s *SQLiteStore) Len() (int64, error) {
+	return models.CountConsumedChallenges(s.DB)
+}
+
+// Options holds the tuning knobs for the non-SQLite backends.
+type Options struct {
+	BloomCapacity uint
+	BloomFPRate   float64
+	BloomWindow   time.Duration
+}
+
+// New returns the store for the named backend.
+func New(backend string, db *sql.DB, opts Options) (Store, error) {
+	switch backend {
+	case BackendSQLite, "":
+		return NewSQLiteStore(db), nil
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendBloom:
+		return NewBloomStore(opts.BloomCapacity, opts.BloomFPRate, opts.BloomWindow), nil
+	default:
+		return nil, fmt.Errorf("unknown replay store backend: %s", backend)
+	}
+}
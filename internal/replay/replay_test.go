@@ -0,0 +1,189 @@
+package replay
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	return map[string]Store{
+		BackendSQLite: NewSQLiteStore(testutil.SetupTestDB(t)),
+		BackendMemory: NewMemoryStore(),
+		BackendBloom:  NewBloomStore(10000, 0.0001, time.Minute),
+	}
+}
+
+func TestStore_Consume(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			expiresAt := time.Now().Add(5 * time.Minute)
+
+			used, err := store.Consume("challenge-a", expiresAt)
+			if err != nil {
+				t.Fatalf("Consume failed: %v", err)
+			}
+			if used {
+				t.Error("expected first consume to succeed")
+			}
+
+			used, _ = store.Consume("challenge-a", expiresAt)
+			if !used {
+				t.Error("expected second consume to report already used")
+			}
+
+			used, _ = store.Consume("challenge-b", expiresAt)
+			if used {
+				t.Error("expected a different challenge to be unused")
+			}
+		})
+	}
+}
+
+func TestStore_ConcurrentConsume(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			var wins atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					used, err := store.Consume("contested", time.Now().Add(time.Minute))
+					if err == nil && !used {
+						wins.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if wins.Load() != 1 {
+				t.Errorf("expected exactly one successful consume, got %d", wins.Load())
+			}
+		})
+	}
+}
+
+func TestStore_Cleanup(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.Consume("expired", time.Now().Add(-time.Second))
+
+			if _, err := store.Cleanup(); err != nil {
+				t.Fatalf("Cleanup failed: %v", err)
+			}
+
+			used, _ := store.Consume("fresh", time.Now().Add(time.Minute))
+			if used {
+				t.Error("expected fresh challenge to be unused")
+			}
+		})
+	}
+}
+
+func TestStore_Len(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if n, err := store.Len(); err != nil || n != 0 {
+				t.Fatalf("expected empty store, got %d (%v)", n, err)
+			}
+			store.Consume("challenge-a", time.Now().Add(time.Minute))
+			store.Consume("challenge-b", time.Now().Add(time.Minute))
+			store.Consume("challenge-a", time.Now().Add(time.Minute))
+
+			n, err := store.Len()
+			if err != nil {
+				t.Fatalf("Len failed: %v", err)
+			}
+			if n != 2 {
+				t.Errorf("expected 2 entries, got %d", n)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_Cleanup(t *testing.T) {
+	store := NewMemoryStore()
+	store.Consume("expired", time.Now().Add(-time.Second))
+	store.Consume("valid", time.Now().Add(time.Minute))
+
+	removed, _ := store.Cleanup()
+	if removed != 1 {
+		t.Errorf("expected 1 removed, got %d", removed)
+	}
+
+	// An expired entry no longer blocks reuse (the signature check rejects it anyway)
+	used, _ := store.Consume("expired", time.Now().Add(time.Minute))
+	if used {
+		t.Error("expected expired entry to be gone")
+	}
+	used, _ = store.Consume("valid", time.Now().Add(time.Minute))
+	if !used {
+		t.Error("expected valid entry to remain")
+	}
+}
+
+func TestBloomStore_Rotation(t *testing.T) {
+	store := NewBloomStore(1000, 0.001, 10*time.Millisecond)
+	store.Consume("old", time.Now().Add(20*time.Millisecond))
+
+	time.Sleep(15 * time.Millisecond)
+	store.Consume("new", time.Now().Add(time.Minute))
+	if len(store.generations) != 2 {
+		t.Fatalf("expected 2 generations after window, got %d", len(store.generations))
+	}
+
+	// The old generation still holds an unexpired entry
+	store.Cleanup()
+	if len(store.generations) != 2 {
+		t.Errorf("expected old generation to be kept until its entries expire")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	removed, _ := store.Cleanup()
+	if removed != 1 || len(store.generations) != 1 {
+		t.Errorf("expected old generation dropped, removed=%d generations=%d", removed, len(store.generations))
+	}
+
+	used, _ := store.Consume("new", time.Now().Add(time.Minute))
+	if !used {
+		t.Error("expected entry in current generation to remain")
+	}
+}
+
+func TestBloomStore_FalsePositiveRate(t *testing.T) {
+	store := NewBloomStore(10000, 0.001, time.Hour)
+	expiresAt := time.Now().Add(time.Hour)
+	for i := 0; i < 10000; i++ {
+		store.Consume(fmt.Sprintf("seen-%d", i), expiresAt)
+	}
+
+	// Probe without inserting so the filter load stays at capacity
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if store.generations[0].contains(store.positions(fmt.Sprintf("unseen-%d", i))) {
+			falsePositives++
+		}
+	}
+	// Allow generous slack over the 0.1% target
+	if falsePositives > 50 {
+		t.Errorf("expected false positive rate near 0.1%%, got %d/10000", falsePositives)
+	}
+}
+
+func TestNew(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	for _, backend := range []string{"", BackendSQLite, BackendMemory, BackendBloom} {
+		if _, err := New(backend, db, Options{}); err != nil {
+			t.Errorf("New(%q) failed: %v", backend, err)
+		}
+	}
+	if _, err := New("redis", db, Options{}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
@@ -0,0 +1,105 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	Issuer = "gatecha"
+
+	// TTL is how long a verification token stays valid after issue.
+	TTL = 5 * time.Minute
+)
+
+// Claims is the payload of a verification token. Downstream services
+// check it offline against the keys published at /.well-known/jwks.json.
+type Claims struct {
+	KeyID      string `json:"key_id"`
+	Challenge  string `json:"challenge"`
+	Origin     string `json:"origin,omitempty"`
+	VerifiedAt int64  `json:"verified_at"`
+	jwt.RegisteredClaims
+}
+
+// Sign issues a verification token signed with the given Ed25519 key.
+func Sign(kid string, priv ed25519.PrivateKey, keyID, challenge, origin string, verifiedAt time.Time) (string, time.Time, error) {
+	expiresAt := verifiedAt.Add(TTL)
+	claims := Claims{
+		KeyID:      keyID,
+		Challenge:  challenge,
+		Origin:     origin,
+		VerifiedAt: verifiedAt.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   keyID,
+			IssuedAt:  jwt.NewNumericDate(verifiedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	t.Header["kid"] = kid
+	signed, err := t.SignedString(priv)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Parse validates a verification token. lookup resolves the "kid" header
+// to a public key, typically from a fetched JWKS.
+func Parse(tokenStr string, lookup func(kid string) (ed25519.PublicKey, error)) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodEd25519); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return lookup(kid)
+	}, jwt.WithIssuer(Issuer))
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// JWK is the RFC 8037 representation of an Ed25519 public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(kid string, pub ed25519.PublicKey) JWK {
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(pub),
+		Kid: kid,
+		Alg: "EdDSA",
+		Use: "sig",
+	}
+}
+
+// PublicKey decodes the key material of a JWK.
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.Kty != "OKP" || k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported key type %s/%s", k.Kty, k.Crv)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key %s", k.Kid)
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,68 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignAndParse(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	jwk := NewJWK("sk_test", pub)
+
+	signed, expiresAt, err := Sign("sk_test", priv, "gk_abc", "challenge-hash", "https://example.com", time.Now())
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if time.Until(expiresAt) > TTL {
+		t.Errorf("expected expiry within %v, got %v", TTL, expiresAt)
+	}
+
+	claims, err := Parse(signed, func(kid string) (ed25519.PublicKey, error) {
+		if kid != jwk.Kid {
+			return nil, errors.New("unknown kid")
+		}
+		return jwk.PublicKey()
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if claims.KeyID != "gk_abc" || claims.Challenge != "challenge-hash" || claims.Origin != "https://example.com" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if claims.VerifiedAt == 0 {
+		t.Error("expected verified_at to be set")
+	}
+}
+
+func TestParse_WrongKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	signed, _, _ := Sign("sk_test", priv, "gk_abc", "hash", "", time.Now())
+	_, err := Parse(signed, func(string) (ed25519.PublicKey, error) { return otherPub, nil })
+	if err == nil {
+		t.Error("expected failure for token signed by another key")
+	}
+}
+
+func TestParse_Expired(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	signed, _, _ := Sign("sk_test", priv, "gk_abc", "hash", "", time.Now().Add(-2*TTL))
+	_, err := Parse(signed, func(string) (ed25519.PublicKey, error) { return pub, nil })
+	if err == nil {
+		t.Error("expected failure for expired token")
+	}
+}
+
+func TestJWK_PublicKey_Invalid(t *testing.T) {
+	if _, err := (JWK{Kty: "RSA"}).PublicKey(); err == nil {
+		t.Error("expected error for unsupported key type")
+	}
+	if _, err := (JWK{Kty: "OKP", Crv: "Ed25519", X: "short"}).PublicKey(); err == nil {
+		t.Error("expected error for truncated key")
+	}
+}
@@ -0,0 +1,205 @@
+// Package webhook delivers queued webhook events. Each delivery is a JSON
+// POST signed with the webhook's secret; failed deliveries are retried with
+// exponential backoff until MaxAttempts is reached.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-GateCHA-Event"
+	HeaderDelivery  = "X-GateCHA-Delivery"
+	HeaderSignature = "X-GateCHA-Signature"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before it is marked failed.
+	MaxAttempts = 8
+	// batchSize caps the deliveries sent per Run.
+	batchSize = 50
+)
+
+// Body is the JSON document POSTed to webhook endpoints.
+type Body struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the signature header value for body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay before retrying after the given number of
+// failed attempts: 30s, 1m, 2m, ... capped at 6h.
+func Backoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+// ErrBlockedAddress is returned for deliveries to a loopback, link-local,
+// private or unspecified address outside the allowed networks.
+var ErrBlockedAddress = errors.New("webhook address is not allowed")
+
+// Dispatcher sends due deliveries.
+type Dispatcher struct {
+	DB     *sql.DB
+	Client *http.Client
+}
+
+// NewDispatcher returns a Dispatcher whose client refuses redirects and
+// internal addresses, except those in allowed.
+func NewDispatcher(db *sql.DB, allowed []netip.Prefix) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowed)
+		},
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{DB: db, Client: client}
+}
+
+// checkAddress is called with the resolved address of every connection, so
+// a hostname cannot be pointed at an internal address after validation.
+func checkAddress(address string, allowed []netip.Prefix) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range allowed {
+		if p.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+// Run queues failure rate events and sends every delivery that is due.
+// It returns the number of deliveries attempted.
+func (d *Dispatcher) Run(ctx context.Context) (int, error) {
+	if _, err := models.EnqueueFailureRateEvents(d.DB); err != nil {
+		slog.Error("failed to check verification failure rates", "error", err)
+	}
+
+	due, err := models.DueWebhookDeliveries(d.DB, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	hooks := map[int64]*models.Webhook{}
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		wh, ok := hooks[delivery.WebhookID]
+		if !ok {
+			wh, err = models.GetWebhook(d.DB, delivery.WebhookID)
+			if err != nil {
+				return 0, err
+			}
+			hooks[delivery.WebhookID] = wh
+		}
+		d.deliver(ctx, wh, delivery)
+	}
+	return len(due), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, wh *models.Webhook, delivery models.WebhookDelivery) {
+	statusCode, err := d.send(ctx, wh, delivery)
+	if err == nil {
+		if err := models.RecordWebhookDeliveryAttempt(d.DB, delivery.ID, true, statusCode, "", time.Time{}); err != nil {
+			slog.Error("failed to record webhook delivery", "error", err, "delivery_id", delivery.ID)
+		}
+		return
+	}
+
+	var retryAt time.Time
+	if attempts := delivery.Attempts + 1; attempts < MaxAttempts {
+		retryAt = time.Now().Add(Backoff(attempts))
+	}
+	slog.Warn("webhook delivery failed", "error", err, "webhook_id", wh.ID, "delivery_id", delivery.ID, "attempt", delivery.Attempts+1)
+	if err := models.RecordWebhookDeliveryAttempt(d.DB, delivery.ID, false, statusCode, err.Error(), retryAt); err != nil {
+		slog.Error("failed to record webhook delivery", "error", err, "delivery_id", delivery.ID)
+	}
+}
+
+// send POSTs the delivery and returns the response status. Any non-2xx
+// status, including a redirect, is an error. Response bodies are not kept.
+func (d *Dispatcher) send(ctx context.Context, wh *models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Body{
+		ID:        delivery.ID,
+		Event:     delivery.Event,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GateCHA-Webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(wh.Secret, time.Now(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Upellift99/GateCHA/internal/models"
+	"github.com/Upellift99/GateCHA/internal/testutil"
+)
+
+// loopback lets the dispatcher reach httptest servers.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+func TestSign(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	sig := Sign("whsec_test", ts, []byte(`{"id":1}`))
+	if !strings.HasPrefix(sig, "t=1700000000,v1=") {
+		t.Fatalf("unexpected signature format: %s", sig)
+	}
+	if sig != Sign("whsec_test", ts, []byte(`{"id":1}`)) {
+		t.Error("expected signatures to be deterministic")
+	}
+	if sig == Sign("whsec_other", ts, []byte(`{"id":1}`)) {
+		t.Error("expected different secrets to produce different signatures")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcher_DeliversSignedEvent(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh, _ := models.CreateWebhook(db, 0, srv.URL, []string{models.EventKeyCreated})
+	models.EnqueueWebhookEvent(db, models.EventKeyCreated, map[string]string{"key_id": "gk_test"})
+
+	n, err := NewDispatcher(db, loopback).Run(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 delivery, got %d (%v)", n, err)
+	}
+	if received == nil {
+		t.Fatal("expected the endpoint to be called")
+	}
+	if received.Header.Get(HeaderEvent) != models.EventKeyCreated {
+		t.Errorf("unexpected event header %q", received.Header.Get(HeaderEvent))
+	}
+
+	sig := received.Header.Get(HeaderSignature)
+	ts := strings.TrimPrefix(strings.SplitN(sig, ",", 2)[0], "t=")
+	var unix int64
+	json.Unmarshal([]byte(ts), &unix)
+	if sig != Sign(wh.Secret, time.Unix(unix, 0), body) {
+		t.Errorf("signature does not match body: %s", sig)
+	}
+
+	var payload Body
+	json.Unmarshal(body, &payload)
+	if payload.Event != models.EventKeyCreated || !strings.Contains(string(payload.Data), "gk_test") {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	deliveries, _ := models.ListWebhookDeliveries(db, wh.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliveryStatusDelivered || deliveries[0].LastStatusCode != http.StatusNoContent {
+		t.Errorf("expected a delivered log entry, got %+v", deliveries)
+	}
+}
+
+func TestDispatcher_RetriesFailures(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	wh, _ := models.CreateWebhook(db, 0, srv.URL, []string{models.EventKeyDeleted})
+	models.EnqueueWebhookEvent(db, models.EventKeyDeleted, map[string]int{"id": 1})
+
+	d := NewDispatcher(db, loopback)
+	d.Run(context.Background())
+
+	deliveries, _ := models.ListWebhookDeliveries(db, wh.ID, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	got := deliveries[0]
+	if got.Status != models.DeliveryStatusPending || got.Attempts != 1 || got.LastStatusCode != http.StatusInternalServerError {
+		t.Errorf("expected a pending retry after one attempt, got %+v", got)
+	}
+	if strings.Contains(got.LastError, "boom") {
+		t.Errorf("expected the response body to be dropped, got %q", got.LastError)
+	}
+
+	// The retry is not due yet.
+	if n, _ := d.Run(context.Background()); n != 0 {
+		t.Errorf("expected no due deliveries, got %d", n)
+	}
+
+	// The last attempt marks the delivery as failed.
+	db.Exec(`UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = '2000-01-01T00:00:00Z'`, MaxAttempts-1)
+	d.Run(context.Background())
+	deliveries, _ = models.ListWebhookDeliveries(db, wh.ID, 10)
+	if deliveries[0].Status != models.DeliveryStatusFailed {
+		t.Errorf("expected failed status after %d attempts, got %s", MaxAttempts, deliveries[0].Status)
+	}
+}
+
+func TestDispatcher_BlocksInternalAddresses(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	wh, _ := models.CreateWebhook(db, 0, srv.URL, []string{models.EventKeyCreated})
+	models.EnqueueWebhookEvent(db, models.EventKeyCreated, map[string]int{"id": 1})
+
+	NewDispatcher(db, nil).Run(context.Background())
+	if called {
+		t.Error("expected a loopback endpoint not to be called")
+	}
+	deliveries, _ := models.ListWebhookDeliveries(db, wh.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliveryStatusPending || !strings.Contains(deliveries[0].LastError, "not allowed") {
+		t.Errorf("expected a blocked attempt, got %+v", deliveries)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"127.0.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"10.0.0.5:8080", false},
+		{"192.168.1.1:80", false},
+		{"0.0.0.0:80", false},
+		{"[::1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[fe80::1]:80", false},
+	}
+	for _, tt := range tests {
+		if err := checkAddress(tt.address, nil); (err == nil) != tt.allowed {
+			t.Errorf("checkAddress(%s) = %v, want allowed %v", tt.address, err, tt.allowed)
+		}
+	}
+	if err := checkAddress("10.0.0.5:8080", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}); err != nil {
+		t.Errorf("expected an allowed network to pass, got %v", err)
+	}
+}
+
+func TestDispatcher_RefusesRedirects(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	wh, _ := models.CreateWebhook(db, 0, srv.URL, []string{models.EventKeyCreated})
+	models.EnqueueWebhookEvent(db, models.EventKeyCreated, map[string]int{"id": 1})
+
+	NewDispatcher(db, loopback).Run(context.Background())
+	if followed {
+		t.Error("expected the redirect not to be followed")
+	}
+	deliveries, _ := models.ListWebhookDeliveries(db, wh.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].LastStatusCode != http.StatusTemporaryRedirect || deliveries[0].Status != models.DeliveryStatusPending {
+		t.Errorf("expected a failed attempt with the redirect status, got %+v", deliveries)
+	}
+}
+
+func TestDispatcher_SkipsDisabledWebhooks(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	wh, _ := models.CreateWebhook(db, 0, srv.URL, []string{models.EventKeyCreated})
+	models.EnqueueWebhookEvent(db, models.EventKeyCreated, map[string]int{"id": 1})
+	models.UpdateWebhook(db, wh.ID, models.UpdateWebhookParams{
+		URL:                         wh.URL,
+		Events:                      wh.Events,
+		Enabled:                     false,
+		FailureRateThreshold:        wh.FailureRateThreshold,
+		FailureRateMinVerifications: wh.FailureRateMinVerifications,
+	})
+
+	d := NewDispatcher(db, loopback)
+	if n, _ := d.Run(context.Background()); n != 0 || calls != 0 {
+		t.Errorf("expected no delivery to a disabled webhook, got %d (%d calls)", n, calls)
+	}
+
+	models.UpdateWebhook(db, wh.ID, models.UpdateWebhookParams{
+		URL:                         wh.URL,
+		Events:                      wh.Events,
+		Enabled:                     true,
+		FailureRateThreshold:        wh.FailureRateThreshold,
+		FailureRateMinVerifications: wh.FailureRateMinVerifications,
+	})
+	if n, _ := d.Run(context.Background()); n != 1 || calls != 1 {
+		t.Errorf("expected the queued delivery once enabled again, got %d (%d calls)", n, calls)
+	}
+}
@@ -12,10 +12,12 @@ vi.mock('axios', () => {
     post: vi.fn(),
     put: vi.fn(),
     delete: vi.fn(),
+    request: vi.fn(),
   }
   return {
     default: {
       create: vi.fn(() => instance),
+      post: vi.fn(),
     },
   }
 })
@@ -78,6 +80,28 @@ describe('api', () => {
     expect(globalThis.location.href).toBe('/login')
   })
 
+  it('response interceptor refreshes the token and retries on 401', async () => {
+    localStorage.setItem('gatecha_token', 'expired-token')
+    localStorage.setItem('gatecha_refresh_token', 'refresh-1')
+    ;(axios.post as ReturnType<typeof vi.fn>).mockResolvedValue({
+      data: { token: 'fresh-token', refresh_token: 'refresh-2' },
+    })
+
+    await import('./api')
+
+    const instance = (axios.create as ReturnType<typeof vi.fn>).mock.results[0].value
+    instance.request.mockResolvedValue({ data: 'ok' })
+    const onRejected = instance.interceptors.response.use.mock.calls[0][1]
+
+    const config = { headers: {} as Record<string, string> }
+    await expect(onRejected({ response: { status: 401 }, config })).resolves.toEqual({ data: 'ok' })
+
+    expect(axios.post).toHaveBeenCalledWith('/api/admin/refresh', { refresh_token: 'refresh-1' })
+    expect(instance.request).toHaveBeenCalledWith(expect.objectContaining({ headers: { Authorization: 'Bearer fresh-token' } }))
+    expect(localStorage.getItem('gatecha_token')).toBe('fresh-token')
+    expect(localStorage.getItem('gatecha_refresh_token')).toBe('refresh-2')
+  })
+
   it('response interceptor passes through non-401 errors', async () => {
     await import('./api')
 
@@ -10,11 +10,42 @@ api.interceptors.request.use((config) => {
   return config
 })
 
+// Shared by concurrent 401s: a refresh token only works once.
+let refreshing: Promise<string> | null = null
+
+function refreshAccessToken(refreshToken: string) {
+  if (!refreshing) {
+    refreshing = axios
+      .post('/api/admin/refresh', { refresh_token: refreshToken })
+      .then(({ data }) => {
+        localStorage.setItem('gatecha_token', data.token)
+        localStorage.setItem('gatecha_refresh_token', data.refresh_token)
+        return data.token as string
+      })
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
 api.interceptors.response.use(
   (r) => r,
-  (err) => {
+  async (err) => {
     if (err.response?.status === 401) {
+      const refreshToken = localStorage.getItem('gatecha_refresh_token')
+      if (refreshToken && err.config && !err.config._retried) {
+        try {
+          const token = await refreshAccessToken(refreshToken)
+          err.config._retried = true
+          err.config.headers.Authorization = `Bearer ${token}`
+          return api.request(err.config)
+        } catch {
+          // Fall through to the login page.
+        }
+      }
       localStorage.removeItem('gatecha_token')
+      localStorage.removeItem('gatecha_refresh_token')
       globalThis.location.href = '/login'
     }
     return Promise.reject(err)
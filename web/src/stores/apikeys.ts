@@ -6,8 +6,15 @@ export interface APIKey {
   id: number
   key_id: string
   hmac_secret?: string
+  verify_secret?: string
+  has_verify_secret?: boolean
   name: string
   domain: string
+  allowed_origins?: string[]
+  origin_enforcement?: 'off' | 'lenient' | 'strict'
+  cors_exposed_headers?: string[]
+  cors_allow_credentials?: boolean
+  cors_max_age?: number
   max_number: number
   expire_seconds: number
   algorithm: string
@@ -21,6 +28,11 @@ async function rotateSecret(id: number) {
   return data.hmac_secret as string
 }
 
+async function regenerateVerifySecret(id: number) {
+  const { data } = await api.post(`/keys/${id}/verify-secret`)
+  return data.verify_secret as string
+}
+
 export const useApiKeysStore = defineStore('apikeys', () => {
   const keys = ref<APIKey[]>([])
   const loading = ref(false)
@@ -57,5 +69,5 @@ export const useApiKeysStore = defineStore('apikeys', () => {
     await fetchKeys()
   }
 
-  return { keys, loading, fetchKeys, createKey, getKey, updateKey, deleteKey, rotateSecret }
+  return { keys, loading, fetchKeys, createKey, getKey, updateKey, deleteKey, rotateSecret, regenerateVerifySecret }
 })
@@ -46,6 +46,24 @@ describe('auth store', () => {
     expect(store.isAuthenticated).toBe(true)
   })
 
+  it('login waits for a TOTP code when required', async () => {
+    mockApi.post.mockResolvedValueOnce({ data: { totp_required: true, login_token: 'challenge' } })
+    const store = useAuthStore()
+
+    expect(await store.login('admin', 'password')).toBe(false)
+    expect(store.isAuthenticated).toBe(false)
+
+    mockApi.post.mockResolvedValueOnce({ data: { token: 'new-token' } })
+    await store.loginTOTP('123456')
+
+    expect(mockApi.post).toHaveBeenLastCalledWith('/login/totp', {
+      login_token: 'challenge',
+      code: '123456',
+    })
+    expect(store.token).toBe('new-token')
+    expect(store.loginToken).toBe('')
+  })
+
   it('login with altcha payload includes it in body', async () => {
     mockApi.post.mockResolvedValue({ data: { token: 'token' } })
     const store = useAuthStore()
@@ -59,16 +77,20 @@ describe('auth store', () => {
     })
   })
 
-  it('logout clears token', () => {
+  it('logout ends the session and clears tokens', async () => {
+    mockApi.post.mockResolvedValue({ data: { status: 'logged out' } })
     localStorage.setItem('gatecha_token', 'token')
+    localStorage.setItem('gatecha_refresh_token', 'refresh')
     const store = useAuthStore()
     expect(store.isAuthenticated).toBe(true)
 
-    store.logout()
+    await store.logout()
 
+    expect(mockApi.post).toHaveBeenCalledWith('/logout')
     expect(store.token).toBe('')
     expect(store.isAuthenticated).toBe(false)
     expect(localStorage.getItem('gatecha_token')).toBeNull()
+    expect(localStorage.getItem('gatecha_refresh_token')).toBeNull()
   })
 
   it('checkAuth returns true when authenticated', async () => {
@@ -5,20 +5,52 @@ import api from '../lib/api'
 export const useAuthStore = defineStore('auth', () => {
   const token = ref(localStorage.getItem('gatecha_token') || '')
   const isAuthenticated = computed(() => !!token.value)
+  // Set between the password and TOTP steps of a two-factor login.
+  const loginToken = ref('')
 
+  function setTokens(data: { token: string; refresh_token: string }) {
+    token.value = data.token
+    localStorage.setItem('gatecha_token', data.token)
+    localStorage.setItem('gatecha_refresh_token', data.refresh_token)
+  }
+
+  // Returns false when a TOTP code is still needed; call loginTOTP next.
   async function login(username: string, password: string, altchaPayload?: string) {
     const body: Record<string, string> = { username, password }
     if (altchaPayload) {
       body.altcha_payload = altchaPayload
     }
     const { data } = await api.post('/login', body)
-    token.value = data.token
-    localStorage.setItem('gatecha_token', data.token)
+    if (data.totp_required) {
+      loginToken.value = data.login_token
+      return false
+    }
+    setTokens(data)
+    return true
+  }
+
+  async function loginTOTP(code: string) {
+    const { data } = await api.post('/login/totp', { login_token: loginToken.value, code })
+    loginToken.value = ''
+    setTokens(data)
   }
 
-  function logout() {
+  function clearTokens() {
     token.value = ''
+    loginToken.value = ''
     localStorage.removeItem('gatecha_token')
+    localStorage.removeItem('gatecha_refresh_token')
+  }
+
+  async function logout() {
+    if (token.value) {
+      try {
+        await api.post('/logout')
+      } catch {
+        // The session is gone either way.
+      }
+    }
+    clearTokens()
   }
 
   async function checkAuth() {
@@ -26,10 +58,10 @@ export const useAuthStore = defineStore('auth', () => {
       await api.get('/me')
       return true
     } catch {
-      logout()
+      clearTokens()
       return false
     }
   }
 
-  return { token, isAuthenticated, login, logout, checkAuth }
+  return { token, isAuthenticated, loginToken, setTokens, login, loginTOTP, logout, checkAuth }
 })
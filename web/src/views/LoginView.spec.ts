@@ -113,4 +113,35 @@ describe('LoginView', () => {
     const button = wrapper.find('button[type="submit"]')
     expect(button.attributes('disabled')).toBeDefined()
   })
+
+  it('shows the SSO button and hides the password form when configured', async () => {
+    mockAxios.default.get.mockResolvedValue({
+      data: { captcha_required: false, oidc_enabled: true, password_login_enabled: false },
+    })
+    const wrapper = mountView()
+    await flushPromises()
+
+    expect(wrapper.find('#sso-login').attributes('href')).toBe('/api/admin/oidc/login')
+    expect(wrapper.find('form').exists()).toBe(false)
+  })
+
+  it('stores tokens from the SSO callback and redirects', async () => {
+    window.history.replaceState(null, '', '/login#token=sso-token&refresh_token=sso-refresh')
+    mountView()
+    await flushPromises()
+
+    expect(localStorage.getItem('gatecha_token')).toBe('sso-token')
+    expect(localStorage.getItem('gatecha_refresh_token')).toBe('sso-refresh')
+    expect(window.location.hash).toBe('')
+    expect(mockPush).toHaveBeenCalledWith('/')
+  })
+
+  it('shows the SSO callback error', async () => {
+    window.history.replaceState(null, '', '/login#error=not+allowed')
+    const wrapper = mountView()
+    await flushPromises()
+
+    expect(wrapper.text()).toContain('not allowed')
+    expect(mockPush).not.toHaveBeenCalled()
+  })
 })